@@ -15,6 +15,11 @@ export OUT_STATE
 
 pushd gopath/src/github.com/cloud-gov/buildpack-notify
   go mod vendor
-  go build
+  # Record the build in `buildpack-notify version` and the run logs.
+  pkg=github.com/cloud-gov/buildpack-notify/pkg/notify
+  version=$(git describe --tags --always --dirty 2>/dev/null || echo dev)
+  git_sha=$(git rev-parse HEAD 2>/dev/null || true)
+  build_date=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+  go build -ldflags "-X ${pkg}.version=${version} -X ${pkg}.gitSHA=${git_sha} -X ${pkg}.buildDate=${build_date}"
   ./buildpack-notify
 popd
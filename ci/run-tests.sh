@@ -9,5 +9,5 @@ mkdir -p "${GOPATH}/bin"
 
 pushd gopath/src/github.com/cloud-gov/cg-buildpack-notify
   go mod vendor
-  go test -v
+  go test -v -race ./...
 popd
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloud-gov/buildpack-notify/pkg/notify"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+)
+
+// command is a subcommand of buildpack-notify, configured by the environment
+// like the rest of the tool and by its own flags.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(c *cli, args []string) int
+}
+
+// defaultCommand runs when no command is given, as the tool always did.
+const defaultCommand = "notify"
+
+// commands returns the subcommands, in the order they are listed in the
+// usage.
+func commands() []command {
+	return []command{
+		{"notify", "notify [flags]", "Notify the owners of apps using outdated buildpacks, restaging the apps allowed to be restaged automatically. The default.", (*cli).runNotifyCommand},
+		{"report", "report [--list] [flags]", "Find the apps using outdated buildpacks without notifying anyone, restaging anything or changing the state.", (*cli).runReportCommand},
+		{"restage", "restage [flags]", "Restage the outdated apps allowed to be restaged automatically, notifying the owners of the rest.", (*cli).runRestageCommand},
+		{"list-outdated", "list-outdated [flags]", "Same as report --list.", (*cli).runListOutdatedCommand},
+		{"eol-report", "eol-report [--csv <path>]", "List the apps affected by the end of support dates in EOL_CALENDAR coming up within EOL_WARNING_DAYS, or passed, without notifying anyone or changing the state.", (*cli).runEOLReportCommand},
+		{"check-app", "check-app --app-guid <guid>", "Explain why a single app is or isn't considered outdated, without notifying anyone or changing the state.", (*cli).runCheckAppCommand},
+		{"smoke-test", "smoke-test", "Take the SMOKE_TEST_APP through the pipeline and send its e-mail to SMOKE_TEST_MAILBOX only, then check it was delivered, after a deploy.", (*cli).runSmokeTestCommand},
+		{"resend-failures", "resend-failures --run <id>", "Send the e-mails that failed in a run again, from its checkpoint in CHECKPOINT_DIR, without checking any apps.", (*cli).runResendFailuresCommand},
+		{"export", "export --fixtures <path>", "Export the apps, buildpacks, droplets and roles a run reads from the CF API to a fixtures file for simulate.", (*cli).runExportCommand},
+		{"generate-fixtures", "generate-fixtures --fixtures <path>", "Generate a fake foundation of the size and with the ratio of outdated apps given by flags to a fixtures file for simulate.", (*cli).runGenerateFixturesCommand},
+		{"simulate", "simulate --fixtures <path>", "Run the pipeline against exported fixtures instead of the CF API, writing the e-mails to files instead of sending them. The state is left alone.", (*cli).runSimulateCommand},
+		{"render-previews", "render-previews [--dir <dir>]", "Render every variant of the e-mail templates from fixed data to text and HTML files for review, without connecting to anything.", (*cli).runRenderPreviewsCommand},
+		{"state", "state show|diff [paths]", "Print the state at path or IN_STATE, or what changed between the states at two paths.", (*cli).runStateCommand},
+		{"history", "history [--runs <n>]", "Print the trend of the outdated and restaged apps over the last runs recorded in HISTORY_FILE.", (*cli).runHistoryCommand},
+		{"version", "version", "Print the version of the build, its commit, when it was built and the CF API versions it was tested against.", (*cli).runVersionCommand},
+		{"validate", "validate", "Check the configuration and list every problem with it.", (*cli).runValidateCommand},
+		{"validate-config", "validate-config", "Check the configuration, then check the CF API, the SMTP server and the state files can be reached.", (*cli).runValidateConfigCommand},
+	}
+}
+
+// runCommand runs the command named by the first of args, or the default
+// command when there is none, and returns its exit code.
+func (c *cli) runCommand(args []string) int {
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return notify.ExitOK
+	}
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			c.reporter.SetTag("command", name)
+			return cmd.run(c, args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", name)
+	printUsage(os.Stderr)
+	return notify.ExitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: buildpack-notify [command]\n\nCommands:\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-28s %s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nEvery command is configured by the environment, see the README.\n")
+}
+
+// newFlagSet returns the flags of cmd, which exits with usage errors.
+func newFlagSet(name string) *flag.FlagSet {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			flags := flag.NewFlagSet(name, flag.ExitOnError)
+			flags.Usage = func() {
+				fmt.Fprintf(flags.Output(), "Usage: buildpack-notify %s\n\n%s\n", cmd.usage, cmd.summary)
+				flags.PrintDefaults()
+			}
+			return flags
+		}
+	}
+	panic("Unknown command " + name)
+}
+
+// loadConfig reads the configuration of the run from the environment, along
+// with the reporter of its failures.
+func (c *cli) loadConfig() (notify.Config, notify.CFAPIConfig, error) {
+	var (
+		config      notify.Config
+		cfAPIConfig notify.CFAPIConfig
+	)
+	if err := envconfig.Process("", &config); err != nil {
+		return config, cfAPIConfig, errors.Wrap(err, "Unable to parse config")
+	}
+	if err := envconfig.Process("", &cfAPIConfig); err != nil {
+		return config, cfAPIConfig, errors.Wrap(err, "Unable to parse cf api config")
+	}
+	config.ErrorReporter = c.reporter
+	return config, cfAPIConfig, nil
+}
+
+// loadMailer reads the e-mail configuration from the environment and creates
+// the mailer sending the notifications.
+func loadMailer() (notify.Mailer, error) {
+	var emailConfig notify.EmailConfig
+	if err := envconfig.Process("", &emailConfig); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse email config")
+	}
+	mailer, err := notify.InitSMTPMailer(emailConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create mailer")
+	}
+	return mailer, nil
+}
+
+// patternFlag is a repeatable command line flag of patterns.
+type patternFlag []string
+
+func (f *patternFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *patternFlag) Set(value string) error {
+	if err := notify.ValidatePattern(value); err != nil {
+		return err
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// runFlags are the flags of the commands running the pipeline, narrowing the
+// run to some orgs, spaces and apps and choosing where to report on it and
+// how much to log.
+type runFlags struct {
+	orgs, spaces, apps patternFlag
+	reportJSON         string
+	reportCSV          string
+	decisionTrace      string
+	cohort             int
+	cpuProfile         string
+	heapProfile        string
+	pprofAddr          string
+	logging            *logFlags
+}
+
+func addRunFlags(flags *flag.FlagSet) *runFlags {
+	run := &runFlags{}
+	flags.Var(&run.orgs, "org", "Only consider the apps in this org, by name, GUID, glob or /regexp/. Repeatable.")
+	flags.Var(&run.spaces, "space", "Only consider the apps in this space, by name, GUID, glob or /regexp/. Repeatable.")
+	flags.Var(&run.apps, "app", "Only consider this app, by name, GUID, glob or /regexp/. Repeatable.")
+	flags.StringVar(&run.reportJSON, "report-json", "", "Write what was decided about every app checked to this file as JSON.")
+	flags.StringVar(&run.reportCSV, "report-csv", "", "Write the outdated apps to this file as CSV, a row for each outdated buildpack.")
+	flags.StringVar(&run.decisionTrace, "decision-trace", "", "Write the reasoning behind the decision about every app checked to this file, a line of JSON per app.")
+	flags.IntVar(&run.cohort, "cohort", 0, "Notify this cohort, from 1 to COHORTS, instead of the cohort of the day.")
+	flags.StringVar(&run.cpuProfile, "cpu-profile", "", "Write a CPU profile of the run to this file.")
+	flags.StringVar(&run.heapProfile, "heap-profile", "", "Write a heap profile to this file at the end of the run.")
+	flags.StringVar(&run.pprofAddr, "pprof-addr", "", "Serve net/http/pprof on this address, e.g. localhost:6060, while the run lasts.")
+	run.logging = addLogFlags(flags)
+	return run
+}
+
+// apply configures the run configured by config with the flags.
+func (f *runFlags) apply(config *notify.Config) {
+	config.OnlyOrgs, config.OnlySpaces, config.OnlyApps = f.orgs, f.spaces, f.apps
+	config.ReportJSON, config.ReportCSV = f.reportJSON, f.reportCSV
+	config.DecisionTrace = f.decisionTrace
+	config.Cohort = f.cohort
+	config.CPUProfile, config.HeapProfile, config.PprofAddr = f.cpuProfile, f.heapProfile, f.pprofAddr
+	f.logging.apply(config)
+}
+
+// logFlags are the flags choosing how much a command logs and how, overriding
+// LOG_LEVEL and LOG_FORMAT.
+type logFlags struct {
+	level  string
+	format string
+	quiet  bool
+}
+
+func addLogFlags(flags *flag.FlagSet) *logFlags {
+	logging := &logFlags{}
+	flags.StringVar(&logging.level, "log-level", "", "Log at this level: debug, info, warn or error. Overrides LOG_LEVEL.")
+	flags.BoolVar(&logging.quiet, "quiet", false, "Only log warnings and errors, like --log-level warn.")
+	flags.StringVar(&logging.format, "log-format", "", "Log as text or json. Overrides LOG_FORMAT.")
+	return logging
+}
+
+func (f *logFlags) apply(config *notify.Config) {
+	if f.level != "" {
+		config.LogLevel = f.level
+	}
+	if f.quiet {
+		config.LogLevel = "warn"
+	}
+	if f.format != "" {
+		config.LogFormat = f.format
+	}
+}
+
+// sendFlags are the flags of the commands sending e-mails, resuming a run that
+// died part way through sending them and capping how many are sent.
+type sendFlags struct {
+	resume string
+	limit  int
+}
+
+func addSendFlags(flags *flag.FlagSet) *sendFlags {
+	send := &sendFlags{}
+	flags.StringVar(&send.resume, "resume", "", "Resume the run with this ID, skipping the e-mails its checkpoint in CHECKPOINT_DIR records as sent.")
+	flags.IntVar(&send.limit, "limit", 0, "Send at most this many e-mails about outdated apps, holding back the rest for the next run.")
+	return send
+}
+
+func (f *sendFlags) apply(config *notify.Config) {
+	config.Resume, config.Limit = f.resume, f.limit
+}
+
+func (c *cli) runNotifyCommand(args []string) int {
+	flags := newFlagSet("notify")
+	run := addRunFlags(flags)
+	send := addSendFlags(flags)
+	flags.Parse(args)
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	run.apply(&config)
+	send.apply(&config)
+	mailer, err := loadMailer()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	return notify.ExitCode(notify.Run(config, cfAPIConfig, mailer))
+}
+
+func (c *cli) runReportCommand(args []string) int {
+	flags := newFlagSet("report")
+	run := addRunFlags(flags)
+	list := flags.Bool("list", false, "List the outdated apps and their owners once the run is over, without copying the state or recording anything.")
+	flags.Parse(args)
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	run.apply(&config)
+	// A dry run sends nothing and leaves the state as it was, so a report
+	// doesn't need a mailer.
+	config.DryRun = true
+	config.AutoRestage = false
+	if *list {
+		// Listing has no side effects at all: it doesn't copy the state to
+		// OUT_STATE, which it then doesn't need, nor record the e-mails it
+		// doesn't send, and lists the apps --limit would hold back too.
+		config.ReadOnly = true
+		config.NotificationAuditLog = ""
+		config.Limit = 0
+		config.ListOutdated = os.Stdout
+	}
+	return notify.ExitCode(notify.Run(config, cfAPIConfig, nil))
+}
+
+func (c *cli) runRestageCommand(args []string) int {
+	flags := newFlagSet("restage")
+	run := addRunFlags(flags)
+	send := addSendFlags(flags)
+	flags.Parse(args)
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	run.apply(&config)
+	send.apply(&config)
+	config.AutoRestage = true
+	mailer, err := loadMailer()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	return notify.ExitCode(notify.Run(config, cfAPIConfig, mailer))
+}
+
+// runListOutdatedCommand is report --list.
+func (c *cli) runListOutdatedCommand(args []string) int {
+	return c.runReportCommand(append([]string{"--list"}, args...))
+}
+
+func (c *cli) runEOLReportCommand(args []string) int {
+	flags := newFlagSet("eol-report")
+	run := addRunFlags(flags)
+	csvPath := flags.String("csv", "", "Write the affected apps to this file as CSV instead, a row for each end of support date.")
+	flags.Parse(args)
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	run.apply(&config)
+	config.ReadOnly = true
+	return notify.ExitCode(notify.EOLReport(config, cfAPIConfig, *csvPath, os.Stdout))
+}
+
+func (c *cli) runCheckAppCommand(args []string) int {
+	flags := newFlagSet("check-app")
+	guid := flags.String("app-guid", "", "The GUID of the app to check.")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if *guid == "" {
+		flags.Usage()
+		return notify.ExitUsage
+	}
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	logFlags.apply(&config)
+	config.ReadOnly = true
+	return notify.ExitCode(notify.CheckApp(config, cfAPIConfig, *guid, os.Stdout))
+}
+
+func (c *cli) runSmokeTestCommand(args []string) int {
+	flags := newFlagSet("smoke-test")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	logFlags.apply(&config)
+	config.ReadOnly = true
+	var smokeConfig notify.SmokeTestConfig
+	if err := envconfig.Process("", &smokeConfig); err != nil {
+		return c.exitf(notify.ExitConfig, "Unable to parse smoke test config: %s", err)
+	}
+	mailer, err := loadMailer()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	return notify.ExitCode(notify.SmokeTest(config, cfAPIConfig, smokeConfig, mailer, os.Stdout))
+}
+
+func (c *cli) runResendFailuresCommand(args []string) int {
+	flags := newFlagSet("resend-failures")
+	runID := flags.String("run", "", "The ID of the run whose failed e-mails are sent again.")
+	flags.Parse(args)
+	if *runID == "" {
+		flags.Usage()
+		return notify.ExitUsage
+	}
+	dir := os.Getenv("CHECKPOINT_DIR")
+	if dir == "" {
+		return c.exitf(notify.ExitConfig, "Re-sending e-mails needs CHECKPOINT_DIR.")
+	}
+	mailer, err := loadMailer()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	config := notify.Config{CheckpointDir: dir, Logger: c.logger, ErrorReporter: c.reporter}
+	return notify.ExitCode(notify.ResendFailures(config, *runID, mailer))
+}
+
+func (c *cli) runExportCommand(args []string) int {
+	flags := newFlagSet("export")
+	path := flags.String("fixtures", "", "Write the fixtures to this file.")
+	logFlags := addLogFlags(flags)
+	flags.Parse(args)
+	if *path == "" {
+		flags.Usage()
+		return notify.ExitUsage
+	}
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	logFlags.apply(&config)
+	config.ReadOnly = true
+	return notify.ExitCode(notify.ExportFixtures(config, cfAPIConfig, *path))
+}
+
+func (c *cli) runGenerateFixturesCommand(args []string) int {
+	flags := newFlagSet("generate-fixtures")
+	path := flags.String("fixtures", "", "Write the fixtures to this file.")
+	var spec notify.FixtureSpec
+	flags.IntVar(&spec.Orgs, "orgs", 10, "How many orgs to generate.")
+	flags.IntVar(&spec.SpacesPerOrg, "spaces", 3, "How many spaces to generate in every org.")
+	flags.IntVar(&spec.AppsPerSpace, "apps", 5, "How many apps to generate in every space.")
+	flags.Float64Var(&spec.OutdatedRatio, "outdated-ratio", 0.3, "The fraction of apps staged before their buildpack was last updated, from 0 to 1.")
+	flags.Int64Var(&spec.Seed, "seed", 1, "Generate the foundation picked by this seed, the same for the same seed.")
+	flags.Parse(args)
+	if *path == "" || spec.Orgs < 0 || spec.SpacesPerOrg < 0 || spec.AppsPerSpace < 0 || spec.OutdatedRatio < 0 || spec.OutdatedRatio > 1 {
+		flags.Usage()
+		return notify.ExitUsage
+	}
+	if err := notify.GenerateFixtures(spec, *path, c.logger); err != nil {
+		return c.exitf(notify.ExitCode(err), "%s", err)
+	}
+	return notify.ExitOK
+}
+
+func (c *cli) runSimulateCommand(args []string) int {
+	flags := newFlagSet("simulate")
+	path := flags.String("fixtures", "", "Read the CF API resources from this file, written by export.")
+	emails := flags.String("emails", "", "Write every e-mail the run would send to a file in this directory.")
+	run := addRunFlags(flags)
+	flags.Parse(args)
+	if *path == "" {
+		flags.Usage()
+		return notify.ExitUsage
+	}
+	config, cfAPIConfig, err := c.loadConfig()
+	if err != nil {
+		return c.exitf(notify.ExitConfig, "%s", err)
+	}
+	run.apply(&config)
+	return notify.ExitCode(notify.Simulate(config, cfAPIConfig, *path, *emails))
+}
+
+func (c *cli) runRenderPreviewsCommand(args []string) int {
+	flags := newFlagSet("render-previews")
+	dir := flags.String("dir", "previews", "Write the previews to this directory.")
+	flags.Parse(args)
+	// The campaign template of the configuration is previewed if there is
+	// one, like the rest of the templates.
+	rendered, err := notify.RenderPreviews(os.Getenv("CAMPAIGN_TEMPLATE"), *dir)
+	if err != nil {
+		return c.exitf(notify.ExitCode(err), "%s", err)
+	}
+	fmt.Printf("Rendered %d previews to %s.\n", rendered, filepath.Join(*dir, "index.html"))
+	return notify.ExitOK
+}
+
+func (c *cli) runStateCommand(args []string) int {
+	flags := newFlagSet("state")
+	flags.Parse(args)
+	var err error
+	switch {
+	case flags.Arg(0) == "show" && flags.NArg() <= 2:
+		// The state at IN_STATE is shown without a path.
+		path := flags.Arg(1)
+		if path == "" {
+			path = os.Getenv("IN_STATE")
+		}
+		if path == "" {
+			fmt.Fprintln(os.Stderr, "A state path or IN_STATE is required.")
+			return notify.ExitUsage
+		}
+		err = notify.ShowState(path, os.Stdout)
+	case flags.Arg(0) == "diff" && flags.NArg() == 3:
+		err = notify.DiffStates(flags.Arg(1), flags.Arg(2), os.Stdout)
+	default:
+		flags.Usage()
+		return notify.ExitUsage
+	}
+	if err != nil {
+		return c.exitf(notify.ExitCode(err), "%s", err)
+	}
+	return notify.ExitOK
+}
+
+func (c *cli) runHistoryCommand(args []string) int {
+	flags := newFlagSet("history")
+	runs := flags.Int("runs", 10, "How many of the last runs to print, or 0 for every run.")
+	flags.Parse(args)
+	path := os.Getenv("HISTORY_FILE")
+	if path == "" {
+		return c.exitf(notify.ExitConfig, "Printing the history needs HISTORY_FILE.")
+	}
+	if err := notify.ShowHistory(path, *runs, os.Stdout); err != nil {
+		return c.exitf(notify.ExitCode(err), "%s", err)
+	}
+	return notify.ExitOK
+}
+
+func (c *cli) runVersionCommand(args []string) int {
+	newFlagSet("version").Parse(args)
+	notify.WriteVersion(os.Stdout)
+	return notify.ExitOK
+}
+
+func (c *cli) runValidateCommand(args []string) int {
+	newFlagSet("validate").Parse(args)
+	return printProblems(validateConfig())
+}
+
+func (c *cli) runValidateConfigCommand(args []string) int {
+	newFlagSet("validate-config").Parse(args)
+	problems := validateConfig()
+	if len(problems) == 0 {
+		problems = checkConnections()
+	}
+	return printProblems(problems)
+}
+
+// printProblems lists problems with the configuration and returns the exit
+// code of the command checking it.
+func printProblems(problems []error) int {
+	if len(problems) == 0 {
+		fmt.Println("The configuration is valid.")
+		return notify.ExitOK
+	}
+	fmt.Println("The configuration has problems:")
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+	return notify.ExitConfig
+}
+
+// validateConfig returns every problem with the configuration in the
+// environment, without connecting to anything.
+func validateConfig() []error {
+	var (
+		config      notify.Config
+		emailConfig notify.EmailConfig
+		cfAPIConfig notify.CFAPIConfig
+		problems    []error
+	)
+	configErr := envconfig.Process("", &config)
+	if configErr != nil {
+		problems = append(problems, errors.Wrap(configErr, "Unable to parse config"))
+	}
+	if err := envconfig.Process("", &emailConfig); err != nil {
+		problems = append(problems, errors.Wrap(err, "Unable to parse email config"))
+	}
+	cfAPIConfigErr := envconfig.Process("", &cfAPIConfig)
+	if cfAPIConfigErr != nil {
+		problems = append(problems, errors.Wrap(cfAPIConfigErr, "Unable to parse cf api config"))
+	}
+	if configErr != nil || cfAPIConfigErr != nil {
+		return problems
+	}
+	return append(problems, notify.ValidateConfig(config, cfAPIConfig)...)
+}
+
+// checkConnections returns every problem reaching the CF API, the SMTP server
+// and the state files with the valid configuration in the environment.
+func checkConnections() []error {
+	var (
+		config      notify.Config
+		emailConfig notify.EmailConfig
+		cfAPIConfig notify.CFAPIConfig
+	)
+	envconfig.Process("", &config)
+	envconfig.Process("", &emailConfig)
+	envconfig.Process("", &cfAPIConfig)
+	return notify.CheckConnections(config, emailConfig, cfAPIConfig)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cloud-gov/buildpack-notify/pkg/notify"
+)
+
+func setTestConfigEnv(t *testing.T) {
+	for key, value := range map[string]string{
+		"IN_STATE":      "in.json",
+		"OUT_STATE":     "out.json",
+		"SMTP_FROM":     "no-reply@example.com",
+		"SMTP_HOST":     "smtp.example.com",
+		"SMTP_PASSWORD": "password",
+		"SMTP_PORT":     "587",
+		"SMTP_USER":     "user",
+		"CF_API":        "https://api.example.com",
+		"CLIENT_ID":     "buildpack-notify",
+		"CLIENT_SECRET": "secret",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	setTestConfigEnv(t)
+	if problems := validateConfig(); len(problems) != 0 {
+		t.Errorf("Expected a valid configuration, found %v", problems)
+	}
+
+	// Every problem is listed, not only the first.
+	t.Setenv("OWNER_ROLES", "space_janitor")
+	t.Setenv("RESTAGE_WINDOWS", "* * *")
+	t.Setenv("AUTO_RESTAGE", "true")
+	os.Unsetenv("SMTP_HOST")
+	os.Unsetenv("CF_API")
+	problems := validateConfig()
+	if len(problems) != 4 || !strings.Contains(problems[0].Error(), "SMTP_HOST") || !strings.Contains(problems[1].Error(), "CF_API") {
+		t.Errorf("Expected the missing e-mail and cf api config, found %v", problems)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(`{"Buildpacks": {}}`), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	setTestConfigEnv(t)
+	testCases := []struct {
+		name         string
+		args         []string
+		expectedCode int
+	}{
+		{"help", []string{"help"}, notify.ExitOK},
+		{"version", []string{"version"}, notify.ExitOK},
+		{"unknown command", []string{"notfy"}, notify.ExitUsage},
+		{"state without subcommand", []string{"state"}, notify.ExitUsage},
+		{"state show", []string{"state", "show", statePath}, notify.ExitOK},
+		{"state show missing file", []string{"state", "show", filepath.Join(dir, "missing.json")}, notify.ExitFailed},
+		{"state diff", []string{"state", "diff", statePath, statePath}, notify.ExitOK},
+		{"state diff without new state", []string{"state", "diff", statePath}, notify.ExitUsage},
+		{"generate-fixtures with an invalid ratio", []string{"generate-fixtures", "--fixtures", filepath.Join(dir, "fixtures.json"), "--outdated-ratio", "2"}, notify.ExitUsage},
+		{"smoke-test without a designated app", []string{"smoke-test"}, notify.ExitConfig},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := (&cli{}).runCommand(tc.args); code != tc.expectedCode {
+				t.Errorf("Test %s failed. Expected exit code %d, found %d", tc.name, tc.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestRunFlags(t *testing.T) {
+	flags := newFlagSet("notify")
+	run := addRunFlags(flags)
+	if err := flags.Parse([]string{"--org", "sandbox", "--org", "/^agency-/", "--app", "my-app", "--report-json", "report.json", "--report-csv", "report.csv", "--quiet"}); err != nil {
+		t.Fatalf("Unable to parse flags. Error: %s", err)
+	}
+	var config notify.Config
+	run.apply(&config)
+	if !reflect.DeepEqual(config.OnlyOrgs, []string{"sandbox", "/^agency-/"}) || len(config.OnlySpaces) != 0 || !reflect.DeepEqual(config.OnlyApps, []string{"my-app"}) {
+		t.Errorf("Expected the run to be narrowed to two orgs and an app, found %v %v %v", config.OnlyOrgs, config.OnlySpaces, config.OnlyApps)
+	}
+	if config.ReportJSON != "report.json" || config.ReportCSV != "report.csv" {
+		t.Errorf("Expected the reports to be written to report.json and report.csv, found %q and %q", config.ReportJSON, config.ReportCSV)
+	}
+	if config.LogLevel != "warn" {
+		t.Errorf("Expected --quiet to log at warn, found %q", config.LogLevel)
+	}
+	var patterns patternFlag
+	if err := patterns.Set("/(/"); err == nil {
+		t.Errorf("Expected a pattern that doesn't parse to be rejected")
+	}
+}
@@ -0,0 +1,241 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// Options are the settings of a Notifier. The zero value finds outdated apps
+// one droplet at a time and notifies their space developers and managers, one
+// e-mail at a time, linking to the release notes of the system buildpacks.
+type Options struct {
+	// ListOptions page through the lists of the CF API.
+	ListOptions ListOptions
+	// DropletConcurrency bounds the droplets looked up at a time, and
+	// RoleConcurrency the requests listing roles. Zero is one at a time.
+	DropletConcurrency int
+	RoleConcurrency    int
+	// ClockSkewTolerance is how long after a buildpack update a droplet
+	// staged earlier is still counted as staged with the update, for the
+	// clocks of the CF API and the buildpack upload disagreeing.
+	ClockSkewTolerance time.Duration
+	// IncludeDisabledBuildpacks checks the apps staged with disabled
+	// buildpacks too, which are skipped since they can't be restaged.
+	IncludeDisabledBuildpacks bool
+	// OwnerRoles are the role types whose holders own the apps of a space,
+	// e.g. space_developer or organization_manager for every space of the
+	// org. Zero is space_manager and space_developer.
+	OwnerRoles []string
+	// ResolveEmailsViaUAA looks up in UAA the e-mail address of the owners
+	// whose username isn't one, who are skipped otherwise.
+	ResolveEmailsViaUAA bool
+	// EmailConcurrency bounds the e-mails sent at a time. Zero is one at a
+	// time.
+	EmailConcurrency int
+	// ReleaseURLs are the release notes pages of the buildpacks by name,
+	// which e-mails link to. Nil is the pages of the system buildpacks.
+	ReleaseURLs map[string]string
+	// Docs are the documentation pages of the buildpacks by name, which
+	// e-mails link to as well.
+	Docs map[string][]DocLink
+	// Logger logs what the Notifier finds and sends, if set.
+	Logger *Logger
+}
+
+// Notifier finds the apps of a foundation staged with outdated buildpacks,
+// resolves their owners and notifies them, the way a buildpack-notify run
+// does for the buildpacks updated since the last run.
+type Notifier struct {
+	client  *cfclient.Client
+	options Options
+	owners  ownerSettings
+	links   buildpackLinks
+	// relations keeps the spaces listed along with the apps, so that the
+	// owners of the apps are found without requesting the spaces again.
+	relations *relationships
+}
+
+// OutdatedApp is an app staged with buildpacks updated since, along with the
+// space and org it is in.
+type OutdatedApp struct {
+	App          App
+	Space        Space
+	Organization Organization
+	// StagedAt is when the droplet the app runs was staged.
+	StagedAt   string
+	Buildpacks []OutdatedBuildpack
+	info       appInfo
+}
+
+// OutdatedBuildpack is a buildpack an app was staged with before its latest
+// version was installed.
+type OutdatedBuildpack struct {
+	Name string
+	// CurrentVersion is the version the app was staged with, if its droplet
+	// records it, and LatestVersion the version installed.
+	CurrentVersion string
+	LatestVersion  string
+	// ReleaseURL is the release notes page of LatestVersion, if known.
+	ReleaseURL string
+}
+
+// NewClient returns a client of the CF API set up the way the runs of
+// buildpack-notify set it up, retrying failed requests and renewing tokens.
+// config is usually read from the environment with envconfig.
+func NewClient(config CFAPIConfig) (*cfclient.Client, error) {
+	transport, err := config.transportOptions(false)
+	if err != nil {
+		return nil, err
+	}
+	client, _, err := newCFClient(config, transport, false, nil)
+	return client, err
+}
+
+// NewNotifier returns a Notifier working through client.
+func NewNotifier(client *cfclient.Client, options Options) (*Notifier, error) {
+	roleTypes := options.OwnerRoles
+	if len(roleTypes) == 0 {
+		roleTypes = []string{"space_manager", "space_developer"}
+	}
+	roles, err := newOwnerRoles(roleTypes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid owner roles")
+	}
+	if options.ListOptions.Logger == nil {
+		options.ListOptions.Logger = options.Logger
+	}
+	links := buildpackLinks{releaseURLs: options.ReleaseURLs, docs: options.Docs}
+	if links.releaseURLs == nil {
+		links.releaseURLs = defaultBuildpackReleaseURLs
+	}
+	return &Notifier{
+		client:    client,
+		options:   options,
+		owners:    ownerSettings{roles: roles, resolveEmailsViaUAA: options.ResolveEmailsViaUAA, concurrency: options.RoleConcurrency},
+		links:     links,
+		relations: newRelationships(),
+	}, nil
+}
+
+// Buildpacks lists the buildpacks of the foundation, leaving out the disabled
+// ones unless IncludeDisabledBuildpacks is set.
+func (n *Notifier) Buildpacks() ([]Buildpack, error) {
+	buildpacks, err := ListBuildpacks(n.client, n.options.ListOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get buildpacks")
+	}
+	if !n.options.IncludeDisabledBuildpacks {
+		buildpacks, _ = splitDisabledBuildpacks(buildpacks, n.options.Logger)
+	}
+	return buildpacks, nil
+}
+
+// FindOutdatedApps returns the started apps of the foundation staged before
+// the last update of any of buildpacks, e.g. the buildpacks updated since a
+// given time, or all of them for every app that isn't up to date. Apps that
+// couldn't be checked are left out and make up the error, along with the
+// apps that could.
+func (n *Notifier) FindOutdatedApps(buildpacks []Buildpack) ([]OutdatedApp, error) {
+	byName := make(map[string]Buildpack, len(buildpacks))
+	for _, buildpack := range buildpacks {
+		byName[buildpack.Name] = buildpack
+	}
+	errs := &runErrors{logger: n.options.Logger}
+	report := n.newReport()
+	var outdated []appInfo
+	_, err := listAppsWithSpacesByPage(n.client, n.options.ListOptions, func(apps []App, spaces map[string]spaceInfo) {
+		n.relations.addSpaces(spaces)
+		found, _, _ := findOutdatedApps(n.client, apps, byName, nil, n.links, n.options.ClockSkewTolerance, n.options.DropletConcurrency, report, errs, n.options.Logger)
+		for _, info := range found {
+			space := spaces[info.Relationships.Space.Data.GUID]
+			info.Space, info.Org = space.Space, space.Org
+			outdated = append(outdated, info)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	apps := make([]OutdatedApp, 0, len(outdated))
+	for _, info := range outdated {
+		apps = append(apps, newOutdatedApp(info))
+	}
+	return apps, errs.err()
+}
+
+// FindOwners returns the apps owned by each of their owners, by e-mail
+// address. Apps whose owners couldn't be found make up the error, along with
+// the owners that could.
+func (n *Notifier) FindOwners(apps []OutdatedApp) (map[string][]OutdatedApp, error) {
+	errs := &runErrors{logger: n.options.Logger}
+	settings := n.owners
+	settings.report = n.newReport()
+	owners := make(map[string][]OutdatedApp)
+	for owner, infos := range findOwnersOfApps(appInfos(apps), n.client, settings, errs, n.options.Logger) {
+		for _, info := range infos {
+			owners[owner] = append(owners[owner], newOutdatedApp(info))
+		}
+	}
+	return owners, errs.err()
+}
+
+// Notify sends every owner the e-mail telling them to restage their outdated
+// apps, through mailer. The e-mails that couldn't be sent make up the error.
+func (n *Notifier) Notify(owners map[string][]OutdatedApp, mailer Mailer) error {
+	templates, err := initTemplates()
+	if err != nil {
+		return errors.Wrap(err, "Unable to initialize templates")
+	}
+	errs := &runErrors{logger: n.options.Logger}
+	users := make(map[string][]appInfo, len(owners))
+	for owner, apps := range owners {
+		users[owner] = appInfos(apps)
+	}
+	sendNotifyEmailToUsers(users, templates, mailer, n.options.EmailConcurrency, false, nil, errs, n.options.Logger)
+	return errs.err()
+}
+
+// newReport returns the report a lookup records its decisions in, resolving
+// apps to the spaces already listed.
+func (n *Notifier) newReport() *runReport {
+	report := newRunReport()
+	report.relationships = n.relations
+	return report
+}
+
+func newOutdatedApp(info appInfo) OutdatedApp {
+	app := OutdatedApp{App: info.App, Space: info.Space, Organization: info.Org, StagedAt: info.StagedAt, info: info}
+	for _, buildpack := range info.Buildpacks {
+		app.Buildpacks = append(app.Buildpacks, OutdatedBuildpack{
+			Name:           buildpack.BuildpackName,
+			CurrentVersion: buildpack.CurrentVersion,
+			LatestVersion:  buildpack.BuildpackVersion,
+			ReleaseURL:     buildpack.BuildpackURL,
+		})
+	}
+	return app
+}
+
+// appInfos returns the apps the way the pipeline keeps them, for apps
+// returned by FindOutdatedApps or made up by the caller.
+func appInfos(apps []OutdatedApp) []appInfo {
+	infos := make([]appInfo, 0, len(apps))
+	for _, app := range apps {
+		info := app.info
+		if info.GUID == "" {
+			info = appInfo{App: app.App, StagedAt: app.StagedAt}
+			for _, buildpack := range app.Buildpacks {
+				info.Buildpacks = append(info.Buildpacks, buildpackReleaseInfo{
+					BuildpackName:    buildpack.Name,
+					BuildpackVersion: buildpack.LatestVersion,
+					BuildpackURL:     buildpack.ReleaseURL,
+					CurrentVersion:   buildpack.CurrentVersion,
+				})
+			}
+		}
+		info.Space, info.Org = app.Space, app.Organization
+		infos = append(infos, info)
+	}
+	return infos
+}
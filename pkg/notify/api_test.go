@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotifierAgainstFakeCFAPI(t *testing.T) {
+	api := newFakeCFAPI(t, newTestFixtures())
+	client, err := NewClient(CFAPIConfig{API: api.URL, ClientID: api.clientID, ClientSecret: api.clientSecret})
+	if err != nil {
+		t.Fatalf("Unable to create client. Error: %s", err)
+	}
+	notifier, err := NewNotifier(client, Options{ListOptions: ListOptions{PerPage: 100}, OwnerRoles: []string{"space_developer"}})
+	if err != nil {
+		t.Fatalf("Unable to create notifier. Error: %s", err)
+	}
+	buildpacks, err := notifier.Buildpacks()
+	if err != nil || len(buildpacks) != 1 {
+		t.Fatalf("Expected the python buildpack, found %+v %v", buildpacks, err)
+	}
+	apps, err := notifier.FindOutdatedApps(buildpacks)
+	if err != nil {
+		t.Fatalf("Unable to find outdated apps. Error: %s", err)
+	}
+	expected := OutdatedBuildpack{Name: "python_buildpack", CurrentVersion: "1.7.40", LatestVersion: "v1.8.0", ReleaseURL: getBuildpackVersionURL(defaultBuildpackLinks.releaseURL("python_buildpack"), "v1.8.0")}
+	if len(apps) != 1 || apps[0].App.GUID != "app1" || apps[0].Space.Name != "dev" || apps[0].Organization.Name != "agency" || len(apps[0].Buildpacks) != 1 || apps[0].Buildpacks[0] != expected {
+		t.Fatalf("Expected app1 in agency/dev to be outdated, found %+v", apps)
+	}
+	owners, err := notifier.FindOwners(apps)
+	if err != nil {
+		t.Fatalf("Unable to find owners. Error: %s", err)
+	}
+	if len(owners) != 1 || len(owners[user1]) != 1 {
+		t.Fatalf("Expected the space developer %s to own app1, found %+v", user1, owners)
+	}
+	mailer := newMemoryMailer()
+	if err := notifier.Notify(owners, mailer); err != nil {
+		t.Fatalf("Unable to notify owners. Error: %s", err)
+	}
+	sent := mailer.emails()
+	if len(sent) != 1 || sent[0].To != user1 || !strings.Contains(sent[0].Body, "cf restage --strategy rolling app1") {
+		t.Errorf("Expected %s to be told to restage app1, found %+v", user1, sent)
+	}
+}
+
+func TestNotifierLinksToConfiguredPages(t *testing.T) {
+	api := newFakeCFAPI(t, newTestFixtures())
+	client, err := NewClient(CFAPIConfig{API: api.URL, ClientID: api.clientID, ClientSecret: api.clientSecret})
+	if err != nil {
+		t.Fatalf("Unable to create client. Error: %s", err)
+	}
+	notifier, err := NewNotifier(client, Options{
+		OwnerRoles:       []string{"space_developer"},
+		EmailConcurrency: 2,
+		ReleaseURLs:      map[string]string{"python_buildpack": "https://example.com/python-buildpack/releases"},
+		Docs:             map[string][]DocLink{"python_buildpack": {{Title: "Restaging Python apps", URL: "https://cloud.gov/docs/python/"}}},
+	})
+	if err != nil {
+		t.Fatalf("Unable to create notifier. Error: %s", err)
+	}
+	buildpacks, err := notifier.Buildpacks()
+	if err != nil {
+		t.Fatalf("Unable to get buildpacks. Error: %s", err)
+	}
+	apps, err := notifier.FindOutdatedApps(buildpacks)
+	if err != nil || len(apps) != 1 || len(apps[0].Buildpacks) != 1 {
+		t.Fatalf("Expected app1 to be outdated, found %+v %v", apps, err)
+	}
+	if releaseURL := apps[0].Buildpacks[0].ReleaseURL; !strings.HasPrefix(releaseURL, "https://example.com/python-buildpack/releases") {
+		t.Errorf("Expected the release notes page given in the options, found %q", releaseURL)
+	}
+	owners, err := notifier.FindOwners(apps)
+	if err != nil {
+		t.Fatalf("Unable to find owners. Error: %s", err)
+	}
+	mailer := newMemoryMailer()
+	if err := notifier.Notify(owners, mailer); err != nil {
+		t.Fatalf("Unable to notify owners. Error: %s", err)
+	}
+	if sent := mailer.emails(); len(sent) != 1 || !strings.Contains(sent[0].Body, "https://cloud.gov/docs/python/") {
+		t.Errorf("Expected the e-mail to link to the docs given in the options, found %+v", sent)
+	}
+}
+
+func TestNotifierOwnersOfAppsMadeUp(t *testing.T) {
+	api := newFakeCFAPI(t, newTestFixtures())
+	client, err := NewClient(CFAPIConfig{API: api.URL, ClientID: api.clientID, ClientSecret: api.clientSecret})
+	if err != nil {
+		t.Fatalf("Unable to create client. Error: %s", err)
+	}
+	notifier, err := NewNotifier(client, Options{OwnerRoles: []string{"organization_manager"}})
+	if err != nil {
+		t.Fatalf("Unable to create notifier. Error: %s", err)
+	}
+	// Apps that weren't found by the notifier are resolved to their space
+	// and org.
+	app := OutdatedApp{App: newTestApp("app1", "space1")}
+	owners, err := notifier.FindOwners([]OutdatedApp{app})
+	if err != nil {
+		t.Fatalf("Unable to find owners. Error: %s", err)
+	}
+	if len(owners[user2]) != 1 || owners[user2][0].Organization.Name != "agency" {
+		t.Errorf("Expected the org manager %s to own app1 in agency, found %+v", user2, owners)
+	}
+}
+
+func TestNewNotifierRejectsUnknownRoles(t *testing.T) {
+	if _, err := NewNotifier(nil, Options{OwnerRoles: []string{"space_owner"}}); err == nil || !strings.Contains(err.Error(), "space_owner") {
+		t.Errorf("Expected an unknown owner role to be an error, found %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// restagePlan is the restages awaiting an operator's approval. Its token
+// identifies exactly which droplets of which apps are to be restaged, so that
+// approving a plan never approves restages the operator wasn't shown.
+type restagePlan struct {
+	Token     string
+	CreatedAt string
+	Restages  map[string]queuedRestage
+}
+
+// restageApproval holds restages back until an operator approves the plan of
+// them by passing its token to a following run.
+type restageApproval struct {
+	// token is the token passed to this run, if any.
+	token  string
+	logger *Logger
+	// plan is the plan awaiting approval, which changed if it needs posting
+	// to the operators again.
+	plan    *restagePlan
+	changed bool
+}
+
+// approve returns the apps whose restage is approved. Without the token of
+// the plan of apps, along with the apps of the pending plan that still run the
+// same droplet, it returns none and plans them for approval instead.
+func (a *restageApproval) approve(apps []appInfo, checked []appInfo, now time.Time) []appInfo {
+	current := make(map[string]appInfo)
+	for _, app := range checked {
+		current[app.GUID] = app
+	}
+	planned := make(map[string]appInfo)
+	restages := make(map[string]queuedRestage)
+	if a.plan != nil {
+		for guid, restage := range a.plan.Restages {
+			app, found := current[guid]
+			if !found || app.DropletGUID != restage.DropletGUID {
+				continue
+			}
+			app.Buildpacks = restage.Buildpacks
+			planned[guid] = app
+			restages[guid] = restage
+		}
+	}
+	for _, app := range apps {
+		if _, found := restages[app.GUID]; !found {
+			planned[app.GUID] = app
+			restages[app.GUID] = newQueuedRestage(app, now)
+		}
+	}
+	if len(restages) == 0 {
+		a.changed = a.plan != nil
+		a.plan = nil
+		return nil
+	}
+	token := restagePlanToken(restages)
+	if a.token != "" && a.token == token {
+		a.logger.infof("Restage plan %s was approved, restaging its %d apps.\n", token, len(restages))
+		a.changed = true
+		a.plan = nil
+		return sortedApps(planned)
+	}
+	if a.token != "" {
+		a.logger.warnf("Restage approval token %s doesn't match the restage plan %s, which is left pending.\n", a.token, token)
+	}
+	if a.plan != nil && a.plan.Token == token {
+		a.logger.infof("Restage plan %s of %d apps is still awaiting approval.\n", token, len(restages))
+		return nil
+	}
+	a.logger.infof("Planned %d restages awaiting approval with token %s.\n", len(restages), token)
+	a.plan = &restagePlan{Token: token, CreatedAt: now.UTC().Format(time.RFC3339), Restages: restages}
+	a.changed = true
+	return nil
+}
+
+// restagePlanToken derives the token of a plan from the app and droplet GUIDs
+// of its restages.
+func restagePlanToken(restages map[string]queuedRestage) string {
+	guids := make([]string, 0, len(restages))
+	for guid := range restages {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	hash := sha256.New()
+	for _, guid := range guids {
+		fmt.Fprintf(hash, "%s:%s\n", guid, restages[guid].DropletGUID)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// sortedApps returns apps sorted by name, then GUID.
+func sortedApps(apps map[string]appInfo) []appInfo {
+	sorted := make([]appInfo, 0, len(apps))
+	for _, app := range apps {
+		sorted = append(sorted, app)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].GUID < sorted[j].GUID
+	})
+	return sorted
+}
+
+// sendRestagePlanEmails posts plan to the operators at recipients, asking
+// them to approve it.
+func sendRestagePlanEmails(plan *restagePlan, spaces map[string]spaceInfo, recipients []string, templates *Templates, mailer Mailer, dryRun bool, errs *runErrors, logger *Logger) {
+	planned := make(map[string]appInfo)
+	for guid, restage := range plan.Restages {
+		app := appInfo{App: App{GUID: guid, Name: restage.Name}, DropletGUID: restage.DropletGUID}
+		space := spaces[restage.SpaceGUID]
+		app.Space, app.Org = space.Space, space.Org
+		planned[guid] = app
+	}
+	body := new(bytes.Buffer)
+	if err := templates.getRestagePlanEmail(body, restagePlanEmail{plan.Token, sortedApps(planned)}); err != nil {
+		errs.addf("Unable to render restage plan e-mail. Error: %s", err)
+		return
+	}
+	for _, recipient := range recipients {
+		if !dryRun {
+			subj := fmt.Sprintf("Approval needed to restage %d apps", len(plan.Restages))
+			if err := mailer.SendEmail(recipient, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", recipient, err)
+				continue
+			}
+		}
+		logger.with(logFields{"recipient", recipient}).infof("Sent restage plan e-mail to %s\n", recipient)
+	}
+}
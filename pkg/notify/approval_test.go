@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestageApproval(t *testing.T) {
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	apps := []appInfo{
+		{App: App{GUID: "app1", Name: "app1"}, DropletGUID: "droplet1"},
+		{App: App{GUID: "app2", Name: "app2"}, DropletGUID: "droplet2"},
+	}
+	approval := &restageApproval{}
+	if approved := approval.approve(apps, apps, now); len(approved) != 0 {
+		t.Errorf("Expected no restages without a token, found %+v", approved)
+	}
+	if !approval.changed || approval.plan == nil || len(approval.plan.Restages) != 2 {
+		t.Fatalf("Expected both apps to be planned, found %+v", approval.plan)
+	}
+	firstToken := approval.plan.Token
+
+	// A wrong token approves nothing and leaves the plan as it was.
+	approval = &restageApproval{token: "wrong", plan: approval.plan}
+	if approved := approval.approve(apps, apps, now.Add(time.Hour)); len(approved) != 0 {
+		t.Errorf("Expected no restages with a wrong token, found %+v", approved)
+	}
+	if approval.changed || approval.plan.Token != firstToken || approval.plan.CreatedAt != "2020-02-01T00:00:00Z" {
+		t.Errorf("Expected the plan to be unchanged, found %+v", approval.plan)
+	}
+
+	// Once app2 is restaged by its owners, the first token no longer matches.
+	checked := []appInfo{apps[0], {App: App{GUID: "app2", Name: "app2"}, DropletGUID: "new-droplet"}}
+	approval = &restageApproval{token: firstToken, plan: approval.plan}
+	if approved := approval.approve(nil, checked, now.Add(time.Hour)); len(approved) != 0 {
+		t.Errorf("Expected no restages with an outdated token, found %+v", approved)
+	}
+	if !approval.changed || approval.plan.Token == firstToken || len(approval.plan.Restages) != 1 {
+		t.Fatalf("Expected a new plan of app1 only, found %+v", approval.plan)
+	}
+
+	approval = &restageApproval{token: approval.plan.Token, plan: approval.plan}
+	approved := approval.approve(nil, checked, now.Add(2*time.Hour))
+	if len(approved) != 1 || approved[0].GUID != "app1" {
+		t.Errorf("Expected app1 to be approved, found %+v", approved)
+	}
+	if approval.plan != nil {
+		t.Errorf("Expected the approved plan to be done with, found %+v", approval.plan)
+	}
+}
@@ -0,0 +1,115 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditActor identifies this tool as the one restaging apps in the audit log.
+const auditActor = "buildpack-notify"
+
+// The actions recorded in the restage audit log.
+const (
+	restageAttempted  = "attempted"
+	restageSucceeded  = "succeeded"
+	restageFailed     = "failed"
+	restageRolledBack = "rolled_back"
+)
+
+// restageAuditRecord is a line of the restage audit log.
+type restageAuditRecord struct {
+	Time              string `json:"time"`
+	Actor             string `json:"actor"`
+	RunID             string `json:"run_id"`
+	Version           string `json:"version"`
+	GitSHA            string `json:"git_sha,omitempty"`
+	Action            string `json:"action"`
+	AppGUID           string `json:"app_guid"`
+	AppName           string `json:"app_name"`
+	Org               string `json:"org"`
+	Space             string `json:"space"`
+	BeforeDropletGUID string `json:"before_droplet_guid"`
+	AfterDropletGUID  string `json:"after_droplet_guid,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// restageAuditLog records every automated restage as a line of JSON, for
+// change management records. A nil log records nothing.
+type restageAuditLog struct {
+	mu    sync.Mutex
+	w     io.Writer
+	runID string
+	build buildInfo
+	now   func() time.Time
+}
+
+// openRestageAuditLog opens the audit log at path, appending to it so that it
+// builds up across runs.
+func openRestageAuditLog(path string, runID string) (*restageAuditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &restageAuditLog{w: f, runID: runID, build: currentBuild(), now: time.Now}, nil
+}
+
+// close closes the file the log is written to, if any.
+func (l *restageAuditLog) close() error {
+	if l == nil {
+		return nil
+	}
+	if closer, ok := l.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// record records that action happened to the restage of app, which is on
+// afterDropletGUID afterwards.
+func (l *restageAuditLog) record(app appInfo, action string, afterDropletGUID string, restageErr error, errs *runErrors) {
+	if l == nil {
+		return
+	}
+	record := restageAuditRecord{
+		Time:              l.now().UTC().Format(time.RFC3339),
+		Actor:             auditActor,
+		RunID:             l.runID,
+		Version:           l.build.Version,
+		GitSHA:            l.build.GitSHA,
+		Action:            action,
+		AppGUID:           app.GUID,
+		AppName:           app.Name,
+		Org:               app.Org.Name,
+		Space:             app.Space.Name,
+		BeforeDropletGUID: app.DropletGUID,
+		AfterDropletGUID:  afterDropletGUID,
+	}
+	if restageErr != nil {
+		record.Error = restageErr.Error()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		errs.addf("Unable to record the restage of app %s guid %s in the audit log. Error: %s", app.Name, app.GUID, err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		errs.addf("Unable to record the restage of app %s guid %s in the audit log. Error: %s", app.Name, app.GUID, err)
+	}
+}
+
+// newRunID returns a random ID telling the records of this run apart from
+// those of other runs.
+func newRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().UTC().Format("20060102T150405Z")
+	}
+	return hex.EncodeToString(id)
+}
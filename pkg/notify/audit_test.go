@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRestageAuditLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	audit := &restageAuditLog{w: buf, runID: "run1", build: buildInfo{Version: "v1.2.0", GitSHA: "abc123"}, now: func() time.Time { return time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC) }}
+	apps := []appInfo{
+		{App: App{GUID: "app1", Name: "app1"}, DropletGUID: "droplet1", Space: Space{Name: "dev"}, Org: Organization{Name: "sandbox"}},
+		{App: App{GUID: "app2", Name: "app2"}, DropletGUID: "droplet2"},
+		{App: App{GUID: "app3", Name: "app3"}, DropletGUID: "droplet3"},
+	}
+	restage := func(app appInfo) (string, error) {
+		switch app.GUID {
+		case "app2":
+			return "", errors.New("Staging failed")
+		case "app3":
+			return app.DropletGUID, rolledBackError{errors.New("Instance 0 crashed")}
+		}
+		return "new-droplet", nil
+	}
+	errs := &runErrors{}
+	restageApps(apps, restage, restageLimits{total: 1}, audit, false, errs, nil)
+	if errs.count() != 2 {
+		t.Errorf("Expected the failed and rolled back restages to be errors, found %d errors", errs.count())
+	}
+	var records []restageAuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record restageAuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Unable to unmarshal audit record %s. Error: %s", line, err)
+		}
+		records = append(records, record)
+	}
+	expected := []restageAuditRecord{
+		{Action: restageAttempted, AppGUID: "app1", BeforeDropletGUID: "droplet1"},
+		{Action: restageSucceeded, AppGUID: "app1", BeforeDropletGUID: "droplet1", AfterDropletGUID: "new-droplet"},
+		{Action: restageAttempted, AppGUID: "app2", BeforeDropletGUID: "droplet2"},
+		{Action: restageFailed, AppGUID: "app2", BeforeDropletGUID: "droplet2", Error: "Staging failed"},
+		{Action: restageAttempted, AppGUID: "app3", BeforeDropletGUID: "droplet3"},
+		{Action: restageRolledBack, AppGUID: "app3", BeforeDropletGUID: "droplet3", AfterDropletGUID: "droplet3", Error: "Instance 0 crashed"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d audit records, found %+v", len(expected), records)
+	}
+	for i, record := range records {
+		e := expected[i]
+		if record.Action != e.Action || record.AppGUID != e.AppGUID || record.BeforeDropletGUID != e.BeforeDropletGUID || record.AfterDropletGUID != e.AfterDropletGUID || record.Error != e.Error {
+			t.Errorf("Expected audit record %+v, found %+v", e, record)
+		}
+		if record.Time != "2020-02-01T00:00:00Z" || record.Actor != auditActor || record.RunID != "run1" || record.Version != "v1.2.0" || record.GitSHA != "abc123" {
+			t.Errorf("Expected the audit record to say when and by whom, found %+v", record)
+		}
+	}
+	if records[0].Org != "sandbox" || records[0].Space != "dev" || records[0].AppName != "app1" {
+		t.Errorf("Expected the audit record to say where the app is, found %+v", records[0])
+	}
+}
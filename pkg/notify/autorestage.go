@@ -0,0 +1,625 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// restageScope is the orgs and spaces whose outdated apps are restaged
+// automatically instead of their owners being notified. An app is restaged
+// when either its org or its space is listed, or its org opted in with the
+// auto-restage annotation, during one of the windows.
+type restageScope struct {
+	orgs    patternList
+	spaces  patternList
+	windows *restageWindows
+	// afterNotifications and afterDays, when set, hold restages back until
+	// the owners of an app ignored that many notifications over that many
+	// days. They are then warned notice ahead of the restage.
+	afterNotifications int
+	afterDays          int
+	notice             time.Duration
+	// canary restages one app per space first, and only restages the rest
+	// of the space once it is healthy again.
+	canary bool
+}
+
+// waitsForNotifications reports whether apps are only restaged once their
+// owners ignored notifications about them.
+func (s restageScope) waitsForNotifications() bool {
+	return s.afterNotifications > 0
+}
+
+func (s restageScope) allows(space spaceInfo) bool {
+	return s.orgs.matches(space.Org.Name, space.Org.GUID) || s.spaces.matches(space.Space.Name, space.Space.GUID) || optsIntoAutoRestage(space.Org.Metadata)
+}
+
+// autoRestageAnnotation is the org annotation that, set to true, lets org
+// managers opt their org into automatic restages without operators listing
+// it.
+const autoRestageAnnotation = "notify.cloud.gov/auto-restage"
+
+// optsIntoAutoRestage reports whether metadata opts its org into automatic
+// restages.
+func optsIntoAutoRestage(metadata Metadata) bool {
+	optIn, err := strconv.ParseBool(metadata.Annotations[autoRestageAnnotation])
+	return err == nil && optIn
+}
+
+// restager restages apps through the CF API and waits for them to stage and
+// roll out, so that only as many apps as the restages run concurrently are
+// restaged at once.
+type restager struct {
+	client        *cfclient.Client
+	pollInterval  time.Duration
+	timeout       time.Duration
+	healthTimeout time.Duration
+	logger        *Logger
+}
+
+func newRestager(client *cfclient.Client, timeout time.Duration, healthTimeout time.Duration, logger *Logger) *restager {
+	return &restager{client: client, pollInterval: 5 * time.Second, timeout: timeout, healthTimeout: healthTimeout, logger: logger}
+}
+
+// restage restages app with a rolling deployment, so that it keeps serving
+// traffic while its new droplet is staged and rolled out, and waits until it
+// is. Apps without a package to stage from, or which the CF API won't roll
+// out, are restaged the classic way instead, which restarts them. It returns
+// the GUID of the new droplet, if known.
+func (r *restager) restage(app appInfo) (string, error) {
+	deadline := time.Now().Add(r.timeout)
+	var packageGUID string
+	if app.DropletGUID != "" {
+		droplet, err := GetDroplet(r.client, app.DropletGUID)
+		if err != nil {
+			return "", errors.Wrap(err, "Unable to find the droplet to restage")
+		}
+		packageGUID = droplet.packageGUID()
+	}
+	if packageGUID == "" {
+		r.logger.with(app.logFields()).warnf("App %s guid %s has no package to stage, restaging it without a rolling deployment\n", app.Name, app.GUID)
+		return r.restageClassic(app, deadline)
+	}
+	build, err := CreateBuild(r.client, packageGUID)
+	if err != nil {
+		return "", err
+	}
+	err = r.poll(deadline, "Staging", func() (bool, error) {
+		if build, err = GetBuild(r.client, build.GUID); err != nil {
+			return false, errors.Wrap(err, "Unable to check whether the app staged")
+		}
+		switch build.State {
+		case "STAGED":
+			return true, nil
+		case "FAILED":
+			return false, errors.Errorf("Staging failed: %s", build.Error)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	deployment, err := CreateDeployment(r.client, app.GUID, build.Droplet.GUID)
+	if isAPIRejection(err) {
+		r.logger.with(app.logFields()).warnf("Unable to roll out app %s guid %s, restaging it without a rolling deployment. Error: %s\n", app.Name, app.GUID, err)
+		return r.restageClassic(app, deadline)
+	}
+	if err != nil {
+		return "", err
+	}
+	return build.Droplet.GUID, r.waitForDeployment(deployment, deadline)
+}
+
+// waitForDeployment waits until deployment has rolled out its droplet.
+func (r *restager) waitForDeployment(deployment Deployment, deadline time.Time) error {
+	var err error
+	return r.poll(deadline, "Deployment", func() (bool, error) {
+		if deployment, err = GetDeployment(r.client, deployment.GUID); err != nil {
+			return false, errors.Wrap(err, "Unable to check whether the app rolled out")
+		}
+		// Older CF APIs have a DEPLOYED or CANCELED value instead of a
+		// FINALIZED one with a reason.
+		switch deployment.Status.Value {
+		case "DEPLOYED":
+			return true, nil
+		case "CANCELED":
+			return false, errors.New("Deployment was canceled")
+		case "FINALIZED":
+			if deployment.Status.Reason != "DEPLOYED" {
+				return false, errors.Errorf("Deployment finished as %s", deployment.Status.Reason)
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// rolledBackError is the error of a canary that was rolled back to the
+// droplet it ran before.
+type rolledBackError struct {
+	error
+}
+
+// restageCanary restages app, then waits for the instances of its web process
+// to be running again. Should they not be, app is rolled back to the droplet
+// it ran before.
+func (r *restager) restageCanary(app appInfo) (string, error) {
+	dropletGUID, err := r.restage(app)
+	if err != nil {
+		return dropletGUID, err
+	}
+	err = r.verifyHealth(app)
+	if err == nil {
+		return dropletGUID, nil
+	}
+	r.logger.with(app.logFields()).warnf("App %s guid %s is unhealthy after its restage, rolling it back to droplet %s\n", app.Name, app.GUID, app.DropletGUID)
+	if rollbackErr := r.rollBack(app); rollbackErr != nil {
+		return dropletGUID, errors.Errorf("%s, and rolling back failed: %s", err, rollbackErr)
+	}
+	return app.DropletGUID, rolledBackError{errors.Errorf("%s, rolled back to droplet %s", err, app.DropletGUID)}
+}
+
+// verifyHealth waits until every instance of a started app's web process is
+// running, failing as soon as one crashes.
+func (r *restager) verifyHealth(app appInfo) error {
+	if app.State != "STARTED" {
+		return nil
+	}
+	return r.poll(time.Now().Add(r.healthTimeout), "Health check", func() (bool, error) {
+		instances, err := GetProcessStats(r.client, app.GUID, "web")
+		if err != nil {
+			return false, errors.Wrap(err, "Unable to check whether the app is healthy")
+		}
+		running := 0
+		for _, instance := range instances {
+			switch instance.State {
+			case "RUNNING":
+				running++
+			case "CRASHED":
+				return false, errors.Errorf("Instance %d crashed", instance.Index)
+			}
+		}
+		return len(instances) > 0 && running == len(instances), nil
+	})
+}
+
+// rollBack rolls app back out with the droplet it ran before its restage.
+func (r *restager) rollBack(app appInfo) error {
+	deployment, err := CreateDeployment(r.client, app.GUID, app.DropletGUID)
+	if err != nil {
+		return err
+	}
+	return r.waitForDeployment(deployment, time.Now().Add(r.timeout))
+}
+
+// restageClassic restages app through the V2 API, which stops it while its
+// new droplet stages, and waits until it is staged.
+func (r *restager) restageClassic(app appInfo, deadline time.Time) (string, error) {
+	if err := RestageApp(r.client, app.GUID); err != nil {
+		return "", err
+	}
+	err := r.poll(deadline, "Staging", func() (bool, error) {
+		v2App, err := GetV2App(r.client, app.GUID)
+		if err != nil {
+			return false, errors.Wrap(err, "Unable to check whether the app staged")
+		}
+		switch v2App.Entity.PackageState {
+		case "STAGED":
+			return true, nil
+		case "FAILED":
+			return false, errors.Errorf("Staging failed: %s", v2App.Entity.StagingFailedReason)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	// The V2 API doesn't tell which droplet was staged, so it's left unknown
+	// should looking it up fail.
+	droplet, found, err := getCurrentDropletForApp(app.App, r.client)
+	if err != nil || !found {
+		return "", nil
+	}
+	return droplet.GUID, nil
+}
+
+// poll calls check every poll interval until it's done or fails, failing
+// itself past the deadline.
+func (r *restager) poll(deadline time.Time, step string, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("%s didn't finish by %s", step, deadline.Format(time.RFC3339))
+		}
+		time.Sleep(r.pollInterval)
+	}
+}
+
+// restageResult is the outcome of restaging an app.
+type restageResult struct {
+	app appInfo
+	err error
+}
+
+// filterForAppsToRestage returns the outdated apps in the orgs and spaces
+// allowed by scope, along with the space and org they are in.
+func filterForAppsToRestage(apps []appInfo, spaces map[string]spaceInfo, scope restageScope) []appInfo {
+	var toRestage []appInfo
+	for _, app := range apps {
+		space, found := spaces[app.Relationships.Space.Data.GUID]
+		if !found || !scope.allows(space) {
+			continue
+		}
+		app.Space, app.Org = space.Space, space.Org
+		toRestage = append(toRestage, app)
+	}
+	return toRestage
+}
+
+// restageLimits caps how many restages run at the same time, overall and
+// within a space or an org, so that restages don't overwhelm the Diego cells.
+// Zero space and org limits mean no limit.
+type restageLimits struct {
+	total    int
+	perSpace int
+	perOrg   int
+}
+
+// restageApps restages apps with restage within limits, logging every restage
+// as it starts and ends along with the progress, and recording it in audit.
+// Apps wait in a queue until their space and org are below their limits. The
+// results are in the same order as apps.
+func restageApps(apps []appInfo, restage func(appInfo) (string, error), limits restageLimits, audit *restageAuditLog, dryRun bool, errs *runErrors, logger *Logger) []restageResult {
+	results := make([]restageResult, len(apps))
+	workers := limits.total
+	if workers < 1 {
+		workers = 1
+	}
+	var mu sync.Mutex
+	ready := sync.NewCond(&mu)
+	queue := make([]int, len(apps))
+	for i := range apps {
+		queue[i] = i
+	}
+	inSpace, inOrg := make(map[string]int), make(map[string]int)
+	restaging, finished := 0, 0
+	fits := func(app appInfo) bool {
+		return (limits.perSpace < 1 || inSpace[app.Space.GUID] < limits.perSpace) && (limits.perOrg < 1 || inOrg[app.Org.GUID] < limits.perOrg)
+	}
+	// next takes the first queued app that fits within the limits off the
+	// queue, waiting for other restages to finish if none does.
+	next := func() (int, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for len(queue) > 0 {
+			for k, i := range queue {
+				if fits(apps[i]) {
+					queue = append(queue[:k], queue[k+1:]...)
+					inSpace[apps[i].Space.GUID]++
+					inOrg[apps[i].Org.GUID]++
+					restaging++
+					return i, true
+				}
+			}
+			ready.Wait()
+		}
+		return 0, false
+	}
+	done := func(app appInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		inSpace[app.Space.GUID]--
+		inOrg[app.Org.GUID]--
+		restaging--
+		finished++
+		logger.infof("Restage progress: %d of %d done, %d restaging, %d queued.\n", finished, len(apps), restaging, len(queue))
+		ready.Broadcast()
+	}
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers && worker < len(apps); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i, ok := next()
+				if !ok {
+					return
+				}
+				app := apps[i]
+				results[i].app = app
+				if dryRun {
+					logger.with(app.logFields()).infof("Would restage app %s guid %s in org %s space %s\n", app.Name, app.GUID, app.Org.Name, app.Space.Name)
+				} else {
+					logger.with(app.logFields()).infof("Restaging app %s guid %s in org %s space %s\n", app.Name, app.GUID, app.Org.Name, app.Space.Name)
+					audit.record(app, restageAttempted, "", nil, errs)
+					dropletGUID, err := restage(app)
+					switch err.(type) {
+					case nil:
+						logger.with(app.logFields()).infof("Restaged app %s guid %s\n", app.Name, app.GUID)
+						audit.record(app, restageSucceeded, dropletGUID, nil, errs)
+					case rolledBackError:
+						audit.record(app, restageRolledBack, dropletGUID, err, errs)
+					default:
+						audit.record(app, restageFailed, dropletGUID, err, errs)
+					}
+					if err != nil {
+						results[i].err = err
+						errs.addf("Unable to restage app %s guid %s. Error: %s", app.Name, app.GUID, err)
+					}
+				}
+				done(app)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// canaryFailure is a canary restage that failed, along with the restages of
+// the rest of its space that were skipped because of it.
+type canaryFailure struct {
+	canary  appInfo
+	err     error
+	skipped []appInfo
+}
+
+// restageAllowedApps restages the apps allowed by scope and returns the GUIDs
+// of those that were restaged. With canaries, the first app of every space is
+// restaged on its own first, and a space whose canary fails is left alone.
+func restageAllowedApps(apps []appInfo, spaces map[string]spaceInfo, scope restageScope, r *restager, limits restageLimits, audit *restageAuditLog, dryRun bool, errs *runErrors, logger *Logger) (map[string]bool, []canaryFailure) {
+	toRestage := filterForAppsToRestage(apps, spaces, scope)
+	logger.infof("Will restage %d of %d outdated apps.\n", len(toRestage), len(apps))
+	restaged := make(map[string]bool)
+	var failures []canaryFailure
+	if scope.canary {
+		canaries, rest := splitCanaries(toRestage)
+		logger.infof("Restaging %d canaries before the rest of their spaces.\n", len(canaries))
+		failedSpaces := make(map[string]int)
+		for _, result := range restageApps(canaries, r.restageCanary, limits, audit, dryRun, errs, logger) {
+			if result.err != nil {
+				failedSpaces[result.app.Space.GUID] = len(failures)
+				failures = append(failures, canaryFailure{canary: result.app, err: result.err})
+				continue
+			}
+			restaged[result.app.GUID] = true
+		}
+		toRestage = nil
+		for _, app := range rest {
+			if i, failed := failedSpaces[app.Space.GUID]; failed {
+				failures[i].skipped = append(failures[i].skipped, app)
+				continue
+			}
+			toRestage = append(toRestage, app)
+		}
+		for _, failure := range failures {
+			logger.infof("Skipping %d restages in org %s space %s after its canary %s failed.\n", len(failure.skipped), failure.canary.Org.Name, failure.canary.Space.Name, failure.canary.Name)
+		}
+	}
+	for _, result := range restageApps(toRestage, r.restage, limits, audit, dryRun, errs, logger) {
+		if result.err == nil {
+			restaged[result.app.GUID] = true
+		}
+	}
+	return restaged, failures
+}
+
+// splitCanaries splits apps into the first app of every space, the canaries,
+// and the rest.
+func splitCanaries(apps []appInfo) ([]appInfo, []appInfo) {
+	var canaries, rest []appInfo
+	seen := make(map[string]bool)
+	for _, app := range apps {
+		if seen[app.Space.GUID] {
+			rest = append(rest, app)
+			continue
+		}
+		seen[app.Space.GUID] = true
+		canaries = append(canaries, app)
+	}
+	return canaries, rest
+}
+
+// runRestages restages the outdated apps allowed by scope along with the
+// queued restages that are due, or queues the apps when outside of the restage
+// windows. When restages wait for the owners to ignore notifications, only the
+// queued restages are done. It returns the apps whose owners still need to be
+// notified: those that weren't restaged, or failed to, along with the failed
+// canaries. With approval, only the restages an operator approved are done.
+func runRestages(outdated []appInfo, checked []appInfo, spaces map[string]spaceInfo, scope restageScope, r *restager, limits restageLimits, audit *restageAuditLog, queue map[string]queuedRestage, approval *restageApproval, now time.Time, dryRun bool, errs *runErrors, logger *Logger) ([]appInfo, []canaryFailure) {
+	if !scope.windows.isOpen(now) {
+		if scope.waitsForNotifications() {
+			return outdated, nil
+		}
+		return queueRestages(outdated, spaces, scope, queue, now, logger), nil
+	}
+	due := takeDueRestages(checked, queue, now, logger)
+	toRestage := due
+	if !scope.waitsForNotifications() {
+		toRestage = mergeApps(outdated, due)
+	}
+	if approval != nil {
+		toRestage = approval.approve(filterForAppsToRestage(toRestage, spaces, scope), checked, now)
+	}
+	restaged, failures := restageAllowedApps(toRestage, spaces, scope, r, limits, audit, dryRun, errs, logger)
+	var toNotify []appInfo
+	for _, app := range mergeApps(outdated, due) {
+		if !restaged[app.GUID] {
+			toNotify = append(toNotify, app)
+		}
+	}
+	return toNotify, failures
+}
+
+// mergeApps returns apps followed by the apps in more that aren't in apps.
+func mergeApps(apps []appInfo, more []appInfo) []appInfo {
+	found := make(map[string]bool)
+	merged := make([]appInfo, 0, len(apps)+len(more))
+	for _, app := range apps {
+		found[app.GUID] = true
+		merged = append(merged, app)
+	}
+	for _, app := range more {
+		if !found[app.GUID] {
+			merged = append(merged, app)
+		}
+	}
+	return merged
+}
+
+// queuedRestage is an outdated app waiting for the next restage window, with
+// what's needed to notify its owners should the restage fail.
+type queuedRestage struct {
+	Name        string
+	SpaceGUID   string
+	DropletGUID string
+	Buildpacks  []buildpackReleaseInfo
+	QueuedAt    string
+	// NotBefore is the time the owners were warned the app would be
+	// restaged at. Without it the app is restaged in the next window.
+	NotBefore string `json:",omitempty"`
+}
+
+// queueRestages queues the outdated apps allowed by scope for the next
+// restage window, and returns the apps whose owners still need to be
+// notified. Apps already queued keep their place in the queue.
+func queueRestages(apps []appInfo, spaces map[string]spaceInfo, scope restageScope, queue map[string]queuedRestage, now time.Time, logger *Logger) []appInfo {
+	queued := make(map[string]bool)
+	for _, app := range filterForAppsToRestage(apps, spaces, scope) {
+		queued[app.GUID] = true
+		if _, found := queue[app.GUID]; found {
+			continue
+		}
+		logger.with(app.logFields()).debugf("Queueing the restage of app %s guid %s for the next restage window\n", app.Name, app.GUID)
+		queue[app.GUID] = newQueuedRestage(app, now)
+	}
+	logger.infof("Outside the restage windows, %d apps are queued for the next one.\n", len(queue))
+	var toNotify []appInfo
+	for _, app := range apps {
+		if !queued[app.GUID] {
+			toNotify = append(toNotify, app)
+		}
+	}
+	return toNotify
+}
+
+func newQueuedRestage(app appInfo, now time.Time) queuedRestage {
+	return queuedRestage{
+		Name:        app.Name,
+		SpaceGUID:   app.Relationships.Space.Data.GUID,
+		DropletGUID: app.DropletGUID,
+		Buildpacks:  app.Buildpacks,
+		QueuedAt:    now.UTC().Format(time.RFC3339),
+	}
+}
+
+// takeDueRestages takes the queued restages that are due off the queue and
+// returns their apps, as long as they still run the droplet they were queued
+// with. Restages of apps that were restaged or stopped in the meantime, or
+// weren't checked this run, are dropped. Those that aren't due yet stay.
+func takeDueRestages(checked []appInfo, queue map[string]queuedRestage, now time.Time, logger *Logger) []appInfo {
+	var due []appInfo
+	stillQueued := make(map[string]bool)
+	for _, app := range checked {
+		restage, found := queue[app.GUID]
+		if !found || restage.DropletGUID != app.DropletGUID {
+			continue
+		}
+		if notBefore, err := time.Parse(time.RFC3339, restage.NotBefore); err == nil && now.Before(notBefore) {
+			stillQueued[app.GUID] = true
+			continue
+		}
+		app.Buildpacks = restage.Buildpacks
+		due = append(due, app)
+	}
+	if len(queue) > 0 {
+		logger.infof("Taking %d of %d queued restages off the queue.\n", len(queue)-len(stillQueued), len(queue))
+	}
+	for guid := range queue {
+		if !stillQueued[guid] {
+			delete(queue, guid)
+		}
+	}
+	return due
+}
+
+// scheduleRestages queues the restage of the notified apps allowed by scope
+// whose owners ignored enough notifications for long enough, no sooner than
+// the notice from now. It returns the apps whose owners are to be warned.
+func scheduleRestages(apps []appInfo, spaces map[string]spaceInfo, scope restageScope, history map[string]appNotificationRecord, queue map[string]queuedRestage, now time.Time, logger *Logger) []appInfo {
+	var scheduled []appInfo
+	for _, app := range filterForAppsToRestage(apps, spaces, scope) {
+		if _, found := queue[app.GUID]; found {
+			continue
+		}
+		record := history[app.GUID]
+		firstNotifiedAt, err := time.Parse(time.RFC3339, record.FirstNotifiedAt)
+		if err != nil || record.Notifications < scope.afterNotifications || now.Sub(firstNotifiedAt) < time.Duration(scope.afterDays)*24*time.Hour {
+			continue
+		}
+		restage := newQueuedRestage(app, now)
+		restage.NotBefore = now.Add(scope.notice).UTC().Format(time.RFC3339)
+		logger.with(app.logFields()).infof("Scheduling the restage of app %s guid %s for %s after %d ignored notifications\n", app.Name, app.GUID, restage.NotBefore, record.Notifications)
+		queue[app.GUID] = restage
+		scheduled = append(scheduled, app)
+	}
+	return scheduled
+}
+
+func sendRestageWarningEmailToUsers(users map[string][]appInfo, restageOn time.Time, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) {
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		body := new(bytes.Buffer)
+		isMultipleApp := len(apps) > 1
+		email := restageWarningEmail{user, apps, isMultipleApp, restageOn.UTC().Format("January 2, 2006")}
+		if err := templates.getRestageWarningEmail(body, email); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationRestageWarning, notificationNotRendered, err, errs)
+			return
+		}
+		if !dryRun {
+			subj := "Action required: your application"
+			if isMultipleApp {
+				subj = "Action required: your applications"
+			}
+			subj += " will be restaged on " + email.RestageOn
+			if err := mailer.SendEmail(user, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationRestageWarning, dryRun, err, errs)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationRestageWarning, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent restage warning e-mail to %s\n", user)
+	})
+}
+
+// sendCanaryFailureEmails alerts the operators at recipients about every
+// failed canary.
+func sendCanaryFailureEmails(failures []canaryFailure, recipients []string, templates *Templates, mailer Mailer, dryRun bool, errs *runErrors, logger *Logger) {
+	for _, failure := range failures {
+		body := new(bytes.Buffer)
+		email := canaryFailedEmail{failure.canary, failure.err.Error(), failure.skipped}
+		if err := templates.getCanaryFailedEmail(body, email); err != nil {
+			errs.addf("Unable to render canary failure e-mail about %s. Error: %s", failure.canary.Name, err)
+			continue
+		}
+		for _, recipient := range recipients {
+			if !dryRun {
+				subj := fmt.Sprintf("Canary restage of %s in %s/%s failed", failure.canary.Name, failure.canary.Org.Name, failure.canary.Space.Name)
+				if err := mailer.SendEmail(recipient, subj, body.Bytes()); err != nil {
+					errs.addf("Unable to send e-mail to %s. Error: %s", recipient, err)
+					continue
+				}
+			}
+			logger.with(logFields{"recipient", recipient}).infof("Sent canary failure e-mail to %s\n", recipient)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestConfigRestageScope(t *testing.T) {
+	if scope, err := (Config{}).restageScope(); scope != nil || err != nil {
+		t.Errorf("Expected no restages without auto restage, found %+v/%v", scope, err)
+	}
+	if scope, err := (Config{AutoRestage: true}).restageScope(); scope == nil || err != nil {
+		t.Errorf("Expected auto restage without orgs or spaces to rely on opt-ins, found %+v/%v", scope, err)
+	}
+	scope, err := (Config{AutoRestage: true, RestageSpaces: []string{"*-sandbox"}}).restageScope()
+	if err != nil || scope == nil || len(scope.spaces) != 1 {
+		t.Errorf("Expected sandbox spaces to be restaged, found %+v/%v", scope, err)
+	}
+}
+
+func TestRunRestages(t *testing.T) {
+	spaces := map[string]spaceInfo{
+		"space1": {Space: Space{GUID: "space1", Name: "dev"}, Org: Organization{GUID: "org1", Name: "sandbox"}},
+		"space2": {Space: Space{GUID: "space2", Name: "prod"}, Org: Organization{GUID: "org2", Name: "agency"}},
+	}
+	outdated := []appInfo{
+		{App: newTestApp("app1", "space1")},
+		{App: newTestApp("app2", "space1")},
+		{App: newTestApp("app3", "space2")},
+	}
+	scope := restageScope{orgs: patternList{{raw: "sandbox"}}}
+	testCases := []struct {
+		name             string
+		dryRun           bool
+		expectedRestages int
+		expectedNotify   []string
+	}{
+		{"restage", false, 2, []string{"app2", "app3"}},
+		{"dry run", true, 0, []string{"app3"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			restages := 0
+			polls := make(map[string]int)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				parts := strings.Split(r.URL.Path, "/")
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case r.Method == "GET" && len(parts) == 5 && parts[4] == "droplets":
+					json.NewEncoder(w).Encode(DropletResponse{Droplets: []Droplet{{GUID: "new-droplet"}}})
+				case r.Method == "POST" && len(parts) == 5 && parts[4] == "restage":
+					restages++
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte("{}"))
+				case r.Method == "GET" && len(parts) == 4 && parts[2] == "apps":
+					// app1 stages on the second poll while app2 fails to.
+					var app V2AppResource
+					polls[parts[3]]++
+					switch {
+					case parts[3] == "app2":
+						app.Entity.PackageState = "FAILED"
+						app.Entity.StagingFailedReason = "BuildpackCompileFailed"
+					case polls[parts[3]] > 1:
+						app.Entity.PackageState = "STAGED"
+					default:
+						app.Entity.PackageState = "PENDING"
+					}
+					json.NewEncoder(w).Encode(app)
+				default:
+					t.Fatalf("Unable to find handler for %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			r := &restager{client: &c, pollInterval: time.Millisecond, timeout: time.Minute}
+			errs := &runErrors{}
+			toNotify, _ := runRestages(outdated, outdated, spaces, scope, r, restageLimits{total: 2}, nil, map[string]queuedRestage{}, nil, time.Now(), tc.dryRun, errs, nil)
+			if restages != tc.expectedRestages {
+				t.Errorf("Test %s failed. Expected %d restages, found %d", tc.name, tc.expectedRestages, restages)
+			}
+			if len(toNotify) != len(tc.expectedNotify) {
+				t.Fatalf("Test %s failed. Expected to notify about %v, found %+v", tc.name, tc.expectedNotify, toNotify)
+			}
+			for i, app := range toNotify {
+				if app.GUID != tc.expectedNotify[i] {
+					t.Errorf("Test %s failed. Expected to notify about %v, found %+v", tc.name, tc.expectedNotify, toNotify)
+				}
+			}
+			if !tc.dryRun && errs.count() != 1 {
+				t.Errorf("Test %s failed. Expected the failed restage to be an error, found %d errors", tc.name, errs.count())
+			}
+		})
+	}
+}
+
+func TestRestageScopeAllowsOptedInOrgs(t *testing.T) {
+	scope := restageScope{spaces: patternList{{raw: "dev"}}}
+	testCases := []struct {
+		name       string
+		space      string
+		annotation string
+		expected   bool
+	}{
+		{"listed space", "dev", "", true},
+		{"opted in org", "prod", "true", true},
+		{"opted out org", "prod", "false", false},
+		{"invalid opt in", "prod", "yes please", false},
+		{"no opt in", "prod", "", false},
+	}
+	for _, tc := range testCases {
+		space := spaceInfo{Space: Space{GUID: "space1", Name: tc.space}, Org: Organization{GUID: "org1", Name: "agency"}}
+		if tc.annotation != "" {
+			space.Org.Metadata.Annotations = map[string]string{autoRestageAnnotation: tc.annotation}
+		}
+		if allowed := scope.allows(space); allowed != tc.expected {
+			t.Errorf("Test %s failed. Expected %t, found %t", tc.name, tc.expected, allowed)
+		}
+	}
+}
+
+func TestRestagerTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var app V2AppResource
+		app.Entity.PackageState = "PENDING"
+		json.NewEncoder(w).Encode(app)
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	r := &restager{client: &c, pollInterval: time.Millisecond, timeout: 10 * time.Millisecond}
+	if _, err := r.restage(appInfo{App: newTestApp("app1", "space1")}); err == nil || !strings.Contains(err.Error(), "didn't finish") {
+		t.Errorf("Expected the restage to time out, found %v", err)
+	}
+}
+
+func TestQueueRestages(t *testing.T) {
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	spaces := map[string]spaceInfo{
+		"space1": {Space: Space{GUID: "space1", Name: "dev"}, Org: Organization{GUID: "org1", Name: "sandbox"}},
+		"space2": {Space: Space{GUID: "space2", Name: "prod"}, Org: Organization{GUID: "org2", Name: "agency"}},
+	}
+	python := []buildpackReleaseInfo{{BuildpackName: "python_buildpack"}}
+	outdated := []appInfo{
+		{App: newTestApp("app1", "space1"), DropletGUID: "droplet1", Buildpacks: python},
+		{App: newTestApp("app2", "space1"), DropletGUID: "droplet2"},
+		{App: newTestApp("app3", "space2"), DropletGUID: "droplet3"},
+	}
+	queue := map[string]queuedRestage{
+		"app2": {Name: "app2", DropletGUID: "droplet2", QueuedAt: "2020-01-01T00:00:00Z"},
+	}
+	toNotify := queueRestages(outdated, spaces, restageScope{orgs: patternList{{raw: "sandbox"}}}, queue, now, nil)
+	if len(toNotify) != 1 || toNotify[0].GUID != "app3" {
+		t.Errorf("Expected only app3 to be notified, found %+v", toNotify)
+	}
+	if len(queue) != 2 || queue["app1"].DropletGUID != "droplet1" || queue["app1"].SpaceGUID != "space1" || len(queue["app1"].Buildpacks) != 1 {
+		t.Errorf("Expected app1 to be queued, found %+v", queue)
+	}
+	if queue["app2"].QueuedAt != "2020-01-01T00:00:00Z" {
+		t.Errorf("Expected app2 to keep its place in the queue, found %+v", queue["app2"])
+	}
+
+	// In the next window app1 is still on the same droplet while app2 was restaged by its owners.
+	checked := []appInfo{
+		{App: newTestApp("app1", "space1"), DropletGUID: "droplet1"},
+		{App: newTestApp("app2", "space1"), DropletGUID: "new-droplet"},
+	}
+	apps := takeDueRestages(checked, queue, now, nil)
+	if len(apps) != 1 || apps[0].GUID != "app1" || len(apps[0].Buildpacks) != 1 {
+		t.Errorf("Expected app1 to be taken off the queue, found %+v", apps)
+	}
+	if len(queue) != 0 {
+		t.Errorf("Expected the queue to be emptied, found %+v", queue)
+	}
+}
+
+func TestScheduleRestages(t *testing.T) {
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	spaces := map[string]spaceInfo{
+		"space1": {Space: Space{GUID: "space1", Name: "dev"}, Org: Organization{GUID: "org1", Name: "sandbox"}},
+		"space2": {Space: Space{GUID: "space2", Name: "prod"}, Org: Organization{GUID: "org2", Name: "agency"}},
+	}
+	notified := []appInfo{
+		{App: newTestApp("app1", "space1"), DropletGUID: "droplet1"},
+		{App: newTestApp("app2", "space1"), DropletGUID: "droplet2"},
+		{App: newTestApp("app3", "space1"), DropletGUID: "droplet3"},
+		{App: newTestApp("app4", "space1"), DropletGUID: "droplet4"},
+		{App: newTestApp("app5", "space2"), DropletGUID: "droplet5"},
+	}
+	history := map[string]appNotificationRecord{
+		// app1 ignored enough notifications for long enough.
+		"app1": {Notifications: 3, FirstNotifiedAt: "2020-01-01T00:00:00Z"},
+		// app2 wasn't notified enough times and app3 not for long enough.
+		"app2": {Notifications: 2, FirstNotifiedAt: "2020-01-01T00:00:00Z"},
+		"app3": {Notifications: 3, FirstNotifiedAt: "2020-01-30T00:00:00Z"},
+		// app4 is already scheduled and app5 isn't allowed to be restaged.
+		"app4": {Notifications: 4, FirstNotifiedAt: "2020-01-01T00:00:00Z"},
+		"app5": {Notifications: 3, FirstNotifiedAt: "2020-01-01T00:00:00Z"},
+	}
+	queue := map[string]queuedRestage{
+		"app4": {Name: "app4", DropletGUID: "droplet4", NotBefore: "2020-01-31T00:00:00Z"},
+	}
+	scope := restageScope{orgs: patternList{{raw: "sandbox"}}, afterNotifications: 3, afterDays: 14, notice: 7 * 24 * time.Hour}
+	scheduled := scheduleRestages(notified, spaces, scope, history, queue, now, nil)
+	if len(scheduled) != 1 || scheduled[0].GUID != "app1" {
+		t.Fatalf("Expected only app1 to be scheduled, found %+v", scheduled)
+	}
+	if queue["app1"].NotBefore != "2020-02-08T00:00:00Z" || queue["app1"].DropletGUID != "droplet1" {
+		t.Errorf("Expected app1 to be restaged after the notice, found %+v", queue["app1"])
+	}
+	if queue["app4"].NotBefore != "2020-01-31T00:00:00Z" {
+		t.Errorf("Expected app4 to keep its schedule, found %+v", queue["app4"])
+	}
+
+	// Before the notice is up only app4 is due.
+	checked := []appInfo{notified[0], notified[3]}
+	due := takeDueRestages(checked, queue, now, nil)
+	if len(due) != 1 || due[0].GUID != "app4" {
+		t.Errorf("Expected only app4 to be due, found %+v", due)
+	}
+	if _, found := queue["app1"]; !found || len(queue) != 1 {
+		t.Errorf("Expected app1 to stay queued, found %+v", queue)
+	}
+	due = takeDueRestages(checked, queue, now.Add(scope.notice), nil)
+	if len(due) != 1 || due[0].GUID != "app1" || len(queue) != 0 {
+		t.Errorf("Expected app1 to be due after the notice, found %+v and %+v queued", due, queue)
+	}
+}
+
+func TestRestagerRolling(t *testing.T) {
+	testCases := []struct {
+		name              string
+		hasPackage        bool
+		rejectDeployment  bool
+		deploymentReason  string
+		expectedRequests  []string
+		expectedDroplet   string
+		expectedErrSubstr string
+	}{
+		{"rolling deployment", true, false, "DEPLOYED",
+			[]string{"GET droplet", "POST build", "GET build", "POST deployment", "GET deployment"}, "droplet2", ""},
+		{"canceled deployment", true, false, "CANCELED",
+			[]string{"GET droplet", "POST build", "GET build", "POST deployment", "GET deployment"}, "droplet2", "CANCELED"},
+		{"no package", false, false, "",
+			[]string{"GET droplet", "POST restage", "GET app", "GET current droplet"}, "droplet3", ""},
+		{"rejected deployment", true, true, "",
+			[]string{"GET droplet", "POST build", "GET build", "POST deployment", "POST restage", "GET app", "GET current droplet"}, "droplet3", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				parts := strings.Split(r.URL.Path, "/")
+				switch {
+				case r.Method == "GET" && r.URL.Path == "/v3/droplets/droplet1":
+					requests = append(requests, "GET droplet")
+					var droplet Droplet
+					if tc.hasPackage {
+						droplet.Links.Package.Href = "https://api.example.com/v3/packages/package1"
+					}
+					json.NewEncoder(w).Encode(droplet)
+				case r.Method == "POST" && r.URL.Path == "/v3/builds":
+					requests = append(requests, "POST build")
+					w.WriteHeader(http.StatusCreated)
+					json.NewEncoder(w).Encode(Build{GUID: "build1", State: "STAGING"})
+				case r.Method == "GET" && r.URL.Path == "/v3/builds/build1":
+					requests = append(requests, "GET build")
+					json.NewEncoder(w).Encode(newTestBuild("build1", "droplet2"))
+				case r.Method == "POST" && r.URL.Path == "/v3/deployments":
+					requests = append(requests, "POST deployment")
+					if tc.rejectDeployment {
+						w.WriteHeader(http.StatusUnprocessableEntity)
+						w.Write([]byte(`{"errors":[{"code":10008,"title":"CF-UnprocessableEntity"}]}`))
+						return
+					}
+					w.WriteHeader(http.StatusCreated)
+					json.NewEncoder(w).Encode(Deployment{GUID: "deployment1"})
+				case r.Method == "GET" && r.URL.Path == "/v3/deployments/deployment1":
+					requests = append(requests, "GET deployment")
+					var deployment Deployment
+					deployment.Status.Value = "FINALIZED"
+					deployment.Status.Reason = tc.deploymentReason
+					json.NewEncoder(w).Encode(deployment)
+				case r.Method == "POST" && len(parts) == 5 && parts[4] == "restage":
+					requests = append(requests, "POST restage")
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte("{}"))
+				case r.Method == "GET" && r.URL.Path == "/v2/apps/app1":
+					requests = append(requests, "GET app")
+					var app V2AppResource
+					app.Entity.PackageState = "STAGED"
+					json.NewEncoder(w).Encode(app)
+				case r.Method == "GET" && r.URL.Path == "/v3/apps/app1/droplets" && r.URL.Query().Get("current") == "true":
+					requests = append(requests, "GET current droplet")
+					json.NewEncoder(w).Encode(DropletResponse{Droplets: []Droplet{{GUID: "droplet3"}}})
+				default:
+					t.Fatalf("Unable to find handler for %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			r := &restager{client: &c, pollInterval: time.Millisecond, timeout: time.Minute}
+			dropletGUID, err := r.restage(appInfo{App: newTestApp("app1", "space1"), DropletGUID: "droplet1"})
+			if dropletGUID != tc.expectedDroplet {
+				t.Errorf("Test %s failed. Expected droplet %s, found %s", tc.name, tc.expectedDroplet, dropletGUID)
+			}
+			if tc.expectedErrSubstr == "" && err != nil {
+				t.Errorf("Test %s failed. Expected no error, found %s", tc.name, err)
+			}
+			if tc.expectedErrSubstr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErrSubstr)) {
+				t.Errorf("Test %s failed. Expected an error about %s, found %v", tc.name, tc.expectedErrSubstr, err)
+			}
+			if strings.Join(requests, ", ") != strings.Join(tc.expectedRequests, ", ") {
+				t.Errorf("Test %s failed. Expected requests %v, found %v", tc.name, tc.expectedRequests, requests)
+			}
+		})
+	}
+}
+
+func TestRestageCanaries(t *testing.T) {
+	spaces := map[string]spaceInfo{
+		"space1": {Space: Space{GUID: "space1", Name: "dev"}, Org: Organization{GUID: "org1", Name: "sandbox"}},
+		"space2": {Space: Space{GUID: "space2", Name: "prod"}, Org: Organization{GUID: "org1", Name: "sandbox"}},
+	}
+	var outdated []appInfo
+	for _, app := range []App{newTestApp("app1", "space1"), newTestApp("app2", "space1"), newTestApp("app3", "space2"), newTestApp("app4", "space2")} {
+		app.State = "STARTED"
+		outdated = append(outdated, appInfo{App: app, DropletGUID: "droplet-" + app.GUID})
+	}
+	var mu sync.Mutex
+	deployed := make(map[string][]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "GET" && len(parts) == 4 && parts[2] == "droplets":
+			var droplet Droplet
+			droplet.Links.Package.Href = "https://api.example.com/v3/packages/package-" + parts[3]
+			json.NewEncoder(w).Encode(droplet)
+		case r.Method == "POST" && r.URL.Path == "/v3/builds":
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(Build{GUID: "build1", State: "STAGING"})
+		case r.Method == "GET" && r.URL.Path == "/v3/builds/build1":
+			json.NewEncoder(w).Encode(newTestBuild("build1", "new-droplet"))
+		case r.Method == "POST" && r.URL.Path == "/v3/deployments":
+			var body Deployment
+			json.NewDecoder(r.Body).Decode(&body)
+			appGUID := body.Relationships.App.Data.GUID
+			deployed[appGUID] = append(deployed[appGUID], body.Droplet.GUID)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(Deployment{GUID: "deployment1"})
+		case r.Method == "GET" && r.URL.Path == "/v3/deployments/deployment1":
+			var deployment Deployment
+			deployment.Status.Value = "FINALIZED"
+			deployment.Status.Reason = "DEPLOYED"
+			json.NewEncoder(w).Encode(deployment)
+		case r.Method == "GET" && len(parts) == 7 && parts[6] == "stats":
+			// app1 crashes with its new droplet.
+			state := "RUNNING"
+			if parts[3] == "app1" {
+				state = "CRASHED"
+			}
+			json.NewEncoder(w).Encode(ProcessStatsResponse{Instances: []ProcessInstance{{Index: 0, State: "RUNNING"}, {Index: 1, State: state}}})
+		default:
+			t.Fatalf("Unable to find handler for %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	r := &restager{client: &c, pollInterval: time.Millisecond, timeout: time.Minute, healthTimeout: time.Minute}
+	scope := restageScope{orgs: patternList{{raw: "sandbox"}}, canary: true}
+	errs := &runErrors{}
+	toNotify, failures := runRestages(outdated, outdated, spaces, scope, r, restageLimits{total: 2}, nil, map[string]queuedRestage{}, nil, time.Now(), false, errs, nil)
+	if len(toNotify) != 2 || toNotify[0].GUID != "app1" || toNotify[1].GUID != "app2" {
+		t.Errorf("Expected the owners of app1 and app2 to be notified, found %+v", toNotify)
+	}
+	if len(failures) != 1 || failures[0].canary.GUID != "app1" || len(failures[0].skipped) != 1 || failures[0].skipped[0].GUID != "app2" {
+		t.Fatalf("Expected app1 to be the only failed canary, found %+v", failures)
+	}
+	if !strings.Contains(failures[0].err.Error(), "rolled back to droplet droplet-app1") {
+		t.Errorf("Expected app1 to be rolled back, found %s", failures[0].err)
+	}
+	expected := map[string][]string{
+		"app1": {"new-droplet", "droplet-app1"},
+		"app3": {"new-droplet"},
+		"app4": {"new-droplet"},
+	}
+	if len(deployed) != len(expected) {
+		t.Errorf("Expected deployments %v, found %v", expected, deployed)
+	}
+	for app, droplets := range expected {
+		if strings.Join(deployed[app], ",") != strings.Join(droplets, ",") {
+			t.Errorf("Expected deployments %v, found %v", expected, deployed)
+		}
+	}
+}
+
+func TestRestageAppsLimits(t *testing.T) {
+	var apps []appInfo
+	for i, space := range []string{"space1", "space1", "space1", "space2", "space2", "space3"} {
+		org := "org1"
+		if space == "space3" {
+			org = "org2"
+		}
+		apps = append(apps, appInfo{App: newTestApp(fmt.Sprintf("app%d", i), space), Space: Space{GUID: space}, Org: Organization{GUID: org}})
+	}
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	track := func(key string, delta int) {
+		running[key] += delta
+		if running[key] > maxRunning[key] {
+			maxRunning[key] = running[key]
+		}
+	}
+	restage := func(app appInfo) (string, error) {
+		mu.Lock()
+		track("total", 1)
+		track(app.Space.GUID, 1)
+		track(app.Org.GUID, 1)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		track("total", -1)
+		track(app.Space.GUID, -1)
+		track(app.Org.GUID, -1)
+		mu.Unlock()
+		return "", nil
+	}
+	results := restageApps(apps, restage, restageLimits{total: 4, perSpace: 1, perOrg: 2}, nil, false, &runErrors{}, nil)
+	for i, result := range results {
+		if result.app.GUID != apps[i].GUID || result.err != nil {
+			t.Errorf("Expected app %s to be restaged, found %+v", apps[i].GUID, result)
+		}
+	}
+	for key, limit := range map[string]int{"total": 4, "space1": 1, "space2": 1, "org1": 2, "org2": 1} {
+		if maxRunning[key] > limit {
+			t.Errorf("Expected at most %d restages at once in %s, found %d", limit, key, maxRunning[key])
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+)
+
+// AWSConfig are the credentials of the AWS services logs and metrics are
+// shipped to, read from the standard AWS environment variables.
+type AWSConfig struct {
+	AccessKeyID     string `envconfig:"aws_access_key_id"`
+	SecretAccessKey string `envconfig:"aws_secret_access_key"`
+	SessionToken    string `envconfig:"aws_session_token"`
+	Region          string `envconfig:"aws_region" default:"us-east-1"`
+	// S3Endpoint is an S3 compatible API to use instead of AWS, with the
+	// bucket in the path of its URLs.
+	S3Endpoint string `envconfig:"aws_endpoint_url_s3"`
+	// LogsEndpoint is the CloudWatch Logs API to use instead of the one of
+	// the region, e.g. a VPC endpoint.
+	LogsEndpoint string `envconfig:"aws_endpoint_url_logs"`
+}
+
+func loadAWSConfig() (AWSConfig, error) {
+	var config AWSConfig
+	if err := envconfig.Process("", &config); err != nil {
+		return config, err
+	}
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return config, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required to write to AWS")
+	}
+	return config, nil
+}
+
+// credentials returns the static credentials of config.
+func (config AWSConfig) credentials() aws.CredentialsProvider {
+	return credentials.NewStaticCredentialsProvider(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)
+}
+
+// signAWS adds the headers authenticating req, whose body is body, to
+// service with AWS Signature Version 4.
+func signAWS(req *http.Request, body []byte, config AWSConfig, service string, now time.Time) error {
+	creds, err := config.credentials().Retrieve(req.Context())
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	return v4.NewSigner().SignHTTP(req.Context(), creds, req, hex.EncodeToString(sum[:]), service, config.Region, now)
+}
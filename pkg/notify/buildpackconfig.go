@@ -0,0 +1,181 @@
+package notify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultBuildpackReleaseURLs are the release notes pages of the system
+// buildpacks shipped with Cloud Foundry.
+var defaultBuildpackReleaseURLs = map[string]string{
+	"staticfile_buildpack":  "https://github.com/cloudfoundry/staticfile-buildpack/releases",
+	"java_buildpack":        "https://github.com/cloudfoundry/java-buildpack/releases",
+	"ruby_buildpack":        "https://github.com/cloudfoundry/ruby-buildpack/releases",
+	"dotnet_core_buildpack": "https://github.com/cloudfoundry/dotnet-core-buildpack/releases",
+	"nodejs_buildpack":      "https://github.com/cloudfoundry/nodejs-buildpack/releases",
+	"go_buildpack":          "https://github.com/cloudfoundry/go-buildpack/releases",
+	"python_buildpack":      "https://github.com/cloudfoundry/python-buildpack/releases",
+	"php_buildpack":         "https://github.com/cloudfoundry/php-buildpack/releases",
+	"binary_buildpack":      "https://github.com/cloudfoundry/binary-buildpack/releases",
+	"nginx_buildpack":       "https://github.com/cloudfoundry/nginx-buildpack/releases",
+	"r_buildpack":           "https://github.com/cloudfoundry/r-buildpack/releases",
+}
+
+// buildpackLinks are the pages notifications about buildpacks link to, by
+// buildpack name: their release notes pages and their documentation pages.
+type buildpackLinks struct {
+	releaseURLs map[string]string
+	docs        map[string][]DocLink
+}
+
+// releaseURL returns the release notes page for a given buildpack; if the
+// buildpack is not found, returns an empty string.
+func (l buildpackLinks) releaseURL(buildpackName string) string {
+	// Note that for a specific release of a buildpack on GitHub, you'll need
+	// to append /tag/<version_number> at the end, e.g.,
+	// https://github.com/cloudfoundry/python-buildpack/releases/tag/v1.7.45
+	// for the Python buildpack.
+	return l.releaseURLs[buildpackName]
+}
+
+// links returns the release notes and documentation pages of the
+// buildpacks, from the BUILDPACK_CONFIG file and BUILDPACK_RELEASE_URLS.
+func (c Config) links() (buildpackLinks, error) {
+	releaseURLs, err := c.releaseURLs()
+	if err != nil {
+		return buildpackLinks{}, err
+	}
+	docs, err := c.docs()
+	if err != nil {
+		return buildpackLinks{}, err
+	}
+	return buildpackLinks{releaseURLs: releaseURLs, docs: docs}, nil
+}
+
+// DocLink is a documentation page notifications about a buildpack link to,
+// e.g. a guide to restaging apps or migrating to a newer runtime.
+type DocLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// buildpackConfig is what the BUILDPACK_CONFIG file sets for a buildpack.
+type buildpackConfig struct {
+	// ReleasesURL is the release notes page of the buildpack. An empty
+	// one leaves out the default page of a system buildpack.
+	ReleasesURL *string `json:"releases_url"`
+	// Docs are the documentation pages of the platform about the buildpack.
+	Docs []DocLink `json:"docs"`
+}
+
+// loadBuildpackConfig reads the settings of each buildpack, by name, from the
+// JSON file at path.
+func loadBuildpackConfig(path string) (map[string]buildpackConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read buildpack config")
+	}
+	var buildpacks map[string]buildpackConfig
+	if err := json.Unmarshal(data, &buildpacks); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse buildpack config %s", path)
+	}
+	return buildpacks, nil
+}
+
+// releaseURLs returns the release notes page of each buildpack: the pages of
+// the system buildpacks, overridden by the BUILDPACK_CONFIG file, overridden
+// in turn by BUILDPACK_RELEASE_URLS.
+func (c Config) releaseURLs() (map[string]string, error) {
+	urls := make(map[string]string)
+	for name, releasesURL := range defaultBuildpackReleaseURLs {
+		urls[name] = releasesURL
+	}
+	set := func(name, releasesURL string) error {
+		if releasesURL == "" {
+			delete(urls, name)
+			return nil
+		}
+		if !isWebURL(releasesURL) {
+			return errors.Errorf("Invalid release notes URL %q for buildpack %s, expected an http or https URL", releasesURL, name)
+		}
+		urls[name] = releasesURL
+		return nil
+	}
+	if c.BuildpackConfig != "" {
+		buildpacks, err := loadBuildpackConfig(c.BuildpackConfig)
+		if err != nil {
+			return nil, err
+		}
+		for name, buildpack := range buildpacks {
+			if buildpack.ReleasesURL == nil {
+				continue
+			}
+			if err := set(name, *buildpack.ReleasesURL); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The URLs are given as name=url, since envconfig would split them at
+	// their colons as a map.
+	for _, pair := range c.BuildpackReleaseURLs {
+		name, releasesURL, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, errors.Errorf("Invalid buildpack release URL %q, expected name=url", pair)
+		}
+		if err := set(strings.TrimSpace(name), strings.TrimSpace(releasesURL)); err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
+
+// docs returns the documentation pages of each buildpack set in the
+// BUILDPACK_CONFIG file.
+func (c Config) docs() (map[string][]DocLink, error) {
+	if c.BuildpackConfig == "" {
+		return nil, nil
+	}
+	buildpacks, err := loadBuildpackConfig(c.BuildpackConfig)
+	if err != nil {
+		return nil, err
+	}
+	docs := make(map[string][]DocLink)
+	for name, buildpack := range buildpacks {
+		for _, doc := range buildpack.Docs {
+			if !isWebURL(doc.URL) {
+				return nil, errors.Errorf("Invalid documentation URL %q for buildpack %s, expected an http or https URL", doc.URL, name)
+			}
+			if strings.TrimSpace(doc.Title) == "" {
+				doc.Title = doc.URL
+			}
+			docs[name] = append(docs[name], doc)
+		}
+	}
+	return docs, nil
+}
+
+// isWebURL reports whether rawURL is an absolute http or https URL.
+func isWebURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// warnUnmappedBuildpacks warns about the updated buildpacks without a release
+// notes page in links, which e-mails can't link to.
+func warnUnmappedBuildpacks(buildpacks map[string]Buildpack, links buildpackLinks, logger *Logger) {
+	var unmapped []string
+	for name := range buildpacks {
+		if links.releaseURL(name) == "" {
+			unmapped = append(unmapped, name)
+		}
+	}
+	sort.Strings(unmapped)
+	for _, name := range unmapped {
+		logger.warnf("Buildpack %s has no release notes URL, so notifications about it won't link to its releases. Set one with BUILDPACK_CONFIG or BUILDPACK_RELEASE_URLS.\n", name)
+	}
+}
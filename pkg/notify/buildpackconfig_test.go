@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// defaultBuildpackLinks link to the release notes of the system buildpacks.
+var defaultBuildpackLinks = buildpackLinks{releaseURLs: defaultBuildpackReleaseURLs}
+
+func TestReleaseURLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buildpacks.json")
+	if err := ioutil.WriteFile(path, []byte(`{
+		"custom_buildpack": {"releases_url": "https://github.com/agency/custom-buildpack/releases"},
+		"r_buildpack": {"releases_url": ""},
+		"python_buildpack": {"releases_url": "https://github.com/agency/python-buildpack/releases"},
+		"go_buildpack": {}
+	}`), 0644); err != nil {
+		t.Fatalf("Unable to write buildpack config. Error: %s", err)
+	}
+	config := Config{BuildpackConfig: path, BuildpackReleaseURLs: []string{"python_buildpack=https://example.gov/python/releases"}}
+	urls, err := config.releaseURLs()
+	if err != nil {
+		t.Fatalf("Unable to get release URLs. Error: %s", err)
+	}
+	expected := map[string]string{
+		"custom_buildpack": "https://github.com/agency/custom-buildpack/releases",
+		"python_buildpack": "https://example.gov/python/releases",
+		"go_buildpack":     defaultBuildpackReleaseURLs["go_buildpack"],
+		"java_buildpack":   defaultBuildpackReleaseURLs["java_buildpack"],
+	}
+	for name, releasesURL := range expected {
+		if urls[name] != releasesURL {
+			t.Errorf("Expected %s to link to %s, found %s", name, releasesURL, urls[name])
+		}
+	}
+	if _, found := urls["r_buildpack"]; found {
+		t.Errorf("Expected an empty URL to leave out the default, found %s", urls["r_buildpack"])
+	}
+	if defaultBuildpackReleaseURLs["python_buildpack"] != "https://github.com/cloudfoundry/python-buildpack/releases" {
+		t.Errorf("Expected the defaults to be left alone, found %v", defaultBuildpackReleaseURLs)
+	}
+
+	for _, invalid := range []Config{
+		{BuildpackReleaseURLs: []string{"python_buildpack"}},
+		{BuildpackReleaseURLs: []string{"python_buildpack=github.com/cloudfoundry/python-buildpack"}},
+		{BuildpackConfig: filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		if _, err := invalid.releaseURLs(); err == nil {
+			t.Errorf("Expected %+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestWarnUnmappedBuildpacks(t *testing.T) {
+	var buf bytes.Buffer
+	links := buildpackLinks{releaseURLs: map[string]string{"python_buildpack": "https://github.com/cloudfoundry/python-buildpack/releases"}}
+	warnUnmappedBuildpacks(map[string]Buildpack{"python_buildpack": {}, "custom_buildpack": {}}, links, newLogger(&buf, levelInfo, formatText))
+	if !strings.Contains(buf.String(), "Buildpack custom_buildpack has no release notes URL") || strings.Contains(buf.String(), "python_buildpack") {
+		t.Errorf("Expected a warning about the unmapped buildpack only, found %q", buf.String())
+	}
+	if info := links.releaseInfo(Buildpack{Name: "java_buildpack", Filename: "java-buildpack-v4.41.zip"}); info.BuildpackURL != "" {
+		t.Errorf("Expected buildpacks left out of the configured URLs not to link anywhere, found %+v", info)
+	}
+}
+
+func TestBuildpackDocs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buildpacks.json")
+	if err := ioutil.WriteFile(path, []byte(`{
+		"python_buildpack": {"docs": [
+			{"title": "Restaging Python apps", "url": "https://cloud.gov/docs/python/"},
+			{"url": "https://cloud.gov/docs/python/migrating/"}
+		]},
+		"go_buildpack": {"releases_url": "https://github.com/agency/go-buildpack/releases"}
+	}`), 0644); err != nil {
+		t.Fatalf("Unable to write buildpack config. Error: %s", err)
+	}
+	docs, err := Config{BuildpackConfig: path}.docs()
+	if err != nil {
+		t.Fatalf("Unable to get buildpack docs. Error: %s", err)
+	}
+	expected := map[string][]DocLink{"python_buildpack": {
+		{Title: "Restaging Python apps", URL: "https://cloud.gov/docs/python/"},
+		{Title: "https://cloud.gov/docs/python/migrating/", URL: "https://cloud.gov/docs/python/migrating/"},
+	}}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, docs)
+	}
+	if docs, err := (Config{}).docs(); docs != nil || err != nil {
+		t.Errorf("Expected no docs without a buildpack config, found %+v and %v", docs, err)
+	}
+
+	links := buildpackLinks{releaseURLs: defaultBuildpackReleaseURLs, docs: docs}
+	if info := links.releaseInfo(Buildpack{Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs4-v1.8.15.zip"}); !reflect.DeepEqual(info.Docs, expected["python_buildpack"]) {
+		t.Errorf("Expected the docs of the buildpack along with its release, found %+v", info)
+	}
+
+	invalid := filepath.Join(t.TempDir(), "buildpacks.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{"python_buildpack": {"docs": [{"title": "Python", "url": "cloud.gov/docs/python"}]}}`), 0644); err != nil {
+		t.Fatalf("Unable to write buildpack config. Error: %s", err)
+	}
+	if _, err := (Config{BuildpackConfig: invalid}).docs(); err == nil {
+		t.Errorf("Expected a documentation URL without a scheme to be invalid")
+	}
+}
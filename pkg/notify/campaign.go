@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"bytes"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// campaign notifies the owners of every app still staged with a buildpack
+// that is being retired, whether or not that buildpack was updated. It can be
+// narrowed down to droplets staged on a single stack, e.g. to retire php on
+// an old stack only.
+type campaign struct {
+	buildpack pattern
+	stack     string
+	subject   string
+	aliases   map[string]string
+	cohorts   *cohorts
+}
+
+// matchingBuildpacks returns the buildpacks of the droplet the campaign is
+// about, or nothing if the droplet isn't part of the campaign.
+func (c *campaign) matchingBuildpacks(app App, droplet Droplet) []buildpackReleaseInfo {
+	if c.stack != "" && c.stack != getStackOfApp(app, droplet) {
+		return nil
+	}
+	var matching []buildpackReleaseInfo
+	for _, dropletBuildpack := range droplet.Buildpacks {
+		if dropletBuildpack.Name == "" {
+			continue
+		}
+		// Droplets staged with an old name of the buildpack are part of the campaign too.
+		if c.buildpack.matches(dropletBuildpack.Name, "") || c.buildpack.matches(resolveBuildpackAlias(dropletBuildpack.Name, c.aliases), "") {
+			matching = append(matching, buildpackReleaseInfo{BuildpackName: dropletBuildpack.Name})
+		}
+	}
+	return matching
+}
+
+// findCampaignApps returns every app staged with a buildpack the campaign is about.
+func findCampaignApps(client *cfclient.Client, apps []App, campaign *campaign, concurrency int, report *runReport, errs *runErrors, logger *Logger) []appInfo {
+	var campaignApps []appInfo
+	for _, result := range getDropletsToCheck(apps, client, concurrency, report, errs, logger) {
+		if !result.ok {
+			continue
+		}
+		app, droplet := result.app, result.droplet
+		buildpacks := campaign.matchingBuildpacks(app, droplet)
+		if len(buildpacks) == 0 {
+			report.traceApp(app, "campaign buildpack", "not matched", "")
+			report.recordApp(app, decisionNotCampaignTarget)
+			continue
+		}
+		logger.with(appFields(app).with("buildpack", buildpacks[0].BuildpackName)).infof("App %s guid %s is using campaign buildpack %s\n", app.Name, app.GUID, buildpacks[0].BuildpackName)
+		report.traceApp(app, "campaign buildpack", "matched", buildpacks[0].BuildpackName)
+		report.recordApp(app, decisionCampaignTarget)
+		campaignApps = append(campaignApps, appInfo{App: app, Buildpacks: buildpacks})
+	}
+	return campaignApps
+}
+
+// runCampaign notifies the owners of every app in scope that the campaign is about.
+func runCampaign(client *cfclient.Client, campaign *campaign, scope runScope, listOpts ListOptions, concurrency int, settings ownerSettings, templates *Templates, mailer Mailer, emailConcurrency int, dryRun bool, audit *notificationAuditLog, report *runReport, errs *runErrors, logger *Logger) error {
+	apps, spaces, err := listAppsWithSpaces(client, listOpts)
+	if err != nil {
+		return err
+	}
+	apps = filterAppsByScope(apps, spaces, scope, report, logger)
+	campaignApps := findCampaignApps(client, apps, campaign, concurrency, report, errs, logger)
+	owners := campaign.cohorts.filter(findOwnersOfApps(campaignApps, client, settings, errs, logger), logger)
+	report.recordOwners(owners)
+	logger.infof("Will notify %d owners of apps using %s.\n", len(owners), campaign.buildpack.raw)
+	sendCampaignEmailToUsers(owners, campaign, templates, mailer, emailConcurrency, dryRun, audit, errs, logger)
+	return nil
+}
+
+func sendCampaignEmailToUsers(users map[string][]appInfo, campaign *campaign, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) {
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		body := new(bytes.Buffer)
+		email := campaignEmail{user, apps, len(apps) > 1, campaign.buildpack.raw, campaign.stack}
+		if err := templates.getCampaignEmail(body, email); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationCampaign, notificationNotRendered, err, errs)
+			return
+		}
+		if !dryRun {
+			if err := mailer.SendEmail(user, campaign.subject, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationCampaign, dryRun, err, errs)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationCampaign, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent campaign e-mail to %s\n", user)
+	})
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestConfigCampaign(t *testing.T) {
+	if campaign, err := (Config{}).campaign(); campaign != nil || err != nil {
+		t.Errorf("Expected no campaign without a campaign buildpack, found %+v/%v", campaign, err)
+	}
+	if _, err := (Config{CampaignBuildpack: "php_buildpack"}).campaign(); err == nil {
+		t.Errorf("Expected a campaign without a template to be invalid")
+	}
+	campaign, err := (Config{CampaignBuildpack: "php_buildpack", CampaignTemplate: "retire.txt"}).campaign()
+	if err != nil || campaign == nil || campaign.buildpack.raw != "php_buildpack" {
+		t.Errorf("Expected a campaign for php_buildpack, found %+v/%v", campaign, err)
+	}
+}
+
+func TestFindCampaignApps(t *testing.T) {
+	php := newTestDroplet("2020-01-01T00:00:00Z", "php_buildpack")
+	php.Stack = "cflinuxfs3"
+	phpOnNewStack := newTestDroplet("2020-01-01T00:00:00Z", "php_buildpack")
+	phpOnNewStack.Stack = "cflinuxfs4"
+	python := newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")
+	python.Stack = "cflinuxfs3"
+	testCases := []struct {
+		name             string
+		campaign         *campaign
+		droplet          Droplet
+		expectedDecision appDecision
+	}{
+		{"buildpack in campaign", &campaign{buildpack: pattern{raw: "php_buildpack"}}, php, decisionCampaignTarget},
+		{"buildpack not in campaign", &campaign{buildpack: pattern{raw: "php_buildpack"}}, python, decisionNotCampaignTarget},
+		{"buildpack and stack in campaign", &campaign{buildpack: pattern{raw: "php_buildpack"}, stack: "cflinuxfs3"}, php, decisionCampaignTarget},
+		{"stack not in campaign", &campaign{buildpack: pattern{raw: "php_buildpack"}, stack: "cflinuxfs3"}, phpOnNewStack, decisionNotCampaignTarget},
+		{"buildpack glob", &campaign{buildpack: pattern{raw: "php*"}}, php, decisionCampaignTarget},
+		{"old buildpack name", &campaign{buildpack: pattern{raw: "php_buildpack_v2"}, aliases: map[string]string{"php_buildpack": "php_buildpack_v2"}}, php, decisionCampaignTarget},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v3/droplets" {
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+				json.NewEncoder(w).Encode(DropletResponse{Droplets: []Droplet{tc.droplet}})
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			report := newRunReport()
+			apps := findCampaignApps(&c, []App{newTestStartedApp("buildpack")}, tc.campaign, 1, report, &runErrors{}, nil)
+			if report.count(tc.expectedDecision) != 1 {
+				t.Errorf("Test %s failed. Expected decision %s, found %+v", tc.name, tc.expectedDecision, report.decisions)
+			}
+			if (len(apps) == 1) != (tc.expectedDecision == decisionCampaignTarget) {
+				t.Errorf("Test %s failed. Unexpected campaign apps %+v", tc.name, apps)
+			}
+		})
+	}
+}
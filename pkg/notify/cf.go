@@ -0,0 +1,766 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// Pagination represents the pagination block that is part of every V3 API
+// JSON Response for a list of resources.
+// http://v3-apidocs.cloudfoundry.org/version/3.34.0/index.html#pagination
+type Pagination struct {
+	TotalResults int `json:"total_results"`
+	TotalPages   int `json:"total_pages"`
+	First        struct {
+		Href string `json:"href"`
+	} `json:"first"`
+	Last struct {
+		Href string `json:"href"`
+	} `json:"last"`
+	Next struct {
+		Href string `json:"href,omitempty"`
+	} `json:"next,omitempty"`
+	Previous struct {
+		Href string `json:"href,omitempty"`
+	} `json:"previous,omitempty"`
+}
+
+// Relationship represents a V3 API to-one relationship to another resource.
+type Relationship struct {
+	Data struct {
+		GUID string `json:"guid"`
+	} `json:"data"`
+}
+
+// App represents the V3 API JSON object of an app
+// http://v3-apidocs.cloudfoundry.org/version/3.34.0/index.html#the-app-object
+type App struct {
+	GUID      string `json:"guid"`
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+	Lifecycle struct {
+		Type string `json:"type"`
+		Data struct {
+			Buildpacks []string `json:"buildpacks,omitempty"`
+			Stack      string   `json:"stack,omitempty"`
+		} `json:"data,omitempty"`
+	} `json:"lifecycle"`
+	Relationships struct {
+		Space Relationship `json:"space"`
+	} `json:"relationships"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Metadata represents the labels and annotations of a V3 API resource.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#metadata
+type Metadata struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// AppResponse represents the V3 API JSON Response when querying for apps
+// with their spaces and organizations included.
+type AppResponse struct {
+	Pagination Pagination `json:"pagination"`
+	Apps       []App      `json:"resources"`
+	Included   struct {
+		Spaces        []Space        `json:"spaces"`
+		Organizations []Organization `json:"organizations"`
+	} `json:"included"`
+}
+
+// Droplet represents the V3 API JSON object of a droplet
+// http://v3-apidocs.cloudfoundry.org/version/3.34.0/index.html#the-app-object
+type Droplet struct {
+	GUID       string             `json:"guid"`
+	State      string             `json:"state"`
+	Error      string             `json:"error"`
+	CreatedAt  string             `json:"created_at"`
+	UpdatedAt  string             `json:"updated_at"`
+	Stack      string             `json:"stack"`
+	Buildpacks []DropletBuildpack `json:"buildpacks,omitempty"`
+	Links      struct {
+		App struct {
+			Href string `json:"href"`
+		} `json:"app"`
+		// Package is missing for droplets that were uploaded instead of
+		// staged from a package.
+		Package struct {
+			Href string `json:"href"`
+		} `json:"package"`
+	} `json:"links"`
+}
+
+// appGUID returns the GUID of the app the droplet belongs to.
+func (d Droplet) appGUID() string {
+	return path.Base(d.Links.App.Href)
+}
+
+// packageGUID returns the GUID of the package the droplet was staged from, if
+// any.
+func (d Droplet) packageGUID() string {
+	if d.Links.Package.Href == "" {
+		return ""
+	}
+	return path.Base(d.Links.Package.Href)
+}
+
+// DropletBuildpack represents a buildpack that was used to stage a droplet.
+type DropletBuildpack struct {
+	Name         string `json:"name"`
+	DetectOutput string `json:"detect_output"`
+	Version      string `json:"version"`
+}
+
+// DropletResponse represents the V3 API JSON Response when querying for droplets.
+type DropletResponse struct {
+	Pagination Pagination `json:"pagination"`
+	Droplets   []Droplet  `json:"resources"`
+}
+
+// Build represents the V3 API JSON object of a build, which stages a droplet.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-build-object
+type Build struct {
+	GUID      string `json:"guid"`
+	State     string `json:"state"`
+	Error     string `json:"error"`
+	CreatedAt string `json:"created_at"`
+	// Droplet is empty until the build has staged a droplet.
+	Droplet struct {
+		GUID string `json:"guid"`
+	} `json:"droplet"`
+}
+
+// BuildResponse represents the V3 API JSON Response when querying for builds.
+type BuildResponse struct {
+	Pagination Pagination `json:"pagination"`
+	Builds     []Build    `json:"resources"`
+}
+
+// Deployment represents the V3 API JSON object of a deployment, which rolls
+// out a droplet to an app.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-deployment-object
+type Deployment struct {
+	GUID   string `json:"guid"`
+	Status struct {
+		Value  string `json:"value"`
+		Reason string `json:"reason"`
+	} `json:"status"`
+	Droplet struct {
+		GUID string `json:"guid"`
+	} `json:"droplet"`
+	Relationships struct {
+		App Relationship `json:"app"`
+	} `json:"relationships"`
+}
+
+// DeploymentResponse represents the V3 API JSON Response when querying for deployments.
+type DeploymentResponse struct {
+	Pagination  Pagination   `json:"pagination"`
+	Deployments []Deployment `json:"resources"`
+}
+
+// Buildpack represents the V3 API JSON object of a buildpack
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-buildpack-object
+type Buildpack struct {
+	GUID      string `json:"guid"`
+	Name      string `json:"name"`
+	Stack     string `json:"stack"`
+	Position  int    `json:"position"`
+	Enabled   bool   `json:"enabled"`
+	Locked    bool   `json:"locked"`
+	Filename  string `json:"filename"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// BuildpackResponse represents the V3 API JSON Response when querying for buildpacks.
+type BuildpackResponse struct {
+	Pagination Pagination  `json:"pagination"`
+	Buildpacks []Buildpack `json:"resources"`
+}
+
+// Space represents the V3 API JSON object of a space
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-space-object
+type Space struct {
+	GUID          string `json:"guid"`
+	Name          string `json:"name"`
+	Relationships struct {
+		Organization Relationship `json:"organization"`
+	} `json:"relationships"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Organization represents the V3 API JSON object of an organization
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-organization-object
+type Organization struct {
+	GUID      string   `json:"guid"`
+	Name      string   `json:"name"`
+	Suspended bool     `json:"suspended"`
+	Metadata  Metadata `json:"metadata"`
+}
+
+// SpaceResponse represents the V3 API JSON Response when getting a single
+// space with its organization included.
+type SpaceResponse struct {
+	Space
+	Included struct {
+		Organizations []Organization `json:"organizations"`
+	} `json:"included"`
+}
+
+// Role represents the V3 API JSON object of a role
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-role-object
+type Role struct {
+	GUID          string `json:"guid"`
+	Type          string `json:"type"`
+	Relationships struct {
+		User         Relationship `json:"user"`
+		Space        Relationship `json:"space"`
+		Organization Relationship `json:"organization"`
+	} `json:"relationships"`
+}
+
+// User represents the V3 API JSON object of a user
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-user-object
+type User struct {
+	GUID             string `json:"guid"`
+	Username         string `json:"username"`
+	PresentationName string `json:"presentation_name"`
+	Origin           string `json:"origin"`
+}
+
+// RoleResponse represents the V3 API JSON Response when querying for roles
+// with their users included.
+type RoleResponse struct {
+	Pagination Pagination `json:"pagination"`
+	Roles      []Role     `json:"resources"`
+	Included   struct {
+		Users []User `json:"users"`
+	} `json:"included"`
+}
+
+// getV3Resource requests a single V3 API resource and unmarshals it into out.
+func getV3Resource(c *cfclient.Client, requestURL string, resource string, out interface{}) error {
+	r := c.NewRequest("GET", requestURL)
+	resp, err := c.DoRequest(r)
+	if err != nil {
+		return errors.Wrapf(err, "Error requesting %s", resource)
+	}
+	defer resp.Body.Close()
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading %s response", resource)
+	}
+	if err := json.Unmarshal(resBody, out); err != nil {
+		return errors.Wrapf(err, "Error unmarshalling %s", resource)
+	}
+	return nil
+}
+
+// postV3Resource creates a V3 API resource from body and unmarshals the
+// created resource into out.
+func postV3Resource(c *cfclient.Client, requestURL string, resource string, body interface{}, out interface{}) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrapf(err, "Error marshalling %s", resource)
+	}
+	r := c.NewRequestWithBody("POST", requestURL, bytes.NewReader(reqBody))
+	resp, err := c.DoRequest(r)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating %s", resource)
+	}
+	defer resp.Body.Close()
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading %s response", resource)
+	}
+	if err := json.Unmarshal(resBody, out); err != nil {
+		return errors.Wrapf(err, "Error unmarshalling %s", resource)
+	}
+	return nil
+}
+
+// isAPIRejection reports whether err is the CF API rejecting a request, as
+// opposed to the request not reaching it.
+func isAPIRejection(err error) bool {
+	switch errors.Cause(err).(type) {
+	case cfclient.CloudFoundryError, cfclient.CloudFoundryHTTPError:
+		return true
+	}
+	return false
+}
+
+// ListOptions controls how the V3 list endpoints are paginated. The zero
+// value uses the API's default page size and fetches every page.
+type ListOptions struct {
+	// PerPage is the number of results requested per page.
+	PerPage int
+	// MaxPages stops listing after this many pages. Zero means no limit.
+	MaxPages int
+	// AllowPartialResults keeps the results of the pages already fetched
+	// instead of failing when a later page errors.
+	AllowPartialResults bool
+	// Truncated, if set, is set when a listing stops before its last page
+	// because of MaxPages or AllowPartialResults, telling the caller the
+	// results it got are partial.
+	Truncated *atomic.Bool
+	// Logger, if set, logs the pages fetched and the listings cut short.
+	Logger *Logger
+}
+
+// truncate records that a listing stopped before its last page.
+func (o ListOptions) truncate() {
+	if o.Truncated != nil {
+		o.Truncated.Store(true)
+	}
+}
+
+// withPerPage adds the per_page query parameter to requestURL when opts
+// specifies a page size.
+func withPerPage(requestURL string, opts ListOptions) (string, error) {
+	if opts.PerPage <= 0 {
+		return requestURL, nil
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("per_page", strconv.Itoa(opts.PerPage))
+	u.RawQuery = query.Encode()
+	return u.RequestURI(), nil
+}
+
+// listV3Resources walks every page of a V3 API list endpoint starting at
+// requestURL. Each page is unmarshalled by decode, which collects the
+// resources it cares about and returns the pagination block of the page.
+func listV3Resources(c *cfclient.Client, requestURL string, resource string, opts ListOptions, decode func([]byte) (Pagination, error)) error {
+	requestURL, err := withPerPage(requestURL, opts)
+	if err != nil {
+		return errors.Wrapf(err, "Error building %s request", resource)
+	}
+	for page := 1; ; page++ {
+		pagination, err := getV3Page(c, requestURL, resource, decode)
+		if err != nil {
+			if page > 1 && opts.AllowPartialResults {
+				opts.Logger.warnf("Unable to fetch page %d of %s, continuing with partial results. Error: %s\n", page, resource, err)
+				opts.truncate()
+				return nil
+			}
+			return err
+		}
+		if pagination.TotalPages > 1 {
+			opts.Logger.debugf("Fetched page %d of %d of %s (%d total)\n", page, pagination.TotalPages, resource, pagination.TotalResults)
+		}
+
+		requestHref := pagination.Next.Href
+		if requestHref == "" {
+			break
+		}
+		if opts.MaxPages > 0 && page >= opts.MaxPages {
+			opts.Logger.infof("Stopping after %d pages of %s as configured\n", page, resource)
+			opts.truncate()
+			break
+		}
+		u, err := url.Parse(requestHref)
+		if err != nil {
+			break
+		}
+		requestURL = u.RequestURI()
+		if requestURL == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// getV3Page requests a single page of a V3 API list endpoint and hands the
+// response body to decode.
+func getV3Page(c *cfclient.Client, requestURL string, resource string, decode func([]byte) (Pagination, error)) (Pagination, error) {
+	r := c.NewRequest("GET", requestURL)
+	resp, err := c.DoRequest(r)
+	if err != nil {
+		return Pagination{}, errors.Wrapf(err, "Error requesting %s", resource)
+	}
+	defer resp.Body.Close()
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Pagination{}, errors.Wrapf(err, "Error reading %s response", resource)
+	}
+
+	pagination, err := decode(resBody)
+	if err != nil {
+		return Pagination{}, errors.Wrapf(err, "Error unmarshalling %s", resource)
+	}
+	return pagination, nil
+}
+
+// ListApps will query for all V3 App objects along with the Spaces and
+// Organizations they are in, so that those don't need to be requested one at a
+// time.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-apps
+func ListApps(c *cfclient.Client, opts ListOptions) ([]App, []Space, []Organization, error) {
+	apps := []App{}
+	var spaces []Space
+	var orgs []Organization
+	// Every page includes the spaces and organizations of its own apps, so
+	// the same ones come along with many pages.
+	seen := make(map[string]bool)
+	err := ListAppsByPage(c, opts, func(page AppResponse) {
+		apps = append(apps, page.Apps...)
+		for _, space := range page.Included.Spaces {
+			if !seen["space "+space.GUID] {
+				seen["space "+space.GUID] = true
+				spaces = append(spaces, space)
+			}
+		}
+		for _, org := range page.Included.Organizations {
+			if !seen["org "+org.GUID] {
+				seen["org "+org.GUID] = true
+				orgs = append(orgs, org)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return apps, spaces, orgs, nil
+}
+
+// ListAppsByPage is like ListApps, but hands each page of V3 App objects to
+// handle as soon as it is fetched, along with the Spaces and Organizations
+// they are in, instead of holding every app at once.
+func ListAppsByPage(c *cfclient.Client, opts ListOptions, handle func(page AppResponse)) error {
+	return listV3Resources(c, "/v3/apps?include=space,space.organization", "apps", opts, func(body []byte) (Pagination, error) {
+		var appResp AppResponse
+		if err := json.Unmarshal(body, &appResp); err != nil {
+			return Pagination{}, err
+		}
+		handle(appResp)
+		return appResp.Pagination, nil
+	})
+}
+
+// FindApp will query for the V3 App object named name in the space named
+// spaceName of the organization named orgName, along with its Space and
+// Organization. It returns false when there is no such app.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-apps
+func FindApp(c *cfclient.Client, orgName string, spaceName string, name string) (App, Space, Organization, bool, error) {
+	var (
+		app   App
+		space Space
+		org   Organization
+		found bool
+	)
+	requestURL := "/v3/apps?include=space,space.organization&names=" + url.QueryEscape(name)
+	err := listV3Resources(c, requestURL, "apps", ListOptions{}, func(body []byte) (Pagination, error) {
+		var appResp AppResponse
+		if err := json.Unmarshal(body, &appResp); err != nil {
+			return Pagination{}, err
+		}
+		spaces := make(map[string]Space)
+		for _, s := range appResp.Included.Spaces {
+			spaces[s.GUID] = s
+		}
+		orgs := make(map[string]Organization)
+		for _, o := range appResp.Included.Organizations {
+			orgs[o.GUID] = o
+		}
+		for _, a := range appResp.Apps {
+			s := spaces[a.Relationships.Space.Data.GUID]
+			o := orgs[s.Relationships.Organization.Data.GUID]
+			if a.Name == name && s.Name == spaceName && o.Name == orgName {
+				app, space, org, found = a, s, o, true
+			}
+		}
+		return appResp.Pagination, nil
+	})
+	return app, space, org, found, err
+}
+
+// GetApp will query for a single V3 App object.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#get-an-app
+func GetApp(c *cfclient.Client, guid string) (App, error) {
+	var app App
+	err := getV3Resource(c, "/v3/apps/"+guid, "app", &app)
+	return app, err
+}
+
+// GetDropletsByQuery will query for droplets using the passed in query parameters
+// http://v3-apidocs.cloudfoundry.org/version/3.34.0/index.html#list-droplets
+func (a *App) GetDropletsByQuery(c *cfclient.Client, query url.Values) ([]Droplet, error) {
+	var droplets []Droplet
+	requestURL := fmt.Sprintf("/v3/apps/%s/droplets?%s", a.GUID, query.Encode())
+	err := listV3Resources(c, requestURL, "droplets", ListOptions{}, func(body []byte) (Pagination, error) {
+		var dropletResp DropletResponse
+		if err := json.Unmarshal(body, &dropletResp); err != nil {
+			return Pagination{}, err
+		}
+		droplets = append(droplets, dropletResp.Droplets...)
+		return dropletResp.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return droplets, nil
+}
+
+// ListDroplets will query for all V3 Droplet objects matching the passed in
+// query parameters.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-droplets
+func ListDroplets(c *cfclient.Client, query url.Values) ([]Droplet, error) {
+	var droplets []Droplet
+	requestURL := "/v3/droplets?" + query.Encode()
+	err := listV3Resources(c, requestURL, "droplets", ListOptions{}, func(body []byte) (Pagination, error) {
+		var dropletResp DropletResponse
+		if err := json.Unmarshal(body, &dropletResp); err != nil {
+			return Pagination{}, err
+		}
+		droplets = append(droplets, dropletResp.Droplets...)
+		return dropletResp.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return droplets, nil
+}
+
+// GetDroplet will query for a single V3 Droplet object.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#get-a-droplet
+func GetDroplet(c *cfclient.Client, guid string) (Droplet, error) {
+	var droplet Droplet
+	err := getV3Resource(c, "/v3/droplets/"+guid, "droplet", &droplet)
+	return droplet, err
+}
+
+// CreateBuild will stage a new droplet from a package. Staging carries on
+// after it returns.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#create-a-build
+func CreateBuild(c *cfclient.Client, packageGUID string) (Build, error) {
+	var body struct {
+		Package struct {
+			GUID string `json:"guid"`
+		} `json:"package"`
+	}
+	body.Package.GUID = packageGUID
+	var build Build
+	err := postV3Resource(c, "/v3/builds", "build", body, &build)
+	return build, err
+}
+
+// GetBuild will query for a single Build object.
+func GetBuild(c *cfclient.Client, guid string) (Build, error) {
+	var build Build
+	err := getV3Resource(c, "/v3/builds/"+guid, "build", &build)
+	return build, err
+}
+
+// CreateDeployment will roll out a droplet to an app, replacing its instances
+// one at a time. The rollout carries on after it returns.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#create-a-deployment
+func CreateDeployment(c *cfclient.Client, appGUID string, dropletGUID string) (Deployment, error) {
+	var body struct {
+		Droplet struct {
+			GUID string `json:"guid"`
+		} `json:"droplet"`
+		Strategy      string `json:"strategy"`
+		Relationships struct {
+			App Relationship `json:"app"`
+		} `json:"relationships"`
+	}
+	body.Droplet.GUID = dropletGUID
+	body.Strategy = "rolling"
+	body.Relationships.App.Data.GUID = appGUID
+	var deployment Deployment
+	err := postV3Resource(c, "/v3/deployments", "deployment", body, &deployment)
+	return deployment, err
+}
+
+// GetDeployment will query for a single Deployment object.
+func GetDeployment(c *cfclient.Client, guid string) (Deployment, error) {
+	var deployment Deployment
+	err := getV3Resource(c, "/v3/deployments/"+guid, "deployment", &deployment)
+	return deployment, err
+}
+
+// ProcessInstance represents the V3 API JSON object of the stats of a
+// process instance, of which only the state is needed.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#the-process-stats-object
+type ProcessInstance struct {
+	Index int    `json:"index"`
+	State string `json:"state"`
+}
+
+// ProcessStatsResponse represents the V3 API JSON Response when querying for
+// the stats of a process.
+type ProcessStatsResponse struct {
+	Instances []ProcessInstance `json:"resources"`
+}
+
+// GetProcessStats will query for the stats of the instances of an app's
+// process of the given type, e.g. web.
+func GetProcessStats(c *cfclient.Client, appGUID string, processType string) ([]ProcessInstance, error) {
+	var stats ProcessStatsResponse
+	err := getV3Resource(c, fmt.Sprintf("/v3/apps/%s/processes/%s/stats", appGUID, processType), "process stats", &stats)
+	return stats.Instances, err
+}
+
+// ListBuilds will query for the V3 Build objects matching the passed in query
+// parameters.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-builds
+func ListBuilds(c *cfclient.Client, query url.Values, opts ListOptions) ([]Build, error) {
+	var builds []Build
+	requestURL := "/v3/builds?" + query.Encode()
+	err := listV3Resources(c, requestURL, "builds", opts, func(body []byte) (Pagination, error) {
+		var buildResp BuildResponse
+		if err := json.Unmarshal(body, &buildResp); err != nil {
+			return Pagination{}, err
+		}
+		builds = append(builds, buildResp.Builds...)
+		return buildResp.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return builds, nil
+}
+
+// ListDeployments will query for all V3 Deployment objects matching the
+// passed in query parameters.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-deployments
+func ListDeployments(c *cfclient.Client, query url.Values) ([]Deployment, error) {
+	var deployments []Deployment
+	requestURL := "/v3/deployments?" + query.Encode()
+	err := listV3Resources(c, requestURL, "deployments", ListOptions{}, func(body []byte) (Pagination, error) {
+		var deploymentResp DeploymentResponse
+		if err := json.Unmarshal(body, &deploymentResp); err != nil {
+			return Pagination{}, err
+		}
+		deployments = append(deployments, deploymentResp.Deployments...)
+		return deploymentResp.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+// ListBuildpacks will query for all V3 Buildpack objects
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-buildpacks
+func ListBuildpacks(c *cfclient.Client, opts ListOptions) ([]Buildpack, error) {
+	buildpacks := []Buildpack{}
+	err := listV3Resources(c, "/v3/buildpacks", "buildpacks", opts, func(body []byte) (Pagination, error) {
+		var buildpackResp BuildpackResponse
+		if err := json.Unmarshal(body, &buildpackResp); err != nil {
+			return Pagination{}, err
+		}
+		buildpacks = append(buildpacks, buildpackResp.Buildpacks...)
+		return buildpackResp.Pagination, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buildpacks, nil
+}
+
+// GetSpaceWithOrganization will get a single V3 Space object along with the
+// Organization it belongs to.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#get-a-space
+func GetSpaceWithOrganization(c *cfclient.Client, spaceGUID string) (Space, Organization, error) {
+	var spaceResp SpaceResponse
+	requestURL := fmt.Sprintf("/v3/spaces/%s?include=organization", spaceGUID)
+	if err := getV3Resource(c, requestURL, "space", &spaceResp); err != nil {
+		return Space{}, Organization{}, err
+	}
+	orgGUID := spaceResp.Relationships.Organization.Data.GUID
+	for _, org := range spaceResp.Included.Organizations {
+		if org.GUID == orgGUID {
+			return spaceResp.Space, org, nil
+		}
+	}
+	return Space{}, Organization{}, fmt.Errorf("organization %s of space %s not included in response", orgGUID, spaceGUID)
+}
+
+// ListSpaceRoles will query for the V3 Role objects of the given types in
+// many spaces at once along with the Users that hold them.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-roles
+func ListSpaceRoles(c *cfclient.Client, spaceGUIDs []string, types []string) ([]Role, []User, error) {
+	return listRoles(c, url.Values{
+		"space_guids": []string{strings.Join(spaceGUIDs, ",")},
+		"types":       []string{strings.Join(types, ",")},
+		"include":     []string{"user"},
+	})
+}
+
+// ListOrganizationRoles will query for the V3 Role objects of the given types
+// in the given organizations along with the Users that hold them.
+// http://v3-apidocs.cloudfoundry.org/version/3.76.0/index.html#list-roles
+func ListOrganizationRoles(c *cfclient.Client, orgGUIDs []string, types []string) ([]Role, []User, error) {
+	return listRoles(c, url.Values{
+		"organization_guids": []string{strings.Join(orgGUIDs, ",")},
+		"types":              []string{strings.Join(types, ",")},
+		"include":            []string{"user"},
+	})
+}
+
+func listRoles(c *cfclient.Client, query url.Values) ([]Role, []User, error) {
+	var roles []Role
+	var users []User
+	requestURL := "/v3/roles?" + query.Encode()
+	err := listV3Resources(c, requestURL, "roles", ListOptions{}, func(body []byte) (Pagination, error) {
+		var roleResp RoleResponse
+		if err := json.Unmarshal(body, &roleResp); err != nil {
+			return Pagination{}, err
+		}
+		roles = append(roles, roleResp.Roles...)
+		users = append(users, roleResp.Included.Users...)
+		return roleResp.Pagination, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return roles, users, nil
+}
+
+// V2AppResource represents the V2 API JSON object of an app, of which only
+// the staging state is needed.
+// https://apidocs.cloudfoundry.org/280/apps/retrieve_a_particular_app.html
+type V2AppResource struct {
+	Entity struct {
+		PackageState        string `json:"package_state"`
+		StagingFailedReason string `json:"staging_failed_reason"`
+	} `json:"entity"`
+}
+
+// GetV2App will query for a single V2 App object.
+func GetV2App(c *cfclient.Client, guid string) (V2AppResource, error) {
+	var app V2AppResource
+	err := getV3Resource(c, "/v2/apps/"+guid, "app", &app)
+	return app, err
+}
+
+// RestageApp will restage an app, which stages a new droplet from its package
+// and restarts it with the new droplet. Staging carries on after it returns.
+// https://apidocs.cloudfoundry.org/280/apps/restage_an_app.html
+func RestageApp(c *cfclient.Client, guid string) error {
+	r := c.NewRequest("POST", "/v2/apps/"+guid+"/restage")
+	resp, err := c.DoRequest(r)
+	if err != nil {
+		return errors.Wrap(err, "Error restaging app")
+	}
+	resp.Body.Close()
+	return nil
+}
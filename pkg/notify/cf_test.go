@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// newPagedAppServer serves totalPages pages of apps with one app per page.
+// Requests for failPage return an error.
+func newPagedAppServer(t *testing.T, totalPages, failPage int, requestedPerPage *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/apps" {
+			t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+		}
+		page := 1
+		if p := r.URL.Query().Get("page"); p != "" {
+			page, _ = strconv.Atoi(p)
+		}
+		if page == 1 {
+			*requestedPerPage = r.URL.Query().Get("per_page")
+			if include := r.URL.Query().Get("include"); include != "space,space.organization" {
+				t.Errorf("Expected spaces and organizations to be included, found %q", include)
+			}
+		}
+		if page == failPage {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var resp AppResponse
+		resp.Pagination.TotalPages = totalPages
+		resp.Pagination.TotalResults = totalPages
+		if page < totalPages {
+			resp.Pagination.Next.Href = fmt.Sprintf("http://%s/v3/apps?page=%d&per_page=1", r.Host, page+1)
+		}
+		resp.Apps = []App{{GUID: fmt.Sprintf("app%d", page)}}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestListAppsByPage(t *testing.T) {
+	var requestedPerPage string
+	ts := newPagedAppServer(t, 3, 0, &requestedPerPage)
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	var pages [][]App
+	if err := ListAppsByPage(&c, ListOptions{}, func(page AppResponse) { pages = append(pages, page.Apps) }); err != nil {
+		t.Fatalf("Unable to list apps. Error %s", err)
+	}
+	if len(pages) != 3 || len(pages[0]) != 1 || pages[2][0].GUID != "app3" {
+		t.Errorf("Expected a page per app, found %+v", pages)
+	}
+}
+
+func TestListAppsPagination(t *testing.T) {
+	testCases := []struct {
+		name             string
+		totalPages       int
+		failPage         int
+		opts             ListOptions
+		expectedApps     int
+		expectedPerPage  string
+		expectedErrorNil bool
+		expectedTruncate bool
+	}{
+		{"all pages", 3, 0, ListOptions{}, 3, "", true, false},
+		{"per page passed along", 3, 0, ListOptions{PerPage: 1}, 3, "1", true, false},
+		{"max pages", 3, 0, ListOptions{MaxPages: 2}, 2, "", true, true},
+		{"max pages not reached", 2, 0, ListOptions{MaxPages: 2}, 2, "", true, false},
+		{"later page fails", 3, 2, ListOptions{}, 0, "", false, false},
+		{"later page fails with partial results", 3, 3, ListOptions{AllowPartialResults: true}, 2, "", true, true},
+		{"first page fails with partial results", 3, 1, ListOptions{AllowPartialResults: true}, 0, "", false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requestedPerPage string
+			ts := newPagedAppServer(t, tc.totalPages, tc.failPage, &requestedPerPage)
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			opts := tc.opts
+			opts.Truncated = &atomic.Bool{}
+			apps, _, _, err := ListApps(&c, opts)
+			if (err == nil) != tc.expectedErrorNil {
+				t.Fatalf("Test %s failed. Unexpected error result %v", tc.name, err)
+			}
+			if len(apps) != tc.expectedApps {
+				t.Errorf("Test %s failed. Expected %d apps, found %d", tc.name, tc.expectedApps, len(apps))
+			}
+			if requestedPerPage != tc.expectedPerPage {
+				t.Errorf("Test %s failed. Expected per_page %q, found %q", tc.name, tc.expectedPerPage, requestedPerPage)
+			}
+			if opts.Truncated.Load() != tc.expectedTruncate {
+				t.Errorf("Test %s failed. Expected truncated %t, found %t", tc.name, tc.expectedTruncate, opts.Truncated.Load())
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// decisionExplanations explain each decision about an outdated buildpack run
+// in the words of a support ticket.
+var decisionExplanations = map[appDecision]string{
+	decisionFilteredOut:          "It is left out of runs by the INCLUDE_* and EXCLUDE_* settings or the command line flags.",
+	decisionSystemOrg:            "Its org is a system org, so it is left to the operators instead of its owners being notified.",
+	decisionSuspendedOrg:         "Its org is suspended, so its owners can't restage it.",
+	decisionOptedOut:             "It or its space is annotated with " + skipAnnotation + ", opting it out of notifications.",
+	decisionNotStarted:           "It isn't started.",
+	decisionDocker:               "It is a docker app, which isn't staged with buildpacks.",
+	decisionSnoozed:              "It is snoozed with the " + snoozeLabel + " label.",
+	decisionNoDroplet:            "It has no current droplet to check.",
+	decisionUnsupportedBuildpack: "None of the buildpacks it was staged with were updated since the last run.",
+	decisionDisabledBuildpack:    "It was staged with a disabled buildpack, which it can't be restaged with.",
+	decisionGitBuildpack:         "It was only staged with custom buildpacks from git, which never receive platform updates.",
+	decisionNotOutdated:          "It was staged after the latest update of each of its buildpacks.",
+	decisionOutdated:             "It was staged before an update of its buildpacks, so its owners are notified.",
+	decisionError:                "Checking it failed, see the errors logged.",
+}
+
+// checkApp runs the decisions of an outdated buildpack run for the single app
+// with guid and explains them to w. Like a run, it only considers the
+// buildpacks updated since the state was saved.
+func checkApp(client *cfclient.Client, guid string, config Config, listOpts ListOptions, settings runSettings, state *runState, w io.Writer) (appDecision, error) {
+	app, err := GetApp(client, guid)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to get app %s", guid)
+	}
+	spaceGUID := app.Relationships.Space.Data.GUID
+	space, org, err := GetSpaceWithOrganization(client, spaceGUID)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to get space of app %s", guid)
+	}
+	spaces := map[string]spaceInfo{spaceGUID: {Space: space, Org: org}}
+	fmt.Fprintf(w, "App %s guid %s in org %s space %s\n", app.Name, app.GUID, org.Name, space.Name)
+
+	errs := &runErrors{logger: settings.logger}
+	report := newRunReport()
+	apps := filterAppsByScope([]App{app}, spaces, settings.scope, report, settings.logger)
+	var buildpacks map[string]Buildpack
+	var outdatedApps []appInfo
+	if len(apps) > 0 {
+		var disabledBuildpacks map[string]bool
+		buildpacks, disabledBuildpacks, _, err = getUpdatedBuildpacks(client, state.Buildpacks, listOpts, settings.scope.buildpacks, config.IncludeDisabledBuildpacks, errs, settings.logger)
+		if err != nil {
+			return "", err
+		}
+		addBuildpackAliases(buildpacks, config.BuildpackAliases)
+		outdatedApps, _, _ = findOutdatedApps(client, apps, buildpacks, disabledBuildpacks, settings.links, config.ClockSkewTolerance, 1, report, errs, settings.logger)
+	}
+	details := report.appReports()[0]
+	fmt.Fprintf(w, "Decision: %s\n%s\n", details.Decision, decisionExplanations[details.Decision])
+	if details.Droplet != "" {
+		fmt.Fprintf(w, "Current droplet %s was staged at %s with:\n", details.Droplet, details.StagedAt)
+	}
+	for _, buildpack := range details.Buildpacks {
+		fmt.Fprintf(w, "  %s %s: %s\n", buildpack.Name, versionOrUnknown(buildpack.Version), explainBuildpack(buildpack, buildpacks))
+	}
+	if len(outdatedApps) > 0 {
+		settings.owners.report = report
+		owners := findOwnersOfApps(outdatedApps, client, settings.owners, errs, settings.logger)
+		fmt.Fprintf(w, "Owners notified: %s\n", strings.Join(sortedOwners(owners), ", "))
+	}
+	if errs.count() > 0 {
+		errs.logSummary()
+		return details.Decision, errors.Errorf("Checking the app ran into %d errors", errs.count())
+	}
+	return details.Decision, nil
+}
+
+// explainBuildpack explains whether an app is outdated on buildpack, given the
+// buildpacks updated since the last run.
+func explainBuildpack(buildpack buildpackReport, updated map[string]Buildpack) string {
+	if buildpack.Outdated {
+		return fmt.Sprintf("outdated, version %s was uploaded at %s", versionOrUnknown(buildpack.LatestVersion), updated[buildpack.Name].UpdatedAt)
+	}
+	if update, found := updated[buildpack.Name]; found {
+		return fmt.Sprintf("current, the buildpack was last updated at %s", update.UpdatedAt)
+	}
+	return "not updated since the last run"
+}
+
+func versionOrUnknown(version string) string {
+	if version == "" {
+		return "(unknown version)"
+	}
+	return version
+}
+
+// sortedOwners returns the owners found by findOwnersOfApps, sorted.
+func sortedOwners(owners map[string][]appInfo) []string {
+	sorted := make([]string, 0, len(owners))
+	for owner := range owners {
+		sorted = append(sorted, owner)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,102 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestCheckApp(t *testing.T) {
+	buildpacks := []Buildpack{
+		{GUID: "bp1", Name: "python_buildpack", Enabled: true, Filename: "python_buildpack-cflinuxfs4-v1.8.0.zip", UpdatedAt: "2020-02-01T00:00:00Z"},
+	}
+	testCases := []struct {
+		name             string
+		app              App
+		droplet          Droplet
+		state            *runState
+		expectedDecision appDecision
+		expectedOutput   []string
+	}{
+		{
+			"outdated",
+			newTestStartedApp("buildpack"),
+			newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack"),
+			newRunState(),
+			decisionOutdated,
+			[]string{
+				"Decision: outdated",
+				"python_buildpack (unknown version): outdated, version v1.8.0 was uploaded at 2020-02-01T00:00:00Z",
+				"Owners notified: " + user1,
+			},
+		},
+		{
+			"not outdated",
+			newTestStartedApp("buildpack"),
+			newTestDroplet("2020-03-01T00:00:00Z", "python_buildpack"),
+			newRunState(),
+			decisionNotOutdated,
+			[]string{"Decision: not_outdated", "python_buildpack (unknown version): current, the buildpack was last updated at 2020-02-01T00:00:00Z"},
+		},
+		{
+			"not updated since the last run",
+			newTestStartedApp("buildpack"),
+			newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack"),
+			&runState{Buildpacks: map[string]buildpackRecord{"bp1": {LastUpdatedAt: "2020-02-01T00:00:00Z"}}},
+			decisionUnsupportedBuildpack,
+			[]string{"Decision: unsupported_buildpack", "python_buildpack (unknown version): not updated since the last run"},
+		},
+		{
+			"not started",
+			newTestApp("app1", "space1"),
+			Droplet{},
+			newRunState(),
+			decisionNotStarted,
+			[]string{"Decision: not_started", "It isn't started."},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.app.Relationships.Space.Data.GUID = "space1"
+			roles := newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_developer"}})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoder := json.NewEncoder(w)
+				switch r.URL.Path {
+				case "/v3/apps/app1":
+					encoder.Encode(tc.app)
+				case "/v3/spaces/space1":
+					encoder.Encode(newTestSpace("space1"))
+				case "/v3/buildpacks":
+					encoder.Encode(BuildpackResponse{Buildpacks: buildpacks})
+				case "/v3/droplets":
+					encoder.Encode(DropletResponse{Droplets: []Droplet{tc.droplet}})
+				case "/v3/roles":
+					encoder.Encode(rolesInSpaces(r, func(string) RoleResponse { return roles }))
+				default:
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			settings := runSettings{owners: ownerSettings{roles: mustOwnerRoles(t, "space_developer")}}
+			var out bytes.Buffer
+			decision, err := checkApp(&c, "app1", Config{}, ListOptions{}, settings, tc.state, &out)
+			if err != nil {
+				t.Fatalf("Test %s failed. Unable to check app. Error: %s", tc.name, err)
+			}
+			if decision != tc.expectedDecision {
+				t.Errorf("Test %s failed. Expected decision %s, found %s", tc.name, tc.expectedDecision, decision)
+			}
+			for _, expected := range tc.expectedOutput {
+				if !strings.Contains(out.String(), expected) {
+					t.Errorf("Test %s failed. Expected output to contain %q, found\n%s", tc.name, expected, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The outcomes of sending an e-mail recorded in a checkpoint.
+const (
+	deliverySent   = "sent"
+	deliveryFailed = "failed"
+)
+
+// checkpointRecord is a line of a checkpoint, recording an e-mail that was
+// delivered or failed to be. Failed e-mails keep their body so that they can
+// be sent again without running the whole pipeline.
+type checkpointRecord struct {
+	Time      string `json:"time"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Body      string `json:"body,omitempty"`
+}
+
+func (r checkpointRecord) key() string {
+	return r.Recipient + "\x00" + r.Subject
+}
+
+// checkpointMailer records every e-mail its Mailer delivers or fails to in the
+// checkpoint of the run as soon as it is tried, so that a run dying part way
+// through can be resumed without sending anyone the same e-mail twice, and the
+// failed e-mails can be sent again on their own.
+type checkpointMailer struct {
+	Mailer
+	mu        sync.Mutex
+	w         io.WriteCloser
+	delivered map[string]bool
+	skipped   int
+	now       func() time.Time
+	logger    *Logger
+}
+
+// checkpointPath returns the checkpoint of the run with runID in dir.
+func checkpointPath(dir, runID string) string {
+	return filepath.Join(dir, runID+".jsonl")
+}
+
+// newCheckpointMailer records the e-mails mailer delivers in the checkpoint
+// of the run with runID in dir. Resuming the run skips the e-mails its
+// checkpoint already records, and fails if it has none.
+func newCheckpointMailer(mailer Mailer, dir, runID string, resume bool, logger *Logger) (*checkpointMailer, error) {
+	path := checkpointPath(dir, runID)
+	delivered := make(map[string]bool)
+	if resume {
+		records, err := readCheckpoint(path, logger)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to read the checkpoint of run %s", runID)
+		}
+		for _, record := range records {
+			if record.Status != deliveryFailed {
+				delivered[record.key()] = true
+			}
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if resume {
+		// End a line cut short, so that the next record starts a line.
+		if _, err := f.Write([]byte("\n")); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &checkpointMailer{Mailer: mailer, w: f, delivered: delivered, now: time.Now, logger: logger}, nil
+}
+
+// readCheckpoint reads the e-mails recorded in the checkpoint at path. A last
+// line cut short by the run dying is ignored.
+func readCheckpoint(path string, logger *Logger) ([]checkpointRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []checkpointRecord
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record checkpointRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			logger.warnf("Ignoring unreadable line of checkpoint %s. Error: %s\n", path, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// SendEmail sends the e-mail unless the checkpoint records it was already
+// delivered, and records it once it is.
+func (m *checkpointMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	record := checkpointRecord{Recipient: emailAddress, Subject: subject}
+	m.mu.Lock()
+	delivered := m.delivered[record.key()]
+	if delivered {
+		m.skipped++
+	}
+	m.mu.Unlock()
+	if delivered {
+		m.logger.debugf("Skipping e-mail %q to %s, which was already sent.\n", subject, emailAddress)
+		return nil
+	}
+	sendErr := m.Mailer.SendEmail(emailAddress, subject, body)
+	record.Time = m.now().UTC().Format(time.RFC3339)
+	record.Status = deliverySent
+	if sendErr != nil {
+		record.Status, record.Error, record.Body = deliveryFailed, sendErr.Error(), string(body)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sendErr != nil {
+		if _, err := m.w.Write(append(line, '\n')); err != nil {
+			m.logger.warnf("Unable to record the failed e-mail to %s in the checkpoint. Error: %s\n", emailAddress, err)
+		}
+		return sendErr
+	}
+	m.delivered[record.key()] = true
+	if _, err := m.w.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "Sent, but unable to record the e-mail in the checkpoint")
+	}
+	return nil
+}
+
+// failedDeliveries returns the e-mails recorded in a checkpoint whose last
+// attempt failed, in the order they were first tried.
+func failedDeliveries(records []checkpointRecord) []checkpointRecord {
+	last := make(map[string]checkpointRecord)
+	var order []string
+	for _, record := range records {
+		if _, found := last[record.key()]; !found {
+			order = append(order, record.key())
+		}
+		last[record.key()] = record
+	}
+	var failed []checkpointRecord
+	for _, key := range order {
+		if last[key].Status == deliveryFailed {
+			failed = append(failed, last[key])
+		}
+	}
+	return failed
+}
+
+// resendFailures sends the e-mails that failed in the run whose checkpoint
+// is at path again through mailer, which records them in the same checkpoint.
+func resendFailures(path string, mailer Mailer, errs *runErrors, logger *Logger) error {
+	records, err := readCheckpoint(path, logger)
+	if err != nil {
+		return err
+	}
+	failed := failedDeliveries(records)
+	logger.infof("Re-sending %d e-mails that failed.\n", len(failed))
+	for _, record := range failed {
+		if err := mailer.SendEmail(record.Recipient, record.Subject, []byte(record.Body)); err != nil {
+			errs.addf("Unable to send e-mail to %s. Error: %s", record.Recipient, err)
+			continue
+		}
+		logger.with(logFields{"recipient", record.Recipient}).infof("Sent e-mail to %s\n", record.Recipient)
+	}
+	return nil
+}
+
+// close closes the checkpoint, logging how many e-mails were skipped.
+func (m *checkpointMailer) close() error {
+	if m.skipped > 0 {
+		m.logger.infof("Skipped %d e-mails already sent before resuming.\n", m.skipped)
+	}
+	return m.w.Close()
+}
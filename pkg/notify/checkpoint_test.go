@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/cloud-gov/buildpack-notify/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCheckpointMailer(t *testing.T) {
+	dir := t.TempDir()
+	// The first run dies after delivering to user1 and failing to reach user2.
+	mockMailer := new(mocks.Mailer)
+	mockMailer.On("SendEmail", user1, "Action required", mock.Anything).Return(nil)
+	mockMailer.On("SendEmail", user2, "Action required", mock.Anything).Return(errors.New("connection reset"))
+	checkpoint, err := newCheckpointMailer(mockMailer, dir, "run1", false, nil)
+	if err != nil {
+		t.Fatalf("Unable to open checkpoint. Error: %s", err)
+	}
+	if err := checkpoint.SendEmail(user1, "Action required", []byte("body")); err != nil {
+		t.Errorf("Expected the e-mail to user1 to be sent, found %s", err)
+	}
+	if err := checkpoint.SendEmail(user2, "Action required", []byte("body")); err == nil {
+		t.Errorf("Expected the e-mail to user2 to fail")
+	}
+	checkpoint.close()
+	// Simulate the run dying part way through writing a line.
+	f, _ := os.OpenFile(checkpointPath(dir, "run1"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"time": "2020-`)
+	f.Close()
+
+	// Resuming it only sends the e-mail that wasn't delivered.
+	mockMailer = new(mocks.Mailer)
+	mockMailer.On("SendEmail", user2, "Action required", mock.Anything).Return(nil)
+	checkpoint, err = newCheckpointMailer(mockMailer, dir, "run1", true, nil)
+	if err != nil {
+		t.Fatalf("Unable to resume checkpoint. Error: %s", err)
+	}
+	for _, user := range []string{user1, user2} {
+		if err := checkpoint.SendEmail(user, "Action required", []byte("body")); err != nil {
+			t.Errorf("Expected the e-mail to %s to succeed, found %s", user, err)
+		}
+	}
+	checkpoint.close()
+	mockMailer.AssertNumberOfCalls(t, "SendEmail", 1)
+	records, err := readCheckpoint(checkpointPath(dir, "run1"), nil)
+	if err != nil || len(records) != 3 || records[0].Status != deliverySent || records[1].Status != deliveryFailed || records[2].Recipient != user2 || records[2].Status != deliverySent {
+		t.Errorf("Expected the checkpoint to record both e-mails and the failure, found %+v. Error: %v", records, err)
+	}
+
+	if _, err := newCheckpointMailer(mockMailer, dir, "unknown", true, nil); err == nil {
+		t.Errorf("Expected resuming a run without a checkpoint to fail")
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected a single checkpoint, found %d", len(entries))
+	}
+}
+
+func TestResendFailures(t *testing.T) {
+	dir := t.TempDir()
+	mockMailer := new(mocks.Mailer)
+	mockMailer.On("SendEmail", user1, "Action required", mock.Anything).Return(nil)
+	mockMailer.On("SendEmail", user2, "Action required", mock.Anything).Return(errors.New("mailbox full"))
+	checkpoint, err := newCheckpointMailer(mockMailer, dir, "run1", false, nil)
+	if err != nil {
+		t.Fatalf("Unable to open checkpoint. Error: %s", err)
+	}
+	checkpoint.SendEmail(user1, "Action required", []byte("body 1"))
+	checkpoint.SendEmail(user2, "Action required", []byte("body 2"))
+	checkpoint.close()
+
+	// Only the failed e-mail is sent again, with the body it was rendered with.
+	mockMailer = new(mocks.Mailer)
+	mockMailer.On("SendEmail", user2, "Action required", []byte("body 2")).Return(nil)
+	checkpoint, err = newCheckpointMailer(mockMailer, dir, "run1", true, nil)
+	if err != nil {
+		t.Fatalf("Unable to resume checkpoint. Error: %s", err)
+	}
+	errs := &runErrors{}
+	if err := resendFailures(checkpointPath(dir, "run1"), checkpoint, errs, nil); err != nil {
+		t.Fatalf("Unable to re-send failed e-mails. Error: %s", err)
+	}
+	checkpoint.close()
+	mockMailer.AssertNumberOfCalls(t, "SendEmail", 1)
+	if errs.count() != 0 {
+		t.Errorf("Expected no errors, found %d", errs.count())
+	}
+	records, _ := readCheckpoint(checkpointPath(dir, "run1"), nil)
+	if failed := failedDeliveries(records); len(failed) != 0 {
+		t.Errorf("Expected no failed e-mails left, found %+v", failed)
+	}
+}
@@ -0,0 +1,229 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The limits of a single PutLogEvents request, see
+// https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutLogEvents.html.
+const (
+	cloudWatchMaxEvents = 10000
+	// cloudWatchMaxBatchBytes counts the message of every event along with
+	// the 26 bytes CloudWatch adds to each.
+	cloudWatchMaxBatchBytes = 1048576
+	cloudWatchEventOverhead = 26
+)
+
+// cloudWatchLogs ships the lines logged by a run to a CloudWatch Logs stream
+// named after the run, and publishes the metrics of the run to the same
+// stream in the embedded metric format, which CloudWatch extracts them from.
+type cloudWatchLogs struct {
+	config    AWSConfig
+	group     string
+	namespace string
+	client    *http.Client
+	now       func() time.Time
+
+	mu     sync.Mutex
+	stream string
+	events []cloudWatchEvent
+	size   int
+	// err is the first failure to ship the lines, which can't be logged
+	// while lines are being written.
+	err error
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// newCloudWatchLogs returns the shipper writing to group, publishing metrics
+// under namespace.
+func newCloudWatchLogs(config AWSConfig, group, namespace string) *cloudWatchLogs {
+	return &cloudWatchLogs{
+		config:    config,
+		group:     group,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+	}
+}
+
+// start creates the stream of the run with runID, which a resumed run
+// shares with the run it resumes.
+func (c *cloudWatchLogs) start(runID string) error {
+	c.mu.Lock()
+	c.stream = runID
+	c.mu.Unlock()
+	err := c.call("CreateLogStream", map[string]string{"logGroupName": c.group, "logStreamName": runID}, nil)
+	if err != nil && strings.Contains(err.Error(), "ResourceAlreadyExistsException") {
+		return nil
+	}
+	return err
+}
+
+// Write buffers the lines in p as events, sending them once there are
+// enough for a request.
+func (c *cloudWatchLogs) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now().UnixMilli()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		size := len(line) + cloudWatchEventOverhead
+		if len(c.events) == cloudWatchMaxEvents || c.size+size > cloudWatchMaxBatchBytes {
+			c.flushLocked()
+		}
+		c.events = append(c.events, cloudWatchEvent{Timestamp: now, Message: line})
+		c.size += size
+	}
+	return len(p), nil
+}
+
+// flushLocked sends the buffered events, keeping the first failure for
+// close to return. c.mu must be held.
+func (c *cloudWatchLogs) flushLocked() {
+	if len(c.events) == 0 {
+		return
+	}
+	body := map[string]interface{}{"logGroupName": c.group, "logStreamName": c.stream, "logEvents": c.events}
+	if err := c.call("PutLogEvents", body, nil); err != nil && c.err == nil {
+		c.err = err
+	}
+	c.events, c.size = nil, 0
+}
+
+// close sends the lines still buffered, returning the first failure to ship
+// any of them.
+func (c *cloudWatchLogs) close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+	err := c.err
+	c.err = nil
+	return err
+}
+
+// publish sends the metrics of the run as an event in the embedded metric
+// format, see
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html.
+func (c *cloudWatchLogs) publish(m runMetrics) error {
+	outdated := m.Decisions[decisionOutdated] + m.Decisions[decisionHeldBack]
+	evaluated := 0
+	for _, count := range m.Decisions {
+		evaluated += count
+	}
+	values := []struct {
+		name  string
+		unit  string
+		value float64
+	}{
+		{"AppsEvaluated", "Count", float64(evaluated)},
+		{"OutdatedApps", "Count", float64(outdated)},
+		{"OwnersNotified", "Count", float64(m.OwnersNotified)},
+		{"EmailsSent", "Count", float64(m.EmailsSent)},
+		{"EmailsFailed", "Count", float64(m.EmailsFailed)},
+		{"CFAPIRequests", "Count", float64(m.CFAPIRequests)},
+		{"RunDuration", "Seconds", m.Duration.Seconds()},
+	}
+	var definitions []map[string]string
+	event := map[string]interface{}{"Service": "buildpack-notify", "RunId": m.RunID}
+	for _, v := range values {
+		definitions = append(definitions, map[string]string{"Name": v.name, "Unit": v.unit})
+		event[v.name] = v.value
+	}
+	event["_aws"] = map[string]interface{}{
+		"Timestamp": m.FinishedAt.UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  c.namespace,
+			"Dimensions": [][]string{{"Service"}},
+			"Metrics":    definitions,
+		}},
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	stream := c.stream
+	c.mu.Unlock()
+	body := map[string]interface{}{
+		"logGroupName":  c.group,
+		"logStreamName": stream,
+		"logEvents":     []cloudWatchEvent{{Timestamp: m.FinishedAt.UnixMilli(), Message: string(message)}},
+	}
+	return c.call("PutLogEvents", body, http.Header{"X-Amzn-Logs-Format": {"json/emf"}})
+}
+
+// endpoint returns the URL of the CloudWatch Logs API.
+func (c *cloudWatchLogs) endpoint() string {
+	if c.config.LogsEndpoint != "" {
+		return strings.TrimSuffix(c.config.LogsEndpoint, "/") + "/"
+	}
+	return "https://logs." + c.config.Region + ".amazonaws.com/"
+}
+
+// call calls action of the CloudWatch Logs API with input.
+func (c *cloudWatchLogs) call(action string, input interface{}, header http.Header) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.endpoint(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	if err := signAWS(req, body, c.config, "logs", c.now()); err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("CloudWatch Logs responded to %s with %s: %s", action, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// shipLogs returns a copy of logger also shipping the lines logged to it to
+// shipper, along with the function that stops it and sends the lines still
+// buffered. Without a shipper, logger is returned as is.
+func shipLogs(shipper *cloudWatchLogs, runID string, logger *Logger) (*Logger, func()) {
+	if shipper == nil || logger == nil {
+		return logger, func() {}
+	}
+	if err := shipper.start(runID); err != nil {
+		logger.warnf("Unable to create CloudWatch log stream %s. Error: %s\n", runID, err)
+		return logger, func() {}
+	}
+	return logger.withOutput(io.MultiWriter(logger.out, shipper)), func() {
+		// Failures to ship are logged to the logger not shipping its
+		// lines, which would be shipped nowhere otherwise.
+		if err := shipper.close(); err != nil {
+			logger.warnf("Unable to ship the logs of the run to CloudWatch. Error: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cohorts split the recipients of a foundation-wide notification into count
+// groups, so that a single group is notified per run to spread the support
+// tickets that follow. A recipient is always in the same cohort.
+type cohorts struct {
+	count int
+	// current is the cohort notified by this run, from 0.
+	current int
+}
+
+// cohorts returns the cohorts of a campaign or stack end of life run at now,
+// or nil when every recipient is notified at once. Without a cohort given
+// with --cohort, the cohort changes every day.
+func (c Config) cohorts(now time.Time) (*cohorts, error) {
+	if c.Cohorts <= 1 {
+		if c.Cohort != 0 {
+			return nil, errors.New("--cohort needs COHORTS")
+		}
+		return nil, nil
+	}
+	if c.CampaignBuildpack == "" && len(c.EOLStacks) == 0 {
+		return nil, errors.New("COHORTS only applies to campaigns and stack end of life notifications")
+	}
+	if c.Cohort < 0 || c.Cohort > c.Cohorts {
+		return nil, errors.Errorf("Invalid cohort %d, expected 1 to %d", c.Cohort, c.Cohorts)
+	}
+	current := c.Cohort - 1
+	if c.Cohort == 0 {
+		current = int(now.UTC().Unix()/int64(24*time.Hour/time.Second)) % c.Cohorts
+	}
+	return &cohorts{count: c.Cohorts, current: current}, nil
+}
+
+// of returns the cohort of recipient, from a hash of the address so that it
+// doesn't depend on who else is notified.
+func (c *cohorts) of(recipient string) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(recipient)))
+	return int(h.Sum32() % uint32(c.count))
+}
+
+// filter returns the owners in the cohort notified by this run. Nil cohorts
+// keep every owner.
+func (c *cohorts) filter(owners map[string][]appInfo, logger *Logger) map[string][]appInfo {
+	if c == nil {
+		return owners
+	}
+	filtered := make(map[string][]appInfo)
+	for owner, apps := range owners {
+		if c.of(owner) == c.current {
+			filtered[owner] = apps
+		}
+	}
+	logger.infof("Notifying cohort %d of %d: %d of %d owners.\n", c.current+1, c.count, len(filtered), len(owners))
+	return filtered
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCohorts(t *testing.T) {
+	owners := make(map[string][]appInfo)
+	for i := 0; i < 100; i++ {
+		owners[fmt.Sprintf("user%d@example.com", i)] = []appInfo{{App: App{GUID: fmt.Sprintf("app%d", i)}}}
+	}
+	// Every owner is notified in exactly one of the cohorts, whichever order
+	// they are run in.
+	notified := make(map[string]int)
+	for cohort := 3; cohort >= 1; cohort-- {
+		c, err := Config{CampaignBuildpack: "php_buildpack", Cohorts: 3, Cohort: cohort}.cohorts(time.Now())
+		if err != nil {
+			t.Fatalf("Unable to parse cohorts. Error: %s", err)
+		}
+		filtered := c.filter(owners, nil)
+		if len(filtered) == 0 || len(filtered) == len(owners) {
+			t.Errorf("Expected cohort %d to hold some of the owners, found %d", cohort, len(filtered))
+		}
+		for owner := range filtered {
+			notified[owner]++
+		}
+	}
+	for owner := range owners {
+		if notified[owner] != 1 {
+			t.Errorf("Expected %s to be notified once, found %d", owner, notified[owner])
+		}
+	}
+	if c := (&cohorts{count: 3}); c.of("User1@Example.com") != c.of("user1@example.com") {
+		t.Errorf("Expected the cohort of an address not to depend on its case")
+	}
+
+	day := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	today, _ := Config{EOLStacks: []string{"cflinuxfs3"}, Cohorts: 3}.cohorts(day)
+	tomorrow, _ := Config{EOLStacks: []string{"cflinuxfs3"}, Cohorts: 3}.cohorts(day.Add(24 * time.Hour))
+	if tomorrow.current != (today.current+1)%3 {
+		t.Errorf("Expected the next cohort to be notified the next day, found %d then %d", today.current, tomorrow.current)
+	}
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{"cohort without cohorts", Config{CampaignBuildpack: "php_buildpack", Cohort: 2}},
+		{"cohort out of range", Config{CampaignBuildpack: "php_buildpack", Cohorts: 3, Cohort: 4}},
+		{"cohorts of outdated buildpack notifications", Config{Cohorts: 3}},
+	}
+	for _, tc := range testCases {
+		if _, err := tc.config.cohorts(time.Now()); err == nil {
+			t.Errorf("Test %s failed. Expected an error", tc.name)
+		}
+	}
+	if c, err := (Config{}).cohorts(time.Now()); c != nil || err != nil || len(c.filter(owners, nil)) != len(owners) {
+		t.Errorf("Expected every owner to be notified without cohorts")
+	}
+}
@@ -0,0 +1,281 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The functions below do the work of the commands of buildpack-notify other
+// than the runs, which Run does. The command line parses the flags and the
+// environment into their arguments and exits with the ExitCode of the error
+// they return. Those given a Config log the failure they return and report
+// it to config.ErrorReporter, like Run, and the others leave that to the
+// caller.
+
+// logFailure logs err, the failure a command returns, and reports it to
+// reporter, unless its details were already logged.
+func logFailure(err error, logger *Logger, reporter *ErrorReporter) {
+	if err == nil {
+		return
+	}
+	if failure, ok := err.(*Error); ok && failure.logged {
+		return
+	}
+	logger.errorf("%s\n", err)
+	reporter.captureMessage("fatal", err.Error())
+}
+
+// EOLReport lists the apps affected by the end of support dates of the EOL
+// calendar coming up or passed to w, or to a CSV file at csvPath if set,
+// without notifying anyone or changing the state.
+func EOLReport(config Config, cfAPIConfig CFAPIConfig, csvPath string, w io.Writer) (err error) {
+	insecure := os.Getenv("INSECURE") == "1"
+	settings, err := parseSettings(config, cfAPIConfig, insecure)
+	logger := settings.logger
+	defer func() { logFailure(err, logger, config.ErrorReporter) }()
+	if err != nil {
+		return err
+	}
+	if settings.calendar == nil {
+		return failf(ExitConfig, "Reporting on end of support dates needs EOL_CALENDAR.")
+	}
+	client, _, err := newCFClient(cfAPIConfig, settings.transport, insecure, logger)
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to create client. Error: %s", err.Error())
+	}
+	errs := &runErrors{logger: logger}
+	report := newRunReport()
+	entries, err := findAppsReachingEOL(client, settings.calendar, settings.scope, cfAPIConfig.listOptions(), cfAPIConfig.DropletConcurrency, report, time.Now(), errs, logger)
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to list apps. Error: %s", err)
+	}
+	if csvPath != "" {
+		writeCSV := func(w io.Writer) error { return writeEOLCSV(w, entries) }
+		if err := writeReportFile(csvPath, writeCSV); err != nil {
+			errs.addf("Unable to write CSV report. Error: %s", err)
+		}
+	} else if err := writeEOLReport(w, entries); err != nil {
+		errs.addf("Unable to write report. Error: %s", err)
+	}
+	writeReports(config, report, newRunID(), errs)
+	if errs.count() > 0 {
+		errs.logSummary()
+	}
+	return errs.failure()
+}
+
+// CheckApp explains to w why the app with guid is or isn't considered
+// outdated by a run, without notifying anyone or changing the state.
+func CheckApp(config Config, cfAPIConfig CFAPIConfig, guid string, w io.Writer) (err error) {
+	insecure := os.Getenv("INSECURE") == "1"
+	settings, err := parseSettings(config, cfAPIConfig, insecure)
+	logger := settings.logger
+	defer func() { logFailure(err, logger, config.ErrorReporter) }()
+	if err != nil {
+		return err
+	}
+	state, err := loadState(config.InState)
+	if err != nil {
+		return failf(ExitFailed, "Error reading state: %s", err)
+	}
+	client, _, err := newCFClient(cfAPIConfig, settings.transport, insecure, logger)
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to create client. Error: %s", err.Error())
+	}
+	if _, err := checkApp(client, guid, config, cfAPIConfig.listOptions(), settings, state, w); err != nil {
+		return &Error{Code: ExitCFAPI, Err: err}
+	}
+	return nil
+}
+
+// SmokeTest takes the app designated by smokeConfig through the pipeline and
+// sends its e-mail to the designated mailbox only through mailer, printing
+// each step to w, then checks the e-mail was delivered.
+func SmokeTest(config Config, cfAPIConfig CFAPIConfig, smokeConfig SmokeTestConfig, mailer Mailer, w io.Writer) (err error) {
+	insecure := os.Getenv("INSECURE") == "1"
+	settings, err := parseSettings(config, cfAPIConfig, insecure)
+	logger := settings.logger
+	defer func() { logFailure(err, logger, config.ErrorReporter) }()
+	if err != nil {
+		return err
+	}
+	checker, err := smokeConfig.deliveryChecker(logger)
+	if err != nil {
+		return failf(ExitConfig, "Unable to parse smoke test config: %s", err)
+	}
+	templates, err := initTemplates()
+	if err != nil {
+		return failf(ExitConfig, "Unable to initialize templates: %s", err)
+	}
+	client, _, err := newCFClient(cfAPIConfig, settings.transport, insecure, logger)
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to create client. Error: %s", err.Error())
+	}
+	runID := config.RunID
+	if runID == "" {
+		runID = newRunID()
+	}
+	logger = logger.WithRunID(runID)
+	logger.infof("Starting smoke test %s.\n", runID)
+	test := &smokeTest{config: smokeConfig, client: client, owners: settings.owners, links: settings.links, templates: templates, mailer: withRunID(mailer, runID), checker: checker, logger: logger, interval: 10 * time.Second}
+	if err := test.run(runID, w); err != nil {
+		return &Error{Code: ExitCode(err), Err: errors.Wrapf(err, "Smoke test %s failed", runID)}
+	}
+	fmt.Fprintf(w, "Smoke test %s passed.\n", runID)
+	return nil
+}
+
+// ResendFailures sends the e-mails that failed in the run with runID again
+// through mailer, from its checkpoint in config.CheckpointDir, without
+// checking any apps. It only reads CheckpointDir, Logger and ErrorReporter
+// of config.
+func ResendFailures(config Config, runID string, mailer Mailer) (err error) {
+	logger := config.Logger
+	defer func() { logFailure(err, logger, config.ErrorReporter) }()
+	errs := &runErrors{logger: logger}
+	checkpoint, err := newCheckpointMailer(errs.trackSends(mailer), config.CheckpointDir, runID, true, logger)
+	if err != nil {
+		return failf(ExitFailed, "Unable to open checkpoint. Error: %s", err)
+	}
+	defer checkpoint.close()
+	if err := resendFailures(checkpointPath(config.CheckpointDir, runID), checkpoint, errs, logger); err != nil {
+		return failf(ExitFailed, "Unable to read checkpoint. Error: %s", err)
+	}
+	if errs.count() > 0 {
+		errs.logSummary()
+	}
+	return errs.failure()
+}
+
+// ExportFixtures exports the apps, buildpacks, droplets and roles a run reads
+// from the CF API to a fixtures file at path, for Simulate.
+func ExportFixtures(config Config, cfAPIConfig CFAPIConfig, path string) (err error) {
+	insecure := os.Getenv("INSECURE") == "1"
+	settings, err := parseSettings(config, cfAPIConfig, insecure)
+	logger := settings.logger
+	defer func() { logFailure(err, logger, config.ErrorReporter) }()
+	if err != nil {
+		return err
+	}
+	client, _, err := newCFClient(cfAPIConfig, settings.transport, insecure, logger)
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to create client. Error: %s", err.Error())
+	}
+	errs := &runErrors{logger: logger}
+	exported, err := exportFixtures(client, cfAPIConfig.listOptions(), cfAPIConfig.DropletConcurrency, errs, logger)
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to export fixtures. Error: %s", err)
+	}
+	if err := saveFixtures(exported, path); err != nil {
+		return failf(ExitFailed, "Unable to write fixtures. Error: %s", err)
+	}
+	logger.infof("Exported %d apps, %d buildpacks, %d droplets and %d roles to %s.\n", len(exported.Apps), len(exported.Buildpacks), len(exported.Droplets), len(exported.Roles), path)
+	if errs.count() > 0 {
+		errs.logSummary()
+	}
+	return errs.failure()
+}
+
+// GenerateFixtures generates a fake foundation of the shape of spec to a
+// fixtures file at path, for Simulate.
+func GenerateFixtures(spec FixtureSpec, path string, logger *Logger) error {
+	generated := generateFixtures(spec, time.Now())
+	if err := saveFixtures(generated, path); err != nil {
+		return failf(ExitFailed, "Unable to write fixtures. Error: %s", err)
+	}
+	logger.infof("Generated %d apps, %d buildpacks, %d droplets and %d roles to %s.\n", len(generated.Apps), len(generated.Buildpacks), len(generated.Droplets), len(generated.Roles), path)
+	return nil
+}
+
+// Simulate runs the pipeline against the fixtures at fixturesPath instead of
+// the CF API, writing every e-mail the run would send to a file in emailDir,
+// or sending nothing without one. The state is left alone, and nothing is
+// restaged or looked up in UAA, which need a real foundation.
+func Simulate(config Config, cfAPIConfig CFAPIConfig, fixturesPath, emailDir string) error {
+	config.Fixtures = fixturesPath
+	config.ReadOnly = true
+	config.AutoRestage = false
+	config.ResolveEmailsViaUAA = false
+	if emailDir == "" {
+		config.DryRun = true
+		return Run(config, cfAPIConfig, nil)
+	}
+	mailer, err := newDirMailer(emailDir)
+	if err != nil {
+		err = failf(ExitFailed, "Unable to create mailer. Error: %s", err)
+		logFailure(err, config.Logger, config.ErrorReporter)
+		return err
+	}
+	config.DryRun = false
+	return Run(config, cfAPIConfig, mailer)
+}
+
+// RenderPreviews renders every variant of the e-mail templates from fixed
+// data to text and HTML files in dir, previewing the campaign template at
+// campaignPath, or the example one without it. It returns the number of
+// previews rendered.
+func RenderPreviews(campaignPath, dir string) (int, error) {
+	templates, err := initPreviewTemplates(campaignPath)
+	if err != nil {
+		return 0, failf(ExitConfig, "Error reading templates: %s", err)
+	}
+	previews := templatePreviews()
+	if err := renderPreviews(templates, previews, dir); err != nil {
+		return 0, failf(ExitFailed, "Error rendering previews: %s", err)
+	}
+	return len(previews), nil
+}
+
+// ShowState prints the state at path to w.
+func ShowState(path string, w io.Writer) error {
+	state, err := loadState(path)
+	if err != nil {
+		return failf(ExitFailed, "Error reading state: %s", err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(state); err != nil {
+		return failf(ExitFailed, "Error printing state: %s", err)
+	}
+	return nil
+}
+
+// DiffStates prints what changed between the states at beforePath and
+// afterPath to w.
+func DiffStates(beforePath, afterPath string, w io.Writer) error {
+	before, err := loadState(beforePath)
+	if err != nil {
+		return failf(ExitFailed, "Error reading state %s: %s", beforePath, err)
+	}
+	after, err := loadState(afterPath)
+	if err != nil {
+		return failf(ExitFailed, "Error reading state %s: %s", afterPath, err)
+	}
+	writeStateDiff(w, before, after)
+	return nil
+}
+
+// ShowHistory prints the trend of the outdated and restaged apps over the
+// last runs recorded in the history database at path to w, or over every
+// run if runs is 0.
+func ShowHistory(path string, runs int, w io.Writer) error {
+	snapshots, err := loadRunSnapshots(path)
+	if err != nil {
+		return failf(ExitFailed, "Error reading history: %s", err)
+	}
+	if err := writeHistory(w, snapshots, runs); err != nil {
+		return failf(ExitFailed, "Error printing history: %s", err)
+	}
+	return nil
+}
+
+// WriteVersion prints the version of the build, its commit, when it was built
+// and the CF API versions it was tested against to w.
+func WriteVersion(w io.Writer) {
+	writeVersion(w, currentBuild())
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+func setTestConfigEnv(t *testing.T) {
+	for key, value := range map[string]string{
+		"IN_STATE":      "in.json",
+		"OUT_STATE":     "out.json",
+		"SMTP_FROM":     "no-reply@example.com",
+		"SMTP_HOST":     "smtp.example.com",
+		"SMTP_PASSWORD": "password",
+		"SMTP_PORT":     "587",
+		"SMTP_USER":     "user",
+		"CF_API":        "https://api.example.com",
+		"CLIENT_ID":     "buildpack-notify",
+		"CLIENT_SECRET": "secret",
+	} {
+		t.Setenv(key, value)
+	}
+}
+
+// loadTestConfig reads the configuration from the environment, the way the
+// command line does.
+func loadTestConfig(t *testing.T) (Config, CFAPIConfig) {
+	var (
+		config      Config
+		cfAPIConfig CFAPIConfig
+	)
+	if err := envconfig.Process("", &config); err != nil {
+		t.Fatalf("Unable to parse config. Error: %s", err)
+	}
+	if err := envconfig.Process("", &cfAPIConfig); err != nil {
+		t.Fatalf("Unable to parse cf api config. Error: %s", err)
+	}
+	return config, cfAPIConfig
+}
+
+func TestValidateConfig(t *testing.T) {
+	setTestConfigEnv(t)
+	if problems := ValidateConfig(loadTestConfig(t)); len(problems) != 0 {
+		t.Errorf("Expected a valid configuration, found %v", problems)
+	}
+
+	// Every problem is listed, not only the first.
+	t.Setenv("OWNER_ROLES", "space_janitor")
+	t.Setenv("RESTAGE_WINDOWS", "* * *")
+	t.Setenv("AUTO_RESTAGE", "true")
+	problems := ValidateConfig(loadTestConfig(t))
+	if len(problems) != 2 || !strings.Contains(problems[0].Error(), "restage windows") || !strings.Contains(problems[1].Error(), "owner roles") {
+		t.Errorf("Expected invalid restage windows and owner roles, found %v", problems)
+	}
+
+	// Runs cut short by CF_MAX_PAGES leave the state alone, so they would
+	// notify the owners of the apps on the first pages every time.
+	t.Setenv("CF_MAX_PAGES", "1")
+	if problems := ValidateConfig(loadTestConfig(t)); len(problems) != 3 || !strings.Contains(problems[0].Error(), "CF_MAX_PAGES") {
+		t.Errorf("Expected CF_MAX_PAGES to need DRY_RUN, found %v", problems)
+	}
+	t.Setenv("DRY_RUN", "true")
+	if problems := ValidateConfig(loadTestConfig(t)); len(problems) != 2 {
+		t.Errorf("Expected CF_MAX_PAGES to be allowed in dry runs, found %v", problems)
+	}
+}
+
+func TestStateCommands(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(`{"Buildpacks": {}}`), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	var out bytes.Buffer
+	if err := ShowState(statePath, &out); err != nil || !strings.Contains(out.String(), `"Buildpacks": {}`) {
+		t.Errorf("Expected the state to be shown, found %v\n%s", err, out.String())
+	}
+	if err := ShowState(filepath.Join(dir, "missing.json"), &out); ExitCode(err) != ExitFailed {
+		t.Errorf("Expected a missing state to fail, found %v", err)
+	}
+	if err := DiffStates(statePath, statePath, &out); err != nil {
+		t.Errorf("Expected the states to be diffed, found %v", err)
+	}
+}
+
+func TestCheckStateFiles(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(`{"Buildpacks": {}}`), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	if problems := checkStateFiles(statePath, filepath.Join(dir, "out.json")); len(problems) != 0 {
+		t.Errorf("Expected the state files to be usable, found %v", problems)
+	}
+	problems := checkStateFiles(filepath.Join(dir, "missing.json"), filepath.Join(dir, "missing", "out.json"))
+	if len(problems) != 2 || !strings.Contains(problems[0].Error(), "IN_STATE") || !strings.Contains(problems[1].Error(), "OUT_STATE") {
+		t.Errorf("Expected IN_STATE and OUT_STATE problems, found %v", problems)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected the check to leave no files behind, found %d", len(entries))
+	}
+}
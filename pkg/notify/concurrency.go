@@ -0,0 +1,43 @@
+package notify
+
+import "sync"
+
+// forEachConcurrently calls work with every index below count, from at most
+// concurrency goroutines at a time, and returns once every call returned.
+// Each stage of the pipeline bounds its requests to the CF API this way, so
+// that large foundations are checked in parallel without flooding the API.
+func forEachConcurrently(count, concurrency int, work func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency && worker < count; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				work(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// guidBatches splits count GUIDs into the [start, end) ranges of the bulk
+// requests filtering on them, guidsPerRequest at a time.
+func guidBatches(count int) [][2]int {
+	var batches [][2]int
+	for start := 0; start < count; start += guidsPerRequest {
+		end := start + guidsPerRequest
+		if end > count {
+			end = count
+		}
+		batches = append(batches, [2]int{start, end})
+	}
+	return batches
+}
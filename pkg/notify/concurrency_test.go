@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestForEachConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	called := make([]int, 20)
+	running, maxRunning := 0, 0
+	forEachConcurrently(len(called), 3, func(i int) {
+		mu.Lock()
+		called[i]++
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	for i, count := range called {
+		if count != 1 {
+			t.Errorf("Expected index %d to be worked on once, found %d", i, count)
+		}
+	}
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 calls at a time, found %d", maxRunning)
+	}
+}
+
+func TestGUIDBatches(t *testing.T) {
+	expected := [][2]int{{0, 50}, {50, 100}, {100, 120}}
+	if batches := guidBatches(120); !reflect.DeepEqual(batches, expected) {
+		t.Errorf("Expected batches %v, found %v", expected, batches)
+	}
+	if batches := guidBatches(0); len(batches) != 0 {
+		t.Errorf("Expected no batches, found %v", batches)
+	}
+}
@@ -0,0 +1,167 @@
+package notify
+
+import (
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capiContract is what buildpack-notify makes of the responses of a CF API,
+// the same whatever the version of the CF API answering.
+type capiContract struct {
+	Buildpacks    []Buildpack
+	Apps          []App
+	Spaces        []Space
+	Organizations []Organization
+	Droplets      []Droplet
+	// DropletBuildpack is the buildpack the droplet was staged with, as
+	// matched to the buildpacks.
+	DropletBuildpack DropletBuildpack
+	Space            Space
+	Organization     Organization
+	// AutoRestage is whether the org opts into automatic restages.
+	AutoRestage bool
+	SpaceRoles  []Role
+	SpaceUsers  []User
+	OrgRoles    []Role
+	OrgUsers    []User
+	Build       Build
+	Deployed    bool
+	Instances   []ProcessInstance
+	V2App       V2AppResource
+	Restaged    bool
+}
+
+// capiContractVersions are the CF API versions whose responses are recorded
+// in testdata/capi, one recording each. testedCAPIVersions lists them.
+var capiContractVersions = []string{"3.76.0", "3.102.0", "3.166.0"}
+
+// readCAPIContract reads what buildpack-notify makes of the responses of the
+// CF API answering client, through the functions runs read it with.
+func readCAPIContract(t *testing.T, r *restager) capiContract {
+	var contract capiContract
+	var err error
+	c := r.client
+	if contract.Buildpacks, err = ListBuildpacks(c, ListOptions{PerPage: 2}); err != nil {
+		t.Fatalf("Unable to list buildpacks. Error: %s", err)
+	}
+	if contract.Apps, contract.Spaces, contract.Organizations, err = ListApps(c, ListOptions{PerPage: 50}); err != nil {
+		t.Fatalf("Unable to list apps. Error: %s", err)
+	}
+	if contract.Droplets, err = ListDroplets(c, url.Values{"app_guids": {"app1"}, "states": {"STAGED"}}); err != nil {
+		t.Fatalf("Unable to list droplets. Error: %s", err)
+	}
+	if len(contract.Droplets) == 1 {
+		buildpacks := make(map[string]Buildpack)
+		for _, buildpack := range contract.Buildpacks {
+			buildpacks[buildpack.Name] = buildpack
+		}
+		contract.DropletBuildpack = findDropletBuildpack(contract.Droplets[0], buildpacks, buildpacks["python_buildpack"])
+	}
+	if contract.Space, contract.Organization, err = GetSpaceWithOrganization(c, "space1"); err != nil {
+		t.Fatalf("Unable to get space. Error: %s", err)
+	}
+	contract.AutoRestage = optsIntoAutoRestage(contract.Organization.Metadata)
+	if contract.SpaceRoles, contract.SpaceUsers, err = ListSpaceRoles(c, []string{"space1"}, []string{"space_developer", "space_manager"}); err != nil {
+		t.Fatalf("Unable to list space roles. Error: %s", err)
+	}
+	if contract.OrgRoles, contract.OrgUsers, err = ListOrganizationRoles(c, []string{"org1"}, []string{"organization_manager"}); err != nil {
+		t.Fatalf("Unable to list org roles. Error: %s", err)
+	}
+	if contract.Build, err = GetBuild(c, "build1"); err != nil {
+		t.Fatalf("Unable to get build. Error: %s", err)
+	}
+	deployment := Deployment{GUID: "deployment1"}
+	if err := r.waitForDeployment(deployment, time.Now()); err != nil {
+		t.Errorf("Expected the deployment to be rolled out. Error: %s", err)
+	}
+	contract.Deployed = true
+	if contract.Instances, err = GetProcessStats(c, "app1", "web"); err != nil {
+		t.Fatalf("Unable to get process stats. Error: %s", err)
+	}
+	if contract.V2App, err = GetV2App(c, "app1"); err != nil {
+		t.Fatalf("Unable to get V2 app. Error: %s", err)
+	}
+	contract.Restaged = RestageApp(c, "app1") == nil
+	return contract
+}
+
+// expectedCAPIContract is what buildpack-notify makes of the recorded
+// responses of every CF API version.
+func expectedCAPIContract() capiContract {
+	var contract capiContract
+	contract.Buildpacks = []Buildpack{
+		{GUID: "bp1", Name: "python_buildpack", Stack: "cflinuxfs4", Position: 1, Enabled: true, Filename: "python_buildpack-cflinuxfs4-v1.8.15.zip", CreatedAt: "2022-01-10T15:00:00Z", UpdatedAt: "2024-01-10T15:00:00Z"},
+		{GUID: "bp2", Name: "nodejs_buildpack", Stack: "cflinuxfs4", Position: 2, Enabled: true, Filename: "nodejs_buildpack-cflinuxfs4-v1.8.20.zip", CreatedAt: "2022-01-10T15:00:00Z", UpdatedAt: "2024-01-10T15:00:00Z"},
+		{GUID: "bp3", Name: "go_buildpack", Stack: "cflinuxfs4", Position: 3, Enabled: false, Locked: true, Filename: "go_buildpack-cflinuxfs4-v1.10.14.zip", CreatedAt: "2022-01-10T15:00:00Z", UpdatedAt: "2024-01-10T15:00:00Z"},
+	}
+	app := App{GUID: "app1", Name: "my-app", State: "STARTED", CreatedAt: "2023-01-05T10:00:00Z", UpdatedAt: "2023-12-01T10:00:00Z"}
+	app.Lifecycle.Type = "buildpack"
+	app.Lifecycle.Data.Buildpacks = []string{"python_buildpack"}
+	app.Lifecycle.Data.Stack = "cflinuxfs4"
+	app.Relationships.Space.Data.GUID = "space1"
+	app.Metadata = Metadata{Labels: map[string]string{}, Annotations: map[string]string{}}
+	contract.Apps = []App{app}
+	space := Space{GUID: "space1", Name: "dev", Metadata: Metadata{Labels: map[string]string{}, Annotations: map[string]string{}}}
+	space.Relationships.Organization.Data.GUID = "org1"
+	contract.Spaces, contract.Space = []Space{space}, space
+	org := Organization{GUID: "org1", Name: "agency", Metadata: Metadata{Labels: map[string]string{}, Annotations: map[string]string{autoRestageAnnotation: "true"}}}
+	contract.Organizations, contract.Organization = []Organization{org}, org
+	contract.AutoRestage = true
+	droplet := Droplet{GUID: "droplet1", State: "STAGED", CreatedAt: "2023-12-01T10:00:00Z", UpdatedAt: "2023-12-01T10:01:00Z", Stack: "cflinuxfs4"}
+	droplet.Buildpacks = []DropletBuildpack{
+		{Name: "python_buildpack", DetectOutput: "python 3.11.6", Version: "1.8.14"},
+		{Name: "https://github.com/cloudfoundry/apt-buildpack#v0.3.0"},
+	}
+	droplet.Links.App.Href = replayAPIAddress + "/v3/apps/app1"
+	droplet.Links.Package.Href = replayAPIAddress + "/v3/packages/package1"
+	contract.Droplets = []Droplet{droplet}
+	contract.DropletBuildpack = droplet.Buildpacks[0]
+	role := func(guid, roleType, userGUID, spaceGUID, orgGUID string) Role {
+		r := Role{GUID: guid, Type: roleType}
+		r.Relationships.User.Data.GUID = userGUID
+		r.Relationships.Space.Data.GUID = spaceGUID
+		r.Relationships.Organization.Data.GUID = orgGUID
+		return r
+	}
+	contract.SpaceRoles = []Role{role("role1", "space_developer", "user1", "space1", ""), role("role2", "space_manager", "client1", "space1", "")}
+	contract.SpaceUsers = []User{
+		{GUID: "user1", Username: recordedAddress("jane@agency.gov"), PresentationName: recordedAddress("jane@agency.gov"), Origin: "uaa"},
+		// Clients hold roles too, without a username or origin.
+		{GUID: "client1", PresentationName: "ci-client"},
+	}
+	contract.OrgRoles = []Role{role("role3", "organization_manager", "user2", "", "org1")}
+	contract.OrgUsers = []User{{GUID: "user2", Username: recordedAddress("sam@agency.gov"), PresentationName: recordedAddress("sam@agency.gov"), Origin: "uaa"}}
+	contract.Build = Build{GUID: "build1", State: "STAGED", CreatedAt: "2024-01-11T09:00:00Z"}
+	contract.Build.Droplet.GUID = "droplet2"
+	contract.Deployed = true
+	contract.Instances = []ProcessInstance{{Index: 0, State: "RUNNING"}, {Index: 1, State: "RUNNING"}}
+	contract.V2App.Entity.PackageState = "STAGED"
+	contract.Restaged = true
+	return contract
+}
+
+func TestCAPIContract(t *testing.T) {
+	expected := expectedCAPIContract()
+	for _, version := range capiContractVersions {
+		t.Run(version, func(t *testing.T) {
+			client, _, err := newReplayClient(filepath.Join("testdata", "capi", version+".jsonl"))
+			if err != nil {
+				t.Fatalf("Unable to load recording. Error: %s", err)
+			}
+			contract := readCAPIContract(t, &restager{client: client, timeout: time.Minute})
+			if !reflect.DeepEqual(contract, expected) {
+				t.Errorf("Expected CAPI %s to be read as\n%+v\nfound\n%+v", version, expected, contract)
+			}
+		})
+	}
+}
+
+func TestTestedCAPIVersions(t *testing.T) {
+	if tested := strings.Join(capiContractVersions, ","); testedCAPIVersions != tested {
+		t.Errorf("Expected the build to say it was tested against the CAPI versions of the contract tests, %s, found %s", tested, testedCAPIVersions)
+	}
+}
@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// nvdAPI is where the severity of CVEs is looked up.
+const nvdAPI = "https://services.nvd.nist.gov/rest/json/cves/2.0"
+
+// securityFix is a CVE fixed by a buildpack release, along with its severity
+// if known.
+type securityFix struct {
+	CVE      string
+	Severity string `json:",omitempty"`
+}
+
+func (f securityFix) String() string {
+	if f.Severity == "" {
+		return f.CVE
+	}
+	return f.CVE + " (" + f.Severity + ")"
+}
+
+// severities rank the severities of CVEs, from the least severe.
+var severities = []string{"Low", "Medium", "High", "Critical"}
+
+// severityRank returns how severe severity is, or 0 if it isn't known.
+func severityRank(severity string) int {
+	for i, known := range severities {
+		if strings.EqualFold(severity, known) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// normalizeSeverity returns severity as one of severities, or an empty string
+// if it isn't one of them.
+func normalizeSeverity(severity string) string {
+	if strings.EqualFold(severity, "moderate") {
+		severity = "Medium"
+	}
+	if rank := severityRank(severity); rank > 0 {
+		return severities[rank-1]
+	}
+	return ""
+}
+
+var (
+	cveRe         = regexp.MustCompile(`CVE-[0-9]{4}-[0-9]{4,}`)
+	cveSeverityRe = regexp.MustCompile(`(?i)^\s*[(:\-]?\s*(critical|high|medium|moderate|low)\b`)
+)
+
+// extractSecurityFixes returns the CVEs named in the notes of a release, in
+// the order they are first named, along with the severity given right after
+// them if any, e.g. "CVE-2023-1234 (High)".
+func extractSecurityFixes(body string) []securityFix {
+	var fixes []securityFix
+	found := make(map[string]int)
+	for _, loc := range cveRe.FindAllStringIndex(body, -1) {
+		cve := body[loc[0]:loc[1]]
+		severity := ""
+		if match := cveSeverityRe.FindStringSubmatch(body[loc[1]:]); match != nil {
+			severity = normalizeSeverity(match[1])
+		}
+		if i, seen := found[cve]; seen {
+			if fixes[i].Severity == "" {
+				fixes[i].Severity = severity
+			}
+			continue
+		}
+		found[cve] = len(fixes)
+		fixes = append(fixes, securityFix{CVE: cve, Severity: severity})
+	}
+	return fixes
+}
+
+// joinSecurityFixes lists fixes separated by sep.
+func joinSecurityFixes(fixes []securityFix, sep string) string {
+	listed := make([]string, len(fixes))
+	for i, fix := range fixes {
+		listed[i] = fix.String()
+	}
+	return strings.Join(listed, sep)
+}
+
+// securityFixesOf returns the CVEs fixed by the outdated buildpacks of app.
+func securityFixesOf(app appInfo) []securityFix {
+	var fixes []securityFix
+	for _, buildpack := range app.Buildpacks {
+		fixes = append(fixes, buildpack.SecurityFixes...)
+	}
+	return fixes
+}
+
+// securityRank returns how urgent restaging app is for its security fixes:
+// 0 without any, and above the rank of the most severe CVE fixed otherwise,
+// so that fixes of unknown severity still come before no fixes at all.
+func securityRank(app appInfo) int {
+	rank := 0
+	for _, fix := range securityFixesOf(app) {
+		if r := severityRank(fix.Severity) + 1; r > rank {
+			rank = r
+		}
+	}
+	return rank
+}
+
+// hasSecurityFixes reports whether any of apps would pick up security fixes by
+// restaging.
+func hasSecurityFixes(apps []appInfo) bool {
+	for _, app := range apps {
+		if securityRank(app) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// cveEnricher looks up the severity of the CVEs release notes don't give one
+// for in the National Vulnerability Database.
+type cveEnricher struct {
+	client *http.Client
+	api    string
+	apiKey string
+	logger *Logger
+
+	mu sync.Mutex
+	// severities are kept in the state by CVE, since they rarely change
+	// once published.
+	severities map[string]string
+	// failed are the CVEs this run couldn't look up, which aren't asked
+	// about again until the next run.
+	failed map[string]bool
+}
+
+func newCVEEnricher(apiKey string, severities map[string]string, logger *Logger) *cveEnricher {
+	return &cveEnricher{
+		client:     &http.Client{Timeout: 10 * time.Second},
+		api:        nvdAPI,
+		apiKey:     apiKey,
+		logger:     logger,
+		severities: severities,
+		failed:     make(map[string]bool),
+	}
+}
+
+// enrich returns fixes with the severity of each CVE looked up if the release
+// notes didn't give one. Failures to ask the NVD leave the severity unknown.
+func (e *cveEnricher) enrich(fixes []securityFix) []securityFix {
+	if e == nil || len(fixes) == 0 {
+		return fixes
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enriched := make([]securityFix, len(fixes))
+	for i, fix := range fixes {
+		enriched[i] = fix
+		if fix.Severity != "" {
+			continue
+		}
+		if severity, found := e.severities[fix.CVE]; found {
+			enriched[i].Severity = severity
+			continue
+		}
+		if e.failed[fix.CVE] {
+			continue
+		}
+		severity, err := e.lookup(fix.CVE)
+		if err != nil {
+			e.logger.warnf("Unable to look up the severity of %s. Error: %s\n", fix.CVE, err)
+			e.failed[fix.CVE] = true
+			continue
+		}
+		e.severities[fix.CVE] = severity
+		enriched[i].Severity = severity
+	}
+	return enriched
+}
+
+// lookup returns the severity of cve by its CVSS v3 score, or its CVSS v2
+// score for older CVEs. e.mu must be held.
+func (e *cveEnricher) lookup(cve string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, e.api+"?cveId="+url.QueryEscape(cve), nil)
+	if err != nil {
+		return "", err
+	}
+	if e.apiKey != "" {
+		req.Header.Set("apiKey", e.apiKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("NVD responded with %s", resp.Status)
+	}
+	type cvssMetric struct {
+		BaseSeverity string `json:"baseSeverity"`
+		CVSSData     struct {
+			BaseSeverity string `json:"baseSeverity"`
+		} `json:"cvssData"`
+	}
+	var result struct {
+		Vulnerabilities []struct {
+			CVE struct {
+				Metrics struct {
+					V31 []cvssMetric `json:"cvssMetricV31"`
+					V30 []cvssMetric `json:"cvssMetricV30"`
+					V2  []cvssMetric `json:"cvssMetricV2"`
+				} `json:"metrics"`
+			} `json:"cve"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", errors.Wrap(err, "Unable to parse CVE")
+	}
+	if len(result.Vulnerabilities) == 0 {
+		return "", errors.Errorf("NVD has no record of %s", cve)
+	}
+	metrics := result.Vulnerabilities[0].CVE.Metrics
+	for _, versions := range [][]cvssMetric{metrics.V31, metrics.V30, metrics.V2} {
+		for _, metric := range versions {
+			if severity := normalizeSeverity(metric.CVSSData.BaseSeverity); severity != "" {
+				return severity, nil
+			}
+			if severity := normalizeSeverity(metric.BaseSeverity); severity != "" {
+				return severity, nil
+			}
+		}
+	}
+	return "", errors.Errorf("NVD has no severity for %s", cve)
+}
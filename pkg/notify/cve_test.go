@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestExtractSecurityFixes(t *testing.T) {
+	body := `* Add node 18.17.1, remove node 18.17.0
+  - Including security fixes for: CVE-2023-32002, CVE-2023-32006 (High)
+* Bump openssl for CVE-2023-0464: moderate
+* Mention CVE-2023-32002 - critical again
+* No CVE here`
+	expected := []securityFix{
+		{CVE: "CVE-2023-32002", Severity: "Critical"},
+		{CVE: "CVE-2023-32006", Severity: "High"},
+		{CVE: "CVE-2023-0464", Severity: "Medium"},
+	}
+	if fixes := extractSecurityFixes(body); !reflect.DeepEqual(fixes, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, fixes)
+	}
+	if fixes := extractSecurityFixes("* Add python 3.11.5"); fixes != nil {
+		t.Errorf("Expected no security fixes, found %+v", fixes)
+	}
+}
+
+func TestSecurityRank(t *testing.T) {
+	none := appInfo{Buildpacks: []buildpackReleaseInfo{{BuildpackName: "python_buildpack"}}}
+	unknown := appInfo{Buildpacks: []buildpackReleaseInfo{{SecurityFixes: []securityFix{{CVE: "CVE-2023-1"}}}}}
+	high := appInfo{Buildpacks: []buildpackReleaseInfo{
+		{SecurityFixes: []securityFix{{CVE: "CVE-2023-1", Severity: "Low"}}},
+		{SecurityFixes: []securityFix{{CVE: "CVE-2023-2", Severity: "High"}}},
+	}}
+	if securityRank(none) != 0 || securityRank(unknown) <= securityRank(none) || securityRank(high) <= securityRank(unknown) {
+		t.Errorf("Expected apps ranked by their most severe fix, found %d, %d and %d", securityRank(none), securityRank(unknown), securityRank(high))
+	}
+	if hasSecurityFixes([]appInfo{none}) || !hasSecurityFixes([]appInfo{none, unknown}) {
+		t.Errorf("Expected only apps with fixes to have security fixes")
+	}
+	if joined := joinSecurityFixes(securityFixesOf(high), ", "); joined != "CVE-2023-1 (Low), CVE-2023-2 (High)" {
+		t.Errorf("Expected the fixes to be listed with their severity, found %s", joined)
+	}
+}
+
+func TestCVEEnricher(t *testing.T) {
+	requests := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cve := r.URL.Query().Get("cveId")
+		requests[cve]++
+		if r.Header.Get("apiKey") != "key" {
+			t.Errorf("Expected the API key to be sent, found %q", r.Header.Get("apiKey"))
+		}
+		switch cve {
+		case "CVE-2023-1":
+			w.Write([]byte(`{"vulnerabilities": [{"cve": {"id": "CVE-2023-1", "metrics": {"cvssMetricV31": [{"cvssData": {"baseSeverity": "HIGH"}}]}}}]}`))
+		case "CVE-2014-1":
+			w.Write([]byte(`{"vulnerabilities": [{"cve": {"id": "CVE-2014-1", "metrics": {"cvssMetricV2": [{"baseSeverity": "MEDIUM"}]}}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	severities := map[string]string{"CVE-2022-1": "Low"}
+	enricher := newCVEEnricher("key", severities, nil)
+	enricher.api = ts.URL
+	fixes := []securityFix{{CVE: "CVE-2023-1"}, {CVE: "CVE-2014-1"}, {CVE: "CVE-2022-1"}, {CVE: "CVE-2023-2", Severity: "Critical"}, {CVE: "CVE-2023-3"}}
+	expected := []securityFix{
+		{CVE: "CVE-2023-1", Severity: "High"},
+		{CVE: "CVE-2014-1", Severity: "Medium"},
+		{CVE: "CVE-2022-1", Severity: "Low"},
+		{CVE: "CVE-2023-2", Severity: "Critical"},
+		{CVE: "CVE-2023-3"},
+	}
+	for i := 0; i < 2; i++ {
+		if enriched := enricher.enrich(fixes); !reflect.DeepEqual(enriched, expected) {
+			t.Errorf("Expected %+v, found %+v", expected, enriched)
+		}
+	}
+	if fixes[0].Severity != "" {
+		t.Errorf("Expected the fixes given to be left alone, found %+v", fixes)
+	}
+	expectedRequests := map[string]int{"CVE-2023-1": 1, "CVE-2014-1": 1, "CVE-2023-3": 1}
+	if !reflect.DeepEqual(requests, expectedRequests) {
+		t.Errorf("Expected only the CVEs without a severity to be looked up once, found %v", requests)
+	}
+	if severities["CVE-2023-1"] != "High" {
+		t.Errorf("Expected the severities looked up to be kept in the state, found %v", severities)
+	}
+	var nilEnricher *cveEnricher
+	if enriched := nilEnricher.enrich(fixes); !reflect.DeepEqual(enriched, fixes) {
+		t.Errorf("Expected the fixes to be left alone without NVD lookups, found %+v", enriched)
+	}
+}
@@ -0,0 +1,33 @@
+// Package notify finds the apps of a Cloud Foundry foundation staged with
+// outdated buildpacks, resolves who owns them and e-mails those owners, and
+// is everything the buildpack-notify command runs.
+//
+// Other tooling reuses the same logic through a Notifier, rather than running
+// the command:
+//
+//	client, err := notify.NewClient(cfAPIConfig)
+//	...
+//	notifier, err := notify.NewNotifier(client, notify.Options{})
+//	...
+//	buildpacks, err := notifier.Buildpacks()
+//	...
+//	apps, err := notifier.FindOutdatedApps(buildpacks)
+//	...
+//	owners, err := notifier.FindOwners(apps)
+//	...
+//	err = notifier.Notify(owners, mailer)
+//
+// FindOutdatedApps, FindOwners and Notify skip the apps, owners and e-mails
+// they fail on and return what they could do along with an error listing the
+// failures, the way a run notifies everybody it can. A Notifier keeps no
+// state between its calls besides the spaces it listed: which buildpacks
+// count as updated is up to the caller, where the command compares them with
+// its state file.
+//
+// The CF API types and functions, e.g. ListApps, are exported for the same
+// tooling. Run runs the pipeline of the command itself, and EOLReport,
+// CheckApp and the other functions its other commands, returning an *Error
+// whose ExitCode the command exits with. Nothing is logged without a Logger,
+// given in Config or Options, and every run carries its own ID in its logs
+// and e-mails.
+package notify
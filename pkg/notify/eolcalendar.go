@@ -0,0 +1,248 @@
+package notify
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// eolDateFormat is how end of support dates are written in the calendar.
+const eolDateFormat = "2006-01-02"
+
+// eolEntry is an end of support date in the EOL calendar, either of a line of
+// versions of a buildpack, e.g. python_buildpack 1.7, or of a runtime shipped
+// with a buildpack, e.g. python 3.8 in python_buildpack.
+type eolEntry struct {
+	Buildpack string `json:"buildpack"`
+	Version   string `json:"version,omitempty"`
+	Runtime   string `json:"runtime,omitempty"`
+	EOL       string `json:"eol"`
+	date      time.Time
+}
+
+// eolCalendar warns about the end of support dates coming up within window,
+// and about those already passed.
+type eolCalendar struct {
+	entries []eolEntry
+	window  time.Duration
+}
+
+// loadEOLCalendar reads the calendar from the JSON file at path, a list of
+// entries.
+func loadEOLCalendar(path string, window time.Duration) (*eolCalendar, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read EOL calendar")
+	}
+	var entries []eolEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrapf(err, "Unable to parse EOL calendar %s", path)
+	}
+	for i, entry := range entries {
+		if entry.Buildpack == "" {
+			return nil, errors.Errorf("EOL calendar entry %d has no buildpack", i+1)
+		}
+		if (entry.Version == "") == (entry.Runtime == "") {
+			return nil, errors.Errorf("EOL calendar entry %d for %s needs either a version or a runtime", i+1, entry.Buildpack)
+		}
+		if entry.Version != "" && parseVersion(entry.Version) == nil {
+			return nil, errors.Errorf("Invalid version %q in EOL calendar entry %d for %s", entry.Version, i+1, entry.Buildpack)
+		}
+		if entries[i].date, err = time.Parse(eolDateFormat, entry.EOL); err != nil {
+			return nil, errors.Errorf("Invalid end of support date %q in EOL calendar entry %d for %s, expected YYYY-MM-DD", entry.EOL, i+1, entry.Buildpack)
+		}
+	}
+	return &eolCalendar{entries: entries, window: window}, nil
+}
+
+// eolWarning is an end of support date an app is affected by. Runtime ones
+// only affect the app if it uses the runtime, which is only known when
+// Detected, from the detect output of its droplet.
+type eolWarning struct {
+	Subject  string
+	Date     string
+	DaysLeft int
+	Runtime  bool `json:",omitempty"`
+	Detected bool `json:",omitempty"`
+}
+
+// Passed reports whether the end of support date is already behind.
+func (w eolWarning) Passed() bool {
+	return w.DaysLeft < 0
+}
+
+func (w eolWarning) String() string {
+	switch {
+	case w.Passed():
+		return fmt.Sprintf("%s reached its end of support on %s", w.Subject, w.Date)
+	case w.DaysLeft == 1:
+		return fmt.Sprintf("%s reaches its end of support on %s, in 1 day", w.Subject, w.Date)
+	default:
+		return fmt.Sprintf("%s reaches its end of support on %s, in %d days", w.Subject, w.Date, w.DaysLeft)
+	}
+}
+
+// warnings returns the end of support dates coming up or passed for an app
+// staged with version of buildpack, soonest first. Entries for versions of
+// the buildpack are left out when the version isn't known, and those for
+// runtimes other than the one detected by detectOutput when it is known.
+func (c *eolCalendar) warnings(buildpack, version, detectOutput string, now time.Time) []eolWarning {
+	if c == nil {
+		return nil
+	}
+	today := now.UTC().Truncate(24 * time.Hour)
+	current := parseVersion(version)
+	var warnings []eolWarning
+	for _, entry := range c.entries {
+		if entry.Buildpack != buildpack || entry.date.Sub(today) > c.window {
+			continue
+		}
+		warning := eolWarning{Date: entry.EOL, DaysLeft: int(entry.date.Sub(today).Hours() / 24)}
+		switch {
+		case entry.Runtime != "":
+			in, known := detectedRuntimeIn(detectOutput, runtimeName(entry.Runtime), runtimeVersion(entry.Runtime))
+			if known && !in {
+				continue
+			}
+			warning.Subject, warning.Runtime, warning.Detected = entry.Runtime, true, in
+		case current != nil && hasVersionPrefix(current, parseVersion(entry.Version)):
+			warning.Subject = buildpack + " " + entry.Version
+		default:
+			continue
+		}
+		warnings = append(warnings, warning)
+	}
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].DaysLeft < warnings[j].DaysLeft })
+	return warnings
+}
+
+// hasVersionPrefix reports whether version is in the line of prefix, e.g.
+// 1.7.40 in 1.7.
+func hasVersionPrefix(version, prefix []int) bool {
+	if len(prefix) > len(version) {
+		return false
+	}
+	for i := range prefix {
+		if version[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// runtimeName and runtimeVersion split the runtime of a calendar entry, e.g.
+// python 3.8, into its name and version.
+func runtimeName(runtime string) string {
+	if i := strings.LastIndex(runtime, " "); i >= 0 {
+		return runtime[:i]
+	}
+	return runtime
+}
+
+func runtimeVersion(runtime string) string {
+	return runtime[strings.LastIndex(runtime, " ")+1:]
+}
+
+// annotate adds the end of support dates coming up to the outdated
+// buildpacks of apps, for their notifications.
+func (c *eolCalendar) annotate(apps []appInfo, now time.Time) {
+	if c == nil {
+		return
+	}
+	for _, app := range apps {
+		for i, buildpack := range app.Buildpacks {
+			app.Buildpacks[i].EOLWarnings = c.warnings(buildpack.BuildpackName, buildpack.CurrentVersion, buildpack.DetectOutput, now)
+		}
+	}
+}
+
+// eolReportEntry is an app affected by an end of support date.
+type eolReportEntry struct {
+	Org, Space, Name, GUID string
+	Buildpack              string
+	Warning                eolWarning
+}
+
+// findAppsReachingEOL returns every app in scope whose current droplet was
+// staged with a buildpack, or a version of one, whose end of support is
+// coming up or passed, soonest first.
+func findAppsReachingEOL(client *cfclient.Client, calendar *eolCalendar, scope runScope, listOpts ListOptions, concurrency int, report *runReport, now time.Time, errs *runErrors, logger *Logger) ([]eolReportEntry, error) {
+	apps, spaces, err := listAppsWithSpaces(client, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	apps = filterAppsByScope(apps, spaces, scope, report, logger)
+	var entries []eolReportEntry
+	for _, result := range getDropletsToCheck(apps, client, concurrency, report, errs, logger) {
+		if !result.ok {
+			continue
+		}
+		app, space := result.app, spaces[result.app.Relationships.Space.Data.GUID]
+		for _, buildpack := range result.droplet.Buildpacks {
+			for _, warning := range calendar.warnings(buildpack.Name, buildpack.Version, buildpack.DetectOutput, now) {
+				entries = append(entries, eolReportEntry{Org: space.Org.Name, Space: space.Space.Name, Name: app.Name, GUID: app.GUID, Buildpack: buildpack.Name, Warning: warning})
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Warning.DaysLeft < entries[j].Warning.DaysLeft })
+	return entries, nil
+}
+
+// writeEOLReport lists the apps affected by end of support dates to w for
+// people to read.
+func writeEOLReport(w io.Writer, entries []eolReportEntry) error {
+	for _, entry := range entries {
+		line := fmt.Sprintf("%s/%s/%s guid %s: %s", entry.Org, entry.Space, entry.Name, entry.GUID, entry.Warning)
+		if entry.Warning.Runtime && !entry.Warning.Detected {
+			line += fmt.Sprintf(", if the app uses it with %s", entry.Buildpack)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	apps := make(map[string]bool)
+	for _, entry := range entries {
+		apps[entry.GUID] = true
+	}
+	_, err := fmt.Fprintf(w, "%d apps are affected by end of support dates.\n", len(apps))
+	return err
+}
+
+var eolCSVHeader = []string{"org", "space", "app", "app_guid", "buildpack", "subject", "runtime", "eol", "days_left"}
+
+// writeEOLCSV writes the apps affected by end of support dates to w as CSV,
+// a row for each date an app is affected by.
+func writeEOLCSV(w io.Writer, entries []eolReportEntry) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(eolCSVHeader); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		row := []string{entry.Org, entry.Space, entry.Name, entry.GUID, entry.Buildpack, entry.Warning.Subject, strconv.FormatBool(entry.Warning.Runtime), entry.Warning.Date, strconv.Itoa(entry.Warning.DaysLeft)}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// eolCalendar returns the configured EOL calendar, or nil without one.
+func (c Config) eolCalendar() (*eolCalendar, error) {
+	if c.EOLCalendar == "" {
+		return nil, nil
+	}
+	if c.EOLWarningDays < 0 {
+		return nil, errors.New("EOL_WARNING_DAYS can't be negative")
+	}
+	return loadEOLCalendar(c.EOLCalendar, time.Duration(c.EOLWarningDays)*24*time.Hour)
+}
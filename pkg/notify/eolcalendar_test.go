@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func writeTestEOLCalendar(t *testing.T, calendar string) string {
+	path := filepath.Join(t.TempDir(), "eol.json")
+	if err := ioutil.WriteFile(path, []byte(calendar), 0644); err != nil {
+		t.Fatalf("Unable to write EOL calendar. Error: %s", err)
+	}
+	return path
+}
+
+const testEOLCalendar = `[
+	{"buildpack": "python_buildpack", "version": "1.7", "eol": "2024-03-31"},
+	{"buildpack": "python_buildpack", "runtime": "python 3.8", "eol": "2024-10-07"},
+	{"buildpack": "python_buildpack", "version": "1.8", "eol": "2025-12-31"},
+	{"buildpack": "nodejs_buildpack", "runtime": "node 16", "eol": "2023-09-11"}
+]`
+
+func TestEOLCalendarWarnings(t *testing.T) {
+	calendar, err := Config{EOLCalendar: writeTestEOLCalendar(t, testEOLCalendar), EOLWarningDays: 365}.eolCalendar()
+	if err != nil {
+		t.Fatalf("Unable to load EOL calendar. Error: %s", err)
+	}
+	now := time.Date(2024, 3, 1, 15, 0, 0, 0, time.UTC)
+	expected := []eolWarning{
+		{Subject: "python_buildpack 1.7", Date: "2024-03-31", DaysLeft: 30},
+		{Subject: "python 3.8", Date: "2024-10-07", DaysLeft: 220, Runtime: true},
+	}
+	if warnings := calendar.warnings("python_buildpack", "1.7.40", "", now); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, warnings)
+	}
+	// The version of the buildpack isn't known, and 1.8 is too far off.
+	if warnings := calendar.warnings("python_buildpack", "", "", now); !reflect.DeepEqual(warnings, expected[1:]) {
+		t.Errorf("Expected only the runtime warning without a version, found %+v", warnings)
+	}
+	passed := calendar.warnings("nodejs_buildpack", "1.8.14", "", now)
+	if len(passed) != 1 || !passed[0].Passed() || passed[0].String() != "node 16 reached its end of support on 2023-09-11" {
+		t.Errorf("Expected the passed end of support of node 16, found %+v", passed)
+	}
+	if expected[0].String() != "python_buildpack 1.7 reaches its end of support on 2024-03-31, in 30 days" {
+		t.Errorf("Unexpected warning %s", expected[0])
+	}
+
+	// The runtime warnings only apply to apps detected with the runtime, when
+	// the droplet says which runtime the app was detected with.
+	if warnings := calendar.warnings("python_buildpack", "1.7.40", "python 3.9.18", now); !reflect.DeepEqual(warnings, expected[:1]) {
+		t.Errorf("Expected no runtime warning for another runtime version, found %+v", warnings)
+	}
+	detected := []eolWarning{expected[0], {Subject: "python 3.8", Date: "2024-10-07", DaysLeft: 220, Runtime: true, Detected: true}}
+	if warnings := calendar.warnings("python_buildpack", "1.7.40", "python-3.8.x", now); !reflect.DeepEqual(warnings, detected) {
+		t.Errorf("Expected %+v, found %+v", detected, warnings)
+	}
+	if warnings := calendar.warnings("python_buildpack", "1.7.40", "python", now); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected the runtime warning when the detect output has no version, found %+v", warnings)
+	}
+
+	apps := []appInfo{{Buildpacks: []buildpackReleaseInfo{{BuildpackName: "python_buildpack", CurrentVersion: "1.7.40"}}}}
+	calendar.annotate(apps, now)
+	if !reflect.DeepEqual(apps[0].Buildpacks[0].EOLWarnings, expected) {
+		t.Errorf("Expected the outdated buildpacks to be annotated with %+v, found %+v", expected, apps[0].Buildpacks[0].EOLWarnings)
+	}
+	var noCalendar *eolCalendar
+	noCalendar.annotate(apps, now)
+	if noCalendar.warnings("python_buildpack", "1.7.40", "", now) != nil {
+		t.Errorf("Expected no warnings without a calendar")
+	}
+}
+
+func TestLoadEOLCalendarInvalid(t *testing.T) {
+	for _, calendar := range []string{
+		`[{"version": "1.7", "eol": "2024-03-31"}]`,
+		`[{"buildpack": "python_buildpack", "eol": "2024-03-31"}]`,
+		`[{"buildpack": "python_buildpack", "version": "1.7", "runtime": "python 3.8", "eol": "2024-03-31"}]`,
+		`[{"buildpack": "python_buildpack", "version": "v1.x", "eol": "2024-03-31"}]`,
+		`[{"buildpack": "python_buildpack", "version": "1.7", "eol": "March 31st"}]`,
+		`{"python_buildpack": "2024-03-31"}`,
+	} {
+		if _, err := loadEOLCalendar(writeTestEOLCalendar(t, calendar), 0); err == nil {
+			t.Errorf("Expected %s to be invalid", calendar)
+		}
+	}
+	if calendar, err := (Config{}).eolCalendar(); calendar != nil || err != nil {
+		t.Errorf("Expected no calendar without EOL_CALENDAR, found %+v/%v", calendar, err)
+	}
+}
+
+func TestFindAppsReachingEOL(t *testing.T) {
+	f := &fixtures{Organizations: []Organization{{GUID: "org1", Name: "agency"}}}
+	space := Space{GUID: "space1", Name: "dev"}
+	space.Relationships.Organization.Data.GUID = "org1"
+	f.Spaces = append(f.Spaces, space)
+	for guid, version := range map[string]string{"app1": "1.7.40", "app2": "1.8.2"} {
+		app := newTestApp(guid, "space1")
+		app.Name, app.State = app.GUID, "STARTED"
+		app.Lifecycle.Type = "buildpack"
+		f.Apps = append(f.Apps, app)
+		droplet := newTestDroplet("2024-01-01T00:00:00Z")
+		droplet.GUID = "droplet-" + app.GUID
+		droplet.Links.App.Href = fixturesAPIAddress + "/v3/apps/" + app.GUID
+		droplet.Buildpacks = []DropletBuildpack{{Name: "python_buildpack", Version: version}}
+		f.Droplets = append(f.Droplets, droplet)
+	}
+	client := &cfclient.Client{Config: cfclient.Config{ApiAddress: fixturesAPIAddress, HttpClient: &http.Client{Transport: f}}}
+	calendar, err := loadEOLCalendar(writeTestEOLCalendar(t, testEOLCalendar), 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("Unable to load EOL calendar. Error: %s", err)
+	}
+	errs := &runErrors{}
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	entries, err := findAppsReachingEOL(client, calendar, runScope{}, ListOptions{PerPage: 100}, 1, newRunReport(), now, errs, nil)
+	if err != nil || errs.count() != 0 {
+		t.Fatalf("Unable to find apps reaching their end of support. Error: %v, %d errors", err, errs.count())
+	}
+	expected := []eolReportEntry{{Org: "agency", Space: "dev", Name: "app1", GUID: "app1", Buildpack: "python_buildpack", Warning: eolWarning{Subject: "python_buildpack 1.7", Date: "2024-03-31", DaysLeft: 30}}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, entries)
+	}
+
+	entries = append(entries, eolReportEntry{Org: "agency", Space: "dev", Name: "app1", GUID: "app1", Buildpack: "python_buildpack", Warning: eolWarning{Subject: "python 3.8", Date: "2024-03-02", DaysLeft: 1, Runtime: true}})
+	var text, csvReport bytes.Buffer
+	if err := writeEOLReport(&text, entries); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	expectedText := "agency/dev/app1 guid app1: python_buildpack 1.7 reaches its end of support on 2024-03-31, in 30 days\n" +
+		"agency/dev/app1 guid app1: python 3.8 reaches its end of support on 2024-03-02, in 1 day, if the app uses it with python_buildpack\n" +
+		"1 apps are affected by end of support dates.\n"
+	if text.String() != expectedText {
+		t.Errorf("Expected report\n%s\nfound\n%s", expectedText, text.String())
+	}
+	if err := writeEOLCSV(&csvReport, entries[:1]); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	expectedCSV := strings.Join(eolCSVHeader, ",") + "\nagency,dev,app1,app1,python_buildpack,python_buildpack 1.7,false,2024-03-31,30\n"
+	if csvReport.String() != expectedCSV {
+		t.Errorf("Expected CSV report\n%s\nfound\n%s", expectedCSV, csvReport.String())
+	}
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"bytes"
+	"time"
+)
+
+// appNotificationRecord is the history of notifications about an app being
+// outdated. A restage gives the app a new droplet, which starts the count
+// over.
+type appNotificationRecord struct {
+	DropletGUID     string
+	Notifications   int
+	FirstNotifiedAt string
+	LastNotifiedAt  string
+	// ResolvedAt is when the app was found restaged against the updated
+	// buildpacks, with ResolvedDropletGUID. Both are empty until then.
+	ResolvedAt          string `json:",omitempty"`
+	ResolvedDropletGUID string `json:",omitempty"`
+}
+
+// recordAppNotifications records that the owners of apps were notified at now
+// and returns apps with the number of notifications about each of them since
+// it was last restaged.
+func recordAppNotifications(apps []appInfo, history map[string]appNotificationRecord, now time.Time) []appInfo {
+	notifiedAt := now.UTC().Format(time.RFC3339)
+	recorded := make([]appInfo, 0, len(apps))
+	for _, app := range apps {
+		record, found := history[app.GUID]
+		if !found || record.DropletGUID != app.DropletGUID {
+			record = appNotificationRecord{DropletGUID: app.DropletGUID, FirstNotifiedAt: notifiedAt}
+		}
+		record.Notifications++
+		record.LastNotifiedAt = notifiedAt
+		history[app.GUID] = record
+		app.Notifications = record.Notifications
+		recorded = append(recorded, app)
+	}
+	return recorded
+}
+
+// filterForAppsToEscalate returns the apps whose owners were notified at least
+// escalateAfter times without restaging them, or securityEscalateAfter times
+// if that is sooner and restaging them picks up security fixes. Either is
+// ignored when 0.
+func filterForAppsToEscalate(apps []appInfo, escalateAfter, securityEscalateAfter int) []appInfo {
+	var escalated []appInfo
+	for _, app := range apps {
+		threshold := escalateAfter
+		if securityEscalateAfter > 0 && (threshold == 0 || securityEscalateAfter < threshold) && securityRank(app) > 0 {
+			threshold = securityEscalateAfter
+		}
+		if threshold > 0 && app.Notifications >= threshold {
+			escalated = append(escalated, app)
+		}
+	}
+	return escalated
+}
+
+func sendEscalationEmailToUsers(users map[string][]appInfo, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) {
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		body := new(bytes.Buffer)
+		isMultipleApp := len(apps) > 1
+		if err := templates.getEscalationEmail(body, escalationEmail{user, apps, isMultipleApp}); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationEscalation, notificationNotRendered, err, errs)
+			return
+		}
+		if !dryRun {
+			subj := "Action required: outdated application"
+			if isMultipleApp {
+				subj += "s"
+			}
+			subj += " in spaces you manage"
+			if err := mailer.SendEmail(user, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationEscalation, dryRun, err, errs)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationEscalation, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent escalation e-mail to %s\n", user)
+	})
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordAppNotifications(t *testing.T) {
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	history := map[string]appNotificationRecord{
+		"app1": {DropletGUID: "droplet1", Notifications: 2, FirstNotifiedAt: "2020-01-01T00:00:00Z"},
+		"app2": {DropletGUID: "old-droplet", Notifications: 4, FirstNotifiedAt: "2020-01-01T00:00:00Z"},
+	}
+	apps := []appInfo{
+		{App: newTestApp("app1", "space1"), DropletGUID: "droplet1"},
+		{App: newTestApp("app2", "space1"), DropletGUID: "droplet2"},
+		{App: newTestApp("app3", "space1"), DropletGUID: "droplet3"},
+	}
+	recorded := recordAppNotifications(apps, history, now)
+	expected := map[string]int{"app1": 3, "app2": 1, "app3": 1}
+	for _, app := range recorded {
+		if app.Notifications != expected[app.GUID] {
+			t.Errorf("Expected %d notifications for %s, found %d", expected[app.GUID], app.GUID, app.Notifications)
+		}
+		if history[app.GUID].Notifications != expected[app.GUID] || history[app.GUID].LastNotifiedAt != "2020-02-01T00:00:00Z" {
+			t.Errorf("Expected the notification of %s to be recorded, found %+v", app.GUID, history[app.GUID])
+		}
+	}
+	if history["app1"].FirstNotifiedAt != "2020-01-01T00:00:00Z" {
+		t.Errorf("Expected app1 to keep its first notification, found %+v", history["app1"])
+	}
+	if history["app2"].FirstNotifiedAt != "2020-02-01T00:00:00Z" || history["app2"].DropletGUID != "droplet2" {
+		t.Errorf("Expected the restage of app2 to start its history over, found %+v", history["app2"])
+	}
+
+	escalated := filterForAppsToEscalate(recorded, 3, 0)
+	if len(escalated) != 1 || escalated[0].GUID != "app1" {
+		t.Errorf("Expected only app1 to be escalated, found %+v", escalated)
+	}
+}
+
+func TestFilterForAppsToEscalateSecurityFixes(t *testing.T) {
+	fixes := []buildpackReleaseInfo{{BuildpackName: "python_buildpack", SecurityFixes: []securityFix{{CVE: "CVE-2023-1"}}}}
+	apps := []appInfo{
+		{App: App{GUID: "app1"}, Notifications: 2, Buildpacks: fixes},
+		{App: App{GUID: "app2"}, Notifications: 2},
+		{App: App{GUID: "app3"}, Notifications: 3},
+	}
+	testCases := []struct {
+		name                  string
+		escalateAfter         int
+		securityEscalateAfter int
+		expected              []string
+	}{
+		{"security fixes sooner", 3, 2, []string{"app1", "app3"}},
+		{"security fixes only", 0, 2, []string{"app1"}},
+		{"security fixes later", 2, 3, []string{"app1", "app2", "app3"}},
+	}
+	for _, tc := range testCases {
+		var escalated []string
+		for _, app := range filterForAppsToEscalate(apps, tc.escalateAfter, tc.securityEscalateAfter) {
+			escalated = append(escalated, app.GUID)
+		}
+		if !reflect.DeepEqual(escalated, tc.expected) {
+			t.Errorf("Test %s failed. Expected %v to be escalated, found %v", tc.name, tc.expected, escalated)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package notify
+
+import "github.com/pkg/errors"
+
+// The exit codes of the commands, telling automation what kind of failure
+// ended a run so that it can branch on it.
+const (
+	ExitOK = 0
+	// ExitFailed is any other failure, such as being unable to read or
+	// write the state.
+	ExitFailed = 1
+	// ExitUsage is a command or flag that can't be parsed.
+	ExitUsage = 2
+	// ExitConfig is a configuration that can't be parsed or is invalid.
+	ExitConfig = 3
+	// ExitCFAPI is a failure reaching or querying the CF API that stopped
+	// the run.
+	ExitCFAPI = 4
+	// ExitSMTP is a run where every e-mail it tried to send failed, which
+	// is the SMTP server being down or rejecting the tool.
+	ExitSMTP = 5
+	// ExitPartial is a run that completed, but with errors for some of the
+	// apps, users or e-mails, which were skipped.
+	ExitPartial = 6
+	// ExitInterrupted is a run stopped by SIGINT or SIGTERM, which left the
+	// state as it was.
+	ExitInterrupted = 7
+)
+
+// Error is a failure of a run along with the exit code it ends the command
+// with.
+type Error struct {
+	Code int
+	Err  error
+	// logged is set on the failures whose details were already logged.
+	logged bool
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// failf returns the failure described by format, ending the command with
+// code.
+func failf(code int, format string, args ...interface{}) error {
+	return &Error{Code: code, Err: errors.Errorf(format, args...)}
+}
+
+// ExitCode returns the exit code err ends a command with: ExitOK without an
+// error, the code of an Error, and ExitFailed for any other error.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if failure, ok := err.(*Error); ok {
+		return failure.Code
+	}
+	return ExitFailed
+}
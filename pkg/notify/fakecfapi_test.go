@@ -0,0 +1,302 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCFAPI is a CF API served over HTTP for integration tests. It answers
+// the V3 reads of a run from fixtures the way simulations do, and on top of
+// that discovers its endpoints through /v2/info, hands out tokens to the
+// client whose credentials it was given, looks users up in UAA and stages and
+// rolls out droplets when apps are restaged, so that runs go through the
+// client, its transports and the restager unchanged.
+type fakeCFAPI struct {
+	*httptest.Server
+	clientID, clientSecret string
+
+	mu       sync.Mutex
+	fixtures *fixtures
+	// uaaEmails are the e-mail addresses of users in UAA by GUID, for users
+	// whose username isn't theirs.
+	uaaEmails map[string]string
+	// staged are the droplets staged by restages, by GUID, which replace
+	// the current droplet of their app once rolled out.
+	staged      map[string]Droplet
+	builds      map[string]Build
+	deployments map[string]Deployment
+	// failing are the requests answered with an error, as "METHOD /path"
+	// prefixes.
+	failing map[string]bool
+	// requests are the requests answered, as "METHOD /path".
+	requests []string
+	// tokens counts the tokens handed out.
+	tokens int
+	guids  int
+}
+
+// fakeCFAPIToken is the only access token the fake accepts.
+const fakeCFAPIToken = "fake-cf-api-token"
+
+// newFakeCFAPI starts a fake CF API serving f, closed when the test is over.
+func newFakeCFAPI(t *testing.T, f *fixtures) *fakeCFAPI {
+	api := &fakeCFAPI{
+		clientID:     "buildpack-notify",
+		clientSecret: "secret",
+		fixtures:     f,
+		uaaEmails:    make(map[string]string),
+		failing:      make(map[string]bool),
+		staged:       make(map[string]Droplet),
+		builds:       make(map[string]Build),
+		deployments:  make(map[string]Deployment),
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// setEnv points the configuration of the run at the fake.
+func (api *fakeCFAPI) setEnv(t *testing.T) {
+	t.Setenv("CF_API", api.URL)
+	t.Setenv("CLIENT_ID", api.clientID)
+	t.Setenv("CLIENT_SECRET", api.clientSecret)
+}
+
+// requestCount returns how many requests matching "METHOD /path" prefix were
+// answered.
+func (api *fakeCFAPI) requestCount(prefix string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	count := 0
+	for _, request := range api.requests {
+		if strings.HasPrefix(request, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// currentDroplet returns the current droplet of the app with GUID appGUID.
+func (api *fakeCFAPI) currentDroplet(appGUID string) (Droplet, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	droplets := api.fixtures.dropletsOf(map[string]bool{appGUID: true})
+	if len(droplets) != 1 {
+		return Droplet{}, false
+	}
+	return droplets[0], true
+}
+
+func (api *fakeCFAPI) serve(w http.ResponseWriter, req *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.requests = append(api.requests, req.Method+" "+req.URL.Path)
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/v2/info":
+		// UAA is served by the fake too.
+		api.reply(w, http.StatusOK, map[string]string{"authorization_endpoint": api.URL, "token_endpoint": api.URL})
+		return
+	case req.Method == http.MethodPost && req.URL.Path == "/oauth/token":
+		api.token(w, req)
+		return
+	case !strings.EqualFold(req.Header.Get("Authorization"), "bearer "+fakeCFAPIToken):
+		api.reply(w, http.StatusUnauthorized, cfErrors(1000, "CF-InvalidAuthToken", "Invalid Auth Token"))
+		return
+	}
+	for prefix := range api.failing {
+		if strings.HasPrefix(req.Method+" "+req.URL.Path, prefix) {
+			api.reply(w, http.StatusForbidden, cfErrors(10003, "CF-NotAuthorized", "You are not authorized to perform the requested action"))
+			return
+		}
+	}
+	var body interface{}
+	status := http.StatusOK
+	switch {
+	case req.Method == http.MethodGet && len(parts) == 2 && parts[0] == "Users":
+		body = api.uaaUser(parts[1])
+	case req.Method == http.MethodGet && len(parts) == 6 && parts[0] == "v3" && parts[1] == "apps" && parts[5] == "stats":
+		body = ProcessStatsResponse{Instances: []ProcessInstance{{Index: 0, State: "RUNNING"}}}
+	case req.Method == http.MethodGet && len(parts) == 3 && parts[0] == "v3" && parts[1] == "droplets":
+		if droplet, ok := api.staged[parts[2]]; ok {
+			body = droplet
+		} else {
+			body = api.fixtures.answer(parts[1:], req.URL.Query())
+		}
+	case req.Method == http.MethodGet && len(parts) == 3 && parts[0] == "v3" && parts[1] == "builds":
+		if build, ok := api.builds[parts[2]]; ok {
+			body = build
+		}
+	case req.Method == http.MethodGet && len(parts) == 3 && parts[0] == "v3" && parts[1] == "deployments":
+		if deployment, ok := api.deployments[parts[2]]; ok {
+			body = deployment
+		}
+	case req.Method == http.MethodGet && len(parts) >= 2 && parts[0] == "v3":
+		body = api.fixtures.answer(parts[1:], req.URL.Query())
+	case req.Method == http.MethodPost && req.URL.Path == "/v3/builds":
+		body, status = api.createBuild(req)
+	case req.Method == http.MethodPost && req.URL.Path == "/v3/deployments":
+		body, status = api.createDeployment(req)
+	case req.Method == http.MethodPost && len(parts) == 4 && parts[0] == "v2" && parts[1] == "apps" && parts[3] == "restage":
+		body, status = api.restageClassic(parts[2])
+	case req.Method == http.MethodGet && len(parts) == 3 && parts[0] == "v2" && parts[1] == "apps":
+		var app V2AppResource
+		app.Entity.PackageState = "STAGED"
+		body = app
+	}
+	if body == nil {
+		body, status = cfErrors(10010, "CF-ResourceNotFound", fmt.Sprintf("%s %s not found", req.Method, req.URL.Path)), http.StatusNotFound
+	}
+	api.reply(w, status, body)
+}
+
+// token hands out the access token to the client the fake was given the
+// credentials of, whether they are sent as basic auth or in the form.
+func (api *fakeCFAPI) token(w http.ResponseWriter, req *http.Request) {
+	clientID, clientSecret, ok := req.BasicAuth()
+	if !ok {
+		req.ParseForm()
+		clientID, clientSecret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+	}
+	if clientID != api.clientID || clientSecret != api.clientSecret {
+		api.reply(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized", "error_description": "Bad credentials"})
+		return
+	}
+	api.tokens++
+	api.reply(w, http.StatusOK, map[string]interface{}{"access_token": fakeCFAPIToken, "token_type": "bearer", "expires_in": 3600})
+}
+
+// uaaUser returns the UAA user of a user of the fixtures, whose verified
+// e-mail address is their username unless given another one.
+func (api *fakeCFAPI) uaaUser(guid string) interface{} {
+	for _, user := range api.fixtures.Users {
+		if user.GUID == guid {
+			email, ok := api.uaaEmails[guid]
+			if !ok {
+				email = user.Username
+			}
+			uaaUser := UAAUser{ID: user.GUID, UserName: user.Username, Verified: true}
+			uaaUser.Emails = append(uaaUser.Emails, struct {
+				Value   string `json:"value"`
+				Primary bool   `json:"primary"`
+			}{Value: email, Primary: true})
+			return uaaUser
+		}
+	}
+	return nil
+}
+
+// createBuild stages a new droplet from the package of the current droplet
+// of an app, which is staged as soon as the build is created.
+func (api *fakeCFAPI) createBuild(req *http.Request) (interface{}, int) {
+	var body struct {
+		Package struct {
+			GUID string `json:"guid"`
+		} `json:"package"`
+	}
+	if err := decodeRequest(req, &body); err != nil {
+		return cfErrors(10008, "CF-UnprocessableEntity", err.Error()), http.StatusUnprocessableEntity
+	}
+	for _, droplet := range api.fixtures.Droplets {
+		if droplet.packageGUID() != body.Package.GUID {
+			continue
+		}
+		staged := droplet
+		staged.GUID = api.newGUID("droplet")
+		staged.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		api.staged[staged.GUID] = staged
+		build := Build{GUID: api.newGUID("build"), State: "STAGED", CreatedAt: staged.CreatedAt}
+		build.Droplet.GUID = staged.GUID
+		api.builds[build.GUID] = build
+		return build, http.StatusCreated
+	}
+	return cfErrors(10008, "CF-UnprocessableEntity", "Unable to use package. Ensure that the package exists and you have access to it."), http.StatusUnprocessableEntity
+}
+
+// createDeployment rolls out a droplet staged by a build, or a droplet the
+// app ran before, which is deployed as soon as the deployment is created.
+func (api *fakeCFAPI) createDeployment(req *http.Request) (interface{}, int) {
+	var body struct {
+		Droplet struct {
+			GUID string `json:"guid"`
+		} `json:"droplet"`
+		Relationships struct {
+			App Relationship `json:"app"`
+		} `json:"relationships"`
+	}
+	if err := decodeRequest(req, &body); err != nil {
+		return cfErrors(10008, "CF-UnprocessableEntity", err.Error()), http.StatusUnprocessableEntity
+	}
+	appGUID := body.Relationships.App.Data.GUID
+	droplet, ok := api.staged[body.Droplet.GUID]
+	if !ok {
+		return cfErrors(10008, "CF-UnprocessableEntity", "Unable to assign current droplet. Ensure the droplet exists and belongs to this app."), http.StatusUnprocessableEntity
+	}
+	api.setCurrentDroplet(appGUID, droplet)
+	deployment := Deployment{GUID: api.newGUID("deployment")}
+	deployment.Status.Value, deployment.Status.Reason = "FINALIZED", "DEPLOYED"
+	deployment.Droplet.GUID = droplet.GUID
+	deployment.Relationships.App.Data.GUID = appGUID
+	api.deployments[deployment.GUID] = deployment
+	return deployment, http.StatusCreated
+}
+
+// restageClassic stages a new droplet for an app through the V2 API, which
+// is staged and running as soon as it is asked for.
+func (api *fakeCFAPI) restageClassic(appGUID string) (interface{}, int) {
+	droplets := api.fixtures.dropletsOf(map[string]bool{appGUID: true})
+	if len(droplets) != 1 {
+		return cfErrors(100004, "CF-AppNotFound", "The app could not be found: "+appGUID), http.StatusNotFound
+	}
+	droplet := droplets[0]
+	droplet.GUID = api.newGUID("droplet")
+	droplet.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	api.setCurrentDroplet(appGUID, droplet)
+	return map[string]interface{}{"metadata": map[string]string{"guid": appGUID}}, http.StatusCreated
+}
+
+// setCurrentDroplet replaces the current droplet of an app with droplet,
+// keeping the droplet it replaces so that the app can be rolled back to it.
+func (api *fakeCFAPI) setCurrentDroplet(appGUID string, droplet Droplet) {
+	for i, current := range api.fixtures.Droplets {
+		if current.appGUID() == appGUID {
+			api.staged[current.GUID] = current
+			api.fixtures.Droplets[i] = droplet
+			return
+		}
+	}
+	api.fixtures.Droplets = append(api.fixtures.Droplets, droplet)
+}
+
+func (api *fakeCFAPI) newGUID(kind string) string {
+	api.guids++
+	return fmt.Sprintf("%s-%d", kind, api.guids)
+}
+
+func (api *fakeCFAPI) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// cfErrors is the body of the CF API errors.
+func cfErrors(code int, title, detail string) map[string]interface{} {
+	return map[string]interface{}{
+		"errors": []map[string]interface{}{{"code": code, "title": title, "detail": detail}},
+	}
+}
+
+func decodeRequest(req *http.Request, out interface{}) error {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
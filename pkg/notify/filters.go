@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pattern matches a CF resource either by name or by GUID. Names can also be
+// matched with a shell style glob (e.g. *-sandbox) or with a regular
+// expression wrapped in slashes (e.g. /^dev-[0-9]+$/).
+type pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+func parsePattern(raw string) (pattern, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) > 2 && strings.HasPrefix(raw, "/") && strings.HasSuffix(raw, "/") {
+		re, err := regexp.Compile(raw[1 : len(raw)-1])
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid regular expression %s: %s", raw, err)
+		}
+		return pattern{raw: raw, re: re}, nil
+	}
+	if _, err := path.Match(raw, ""); err != nil {
+		return pattern{}, fmt.Errorf("invalid glob %s: %s", raw, err)
+	}
+	return pattern{raw: raw}, nil
+}
+
+func (p pattern) matches(name, guid string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	if p.raw == name || p.raw == guid {
+		return true
+	}
+	matched, _ := path.Match(p.raw, name)
+	return matched
+}
+
+// patternList is a list of patterns which matches a resource if any of its
+// patterns do.
+type patternList []pattern
+
+func parsePatternList(raw []string) (patternList, error) {
+	var patterns patternList
+	for _, entry := range raw {
+		if strings.TrimSpace(entry) == "" {
+			// An empty setting still lists one blank entry.
+			continue
+		}
+		p, err := parsePattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+func (l patternList) matches(name, guid string) bool {
+	for _, p := range l {
+		if p.matches(name, guid) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceFilter limits a run to some CF resources. A resource is in scope
+// when it is not excluded and either there is no include list or it is on it.
+// The only list, given on the command line, narrows the scope further.
+type resourceFilter struct {
+	include patternList
+	exclude patternList
+	only    patternList
+}
+
+func newResourceFilter(include, exclude []string) (resourceFilter, error) {
+	includePatterns, err := parsePatternList(include)
+	if err != nil {
+		return resourceFilter{}, err
+	}
+	excludePatterns, err := parsePatternList(exclude)
+	if err != nil {
+		return resourceFilter{}, err
+	}
+	return resourceFilter{include: includePatterns, exclude: excludePatterns}, nil
+}
+
+func (f resourceFilter) isEmpty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0 && len(f.only) == 0
+}
+
+func (f resourceFilter) allows(name, guid string) bool {
+	if f.exclude.matches(name, guid) {
+		return false
+	}
+	if len(f.only) > 0 && !f.only.matches(name, guid) {
+		return false
+	}
+	return len(f.include) == 0 || f.include.matches(name, guid)
+}
+
+// runScope limits a run to the apps in some orgs and spaces, and to updates
+// of some buildpacks. Apps in systemOrgs belong to the operators, who are
+// told about them in the run summary rather than by e-mail.
+type runScope struct {
+	orgs       resourceFilter
+	spaces     resourceFilter
+	buildpacks resourceFilter
+	systemOrgs patternList
+	// apps, given on the command line, limits the run to the apps it
+	// matches.
+	apps patternList
+}
+
+// filtersApps reports whether the scope limits which apps are considered.
+func (s runScope) filtersApps() bool {
+	return !s.orgs.isEmpty() || !s.spaces.isEmpty() || len(s.apps) > 0
+}
+
+// isNarrowed reports whether the command line narrows the scope to some
+// orgs, spaces or apps. INCLUDE_ORGS and INCLUDE_SPACES don't count: they
+// set the orgs and spaces a deployment is responsible for, and runs limited
+// to them record the updates as handled, since the apps elsewhere are never
+// theirs to notify.
+func (s runScope) isNarrowed() bool {
+	return len(s.orgs.only) > 0 || len(s.spaces.only) > 0 || len(s.apps) > 0
+}
+
+// skipAnnotation is the app or space annotation that, set to true, opts apps
+// out of notifications entirely, e.g. apps intentionally frozen for an audit.
+const skipAnnotation = "notify.cloud.gov/skip"
+
+// isSkipped reports whether metadata opts its resource out of notifications.
+func isSkipped(metadata Metadata) bool {
+	skip, err := strconv.ParseBool(metadata.Annotations[skipAnnotation])
+	return err == nil && skip
+}
+
+// filterAppsByScope drops the apps whose org or space isn't allowed by scope,
+// the apps in system orgs, the apps in suspended orgs, which their owners
+// can't restage, and the apps opted out of notifications with the skip annotation on either
+// the app or its space. The spaces come along with the apps when they are
+// listed, so apps are dropped before any per-app droplet lookups happen.
+func filterAppsByScope(apps []App, spaces map[string]spaceInfo, scope runScope, report *runReport, logger *Logger) []App {
+	filteredApps := []App{}
+	report.recordSpaces(spaces)
+	for _, app := range apps {
+		spaceGUID := app.Relationships.Space.Data.GUID
+		space, found := spaces[spaceGUID]
+		if !found {
+			space = spaceInfo{Space: Space{GUID: spaceGUID}}
+		}
+		if !scope.orgs.allows(space.Org.Name, space.Org.GUID) {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because org %s is filtered out\n", app.Name, app.GUID, space.Org.Name)
+			report.traceApp(app, "scope", "filtered out", fmt.Sprintf("org %s isn't in the orgs of the run", space.Org.Name))
+			report.recordApp(app, decisionFilteredOut)
+			continue
+		}
+		if !scope.spaces.allows(space.Space.Name, space.Space.GUID) {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because space %s is filtered out\n", app.Name, app.GUID, space.Space.Name)
+			report.traceApp(app, "scope", "filtered out", fmt.Sprintf("space %s isn't in the spaces of the run", space.Space.Name))
+			report.recordApp(app, decisionFilteredOut)
+			continue
+		}
+		if len(scope.apps) > 0 && !scope.apps.matches(app.Name, app.GUID) {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because it is filtered out\n", app.Name, app.GUID)
+			report.traceApp(app, "scope", "filtered out", "the app isn't in the apps of the run")
+			report.recordApp(app, decisionFilteredOut)
+			continue
+		}
+		if scope.systemOrgs.matches(space.Org.Name, space.Org.GUID) {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because org %s is a system org\n", app.Name, app.GUID, space.Org.Name)
+			report.recordSystemApp(app, space)
+			continue
+		}
+		if space.Org.Suspended {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because org %s is suspended\n", app.Name, app.GUID, space.Org.Name)
+			report.traceApp(app, "org status", "suspended", fmt.Sprintf("org %s is suspended, so its apps can't be restaged", space.Org.Name))
+			report.recordApp(app, decisionSuspendedOrg)
+			continue
+		}
+		if isSkipped(app.Metadata) {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because it is annotated with %s\n", app.Name, app.GUID, skipAnnotation)
+			report.traceApp(app, "opt out", "opted out", fmt.Sprintf("the app is annotated with %s", skipAnnotation))
+			report.recordApp(app, decisionOptedOut)
+			continue
+		}
+		if isSkipped(space.Space.Metadata) {
+			logger.with(appFields(app)).debugf("App %s guid %s skipped because space %s is annotated with %s\n", app.Name, app.GUID, space.Space.Name, skipAnnotation)
+			report.traceApp(app, "opt out", "opted out", fmt.Sprintf("space %s is annotated with %s", space.Space.Name, skipAnnotation))
+			report.recordApp(app, decisionOptedOut)
+			continue
+		}
+		report.traceApp(app, "scope", "in scope", fmt.Sprintf("org %s space %s", space.Org.Name, space.Space.Name))
+		filteredApps = append(filteredApps, app)
+	}
+	logger.infof("%d of %d apps are in scope.\n", len(filteredApps), len(apps))
+	return filteredApps
+}
+
+// ValidatePattern returns the problem with pattern, a name, GUID, glob or
+// /regexp/ matching orgs, spaces or apps, if it can't be parsed.
+func ValidatePattern(pattern string) error {
+	_, err := parsePattern(pattern)
+	return err
+}
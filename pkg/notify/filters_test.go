@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func newTestSpaceInOrg(guid, name, orgGUID string) Space {
+	space := Space{GUID: guid, Name: name}
+	space.Relationships.Organization.Data.GUID = orgGUID
+	return space
+}
+
+func mustResourceFilter(t *testing.T, include, exclude []string) resourceFilter {
+	filter, err := newResourceFilter(include, exclude)
+	if err != nil {
+		t.Fatalf("Unable to create filter. Error %s", err)
+	}
+	return filter
+}
+
+func TestPatternMatches(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		name     string
+		guid     string
+		expected bool
+	}{
+		{"sandbox", "sandbox", "guid1", true},
+		{"guid1", "sandbox", "guid1", true},
+		{"sandbox", "sandbox-2", "guid1", false},
+		{"*-sandbox", "alice-sandbox", "guid1", true},
+		{"*-sandbox", "production", "guid1", false},
+		{"/^dev-[0-9]+$/", "dev-42", "guid1", true},
+		{"/^dev-[0-9]+$/", "dev-x", "guid1", false},
+	}
+	for _, tc := range testCases {
+		p, err := parsePattern(tc.pattern)
+		if err != nil {
+			t.Fatalf("Unable to parse pattern %s. Error %s", tc.pattern, err)
+		}
+		if actual := p.matches(tc.name, tc.guid); actual != tc.expected {
+			t.Errorf("Pattern %s matching %s/%s. Expected %v Actual %v", tc.pattern, tc.name, tc.guid, tc.expected, actual)
+		}
+	}
+	for _, invalid := range []string{"/dev-(/", "[a-"} {
+		if _, err := parsePattern(invalid); err == nil {
+			t.Errorf("Expected pattern %s to be invalid", invalid)
+		}
+	}
+}
+
+func TestFilterAppsByScope(t *testing.T) {
+	var listed AppResponse
+	listed.Included.Spaces = []Space{
+		newTestSpaceInOrg("space1", "dev", "org1"),
+		newTestSpaceInOrg("space2", "dev", "org2"),
+		newTestSpaceInOrg("space3", "dev", "org3"),
+		newTestSpaceInOrg("space4", "alice-sandbox", "org2"),
+	}
+	frozenSpace := newTestSpaceInOrg("space5", "frozen", "org2")
+	frozenSpace.Metadata.Annotations = map[string]string{skipAnnotation: "true"}
+	listed.Included.Spaces = append(listed.Included.Spaces, frozenSpace)
+	listed.Included.Organizations = []Organization{
+		{GUID: "org1", Name: "sandbox"},
+		{GUID: "org2", Name: "agency"},
+		{GUID: "org3", Name: "system"},
+	}
+	frozenApp := newTestApp("app5", "space1")
+	frozenApp.Metadata.Annotations = map[string]string{skipAnnotation: "true"}
+	notFrozenApp := newTestApp("app6", "space1")
+	notFrozenApp.Metadata.Annotations = map[string]string{skipAnnotation: "false"}
+	listed.Apps = []App{newTestApp("app1", "space1"), newTestApp("app2", "space2"), newTestApp("app3", "space3"), newTestApp("app4", "space4"),
+		frozenApp, notFrozenApp, newTestApp("app7", "space5")}
+	testCases := []struct {
+		name     string
+		scope    runScope
+		expected []string
+		optedOut int
+		system   int
+	}{
+		{"no filter", runScope{}, []string{"app1", "app2", "app3", "app4", "app6"}, 2, 0},
+		{"include org by name", runScope{orgs: mustResourceFilter(t, []string{"agency"}, nil)}, []string{"app2", "app4"}, 1, 0},
+		{"include org by guid", runScope{orgs: mustResourceFilter(t, []string{"org1", "org3"}, nil)}, []string{"app1", "app3", "app6"}, 1, 0},
+		{"exclude org by name", runScope{orgs: mustResourceFilter(t, nil, []string{"system", "sandbox"})}, []string{"app2", "app4"}, 1, 0},
+		{"exclude org wins over include", runScope{orgs: mustResourceFilter(t, []string{"sandbox", "agency"}, []string{"org1"})}, []string{"app2", "app4"}, 1, 0},
+		{"exclude space by glob", runScope{spaces: mustResourceFilter(t, nil, []string{"*-sandbox"})}, []string{"app1", "app2", "app3", "app6"}, 2, 0},
+		{"include space by regex", runScope{spaces: mustResourceFilter(t, []string{"/sandbox$/"}, nil)}, []string{"app4"}, 0, 0},
+		{"org and space filters combined", runScope{
+			orgs:   mustResourceFilter(t, []string{"agency"}, nil),
+			spaces: mustResourceFilter(t, nil, []string{"*-sandbox"}),
+		}, []string{"app2"}, 1, 0},
+		{"system org", runScope{systemOrgs: patternList{{raw: "system"}}}, []string{"app1", "app2", "app4", "app6"}, 2, 1},
+		{"only org narrows include", runScope{orgs: resourceFilter{
+			include: patternList{{raw: "sandbox"}, {raw: "agency"}},
+			only:    patternList{{raw: "sandbox"}},
+		}}, []string{"app1", "app6"}, 1, 0},
+		{"only apps", runScope{apps: patternList{{raw: "app1"}, {raw: "app2"}, {raw: "app7"}}}, []string{"app1", "app2"}, 1, 0},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/apps" {
+			t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(listed)
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	apps, spaces, err := listAppsWithSpaces(&c, ListOptions{})
+	if err != nil {
+		t.Fatalf("Unable to list apps. Error %s", err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			report := newRunReport()
+			filtered := filterAppsByScope(apps, spaces, tc.scope, report, nil)
+			if len(filtered) != len(tc.expected) {
+				t.Fatalf("Test %s failed. Expected apps %v, found %+v", tc.name, tc.expected, filtered)
+			}
+			for i, app := range filtered {
+				if app.GUID != tc.expected[i] {
+					t.Errorf("Test %s failed. Expected apps %v, found %+v", tc.name, tc.expected, filtered)
+				}
+			}
+			if report.count(decisionOptedOut) != tc.optedOut {
+				t.Errorf("Test %s failed. Expected %d apps opted out, found %d", tc.name, tc.optedOut, report.count(decisionOptedOut))
+			}
+			if report.count(decisionSystemOrg) != tc.system || len(report.systemApps) != tc.system {
+				t.Errorf("Test %s failed. Expected %d apps in system orgs, found %d", tc.name, tc.system, report.count(decisionSystemOrg))
+			}
+		})
+	}
+}
+
+func TestFilterAppsBySuspendedOrg(t *testing.T) {
+	spaces := map[string]spaceInfo{
+		"space1": {Space: Space{GUID: "space1", Name: "dev"}, Org: Organization{GUID: "org1", Name: "agency"}},
+		"space2": {Space: Space{GUID: "space2", Name: "dev"}, Org: Organization{GUID: "org2", Name: "lapsed", Suspended: true}},
+	}
+	report := newRunReport()
+	filtered := filterAppsByScope([]App{newTestApp("app1", "space1"), newTestApp("app2", "space2")}, spaces, runScope{}, report, nil)
+	if len(filtered) != 1 || filtered[0].GUID != "app1" {
+		t.Errorf("Expected only app1 to be in scope, found %+v", filtered)
+	}
+	if report.count(decisionSuspendedOrg) != 1 {
+		t.Errorf("Expected 1 app in a suspended org, found %+v", report.decisions)
+	}
+}
@@ -0,0 +1,305 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// fixtures are the CF API resources a simulated run reads instead of the CF
+// API, as written by the export command.
+type fixtures struct {
+	Apps          []App          `json:"apps"`
+	Spaces        []Space        `json:"spaces"`
+	Organizations []Organization `json:"organizations"`
+	Buildpacks    []Buildpack    `json:"buildpacks"`
+	// Droplets are the current droplets of the apps.
+	Droplets []Droplet `json:"droplets"`
+	Roles    []Role    `json:"roles"`
+	Users    []User    `json:"users"`
+}
+
+// fixturesAPIAddress is the API address of the client reading fixtures, which
+// is never dialed.
+const fixturesAPIAddress = "http://fixtures.invalid"
+
+func loadFixtures(path string) (*fixtures, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse fixtures")
+	}
+	return &f, nil
+}
+
+func saveFixtures(f *fixtures, path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// newFixtureClient creates a client answering the CF API requests of a run
+// from the fixtures at path, along with the rate limiting transport runs
+// report on.
+func newFixtureClient(path string) (*cfclient.Client, *rateLimitTransport, error) {
+	f, err := loadFixtures(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rateLimiter := newRateLimitTransport(f, 0, 0, nil)
+	client := &cfclient.Client{Config: cfclient.Config{
+		ApiAddress: fixturesAPIAddress,
+		HttpClient: &http.Client{Transport: rateLimiter},
+	}}
+	return client, rateLimiter, nil
+}
+
+// RoundTrip answers the read-only V3 API requests a run makes from the
+// fixtures. Anything else, such as restaging an app, is not found.
+func (f *fixtures) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	query := req.URL.Query()
+	var body interface{}
+	if req.Method == http.MethodGet && len(parts) >= 2 && parts[0] == "v3" {
+		body = f.answer(parts[1:], query)
+	}
+	if body == nil {
+		return fixtureResponse(req, http.StatusNotFound, map[string]interface{}{
+			"errors": []map[string]interface{}{{"code": 10010, "title": "CF-ResourceNotFound", "detail": fmt.Sprintf("%s %s is not available in a simulation", req.Method, req.URL.Path)}},
+		})
+	}
+	return fixtureResponse(req, http.StatusOK, body)
+}
+
+// answer returns the response to a request for the V3 API resource at path,
+// or nil if the fixtures don't have it.
+func (f *fixtures) answer(path []string, query url.Values) interface{} {
+	switch {
+	case len(path) == 1 && path[0] == "apps":
+		return f.appsPage(query)
+	case len(path) == 2 && path[0] == "apps":
+		for _, app := range f.Apps {
+			if app.GUID == path[1] {
+				return app
+			}
+		}
+	case len(path) == 3 && path[0] == "apps" && path[2] == "droplets":
+		return DropletResponse{Droplets: f.dropletsOf(map[string]bool{path[1]: true})}
+	case len(path) == 1 && path[0] == "droplets":
+		return DropletResponse{Droplets: f.dropletsOf(guidSet(query.Get("app_guids")))}
+	case len(path) == 2 && path[0] == "droplets":
+		for _, droplet := range f.Droplets {
+			if droplet.GUID == path[1] {
+				return droplet
+			}
+		}
+	case len(path) == 1 && path[0] == "buildpacks":
+		return BuildpackResponse{Buildpacks: f.Buildpacks}
+	case len(path) == 1 && (path[0] == "builds" || path[0] == "deployments"):
+		// Apps are only ever on their current droplets in a simulation.
+		return map[string]interface{}{"pagination": Pagination{}, "resources": []interface{}{}}
+	case len(path) == 2 && path[0] == "spaces":
+		for _, space := range f.Spaces {
+			if space.GUID == path[1] {
+				resp := SpaceResponse{Space: space}
+				for _, org := range f.Organizations {
+					if org.GUID == space.Relationships.Organization.Data.GUID {
+						resp.Included.Organizations = append(resp.Included.Organizations, org)
+					}
+				}
+				return resp
+			}
+		}
+	case len(path) == 1 && path[0] == "roles":
+		return f.rolesMatching(query)
+	}
+	return nil
+}
+
+// fixturesPerPage is how many apps a page holds when a request doesn't ask,
+// as with the CF API.
+const fixturesPerPage = 50
+
+// appsPage returns the page of apps asked for by query, along with the spaces
+// and organizations of the apps on the page and a link to the next page.
+func (f *fixtures) appsPage(query url.Values) AppResponse {
+	perPage, err := strconv.Atoi(query.Get("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = fixturesPerPage
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	var resp AppResponse
+	resp.Apps = []App{}
+	resp.Pagination.TotalResults = len(f.Apps)
+	resp.Pagination.TotalPages = (len(f.Apps) + perPage - 1) / perPage
+	if start := (page - 1) * perPage; start < len(f.Apps) {
+		end := start + perPage
+		if end > len(f.Apps) {
+			end = len(f.Apps)
+		}
+		resp.Apps = f.Apps[start:end]
+	}
+	if page < resp.Pagination.TotalPages {
+		next := url.Values{}
+		for key, values := range query {
+			next[key] = values
+		}
+		next.Set("page", strconv.Itoa(page+1))
+		resp.Pagination.Next.Href = fixturesAPIAddress + "/v3/apps?" + next.Encode()
+	}
+	spaces, orgs := make(map[string]bool), make(map[string]bool)
+	for _, app := range resp.Apps {
+		spaces[app.Relationships.Space.Data.GUID] = true
+	}
+	for _, space := range f.Spaces {
+		if spaces[space.GUID] {
+			resp.Included.Spaces = append(resp.Included.Spaces, space)
+			orgs[space.Relationships.Organization.Data.GUID] = true
+		}
+	}
+	for _, org := range f.Organizations {
+		if orgs[org.GUID] {
+			resp.Included.Organizations = append(resp.Included.Organizations, org)
+		}
+	}
+	return resp
+}
+
+// dropletsOf returns the droplets of the apps with GUIDs in appGUIDs.
+func (f *fixtures) dropletsOf(appGUIDs map[string]bool) []Droplet {
+	droplets := []Droplet{}
+	for _, droplet := range f.Droplets {
+		if appGUIDs[droplet.appGUID()] {
+			droplets = append(droplets, droplet)
+		}
+	}
+	return droplets
+}
+
+// rolesMatching returns the roles in the spaces or organizations and of the
+// types asked for by query, along with their users.
+func (f *fixtures) rolesMatching(query url.Values) RoleResponse {
+	spaces, orgs, types := guidSet(query.Get("space_guids")), guidSet(query.Get("organization_guids")), guidSet(query.Get("types"))
+	var resp RoleResponse
+	resp.Roles = []Role{}
+	users := make(map[string]bool)
+	for _, role := range f.Roles {
+		if len(types) > 0 && !types[role.Type] {
+			continue
+		}
+		inSpace := spaces[role.Relationships.Space.Data.GUID]
+		inOrg := orgs[role.Relationships.Organization.Data.GUID]
+		if !inSpace && !inOrg {
+			continue
+		}
+		resp.Roles = append(resp.Roles, role)
+		users[role.Relationships.User.Data.GUID] = true
+	}
+	for _, user := range f.Users {
+		if users[user.GUID] {
+			resp.Included.Users = append(resp.Included.Users, user)
+		}
+	}
+	return resp
+}
+
+// guidSet splits a comma separated list of GUIDs from a query.
+func guidSet(raw string) map[string]bool {
+	set := make(map[string]bool)
+	for _, guid := range strings.Split(raw, ",") {
+		if guid != "" {
+			set[guid] = true
+		}
+	}
+	return set
+}
+
+func fixtureResponse(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(data)),
+		Request:    req,
+	}, nil
+}
+
+// exportFixtures reads the resources a run needs from the CF API into
+// fixtures for simulated runs: every app with its space and organization,
+// every buildpack, the current droplet of every started buildpack app and
+// every role in the spaces and organizations of the apps.
+func exportFixtures(client *cfclient.Client, listOpts ListOptions, concurrency int, errs *runErrors, logger *Logger) (*fixtures, error) {
+	f := &fixtures{}
+	var err error
+	if f.Apps, f.Spaces, f.Organizations, err = ListApps(client, listOpts); err != nil {
+		return nil, errors.Wrap(err, "Unable to get apps")
+	}
+	if f.Buildpacks, err = ListBuildpacks(client, listOpts); err != nil {
+		return nil, errors.Wrap(err, "Unable to get buildpacks")
+	}
+	for _, result := range getDropletsToCheck(f.Apps, client, concurrency, newRunReport(), errs, logger) {
+		if result.ok {
+			f.Droplets = append(f.Droplets, result.droplet)
+		}
+	}
+	users := make(map[string]User)
+	addRoles := func(key string, guids []string) error {
+		for start := 0; start < len(guids); start += guidsPerRequest {
+			end := start + guidsPerRequest
+			if end > len(guids) {
+				end = len(guids)
+			}
+			roles, roleUsers, err := listRoles(client, url.Values{key: []string{strings.Join(guids[start:end], ",")}, "include": []string{"user"}})
+			if err != nil {
+				return errors.Wrap(err, "Unable to get roles")
+			}
+			f.Roles = append(f.Roles, roles...)
+			for _, user := range roleUsers {
+				users[user.GUID] = user
+			}
+		}
+		return nil
+	}
+	var spaceGUIDs, orgGUIDs []string
+	for _, space := range f.Spaces {
+		spaceGUIDs = append(spaceGUIDs, space.GUID)
+	}
+	for _, org := range f.Organizations {
+		orgGUIDs = append(orgGUIDs, org.GUID)
+	}
+	if err := addRoles("space_guids", spaceGUIDs); err != nil {
+		return nil, err
+	}
+	if err := addRoles("organization_guids", orgGUIDs); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		f.Users = append(f.Users, user)
+	}
+	sort.Slice(f.Users, func(i, j int) bool { return f.Users[i].GUID < f.Users[j].GUID })
+	return f, nil
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestFixtures() *fixtures {
+	app := newTestApp("app1", "space1")
+	app.Name, app.State = "app1", "STARTED"
+	app.Lifecycle.Type = "buildpack"
+	space := Space{GUID: "space1", Name: "dev"}
+	space.Relationships.Organization.Data.GUID = "org1"
+	droplet := newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")
+	droplet.Buildpacks[0].Version = "1.7.40"
+	developer := Role{GUID: "role1", Type: "space_developer"}
+	developer.Relationships.User.Data.GUID = user1GUID
+	developer.Relationships.Space.Data.GUID = "space1"
+	manager := Role{GUID: "role2", Type: "organization_manager"}
+	manager.Relationships.User.Data.GUID = user2GUID
+	manager.Relationships.Organization.Data.GUID = "org1"
+	return &fixtures{
+		Apps:          []App{app},
+		Spaces:        []Space{space},
+		Organizations: []Organization{{GUID: "org1", Name: "agency"}},
+		Buildpacks: []Buildpack{
+			{GUID: "bp1", Name: "python_buildpack", Enabled: true, Filename: "python_buildpack-cflinuxfs4-v1.8.0.zip", UpdatedAt: "2020-02-01T00:00:00Z"},
+		},
+		Droplets: []Droplet{droplet},
+		Roles:    []Role{developer, manager},
+		Users:    []User{{GUID: user1GUID, Username: user1}, {GUID: user2GUID, Username: user2}},
+	}
+}
+
+func TestExportFixtures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	expected := newTestFixtures()
+	if err := saveFixtures(expected, path); err != nil {
+		t.Fatalf("Unable to write fixtures. Error: %s", err)
+	}
+	// Exporting from the fixtures gives back the same fixtures.
+	client, _, err := newFixtureClient(path)
+	if err != nil {
+		t.Fatalf("Unable to create fixture client. Error: %s", err)
+	}
+	errs := &runErrors{}
+	exported, err := exportFixtures(client, ListOptions{}, 1, errs, nil)
+	if err != nil {
+		t.Fatalf("Unable to export fixtures. Error: %s", err)
+	}
+	if !reflect.DeepEqual(exported, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, exported)
+	}
+	if errs.count() != 0 {
+		t.Errorf("Expected no errors, found %d", errs.count())
+	}
+	if _, err := GetApp(client, "missing"); err == nil {
+		t.Errorf("Expected an app missing from the fixtures not to be found")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	fixturesPath := filepath.Join(dir, "fixtures.json")
+	if err := saveFixtures(newTestFixtures(), fixturesPath); err != nil {
+		t.Fatalf("Unable to write fixtures. Error: %s", err)
+	}
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(`{"Buildpacks": {"bp1": {"LastUpdatedAt": "2020-01-15T00:00:00Z"}}}`), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	setTestConfigEnv(t)
+	t.Setenv("IN_STATE", statePath)
+	t.Setenv("OUT_STATE", filepath.Join(dir, "out.json"))
+	// A simulation needs neither the CF API nor its credentials.
+	os.Unsetenv("CF_API")
+	os.Unsetenv("CLIENT_SECRET")
+	emailDir := filepath.Join(dir, "emails")
+	config, cfAPIConfig := loadTestConfig(t)
+	if err := Simulate(config, cfAPIConfig, fixturesPath, emailDir); err != nil {
+		t.Fatalf("Expected the simulation to succeed, found %s", err)
+	}
+	emails, err := ioutil.ReadDir(emailDir)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("Expected a single e-mail, found %v. Error: %v", emails, err)
+	}
+	email, err := ioutil.ReadFile(filepath.Join(emailDir, emails[0].Name()))
+	if err != nil {
+		t.Fatalf("Unable to read e-mail. Error: %s", err)
+	}
+	if !strings.HasPrefix(string(email), "To: "+user1+"\n") || !strings.Contains(string(email), "app1") {
+		t.Errorf("Expected the e-mail to tell %s about app1, found\n%s", user1, email)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected the simulation to leave the state alone")
+	}
+}
+
+func TestSimulateSendsConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	f := newTestFixtures()
+	// Every developer of space1 is e-mailed about app1.
+	for i := 3; i <= 10; i++ {
+		guid := fmt.Sprintf("user%d-guid", i)
+		developer := Role{GUID: fmt.Sprintf("role%d", i), Type: "space_developer"}
+		developer.Relationships.User.Data.GUID = guid
+		developer.Relationships.Space.Data.GUID = "space1"
+		f.Roles = append(f.Roles, developer)
+		f.Users = append(f.Users, User{GUID: guid, Username: fmt.Sprintf("user%d@example.com", i)})
+	}
+	fixturesPath := filepath.Join(dir, "fixtures.json")
+	if err := saveFixtures(f, fixturesPath); err != nil {
+		t.Fatalf("Unable to write fixtures. Error: %s", err)
+	}
+	statePath := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(statePath, []byte(`{"Buildpacks": {"bp1": {"LastUpdatedAt": "2020-01-15T00:00:00Z"}}}`), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	setTestConfigEnv(t)
+	t.Setenv("IN_STATE", statePath)
+	t.Setenv("EMAIL_CONCURRENCY", "4")
+	emailDir := filepath.Join(dir, "emails")
+	config, cfAPIConfig := loadTestConfig(t)
+	if err := Simulate(config, cfAPIConfig, fixturesPath, emailDir); err != nil {
+		t.Fatalf("Expected the simulation to succeed, found %s", err)
+	}
+	// Each e-mail gets a number of its own, so none overwrites another.
+	if emails, err := ioutil.ReadDir(emailDir); err != nil || len(emails) != 9 {
+		t.Errorf("Expected 9 e-mails, found %v. Error: %v", emails, err)
+	}
+}
@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FixtureSpec is the size and shape of a foundation generated as fixtures.
+type FixtureSpec struct {
+	Orgs         int
+	SpacesPerOrg int
+	AppsPerSpace int
+	// OutdatedRatio is the fraction of apps staged before their buildpack
+	// was last updated.
+	OutdatedRatio float64
+	// Seed picks the foundation generated: the same seed generates the same
+	// foundation, with its dates relative to the time it is generated at.
+	Seed int64
+}
+
+// generatedBuildpack is a system buildpack of generated foundations, with
+// how many apps use it relative to the others.
+type generatedBuildpack struct {
+	name, version, detectOutput string
+	weight                      int
+}
+
+var generatedBuildpacks = []generatedBuildpack{
+	{"python_buildpack", "1.8.15", "python 3.11.6", 30},
+	{"nodejs_buildpack", "1.8.20", "nodejs 20.10.0", 25},
+	{"ruby_buildpack", "1.10.8", "ruby 3.2.2", 15},
+	{"go_buildpack", "1.10.14", "go 1.21.5", 10},
+	{"java_buildpack", "4.63.1", "", 8},
+	{"php_buildpack", "4.6.12", "php 8.2.13", 6},
+	{"staticfile_buildpack", "1.6.8", "", 4},
+	{"binary_buildpack", "1.1.8", "", 2},
+}
+
+var (
+	generatedAgencies   = []string{"gsa", "nasa", "doi", "fec", "epa", "usda", "va", "hhs", "doc", "dol"}
+	generatedSpaces     = []string{"dev", "staging", "prod", "sandbox", "test", "demo"}
+	generatedApps       = []string{"api", "web", "worker", "admin", "docs", "cms", "dashboard", "search"}
+	generatedFirstNames = []string{"alex", "sam", "jordan", "taylor", "casey", "morgan", "riley", "jamie", "avery", "quinn"}
+	generatedLastNames  = []string{"smith", "garcia", "chen", "patel", "nguyen", "johnson", "kim", "lopez", "brown", "davis"}
+)
+
+// fixtureGenerator generates the resources of a foundation, keeping what is
+// needed to keep names and GUIDs unique.
+type fixtureGenerator struct {
+	rng       *rand.Rand
+	now       time.Time
+	f         *fixtures
+	usernames map[string]bool
+}
+
+// generateFixtures generates a foundation of spec.Orgs orgs, each with
+// spec.SpacesPerOrg spaces of spec.AppsPerSpace started apps using the system
+// buildpacks, the more popular ones more often. The buildpacks were updated
+// in the month before now, and spec.OutdatedRatio of the apps were staged
+// before the update of their buildpack, with an older version of it. Every
+// org has a manager or two and every space a few developers, and often a
+// manager, drawn from the members of its org, some of whose usernames aren't
+// e-mail addresses.
+func generateFixtures(spec FixtureSpec, now time.Time) *fixtures {
+	g := &fixtureGenerator{
+		rng:       rand.New(rand.NewSource(spec.Seed)),
+		now:       now.UTC().Truncate(time.Second),
+		f:         &fixtures{},
+		usernames: make(map[string]bool),
+	}
+	weights := 0
+	for i, buildpack := range generatedBuildpacks {
+		updatedAt := g.now.Add(-time.Duration(1+g.rng.Intn(30*24)) * time.Hour)
+		g.f.Buildpacks = append(g.f.Buildpacks, Buildpack{
+			GUID:      g.guid(),
+			Name:      buildpack.name,
+			Stack:     "cflinuxfs4",
+			Position:  i + 1,
+			Enabled:   true,
+			Filename:  fmt.Sprintf("%s-cflinuxfs4-v%s.zip", buildpack.name, buildpack.version),
+			CreatedAt: g.now.AddDate(-2, 0, 0).Format(time.RFC3339),
+			UpdatedAt: updatedAt.Format(time.RFC3339),
+		})
+		weights += buildpack.weight
+	}
+	for o := 0; o < spec.Orgs; o++ {
+		agency := generatedAgencies[o%len(generatedAgencies)]
+		org := Organization{GUID: g.guid(), Name: uniqueName(agency, o/len(generatedAgencies))}
+		g.f.Organizations = append(g.f.Organizations, org)
+		members := g.users(agency, spec.SpacesPerOrg+2)
+		for _, manager := range g.pick(members, 1+g.rng.Intn(2)) {
+			g.addRole("organization_manager", manager, "", org.GUID)
+		}
+		for s := 0; s < spec.SpacesPerOrg; s++ {
+			space := Space{GUID: g.guid(), Name: uniqueName(generatedSpaces[s%len(generatedSpaces)], s/len(generatedSpaces))}
+			space.Relationships.Organization.Data.GUID = org.GUID
+			g.f.Spaces = append(g.f.Spaces, space)
+			for _, developer := range g.pick(members, 1+g.rng.Intn(3)) {
+				g.addRole("space_developer", developer, space.GUID, "")
+			}
+			if g.rng.Intn(2) == 0 {
+				g.addRole("space_manager", g.pick(members, 1)[0], space.GUID, "")
+			}
+			for a := 0; a < spec.AppsPerSpace; a++ {
+				buildpack := g.weightedBuildpack(weights)
+				g.addApp(space, uniqueName(generatedApps[a%len(generatedApps)], a/len(generatedApps)), buildpack, g.rng.Float64() < spec.OutdatedRatio)
+			}
+		}
+	}
+	return g.f
+}
+
+// weightedBuildpack picks the index of a buildpack, by popularity.
+func (g *fixtureGenerator) weightedBuildpack(weights int) int {
+	n := g.rng.Intn(weights)
+	for i, buildpack := range generatedBuildpacks {
+		if n < buildpack.weight {
+			return i
+		}
+		n -= buildpack.weight
+	}
+	return len(generatedBuildpacks) - 1
+}
+
+// addApp adds a started app of space named name, running a droplet staged
+// with the buildpack at index i, before its update if outdated.
+func (g *fixtureGenerator) addApp(space Space, name string, i int, outdated bool) {
+	buildpack, system := generatedBuildpacks[i], g.f.Buildpacks[i]
+	app := App{GUID: g.guid(), Name: name, State: "STARTED"}
+	app.Lifecycle.Type = "buildpack"
+	app.Lifecycle.Data.Stack = "cflinuxfs4"
+	app.Relationships.Space.Data.GUID = space.GUID
+	updatedAt, _ := time.Parse(time.RFC3339, system.UpdatedAt)
+	version := buildpack.version
+	var stagedAt time.Time
+	if outdated {
+		stagedAt = updatedAt.Add(-time.Duration(1+g.rng.Intn(365*24)) * time.Hour)
+		version = olderVersion(version, 1+g.rng.Intn(5))
+	} else {
+		stagedAt = updatedAt.Add(time.Duration(1+g.rng.Intn(int(g.now.Sub(updatedAt).Hours()))) * time.Hour)
+	}
+	app.CreatedAt = stagedAt.AddDate(0, -1-g.rng.Intn(24), 0).Format(time.RFC3339)
+	app.UpdatedAt = stagedAt.Format(time.RFC3339)
+	g.f.Apps = append(g.f.Apps, app)
+	droplet := Droplet{
+		GUID:       g.guid(),
+		State:      "STAGED",
+		CreatedAt:  stagedAt.Format(time.RFC3339),
+		UpdatedAt:  stagedAt.Format(time.RFC3339),
+		Stack:      "cflinuxfs4",
+		Buildpacks: []DropletBuildpack{{Name: buildpack.name, Version: version, DetectOutput: buildpack.detectOutput}},
+	}
+	droplet.Links.App.Href = fixturesAPIAddress + "/v3/apps/" + app.GUID
+	droplet.Links.Package.Href = fixturesAPIAddress + "/v3/packages/" + g.guid()
+	g.f.Droplets = append(g.f.Droplets, droplet)
+}
+
+// users adds n users of an agency, one in ten of them a bot whose username
+// isn't an e-mail address.
+func (g *fixtureGenerator) users(agency string, n int) []User {
+	users := make([]User, 0, n)
+	for i := 0; i < n; i++ {
+		username := fmt.Sprintf("%s-deployer", agency)
+		if g.rng.Intn(10) > 0 {
+			first := generatedFirstNames[g.rng.Intn(len(generatedFirstNames))]
+			last := generatedLastNames[g.rng.Intn(len(generatedLastNames))]
+			username = fmt.Sprintf("%s.%s@%s.gov", first, last, agency)
+		}
+		for suffix := 2; g.usernames[username]; suffix++ {
+			local, domain := username, ""
+			if at := strings.Index(username, "@"); at >= 0 {
+				local, domain = username[:at], username[at:]
+			}
+			username = strings.TrimRight(local, "0123456789") + strconv.Itoa(suffix) + domain
+		}
+		g.usernames[username] = true
+		user := User{GUID: g.guid(), Username: username, Origin: "uaa"}
+		g.f.Users = append(g.f.Users, user)
+		users = append(users, user)
+	}
+	return users
+}
+
+// pick returns n of users, without picking any twice.
+func (g *fixtureGenerator) pick(users []User, n int) []User {
+	picked := make([]User, 0, n)
+	for _, i := range g.rng.Perm(len(users))[:n] {
+		picked = append(picked, users[i])
+	}
+	return picked
+}
+
+func (g *fixtureGenerator) addRole(roleType string, user User, spaceGUID, orgGUID string) {
+	role := Role{GUID: g.guid(), Type: roleType}
+	role.Relationships.User.Data.GUID = user.GUID
+	role.Relationships.Space.Data.GUID = spaceGUID
+	role.Relationships.Organization.Data.GUID = orgGUID
+	g.f.Roles = append(g.f.Roles, role)
+}
+
+// guid returns a new GUID, in the format of the CF API.
+func (g *fixtureGenerator) guid() string {
+	return fmt.Sprintf("%08x-%04x-4%03x-%04x-%012x", g.rng.Uint32(), g.rng.Intn(1<<16), g.rng.Intn(1<<12), 0x8000|g.rng.Intn(1<<14), g.rng.Int63n(1<<48))
+}
+
+// uniqueName returns name, or name followed by n+1 for the names given out
+// more than once.
+func uniqueName(name string, n int) string {
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, n+1)
+}
+
+// olderVersion returns the version back releases before version, counting
+// down its patch version and then its minor version.
+func olderVersion(version string, back int) string {
+	parts := strings.Split(version, ".")
+	patch, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return version
+	}
+	if patch >= back {
+		parts[len(parts)-1] = strconv.Itoa(patch - back)
+		return strings.Join(parts, ".")
+	}
+	if len(parts) < 2 {
+		return version
+	}
+	minor, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil || minor == 0 {
+		return version
+	}
+	parts[len(parts)-2], parts[len(parts)-1] = strconv.Itoa(minor-1), strconv.Itoa(20+patch-back)
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateFixtures(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	spec := FixtureSpec{Orgs: 12, SpacesPerOrg: 5, AppsPerSpace: 10, OutdatedRatio: 0.3, Seed: 42}
+	f := generateFixtures(spec, now)
+	if len(f.Organizations) != 12 || len(f.Spaces) != 60 || len(f.Apps) != 600 || len(f.Droplets) != 600 {
+		t.Fatalf("Expected 12 orgs, 60 spaces and 600 apps with their droplets, found %d, %d, %d and %d", len(f.Organizations), len(f.Spaces), len(f.Apps), len(f.Droplets))
+	}
+	if !reflect.DeepEqual(f, generateFixtures(spec, now)) {
+		t.Errorf("Expected the same seed to generate the same foundation")
+	}
+	spec.Seed = 43
+	if reflect.DeepEqual(f, generateFixtures(spec, now)) {
+		t.Errorf("Expected another seed to generate another foundation")
+	}
+
+	guids := make(map[string]bool)
+	unique := func(kind, guid string) {
+		if guids[guid] {
+			t.Errorf("Expected unique GUIDs, found %s %s twice", kind, guid)
+		}
+		guids[guid] = true
+	}
+	for _, app := range f.Apps {
+		unique("app", app.GUID)
+	}
+	usernames := make(map[string]bool)
+	for _, user := range f.Users {
+		unique("user", user.GUID)
+		if usernames[user.Username] {
+			t.Errorf("Expected unique usernames, found %s twice", user.Username)
+		}
+		usernames[user.Username] = true
+	}
+	developers := make(map[string]int)
+	for _, role := range f.Roles {
+		unique("role", role.GUID)
+		if role.Type == "space_developer" {
+			developers[role.Relationships.Space.Data.GUID]++
+		}
+	}
+	for _, space := range f.Spaces {
+		if developers[space.GUID] == 0 {
+			t.Errorf("Expected every space to have a developer, found none in %s", space.GUID)
+		}
+	}
+
+	updatedAt := make(map[string]string)
+	for _, buildpack := range f.Buildpacks {
+		updatedAt[buildpack.Name] = buildpack.UpdatedAt
+		if buildpack.UpdatedAt > now.Format(time.RFC3339) {
+			t.Errorf("Expected buildpacks updated before now, found %+v", buildpack)
+		}
+	}
+	outdated := 0
+	for _, droplet := range f.Droplets {
+		buildpack := droplet.Buildpacks[0]
+		if droplet.CreatedAt < updatedAt[buildpack.Name] {
+			outdated++
+		} else if droplet.CreatedAt > now.Format(time.RFC3339) {
+			t.Errorf("Expected droplets staged before now, found %+v", droplet)
+		}
+	}
+	if outdated < 150 || outdated > 210 {
+		t.Errorf("Expected about 30%% of 600 apps to be outdated, found %d", outdated)
+	}
+}
+
+func TestOlderVersion(t *testing.T) {
+	testCases := []struct {
+		version  string
+		back     int
+		expected string
+	}{
+		{"1.8.15", 3, "1.8.12"},
+		{"1.8.2", 3, "1.7.19"},
+		{"1.0.1", 3, "1.0.1"},
+		{"4.63.1", 1, "4.63.0"},
+	}
+	for _, tc := range testCases {
+		if older := olderVersion(tc.version, tc.back); older != tc.expected {
+			t.Errorf("Expected %d releases before %s to be %s, found %s", tc.back, tc.version, tc.expected, older)
+		}
+	}
+}
+
+func TestSimulateGeneratedFixtures(t *testing.T) {
+	setTestConfigEnv(t)
+	dir := t.TempDir()
+	path, emails := filepath.Join(dir, "fixtures.json"), filepath.Join(dir, "emails")
+	if err := GenerateFixtures(FixtureSpec{Orgs: 3, SpacesPerOrg: 3, AppsPerSpace: 5, OutdatedRatio: 1, Seed: 1}, path, nil); err != nil {
+		t.Fatalf("Expected the fixtures to be generated, found %s", err)
+	}
+	state := filepath.Join(dir, "state.json")
+	if err := ioutil.WriteFile(state, []byte(`{"Buildpacks": {}}`), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	t.Setenv("IN_STATE", state)
+	config, cfAPIConfig := loadTestConfig(t)
+	if err := Simulate(config, cfAPIConfig, path, emails); err != nil {
+		t.Fatalf("Expected the simulation to succeed, found %s", err)
+	}
+	files, err := ioutil.ReadDir(emails)
+	if err != nil || len(files) == 0 {
+		t.Errorf("Expected the owners of the outdated apps to be notified, found %v %v", files, err)
+	}
+}
+
+func TestPipelineAgainstGeneratedFixtures(t *testing.T) {
+	setTestConfigEnv(t)
+	f := generateFixtures(FixtureSpec{Orgs: 2, SpacesPerOrg: 2, AppsPerSpace: 3, OutdatedRatio: 0.5, Seed: 7}, time.Now())
+	outdated := make(map[string]bool)
+	for _, droplet := range f.Droplets {
+		for _, buildpack := range f.Buildpacks {
+			if droplet.Buildpacks[0].Name == buildpack.Name && droplet.CreatedAt < buildpack.UpdatedAt {
+				outdated[droplet.appGUID()] = true
+			}
+		}
+	}
+	api := newFakeCFAPI(t, f)
+	code, mailer, _ := runAgainstFakeCFAPI(t, api, `{"Buildpacks": {}}`)
+	if code != ExitOK {
+		t.Fatalf("Expected the run to succeed, found exit code %d", code)
+	}
+	if len(mailer.emails()) == 0 {
+		t.Errorf("Expected the owners of the outdated apps to be notified, found no e-mails")
+	}
+	for _, email := range mailer.emails() {
+		if !strings.Contains(email.To, "@") {
+			t.Errorf("Expected only e-mail addresses to be notified, found %s", email.To)
+		}
+	}
+	spaces, orgs := make(map[string]Space), make(map[string]string)
+	for _, space := range f.Spaces {
+		spaces[space.GUID] = space
+	}
+	for _, org := range f.Organizations {
+		orgs[org.GUID] = org.Name
+	}
+	for _, app := range f.Apps {
+		space := spaces[app.Relationships.Space.Data.GUID]
+		command := "cf target -o " + orgs[space.Relationships.Organization.Data.GUID] + " -s " + space.Name + " ; cf restage --strategy rolling " + app.Name + "\n"
+		for _, email := range mailer.emails() {
+			if strings.Contains(email.Body, command) && !outdated[app.GUID] {
+				t.Errorf("Expected only outdated apps to be notified about, found %s in %s", app.Name, space.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package notify
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// gitBuildpack is a custom buildpack an app stages with straight from a git
+// repository, e.g. https://github.com/cloudfoundry/python-buildpack#v1.7.43.
+// Ref is the branch, tag or commit after the #, if any. Archive buildpacks
+// are downloaded from a release instead, e.g.
+// https://github.com/cloudfoundry/python-buildpack/releases/download/v1.7.43/python-buildpack-cflinuxfs4-v1.7.43.zip,
+// and Ref is the version in their URL.
+type gitBuildpack struct {
+	URL     string
+	Ref     string
+	Archive bool `json:",omitempty"`
+}
+
+// pinnedRefRe matches refs that never move: version tags like v1.7.43 and
+// commit SHAs. Branches like main keep getting updates on restage.
+var pinnedRefRe = regexp.MustCompile(`^(v?[0-9]+(\.[0-9]+)*|[0-9a-f]{7,40})$`)
+
+// isPinned reports whether the buildpack is pinned to a tag or commit, or is
+// the archive of a version, which means restaging the app never picks up
+// security fixes.
+func (b gitBuildpack) isPinned() bool {
+	return b.Archive || pinnedRefRe.MatchString(b.Ref)
+}
+
+func (b gitBuildpack) String() string {
+	if b.Ref == "" || b.Archive {
+		return b.URL
+	}
+	return b.URL + "#" + b.Ref
+}
+
+// parseGitBuildpack parses the name of a droplet buildpack, which for custom
+// buildpacks is the URL given to cf push. Credentials in the URL are dropped
+// so they never end up in logs or e-mails.
+func parseGitBuildpack(name string) (gitBuildpack, bool) {
+	if strings.HasPrefix(name, "git@") {
+		location, ref, _ := strings.Cut(name, "#")
+		return gitBuildpack{URL: location, Ref: ref}, true
+	}
+	u, err := url.Parse(name)
+	if err != nil || u.Host == "" {
+		return gitBuildpack{}, false
+	}
+	switch u.Scheme {
+	case "git", "ssh", "git+ssh":
+	case "http", "https":
+		// A zip download isn't a git repository.
+		if strings.HasSuffix(u.Path, ".zip") {
+			return gitBuildpack{}, false
+		}
+	default:
+		return gitBuildpack{}, false
+	}
+	ref := u.Fragment
+	u.Fragment = ""
+	u.User = nil
+	return gitBuildpack{URL: u.String(), Ref: ref}, true
+}
+
+// archiveVersionRe matches the version in the URL of a buildpack archive, as
+// a directory like /v1.7.43/ or at the end of its file name.
+var archiveVersionRe = regexp.MustCompile(`[/-](v?[0-9]+\.[0-9]+(?:\.[0-9]+)*)(?:/|\.zip$)`)
+
+// parseBuildpackArchive parses a buildpack given as the URL of a zip archive
+// with a version in it, which stays at that version whatever is released
+// after it. Credentials in the URL are dropped.
+func parseBuildpackArchive(name string) (gitBuildpack, bool) {
+	u, err := url.Parse(name)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") || !strings.HasSuffix(u.Path, ".zip") {
+		return gitBuildpack{}, false
+	}
+	match := archiveVersionRe.FindStringSubmatch(u.Path)
+	if match == nil {
+		return gitBuildpack{}, false
+	}
+	u.User = nil
+	u.Fragment = ""
+	return gitBuildpack{URL: u.String(), Ref: match[1], Archive: true}, true
+}
+
+// getCustomBuildpacksOfApp returns the custom buildpacks the app stages with
+// from a URL: those of its lifecycle, which the next restage stages with, or
+// those of its droplet when the lifecycle leaves the buildpacks to detection.
+func getCustomBuildpacksOfApp(app App, droplet Droplet) []gitBuildpack {
+	names := app.Lifecycle.Data.Buildpacks
+	if len(names) == 0 {
+		for _, dropletBuildpack := range droplet.Buildpacks {
+			names = append(names, dropletBuildpack.Name)
+		}
+	}
+	var custom []gitBuildpack
+	for _, name := range names {
+		if buildpack, ok := parseGitBuildpack(name); ok {
+			custom = append(custom, buildpack)
+		} else if buildpack, ok := parseBuildpackArchive(name); ok {
+			custom = append(custom, buildpack)
+		}
+	}
+	return custom
+}
+
+// getGitBuildpacksOfDroplet returns every custom git buildpack the droplet was staged with.
+func getGitBuildpacksOfDroplet(droplet Droplet) []gitBuildpack {
+	var gitBuildpacks []gitBuildpack
+	for _, dropletBuildpack := range droplet.Buildpacks {
+		if buildpack, ok := parseGitBuildpack(dropletBuildpack.Name); ok {
+			gitBuildpacks = append(gitBuildpacks, buildpack)
+		}
+	}
+	return gitBuildpacks
+}
+
+// pinnedBuildpackRecord remembers which pinned buildpacks the owners of an
+// app were warned about.
+type pinnedBuildpackRecord struct {
+	Buildpacks []string
+}
+
+// filterForNewPinnedBuildpacks returns the apps using pinned git buildpacks
+// whose owners haven't been warned about those pins yet, with only the pinned
+// buildpacks listed, and records the warning in the state. Owners are warned
+// again if an app is later pinned to something else.
+func filterForNewPinnedBuildpacks(apps []appInfo, warnings map[string]pinnedBuildpackRecord, logger *Logger) ([]appInfo, map[string]pinnedBuildpackRecord) {
+	var pinnedApps []appInfo
+	for _, app := range apps {
+		var pinned []gitBuildpack
+		var refs []string
+		for _, buildpack := range app.GitBuildpacks {
+			if buildpack.isPinned() {
+				pinned = append(pinned, buildpack)
+				refs = append(refs, buildpack.String())
+			}
+		}
+		if len(pinned) == 0 {
+			continue
+		}
+		sort.Strings(refs)
+		if record, found := warnings[app.GUID]; found && strings.Join(record.Buildpacks, ",") == strings.Join(refs, ",") {
+			logger.with(app.logFields()).debugf("Owners of app %s guid %s were already warned about pinned buildpacks %v\n", app.Name, app.GUID, refs)
+			continue
+		}
+		warnings[app.GUID] = pinnedBuildpackRecord{Buildpacks: refs}
+		pinnedApps = append(pinnedApps, appInfo{App: app.App, GitBuildpacks: pinned})
+	}
+	return pinnedApps, warnings
+}
+
+func sendPinnedBuildpackEmailToUsers(users map[string][]appInfo, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) {
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		body := new(bytes.Buffer)
+		isMultipleApp := len(apps) > 1
+		if err := templates.getPinnedBuildpackEmail(body, pinnedBuildpackEmail{user, apps, isMultipleApp}); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationPinnedBuildpack, notificationNotRendered, err, errs)
+			return
+		}
+		if !dryRun {
+			subj := "Action required: update the pinned buildpack of your application"
+			if isMultipleApp {
+				subj += "s"
+			}
+			if err := mailer.SendEmail(user, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationPinnedBuildpack, dryRun, err, errs)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationPinnedBuildpack, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent pinned buildpack e-mail to %s\n", user)
+	})
+}
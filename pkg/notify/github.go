@@ -0,0 +1,415 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// githubAPI is where release tags are verified.
+const githubAPI = "https://api.github.com"
+
+// releaseRecord is what GitHub last answered about the release of a buildpack
+// version, along with the ETag to ask again with. Conditional requests
+// answered with 304 Not Modified don't count against the GitHub rate limit.
+type releaseRecord struct {
+	Found bool
+	ETag  string `json:",omitempty"`
+	// Notes is the excerpt of the release notes of the release, CVEs the
+	// CVEs they name and Removed the dependency lines they remove.
+	Notes   releaseNotes     `json:",omitempty"`
+	CVEs    []securityFix    `json:",omitempty"`
+	Removed []removedRuntime `json:",omitempty"`
+}
+
+// releaseOptions are what a releaseVerifier looks up on GitHub: verifyTags
+// links to the releases page when a release isn't found, includeNotes adds
+// the excerpt of the release notes, and countBehind counts the releases
+// since the version apps were staged with.
+type releaseOptions struct {
+	verifyTags   bool
+	includeNotes bool
+	countBehind  bool
+}
+
+// releaseVerifier checks that the release notes e-mails link to exist on
+// GitHub before linking to them, falling back to the releases page of the
+// buildpack otherwise, e.g. for versions built by the operators, and adds an
+// excerpt of the release notes and how far behind apps are to the e-mails.
+type releaseVerifier struct {
+	client *http.Client
+	api    string
+	token  string
+	logger *Logger
+	// links are the release notes pages of the buildpacks.
+	links buildpackLinks
+	releaseOptions
+	// cves looks up the severity of the CVEs the notes don't give one
+	// for, if set.
+	cves *cveEnricher
+
+	mu sync.Mutex
+	// records are kept in the state by buildpack and version, so that
+	// later runs only send conditional requests.
+	records map[string]releaseRecord
+	// verified are the releases already checked by this run.
+	verified map[string]bool
+	// tags are the versions released in each repository, newest first,
+	// listed once per run.
+	tags map[string][]string
+}
+
+func newReleaseVerifier(token string, links buildpackLinks, records map[string]releaseRecord, options releaseOptions, logger *Logger) *releaseVerifier {
+	if options == (releaseOptions{}) {
+		return nil
+	}
+	return &releaseVerifier{
+		client:         &http.Client{Timeout: 10 * time.Second},
+		api:            githubAPI,
+		token:          token,
+		logger:         logger,
+		links:          links,
+		releaseOptions: options,
+		records:        records,
+		verified:       make(map[string]bool),
+		tags:           make(map[string][]string),
+	}
+}
+
+// verifyApps verifies the release notes of the outdated buildpacks of apps,
+// recording the security fixes found in them in report.
+func (v *releaseVerifier) verifyApps(apps []appInfo, report *runReport) {
+	if v == nil {
+		return
+	}
+	for _, app := range apps {
+		for i, buildpack := range app.Buildpacks {
+			app.Buildpacks[i] = v.verify(buildpack)
+		}
+		report.recordOutdatedBuildpacks(app.App, app.Buildpacks)
+	}
+}
+
+// verify returns info linking to the releases page of the buildpack instead
+// of the release of its version when GitHub has no such release, or to the
+// tag the release was found under, along with the excerpt of the release
+// notes, the CVEs they name and the dependency lines they remove, and how
+// many releases behind the app is. Failures to ask GitHub leave the link
+// alone, without notes.
+func (v *releaseVerifier) verify(info buildpackReleaseInfo) buildpackReleaseInfo {
+	releasesURL := v.links.releaseURL(info.BuildpackName)
+	// Only releases on GitHub can be looked up, and only by version.
+	if !strings.HasPrefix(releasesURL, "https://github.com/") || parseVersion(info.BuildpackVersion) == nil {
+		return info
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.countBehind {
+		info.ReleasesBehind = v.releasesBehind(releasesURL, info)
+	}
+	if !v.verifyTags && !v.includeNotes {
+		return info
+	}
+	record, tag, err := v.lookupTag(info.BuildpackName, releasesURL, info.BuildpackVersion)
+	if err != nil {
+		v.logger.warnf("Unable to verify the release of buildpack %s %s on GitHub. Error: %s\n", info.BuildpackName, info.BuildpackVersion, err)
+		return info
+	}
+	if !record.Found {
+		if v.verifyTags {
+			v.logger.debugf("Buildpack %s %s has no release on GitHub, linking to its releases page\n", info.BuildpackName, info.BuildpackVersion)
+			info.BuildpackURL = releasesURL
+		}
+		return info
+	}
+	info.BuildpackURL = releasesURL + "/tag/" + tag
+	if v.includeNotes {
+		info.ReleaseNotes = record.Notes
+		info.SecurityFixes = v.cves.enrich(record.CVEs)
+		info.RemovedRuntimes = record.Removed
+	}
+	return info
+}
+
+// lookupTag returns the record of the release of version and the tag it was
+// found under. Tags don't always follow the file names of buildpacks, so the
+// version is also tried without its v prefix, or with one, when GitHub has
+// no release tagged version. v.mu must be held.
+func (v *releaseVerifier) lookupTag(name, releasesURL, version string) (releaseRecord, string, error) {
+	record, err := v.lookup(name+"@"+version, releasesURL, version)
+	if err != nil || record.Found {
+		return record, version, err
+	}
+	tag := "v" + version
+	if strings.HasPrefix(version, "v") {
+		tag = strings.TrimPrefix(version, "v")
+	}
+	alternate, err := v.lookup(name+"@"+tag, releasesURL, tag)
+	if err != nil || !alternate.Found {
+		return record, version, err
+	}
+	return alternate, tag, nil
+}
+
+// lookup returns the record of the release, asking GitHub once per run.
+// v.mu must be held.
+func (v *releaseVerifier) lookup(key, releasesURL, version string) (releaseRecord, error) {
+	record, cached := v.records[key]
+	if v.verified[key] {
+		return record, nil
+	}
+	req, err := v.newRequest("/repos/" + githubRepo(releasesURL) + "/releases/tags/" + url.PathEscape(version))
+	if err != nil {
+		return releaseRecord{}, err
+	}
+	// Releases recorded before their notes were kept are fetched again
+	// when the notes are wanted, since GitHub wouldn't send them along
+	// with a 304.
+	if cached && record.ETag != "" && !(v.includeNotes && record.Found && record.Notes == "") {
+		req.Header.Set("If-None-Match", record.ETag)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return releaseRecord{}, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+	case resp.StatusCode == http.StatusOK:
+		var release struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxReleaseBody)).Decode(&release); err != nil {
+			return releaseRecord{}, errors.Wrap(err, "Unable to parse release")
+		}
+		record = releaseRecord{
+			Found:   true,
+			ETag:    resp.Header.Get("ETag"),
+			Notes:   releaseNotesExcerpt(release.Body),
+			CVEs:    extractSecurityFixes(release.Body),
+			Removed: extractRemovedRuntimes(release.Body),
+		}
+	case resp.StatusCode == http.StatusNotFound:
+		record = releaseRecord{Found: false, ETag: resp.Header.Get("ETag")}
+	default:
+		return releaseRecord{}, errors.Errorf("GitHub responded with %s", resp.Status)
+	}
+	v.records[key] = record
+	v.verified[key] = true
+	return record, nil
+}
+
+// newRequest returns a request to the GitHub API for path.
+func (v *releaseVerifier) newRequest(path string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, v.api+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if v.token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.token)
+	}
+	return req, nil
+}
+
+// githubRepo returns the owner and name of the repository of a releases page
+// on GitHub.
+func githubRepo(releasesURL string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(releasesURL, "https://github.com/"), "/releases")
+}
+
+// maxReleasePages bounds how many pages of releases are listed to find the
+// version an app was staged with, after which it is too far behind to say.
+const maxReleasePages = 5
+
+// releasesBehind returns how many releases of the buildpack came out after
+// the version the app was staged with, up to the latest version, or 0 if it
+// can't tell. Failures to ask GitHub are logged. v.mu must be held.
+func (v *releaseVerifier) releasesBehind(releasesURL string, info buildpackReleaseInfo) int {
+	current, latest := parseVersion(info.CurrentVersion), parseVersion(info.BuildpackVersion)
+	if current == nil || latest == nil || compareVersions(current, latest) >= 0 {
+		return 0
+	}
+	repo := githubRepo(releasesURL)
+	tags, listed := v.tags[repo]
+	if !listed {
+		var err error
+		if tags, err = v.listTags(repo); err != nil {
+			v.logger.warnf("Unable to list the releases of buildpack %s on GitHub. Error: %s\n", info.BuildpackName, err)
+		}
+		v.tags[repo] = tags
+	}
+	behind := 0
+	for _, tag := range tags {
+		version := parseVersion(tag)
+		if version == nil {
+			continue
+		}
+		if compareVersions(version, current) <= 0 {
+			return behind
+		}
+		if compareVersions(version, latest) <= 0 {
+			behind++
+		}
+	}
+	// The releases listed don't go back far enough to tell.
+	return 0
+}
+
+// listTags lists the tags of the releases of repo, newest first, leaving out
+// drafts and pre-releases.
+func (v *releaseVerifier) listTags(repo string) ([]string, error) {
+	var tags []string
+	for page := 1; page <= maxReleasePages; page++ {
+		req, err := v.newRequest("/repos/" + repo + "/releases?per_page=100&page=" + strconv.Itoa(page))
+		if err != nil {
+			return tags, err
+		}
+		resp, err := v.client.Do(req)
+		if err != nil {
+			return tags, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return tags, errors.Errorf("GitHub responded with %s", resp.Status)
+		}
+		var releases []struct {
+			TagName    string `json:"tag_name"`
+			Draft      bool   `json:"draft"`
+			Prerelease bool   `json:"prerelease"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&releases)
+		resp.Body.Close()
+		if err != nil {
+			return tags, errors.Wrap(err, "Unable to parse releases")
+		}
+		for _, release := range releases {
+			if !release.Draft && !release.Prerelease {
+				tags = append(tags, release.TagName)
+			}
+		}
+		if len(releases) < 100 {
+			break
+		}
+	}
+	return tags, nil
+}
+
+// parseVersion parses the numbers of a version like v1.7.43 or 1.7.43, or
+// returns nil if it isn't one.
+func parseVersion(version string) []int {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return nil
+		}
+		numbers[i] = number
+	}
+	return numbers
+}
+
+// compareVersions returns -1, 0 or 1 as a is older than, the same as or newer
+// than b, missing numbers counting as 0.
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// maxReleaseBody bounds how much of a release is read.
+const maxReleaseBody = 1 << 20
+
+// maxReleaseNotes is how many changes of a release go into its excerpt.
+const maxReleaseNotes = 8
+
+// maxReleaseNoteLength is how long a change in the excerpt can be.
+const maxReleaseNoteLength = 120
+
+// releaseNotes is an excerpt of the release notes of a buildpack, one change
+// per line.
+type releaseNotes string
+
+// Lines returns the changes of the excerpt.
+func (n releaseNotes) Lines() []string {
+	if n == "" {
+		return nil
+	}
+	return strings.Split(string(n), "\n")
+}
+
+var (
+	htmlTagRe       = regexp.MustCompile(`<[^>]*>`)
+	markdownLinkRe  = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownStyleRe = regexp.MustCompile("\\*\\*|__|`")
+)
+
+// releaseNotesExcerpt picks the changes out of the body of a buildpack
+// release, such as the dependencies added or removed and the CVEs fixed,
+// leaving out the tables of packaged binaries and anything but plain text.
+// Nested items are only kept when they name a CVE.
+func releaseNotesExcerpt(body string) releaseNotes {
+	var changes []string
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		lower := strings.ToLower(trimmed)
+		if strings.HasPrefix(trimmed, "|") || strings.Contains(lower, "packaged binaries") || strings.Contains(lower, "default binary versions") {
+			break
+		}
+		if !strings.HasPrefix(trimmed, "* ") && !strings.HasPrefix(trimmed, "- ") {
+			continue
+		}
+		if line != strings.TrimLeft(line, " \t") && !strings.Contains(trimmed, "CVE-") {
+			continue
+		}
+		if change := sanitizeReleaseNote(trimmed[2:]); change != "" {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) > maxReleaseNotes {
+		more := len(changes) - maxReleaseNotes + 1
+		changes = append(changes[:maxReleaseNotes-1], fmt.Sprintf("and %d more changes", more))
+	}
+	return releaseNotes(strings.Join(changes, "\n"))
+}
+
+// sanitizeReleaseNote reduces a change to a single line of plain text.
+func sanitizeReleaseNote(change string) string {
+	change = htmlTagRe.ReplaceAllString(change, "")
+	change = markdownLinkRe.ReplaceAllString(change, "$1")
+	change = markdownStyleRe.ReplaceAllString(change, "")
+	change = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || r == '<' || r == '>' {
+			return ' '
+		}
+		return r
+	}, change)
+	change = strings.Join(strings.Fields(change), " ")
+	if runes := []rune(change); len(runes) > maxReleaseNoteLength {
+		change = strings.TrimSpace(string(runes[:maxReleaseNoteLength-3])) + "..."
+	}
+	return change
+}
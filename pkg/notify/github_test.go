@@ -0,0 +1,197 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReleaseVerifier(t *testing.T) {
+	requests := make(map[string]int)
+	conditional := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		switch r.URL.Path {
+		case "/repos/cloudfoundry/python-buildpack/releases/tags/v1.7.45":
+			if r.Header.Get("If-None-Match") == `"etag1"` {
+				conditional++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"etag1"`)
+			w.Write([]byte(`{"tag_name": "v1.7.45", "body": ""}`))
+		case "/repos/cloudfoundry/python-buildpack/releases/tags/v1.7.46":
+			w.Header().Set("ETag", `"etag2"`)
+			w.Write([]byte(`{"tag_name": "v1.7.46", "body": "* Add python 3.11.5\r\n* Remove python 3.11.4\r\n  - Fixes CVE-2023-40217 (Medium)\r\n* Python 3.7 removed"}`))
+		case "/repos/cloudfoundry/python-buildpack/releases/tags/v1.7.99", "/repos/cloudfoundry/python-buildpack/releases/tags/1.7.99", "/repos/cloudfoundry/python-buildpack/releases/tags/v1.7.47":
+			w.WriteHeader(http.StatusNotFound)
+		case "/repos/cloudfoundry/python-buildpack/releases/tags/1.7.47":
+			w.Write([]byte(`{"tag_name": "1.7.47", "body": "* Add python 3.12.0"}`))
+		default:
+			t.Errorf("Unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	released := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs3-v1.7.45.zip"})
+	unreleased := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs3-v1.7.99.zip"})
+	custom := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "custom_buildpack", Filename: "custom_buildpack-v1.0.0.zip"})
+
+	records := make(map[string]releaseRecord)
+	verifier := newReleaseVerifier("", defaultBuildpackLinks, records, releaseOptions{verifyTags: true}, nil)
+	verifier.api = ts.URL
+	apps := []appInfo{
+		{Buildpacks: []buildpackReleaseInfo{released, unreleased, custom}},
+		{Buildpacks: []buildpackReleaseInfo{released}},
+	}
+	verifier.verifyApps(apps, newRunReport())
+	if apps[0].Buildpacks[0].BuildpackURL != released.BuildpackURL || apps[1].Buildpacks[0].BuildpackURL != released.BuildpackURL {
+		t.Errorf("Expected the released version to keep linking to its release, found %+v", apps)
+	}
+	if url := apps[0].Buildpacks[1].BuildpackURL; url != "https://github.com/cloudfoundry/python-buildpack/releases" {
+		t.Errorf("Expected the unreleased version to link to the releases page, found %s", url)
+	}
+	if !reflect.DeepEqual(apps[0].Buildpacks[2], custom) {
+		t.Errorf("Expected buildpacks without releases on GitHub to be left alone, found %+v", apps[0].Buildpacks[2])
+	}
+	if requests["/repos/cloudfoundry/python-buildpack/releases/tags/v1.7.45"] != 1 {
+		t.Errorf("Expected the release to be looked up once per run, found %v", requests)
+	}
+	// Tags that don't follow the file name are found without the v prefix.
+	untagged := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs3-v1.7.47.zip"})
+	if url := verifier.verify(untagged).BuildpackURL; url != "https://github.com/cloudfoundry/python-buildpack/releases/tag/1.7.47" {
+		t.Errorf("Expected the release to link to the tag it was found under, found %s", url)
+	}
+
+	// A later run asks again with the ETag kept in the state.
+	verifier = newReleaseVerifier("", defaultBuildpackLinks, records, releaseOptions{verifyTags: true}, nil)
+	verifier.api = ts.URL
+	if info := verifier.verify(released); !reflect.DeepEqual(info, released) || conditional != 1 {
+		t.Errorf("Expected a conditional request keeping the release, found %+v after %d conditional requests", info, conditional)
+	}
+
+	// Only the notes are added when the tags aren't verified.
+	verifier = newReleaseVerifier("", defaultBuildpackLinks, records, releaseOptions{includeNotes: true}, nil)
+	verifier.api = ts.URL
+	noted := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs3-v1.7.46.zip"})
+	info := verifier.verify(noted)
+	if info.ReleaseNotes != "Add python 3.11.5\nRemove python 3.11.4\nFixes CVE-2023-40217 (Medium)\nPython 3.7 removed" || info.BuildpackURL != noted.BuildpackURL {
+		t.Errorf("Expected the release notes along with the release, found %+v", info)
+	}
+	if expected := []securityFix{{CVE: "CVE-2023-40217", Severity: "Medium"}}; !reflect.DeepEqual(info.SecurityFixes, expected) {
+		t.Errorf("Expected the security fixes of the release %+v, found %+v", expected, info.SecurityFixes)
+	}
+	if expected := []removedRuntime{{Name: "python", Version: "3.7"}}; !reflect.DeepEqual(info.RemovedRuntimes, expected) {
+		t.Errorf("Expected the runtimes removed by the release %+v, found %+v", expected, info.RemovedRuntimes)
+	}
+	if info := verifier.verify(unreleased); !reflect.DeepEqual(info, unreleased) {
+		t.Errorf("Expected the link to a missing release to be left alone, found %+v", info)
+	}
+	if records["python_buildpack@v1.7.46"].Notes == "" {
+		t.Errorf("Expected the release notes to be kept in the state, found %+v", records)
+	}
+	if newReleaseVerifier("", defaultBuildpackLinks, records, releaseOptions{}, nil) != nil {
+		t.Errorf("Expected no verifier when neither the tags nor the notes are wanted")
+	}
+}
+
+func TestReleaseNotesExcerpt(t *testing.T) {
+	body := `* Add node 18.17.1, remove node 18.17.0
+  - for stack(s): cflinuxfs4
+  - Including security fixes for: CVE-2023-32002, CVE-2023-32006
+* Bump **yarn** to ` + "`1.22.19`" + ` ([#512](https://github.com/cloudfoundry/nodejs-buildpack/pull/512))
+* <img src="x"> Fix <b>detection</b>	of engines
+
+Some paragraph that isn't a change.
+
+## Packaged binaries:
+
+| name | version | cf_stacks |
+|-|-|-|
+| node | 18.17.1 | cflinuxfs4 |
+* Not a change either`
+	expected := releaseNotes(`Add node 18.17.1, remove node 18.17.0
+Including security fixes for: CVE-2023-32002, CVE-2023-32006
+Bump yarn to 1.22.19 (#512)
+Fix detection of engines`)
+	if excerpt := releaseNotesExcerpt(body); excerpt != expected {
+		t.Errorf("Expected %q, found %q", expected, excerpt)
+	}
+	var long strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&long, "* Change %d %s\n", i, strings.Repeat("x", 200))
+	}
+	lines := releaseNotesExcerpt(long.String()).Lines()
+	if len(lines) != maxReleaseNotes || lines[len(lines)-1] != "and 3 more changes" {
+		t.Errorf("Expected the excerpt to be cut short, found %q", lines)
+	}
+	if len(lines[0]) != maxReleaseNoteLength || !strings.HasSuffix(lines[0], "...") {
+		t.Errorf("Expected long changes to be truncated, found %q", lines[0])
+	}
+	if releaseNotesExcerpt("").Lines() != nil {
+		t.Errorf("Expected no lines without notes")
+	}
+}
+
+func TestReleasesBehind(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/repos/cloudfoundry/python-buildpack/releases" {
+			t.Errorf("Unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`[
+			{"tag_name": "v1.7.47", "prerelease": true},
+			{"tag_name": "v1.7.46"},
+			{"tag_name": "v1.7.45"},
+			{"tag_name": "v1.7.44", "draft": true},
+			{"tag_name": "v1.7.43"},
+			{"tag_name": "v1.7.40"}
+		]`))
+	}))
+	defer ts.Close()
+	verifier := newReleaseVerifier("", defaultBuildpackLinks, map[string]releaseRecord{}, releaseOptions{countBehind: true}, nil)
+	verifier.api = ts.URL
+	info := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs3-v1.7.46.zip"})
+	for current, expected := range map[string]int{
+		"1.7.43":  2,
+		"v1.7.40": 3,
+		"1.7.41":  3,
+		"1.7.46":  0,
+		"1.7.30":  0,
+		"":        0,
+		"custom":  0,
+	} {
+		info.CurrentVersion = current
+		if behind := verifier.verify(info).ReleasesBehind; behind != expected {
+			t.Errorf("Expected %s to be %d releases behind, found %d", current, expected, behind)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected the releases to be listed once per run, found %d requests", requests)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		{"v1.7.43", "1.7.43", 0},
+		{"1.7.9", "1.7.10", -1},
+		{"1.8", "1.7.99", 1},
+		{"1.7", "1.7.0", 0},
+	} {
+		if compared := compareVersions(parseVersion(c.a), parseVersion(c.b)); compared != c.expected {
+			t.Errorf("Expected comparing %s to %s to be %d, found %d", c.a, c.b, c.expected, compared)
+		}
+	}
+	if parseVersion("v1.7.x") != nil {
+		t.Errorf("Expected versions that aren't numbers not to parse")
+	}
+}
@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	// Registers the pure Go SQLite driver, which needs no cgo.
+	_ "modernc.org/sqlite"
+)
+
+// runSnapshot is a run recorded in the history database, summing up how
+// compliant the foundation was at the end of the run.
+type runSnapshot struct {
+	RunID string
+	Time  string
+	// AppsChecked are the apps whose droplets were checked.
+	AppsChecked int
+	// OutdatedApps are the apps found outdated, including the apps held
+	// back by --limit, and OutdatedByBuildpack breaks them down by the
+	// buildpacks they are outdated on.
+	OutdatedApps        int
+	OutdatedByBuildpack map[string]int
+	// ResolvedApps are the apps restaged since their owners were first
+	// notified, and MeanTimeToRestageHours how long that took on average.
+	ResolvedApps           int
+	MeanTimeToRestageHours float64
+}
+
+// newRunSnapshot sums up the run from its report and the apps it found
+// restaged, whose notifications are recorded in history.
+func newRunSnapshot(runID string, report *runReport, restaged []appInfo, history map[string]appNotificationRecord, now time.Time) runSnapshot {
+	snapshot := runSnapshot{
+		RunID:               runID,
+		Time:                now.UTC().Format(time.RFC3339),
+		OutdatedByBuildpack: make(map[string]int),
+		ResolvedApps:        len(restaged),
+	}
+	for _, app := range report.appReports() {
+		if app.Droplet != "" {
+			snapshot.AppsChecked++
+		}
+		if app.Decision != decisionOutdated && app.Decision != decisionHeldBack {
+			continue
+		}
+		snapshot.OutdatedApps++
+		for _, buildpack := range app.Buildpacks {
+			if buildpack.Outdated {
+				snapshot.OutdatedByBuildpack[buildpack.Name]++
+			}
+		}
+	}
+	var total time.Duration
+	measured := 0
+	for _, app := range restaged {
+		record := history[app.GUID]
+		notifiedAt, err := time.Parse(time.RFC3339, record.FirstNotifiedAt)
+		if err != nil {
+			continue
+		}
+		resolvedAt, err := time.Parse(time.RFC3339, record.ResolvedAt)
+		if err != nil {
+			continue
+		}
+		total += resolvedAt.Sub(notifiedAt)
+		measured++
+	}
+	if measured > 0 {
+		snapshot.MeanTimeToRestageHours = (total / time.Duration(measured)).Hours()
+	}
+	return snapshot
+}
+
+// historySchema creates the tables of the history database: a row per run,
+// and a row per buildpack the apps of a run were outdated on.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	run_id                     TEXT PRIMARY KEY,
+	time                       TEXT NOT NULL,
+	apps_checked               INTEGER NOT NULL,
+	outdated_apps              INTEGER NOT NULL,
+	resolved_apps              INTEGER NOT NULL,
+	mean_time_to_restage_hours REAL NOT NULL
+);
+CREATE TABLE IF NOT EXISTS outdated_buildpacks (
+	run_id        TEXT NOT NULL REFERENCES runs (run_id) ON DELETE CASCADE,
+	buildpack     TEXT NOT NULL,
+	outdated_apps INTEGER NOT NULL,
+	PRIMARY KEY (run_id, buildpack)
+);
+CREATE INDEX IF NOT EXISTS runs_time ON runs (time);
+`
+
+// openHistory opens the SQLite history database at path, creating it and
+// its tables if needed.
+func openHistory(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// saveRunSnapshot records snapshot in the history database at path, creating
+// it if needed. Recording a run again, e.g. once it is resumed, replaces it.
+func saveRunSnapshot(path string, snapshot runSnapshot) error {
+	db, err := openHistory(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM runs WHERE run_id = ?`, snapshot.RunID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO runs (run_id, time, apps_checked, outdated_apps, resolved_apps, mean_time_to_restage_hours) VALUES (?, ?, ?, ?, ?, ?)`,
+		snapshot.RunID, snapshot.Time, snapshot.AppsChecked, snapshot.OutdatedApps, snapshot.ResolvedApps, snapshot.MeanTimeToRestageHours); err != nil {
+		return err
+	}
+	for buildpack, outdated := range snapshot.OutdatedByBuildpack {
+		if _, err := tx.Exec(`INSERT INTO outdated_buildpacks (run_id, buildpack, outdated_apps) VALUES (?, ?, ?)`, snapshot.RunID, buildpack, outdated); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadRunSnapshots reads the snapshots of the history database at path,
+// oldest first.
+func loadRunSnapshots(path string) ([]runSnapshot, error) {
+	// Opening a database that doesn't exist would create an empty one.
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := openHistory(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT run_id, time, apps_checked, outdated_apps, resolved_apps, mean_time_to_restage_hours FROM runs ORDER BY time, rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var snapshots []runSnapshot
+	byRunID := make(map[string]int)
+	for rows.Next() {
+		snapshot := runSnapshot{OutdatedByBuildpack: make(map[string]int)}
+		if err := rows.Scan(&snapshot.RunID, &snapshot.Time, &snapshot.AppsChecked, &snapshot.OutdatedApps, &snapshot.ResolvedApps, &snapshot.MeanTimeToRestageHours); err != nil {
+			return nil, errors.Wrap(err, "Invalid history")
+		}
+		byRunID[snapshot.RunID] = len(snapshots)
+		snapshots = append(snapshots, snapshot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows, err = db.Query(`SELECT run_id, buildpack, outdated_apps FROM outdated_buildpacks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var runID, buildpack string
+		var outdated int
+		if err := rows.Scan(&runID, &buildpack, &outdated); err != nil {
+			return nil, errors.Wrap(err, "Invalid history")
+		}
+		if i, ok := byRunID[runID]; ok {
+			snapshots[i].OutdatedByBuildpack[buildpack] = outdated
+		}
+	}
+	return snapshots, rows.Err()
+}
+
+// writeHistory writes the trend of the last runs of snapshots to w for
+// compliance reporting, a line per run along with how the outdated apps
+// changed since the run before.
+func writeHistory(w io.Writer, snapshots []runSnapshot, runs int) error {
+	start := 0
+	if runs > 0 && len(snapshots) > runs {
+		start = len(snapshots) - runs
+	}
+	if _, err := fmt.Fprintf(w, "%-20s  %-16s  %8s  %8s  %6s  %8s  %s\n", "TIME", "RUN", "CHECKED", "OUTDATED", "CHANGE", "RESOLVED", "MEAN TIME TO RESTAGE"); err != nil {
+		return err
+	}
+	for i := start; i < len(snapshots); i++ {
+		snapshot := snapshots[i]
+		change := "-"
+		if i > 0 {
+			change = fmt.Sprintf("%+d", snapshot.OutdatedApps-snapshots[i-1].OutdatedApps)
+		}
+		mttr := "-"
+		if snapshot.MeanTimeToRestageHours > 0 {
+			mttr = fmt.Sprintf("%.1f days", snapshot.MeanTimeToRestageHours/24)
+		}
+		if _, err := fmt.Fprintf(w, "%-20s  %-16s  %8d  %8d  %6s  %8d  %s\n", snapshot.Time, snapshot.RunID, snapshot.AppsChecked, snapshot.OutdatedApps, change, snapshot.ResolvedApps, mttr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
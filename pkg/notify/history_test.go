@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSnapshot(t *testing.T) {
+	report := newTestRunReport()
+	history := map[string]appNotificationRecord{
+		"app4": {FirstNotifiedAt: "2020-01-01T00:00:00Z", ResolvedAt: "2020-01-03T00:00:00Z"},
+		"app5": {FirstNotifiedAt: "2020-01-01T00:00:00Z", ResolvedAt: "2020-01-05T00:00:00Z"},
+	}
+	restaged := []appInfo{{App: App{GUID: "app4"}}, {App: App{GUID: "app5"}}}
+	snapshot := newRunSnapshot("run1", report, restaged, history, time.Date(2020, 1, 5, 0, 0, 0, 0, time.UTC))
+	if snapshot.AppsChecked != 2 || snapshot.OutdatedApps != 1 || snapshot.OutdatedByBuildpack["python_buildpack"] != 1 {
+		t.Errorf("Expected 1 of 2 apps checked to be outdated on python_buildpack, found %+v", snapshot)
+	}
+	if snapshot.ResolvedApps != 2 || snapshot.MeanTimeToRestageHours != 72 {
+		t.Errorf("Expected 2 apps restaged after 3 days on average, found %+v", snapshot)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	for i, outdated := range []int{12, 9, 10} {
+		snapshot := runSnapshot{
+			RunID:                  "run" + string(rune('1'+i)),
+			Time:                   time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			AppsChecked:            100,
+			OutdatedApps:           outdated,
+			ResolvedApps:           i,
+			MeanTimeToRestageHours: float64(i) * 36,
+		}
+		if err := saveRunSnapshot(path, snapshot); err != nil {
+			t.Fatalf("Unable to record snapshot. Error: %s", err)
+		}
+	}
+	// A resumed run replaces what it recorded before.
+	resumed := runSnapshot{RunID: "run3", Time: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC).Format(time.RFC3339), AppsChecked: 100, OutdatedApps: 10, OutdatedByBuildpack: map[string]int{"python_buildpack": 10}, ResolvedApps: 2, MeanTimeToRestageHours: 72}
+	if err := saveRunSnapshot(path, resumed); err != nil {
+		t.Fatalf("Unable to record snapshot. Error: %s", err)
+	}
+	snapshots, err := loadRunSnapshots(path)
+	if err != nil {
+		t.Fatalf("Unable to read history. Error: %s", err)
+	}
+	if len(snapshots) != 3 || !reflect.DeepEqual(snapshots[2], resumed) {
+		t.Errorf("Expected the resumed run to be recorded once, found %+v", snapshots)
+	}
+	if _, err := loadRunSnapshots(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Errorf("Expected reading a history that doesn't exist to fail")
+	}
+	var buf bytes.Buffer
+	if err := writeHistory(&buf, snapshots, 2); err != nil {
+		t.Fatalf("Unable to print history. Error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and the last 2 runs, found:\n%s", buf.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[1] != "run2" || fields[3] != "9" || fields[4] != "-3" || fields[6] != "1.5" {
+		t.Errorf("Expected run2 with 3 fewer outdated apps restaged in 1.5 days, found %q", lines[1])
+	}
+	if fields := strings.Fields(lines[2]); fields[1] != "run3" || fields[4] != "+1" {
+		t.Errorf("Expected run3 with 1 more outdated app, found %q", lines[2])
+	}
+}
@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// logLevel is how much a run logs. Each level logs its own lines and those of
+// every level above it.
+type logLevel int
+
+const (
+	// levelDebug adds a line for every app and user checked, which runs to
+	// tens of thousands of lines on large foundations.
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// parseLogLevel parses one of debug, info, warn or error.
+func parseLogLevel(raw string) (logLevel, error) {
+	level, found := logLevelNames[strings.ToLower(strings.TrimSpace(raw))]
+	if !found {
+		return levelInfo, errors.Errorf("Invalid log level %q, expected debug, info, warn or error", raw)
+	}
+	return level, nil
+}
+
+// logFormat is how the lines of a run are written.
+type logFormat int
+
+const (
+	// formatText writes lines for people to read.
+	formatText logFormat = iota
+	// formatJSON writes a JSON object per line, carrying the fields of the
+	// line as keys of their own for log aggregation to query.
+	formatJSON
+)
+
+var logFormatNames = map[string]logFormat{
+	"text": formatText,
+	"json": formatJSON,
+}
+
+// parseLogFormat parses one of text or json.
+func parseLogFormat(raw string) (logFormat, error) {
+	format, found := logFormatNames[strings.ToLower(strings.TrimSpace(raw))]
+	if !found {
+		return formatText, errors.Errorf("Invalid log format %q, expected text or json", raw)
+	}
+	return format, nil
+}
+
+// Logger writes the lines logged by a run at its level and in its format,
+// every line carrying the ID of the run once it has one. A nil Logger logs
+// nothing.
+type Logger struct {
+	out    io.Writer
+	level  logLevel
+	format logFormat
+	runID  string
+	// fields are the fields of every line, such as the ID of the run.
+	fields logFields
+	text   *log.Logger
+	json   *slog.Logger
+}
+
+// NewLogger returns a Logger writing to w at level, one of debug, info, warn
+// or error, in format, text or json. Empty level and format are info and
+// text.
+func NewLogger(w io.Writer, level, format string) (*Logger, error) {
+	parsedLevel, parsedFormat := levelInfo, formatText
+	var err error
+	if level != "" {
+		if parsedLevel, err = parseLogLevel(level); err != nil {
+			return nil, err
+		}
+	}
+	if format != "" {
+		if parsedFormat, err = parseLogFormat(format); err != nil {
+			return nil, err
+		}
+	}
+	return newLogger(w, parsedLevel, parsedFormat), nil
+}
+
+func newLogger(w io.Writer, level logLevel, format logFormat) *Logger {
+	l := &Logger{out: w, level: level, format: format}
+	return l.withOutput(w)
+}
+
+// withOutput returns a copy of l writing to w instead.
+func (l *Logger) withOutput(w io.Writer) *Logger {
+	c := *l
+	c.out = w
+	c.text = log.New(w, "", log.LstdFlags)
+	if c.runID != "" {
+		// Text lines carry the ID of the run as a prefix of their message.
+		c.text.SetPrefix("[run " + c.runID + "] ")
+		c.text.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	}
+	c.json = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	return &c
+}
+
+// WithRunID returns a copy of l whose lines carry the ID of the run, which
+// ties its logs, e-mails, audit records and metrics together.
+func (l *Logger) WithRunID(runID string) *Logger {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.runID = runID
+	c.fields = append(append(logFields(nil), l.fields...), "run_id", runID)
+	return c.withOutput(l.out)
+}
+
+// RunID returns the ID of the run the lines of l carry, if any.
+func (l *Logger) RunID() string {
+	if l == nil {
+		return ""
+	}
+	return l.runID
+}
+
+// Errorf logs a problem failing the run.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+// with returns a copy of l whose lines carry fields too, which only JSON
+// lines show since the text of the lines already names what they are about.
+func (l *Logger) with(fields logFields) *Logger {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	c.fields = append(append(logFields(nil), l.fields...), fields...)
+	return &c
+}
+
+// slogLevels are the slog levels JSON lines are written with.
+var slogLevels = map[logLevel]slog.Level{
+	levelDebug: slog.LevelDebug,
+	levelInfo:  slog.LevelInfo,
+	levelWarn:  slog.LevelWarn,
+	levelError: slog.LevelError,
+}
+
+func (l *Logger) logf(level logLevel, format string, args ...interface{}) {
+	if l == nil || level < l.level {
+		return
+	}
+	if l.format == formatText {
+		l.text.Printf(format, args...)
+		return
+	}
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	l.json.Log(context.Background(), slogLevels[level], message, l.fields...)
+}
+
+// debugf logs the details of individual apps, users and requests.
+func (l *Logger) debugf(format string, args ...interface{}) {
+	l.logf(levelDebug, format, args...)
+}
+
+// infof logs the progress of the run.
+func (l *Logger) infof(format string, args ...interface{}) {
+	l.logf(levelInfo, format, args...)
+}
+
+// warnf logs problems the run works around.
+func (l *Logger) warnf(format string, args ...interface{}) {
+	l.logf(levelWarn, format, args...)
+}
+
+// errorf logs the problems failing the run.
+func (l *Logger) errorf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+}
+
+// logFields are the fields of a line, as alternating keys and values, e.g.
+// app_guid, space_guid or buildpack.
+type logFields []any
+
+// appFields are the fields of a line about app.
+func appFields(app App) logFields {
+	return logFields{"app_guid", app.GUID, "app_name", app.Name, "space_guid", app.Relationships.Space.Data.GUID}
+}
+
+// with returns the fields with key set to value.
+func (f logFields) with(key string, value any) logFields {
+	return append(append(logFields(nil), f...), key, value)
+}
+
+// logFields are the fields of a line about app, along with its org and the
+// buildpacks it is outdated on.
+func (app appInfo) logFields() logFields {
+	fields := appFields(app.App)
+	if app.Org.GUID != "" {
+		fields = fields.with("org_guid", app.Org.GUID)
+	}
+	if len(app.Buildpacks) > 0 {
+		var buildpacks []string
+		for _, buildpack := range app.Buildpacks {
+			buildpacks = append(buildpacks, buildpack.BuildpackName)
+		}
+		fields = fields.with("buildpacks", buildpacks)
+	}
+	return fields
+}
+
+// buildpackFields are the fields of a line about buildpack.
+func buildpackFields(buildpack Buildpack) logFields {
+	return logFields{"buildpack", buildpack.Name, "buildpack_guid", buildpack.GUID}
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	testCases := []struct {
+		raw      string
+		expected logLevel
+		valid    bool
+	}{
+		{"debug", levelDebug, true},
+		{"info", levelInfo, true},
+		{" WARN ", levelWarn, true},
+		{"error", levelError, true},
+		{"verbose", levelInfo, false},
+	}
+	for _, tc := range testCases {
+		level, err := parseLogLevel(tc.raw)
+		if (err == nil) != tc.valid || level != tc.expected {
+			t.Errorf("Test %s failed. Expected level %d valid %t, found %d and error %v", tc.raw, tc.expected, tc.valid, level, err)
+		}
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "warn", "")
+	if err != nil {
+		t.Fatalf("Unable to create logger. Error: %s", err)
+	}
+	logger.debugf("debug\n")
+	logger.infof("info\n")
+	logger.warnf("warn\n")
+	logger.errorf("error\n")
+	if !bytes.Contains(buf.Bytes(), []byte("warn")) || !bytes.Contains(buf.Bytes(), []byte("error")) {
+		t.Errorf("Expected warnings and errors to be logged, found %q", buf.String())
+	}
+	if bytes.Contains(buf.Bytes(), []byte("debug")) || bytes.Contains(buf.Bytes(), []byte("info")) {
+		t.Errorf("Expected debug and info lines to be left out, found %q", buf.String())
+	}
+	if _, err := NewLogger(&buf, "verbose", ""); err == nil {
+		t.Errorf("Expected verbose to be an invalid log level")
+	}
+	// A nil Logger logs nothing.
+	var none *Logger
+	none.WithRunID("run1").errorf("error\n")
+}
+
+func TestJSONLogging(t *testing.T) {
+	var buf bytes.Buffer
+	format, err := parseLogFormat("JSON")
+	if err != nil {
+		t.Fatalf("Unable to parse log format. Error: %s", err)
+	}
+	logger := newLogger(&buf, levelInfo, format).WithRunID("run1")
+	app := newTestApp("app1", "space1")
+	app.Name = "api"
+	logger.with(appFields(app).with("buildpack", "python_buildpack")).infof("App %s Guid %s | Buildpack %s is outdated\n", app.Name, app.GUID, "python_buildpack")
+	logger.debugf("left out\n")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a single JSON line, found %q. Error: %s", buf.String(), err)
+	}
+	expected := map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "App api Guid app1 | Buildpack python_buildpack is outdated",
+		"run_id":     "run1",
+		"app_guid":   "app1",
+		"space_guid": "space1",
+		"buildpack":  "python_buildpack",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("Expected %s to be %v, found %v", key, value, line[key])
+		}
+	}
+	if _, err := parseLogFormat("logfmt"); err == nil {
+		t.Errorf("Expected logfmt to be an invalid log format")
+	}
+}
+
+func TestTextLoggingRunID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, levelInfo, formatText)
+	logger.WithRunID("run1").infof("Starting run.\n")
+	logger.infof("Done.\n")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " [run run1] Starting run.") || strings.Contains(lines[1], "run1") {
+		t.Errorf("Expected the lines of the run to carry its ID, found %q", lines)
+	}
+	if runID := logger.WithRunID("run1").RunID(); runID != "run1" {
+		t.Errorf("Expected the logger to carry the ID of the run, found %q", runID)
+	}
+}
+
+func TestSentEmailsAreLoggedAsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, levelInfo, formatJSON)
+	templates, err := initTemplates()
+	if err != nil {
+		t.Fatalf("Unable to initialize templates. Error: %s", err)
+	}
+	users := map[string][]appInfo{user1: newTestAppInfos([]App{newTestApp("app1", "space1")})}
+	errs := &runErrors{logger: logger}
+	sendNotifyEmailToUsers(users, templates, newMemoryMailer(), 1, false, nil, errs, logger)
+	if errs.count() != 0 {
+		t.Fatalf("Expected the e-mail to be sent, found %v", errs.messages())
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a single JSON line, found %q. Error: %s", buf.String(), err)
+	}
+	if line["msg"] != "Sent e-mail to "+user1 || line["recipient"] != user1 {
+		t.Errorf("Expected the e-mail sent to %s to be logged, found %v", user1, line)
+	}
+}
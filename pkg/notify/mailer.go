@@ -0,0 +1,321 @@
+package notify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// Mailer is a interface that any mailer should implement.
+type Mailer interface {
+	SendEmail(emailAddress string, subject string, body []byte) error
+}
+
+// runIDHeader is the header of every e-mail naming the run that sent it, so
+// that a question about an e-mail can be traced back to the run and its
+// decisions.
+const runIDHeader = "X-Notify-Run-Id"
+
+// runMailer is a Mailer that can name the run sending an e-mail in its
+// runIDHeader.
+type runMailer interface {
+	sendRunEmail(runID, emailAddress, subject string, body []byte) error
+}
+
+// runIDMailer sends the e-mails of the run with ID runID, naming it in every
+// e-mail.
+type runIDMailer struct {
+	mailer runMailer
+	runID  string
+}
+
+// withRunID returns a Mailer naming the run with ID runID in the e-mails it
+// sends through mailer, or mailer itself if it can't.
+func withRunID(mailer Mailer, runID string) Mailer {
+	if m, ok := mailer.(runMailer); ok {
+		return &runIDMailer{mailer: m, runID: runID}
+	}
+	return mailer
+}
+
+func (m *runIDMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return m.mailer.sendRunEmail(m.runID, emailAddress, subject, body)
+}
+
+// forEachOwner calls send with every user of users along with their apps,
+// from up to concurrency goroutines at a time. Each e-mail is sent over a
+// connection of its own, so sends don't share SMTP sessions.
+func forEachOwner(users map[string][]appInfo, concurrency int, send func(user string, apps []appInfo)) {
+	owners := make([]string, 0, len(users))
+	for user := range users {
+		owners = append(owners, user)
+	}
+	sort.Strings(owners)
+	forEachConcurrently(len(owners), concurrency, func(i int) {
+		send(owners[i], users[owners[i]])
+	})
+}
+
+// throttledMailer spaces the e-mails it sends at least interval apart, however
+// many are sent at a time, to stay under the rate the SMTP server accepts.
+type throttledMailer struct {
+	Mailer
+	interval time.Duration
+	sleep    func(time.Duration)
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newThrottledMailer(mailer Mailer, perSecond float64) *throttledMailer {
+	return &throttledMailer{Mailer: mailer, interval: time.Duration(float64(time.Second) / perSecond), sleep: time.Sleep}
+}
+
+func (m *throttledMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	m.mu.Lock()
+	now := time.Now()
+	wait := m.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	m.next = now.Add(wait + m.interval)
+	m.mu.Unlock()
+	if wait > 0 {
+		m.sleep(wait)
+	}
+	return m.Mailer.SendEmail(emailAddress, subject, body)
+}
+
+// connectionChecker is a Mailer that can check it reaches its server without
+// sending anything.
+type connectionChecker interface {
+	checkConnection() error
+}
+
+// smtpCheckTimeout bounds connecting to the SMTP server when checking it.
+const smtpCheckTimeout = 30 * time.Second
+
+// smtpSendTimeout bounds sending an e-mail through a proxy, from dialing the
+// proxy to the server accepting the e-mail.
+const smtpSendTimeout = 2 * time.Minute
+
+// InitSMTPMailer creates a new SMTP Mailer
+func InitSMTPMailer(config EmailConfig) (Mailer, error) {
+	dialer, err := newProxyDialer(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	var tlsConfig *tls.Config
+	if config.Cert != "" {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM([]byte(config.Cert))
+		tlsConfig = &tls.Config{
+			ServerName: config.Host,
+			RootCAs:    pool,
+		}
+
+	}
+	return &smtpMailer{
+		smtpHost:  config.Host,
+		smtpPort:  config.Port,
+		smtpUser:  config.User,
+		smtpPass:  config.Password,
+		smtpFrom:  config.From,
+		tlsConfig: tlsConfig,
+		dialer:    dialer,
+	}, nil
+}
+
+type smtpMailer struct {
+	smtpHost  string
+	smtpPort  string
+	smtpUser  string
+	smtpPass  string
+	smtpFrom  string
+	tlsConfig *tls.Config
+	// dialer connects through a proxy. Without one the server is dialed directly.
+	dialer proxy.Dialer
+}
+
+func (s *smtpMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return s.sendRunEmail("", emailAddress, subject, body)
+}
+
+// sendRunEmail sends the e-mail, naming the run with ID runID in it if there
+// is one.
+func (s *smtpMailer) sendRunEmail(runID, emailAddress, subject string, body []byte) error {
+	e := email.NewEmail()
+	e.From = "cloud.gov <" + s.smtpFrom + ">"
+	e.To = []string{" <" + emailAddress + ">"}
+	e.Text = body
+	e.Subject = subject
+	if runID != "" {
+		e.Headers.Set(runIDHeader, runID)
+	}
+
+	addr := s.smtpHost + ":" + s.smtpPort
+	auth := s.auth()
+
+	if s.dialer != nil {
+		return s.sendThroughProxy(e, addr, auth)
+	}
+	if s.tlsConfig != nil {
+		return e.SendWithTLS(addr, auth, s.tlsConfig)
+	}
+	return e.Send(addr, auth)
+}
+
+// auth returns the credentials to authenticate with, or nil without an
+// SMTP_USER, e.g. for MailHog, which takes any e-mail without them.
+func (s *smtpMailer) auth() smtp.Auth {
+	if s.smtpUser == "" {
+		return nil
+	}
+	return smtp.PlainAuth("", s.smtpUser, s.smtpPass, s.smtpHost)
+}
+
+// sendThroughProxy sends e the same way e.Send and e.SendWithTLS do, but over
+// a connection made through the proxy.
+func (s *smtpMailer) sendThroughProxy(e *email.Email, addr string, auth smtp.Auth) error {
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(e.To[0])
+	if err != nil {
+		return err
+	}
+	raw, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+	conn, err := dialSMTP(s.dialer, addr, smtpSendTimeout)
+	if err != nil {
+		return errors.Wrap(err, "Unable to connect to SMTP server through proxy")
+	}
+	if s.tlsConfig != nil {
+		conn = tls.Client(conn, s.tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, s.smtpHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if s.tlsConfig == nil {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: s.smtpHost}); err != nil {
+				return err
+			}
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// checkConnection connects to the SMTP server, greets it and authenticates the
+// way sending an e-mail does, then hangs up without sending one.
+func (s *smtpMailer) checkConnection() error {
+	addr := s.smtpHost + ":" + s.smtpPort
+	conn, err := dialSMTP(s.dialer, addr, smtpCheckTimeout)
+	if err != nil {
+		return errors.Wrap(err, "Unable to connect")
+	}
+	if s.tlsConfig != nil {
+		conn = tls.Client(conn, s.tlsConfig)
+	}
+	c, err := smtp.NewClient(conn, s.smtpHost)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "Unable to connect")
+	}
+	defer c.Close()
+	if err := c.Hello("localhost"); err != nil {
+		return errors.Wrap(err, "EHLO was rejected")
+	}
+	if s.tlsConfig == nil {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: s.smtpHost}); err != nil {
+				return errors.Wrap(err, "Unable to start TLS")
+			}
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && s.smtpUser != "" {
+		if err := c.Auth(s.auth()); err != nil {
+			return errors.Wrap(err, "The credentials were rejected")
+		}
+	}
+	return c.Quit()
+}
+
+// dirMailer writes every e-mail to a file in a directory instead of sending
+// it, so that simulated runs can be reviewed.
+type dirMailer struct {
+	dir string
+
+	// mu guards sent, since e-mails are sent from up to EMAIL_CONCURRENCY
+	// goroutines at a time.
+	mu   sync.Mutex
+	sent int
+}
+
+func newDirMailer(dir string) (*dirMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Unable to create e-mail directory")
+	}
+	return &dirMailer{dir: dir}, nil
+}
+
+// SendEmail writes the e-mail to a file named after the order it was sent in
+// and its recipient.
+func (m *dirMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return m.sendRunEmail("", emailAddress, subject, body)
+}
+
+// sendRunEmail writes the e-mail, naming the run with ID runID in its headers
+// if there is one.
+func (m *dirMailer) sendRunEmail(runID, emailAddress, subject string, body []byte) error {
+	m.mu.Lock()
+	m.sent++
+	sent := m.sent
+	m.mu.Unlock()
+	path := filepath.Join(m.dir, fmt.Sprintf("%04d-%s.txt", sent, filepath.Base(emailAddress)))
+	headers := fmt.Sprintf("To: %s\nSubject: %s\n", emailAddress, subject)
+	if runID != "" {
+		headers += fmt.Sprintf("%s: %s\n", runIDHeader, runID)
+	}
+	content := headers + "\n" + string(body)
+	return ioutil.WriteFile(path, []byte(content), 0644)
+}
@@ -0,0 +1,271 @@
+package notify
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-gov/buildpack-notify/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// sentEmail is an e-mail kept by a memoryMailer.
+type sentEmail struct {
+	To      string
+	Subject string
+	Body    string
+	// RunID is the run the e-mail would have named in its headers.
+	RunID string
+}
+
+// memoryMailer keeps every e-mail in memory instead of sending it, so that
+// tests can check who was sent what. It is safe to send from many goroutines
+// at a time, as runs do.
+type memoryMailer struct {
+	mu   sync.Mutex
+	sent []sentEmail
+	// failing are the addresses whose e-mails fail to send.
+	failing map[string]bool
+}
+
+func newMemoryMailer() *memoryMailer {
+	return &memoryMailer{}
+}
+
+func (m *memoryMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	return m.sendRunEmail("", emailAddress, subject, body)
+}
+
+func (m *memoryMailer) sendRunEmail(runID, emailAddress, subject string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failing[emailAddress] {
+		return fmt.Errorf("mailbox %s unavailable", emailAddress)
+	}
+	m.sent = append(m.sent, sentEmail{To: emailAddress, Subject: subject, Body: string(body), RunID: runID})
+	return nil
+}
+
+// emails returns the e-mails sent so far, in the order they were sent.
+func (m *memoryMailer) emails() []sentEmail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentEmail(nil), m.sent...)
+}
+
+// emailsTo returns the e-mails sent so far to emailAddress.
+func (m *memoryMailer) emailsTo(emailAddress string) []sentEmail {
+	var sent []sentEmail
+	for _, email := range m.emails() {
+		if email.To == emailAddress {
+			sent = append(sent, email)
+		}
+	}
+	return sent
+}
+
+// serveTestSMTP answers a single SMTP session on listener, accepting the
+// credentials only if acceptAuth is set, and hands every message sent to
+// received, if given.
+func serveTestSMTP(listener net.Listener, acceptAuth bool, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("220 smtp.example.com ESMTP\r\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "EHLO":
+			conn.Write([]byte("250-smtp.example.com\r\n250 AUTH PLAIN\r\n"))
+		case "AUTH":
+			if acceptAuth {
+				conn.Write([]byte("235 Authenticated\r\n"))
+			} else {
+				conn.Write([]byte("535 Authentication failed\r\n"))
+			}
+		case "MAIL", "RCPT":
+			conn.Write([]byte("250 OK\r\n"))
+		case "DATA":
+			conn.Write([]byte("354 End data with <CR><LF>.<CR><LF>\r\n"))
+			var message strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			if received != nil {
+				received <- message.String()
+			}
+			conn.Write([]byte("250 Queued\r\n"))
+		case "QUIT":
+			conn.Write([]byte("221 Bye\r\n"))
+			return
+		default:
+			conn.Write([]byte("502 Not implemented\r\n"))
+		}
+	}
+}
+
+func TestSMTPMailerCheckConnection(t *testing.T) {
+	testCases := []struct {
+		name       string
+		acceptAuth bool
+		valid      bool
+	}{
+		{"accepted credentials", true, true},
+		{"rejected credentials", false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Unable to listen. Error: %s", err)
+			}
+			defer listener.Close()
+			go serveTestSMTP(listener, tc.acceptAuth, nil)
+			_, port, _ := net.SplitHostPort(listener.Addr().String())
+			mailer := &smtpMailer{smtpHost: "127.0.0.1", smtpPort: port, smtpUser: "user", smtpPass: "password"}
+			err = mailer.checkConnection()
+			if (err == nil) != tc.valid {
+				t.Errorf("Test %s failed. Expected valid %t, found error %v", tc.name, tc.valid, err)
+			}
+		})
+	}
+}
+
+// TestSMTPMailerWithoutCredentials sends the way it does to MailHog, which
+// takes e-mails without credentials.
+func TestSMTPMailerWithoutCredentials(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen. Error: %s", err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	// The server would reject any credentials, so sending only works if
+	// none are given.
+	go serveTestSMTP(listener, false, received)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	mailer := &smtpMailer{smtpHost: "127.0.0.1", smtpPort: port, smtpFrom: "no-reply@example.com"}
+	if err := withRunID(mailer, "run-1").SendEmail("user@example.com", "Action required", []byte("Restage your app.")); err != nil {
+		t.Fatalf("Unable to send e-mail without credentials. Error: %s", err)
+	}
+	message := <-received
+	for _, expected := range []string{"<user@example.com>", "From: cloud.gov <no-reply@example.com>", "Subject: Action required", runIDHeader + ": run-1", "Content-Type: text/plain", "Restage your app."} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("Expected the message to contain %q, found\n%s", expected, message)
+		}
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	memory := newMemoryMailer()
+	mailer := withRunID(memory, "run-1")
+	var wg sync.WaitGroup
+	for _, user := range []string{"a@example.com", "b@example.com", "a@example.com"} {
+		wg.Add(1)
+		go func(user string) {
+			defer wg.Done()
+			mailer.SendEmail(user, "Action required", []byte("Restage your app."))
+		}(user)
+	}
+	wg.Wait()
+	if sent := memory.emails(); len(sent) != 3 {
+		t.Errorf("Expected 3 e-mails, found %+v", sent)
+	}
+	expected := sentEmail{To: "b@example.com", Subject: "Action required", Body: "Restage your app.", RunID: "run-1"}
+	if sent := memory.emailsTo("b@example.com"); len(sent) != 1 || sent[0] != expected {
+		t.Errorf("Expected %+v, found %+v", expected, sent)
+	}
+	if sent := memory.emailsTo("a@example.com"); len(sent) != 2 {
+		t.Errorf("Expected 2 e-mails to a@example.com, found %+v", sent)
+	}
+}
+
+func TestDirMailerRunID(t *testing.T) {
+	mailer, err := newDirMailer(t.TempDir())
+	if err != nil {
+		t.Fatalf("Unable to create mailer. Error: %s", err)
+	}
+	if err := withRunID(mailer, "run1").SendEmail("dev@example.gov", "Outdated buildpacks", []byte("Please restage.\n")); err != nil {
+		t.Fatalf("Unable to send e-mail. Error: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(mailer.dir, "0001-dev@example.gov.txt"))
+	if err != nil {
+		t.Fatalf("Unable to read e-mail. Error: %s", err)
+	}
+	expected := "To: dev@example.gov\nSubject: Outdated buildpacks\nX-Notify-Run-Id: run1\n\nPlease restage.\n"
+	if string(content) != expected {
+		t.Errorf("Expected e-mail\n%s\nfound\n%s", expected, content)
+	}
+}
+
+func TestForEachOwner(t *testing.T) {
+	users := make(map[string][]appInfo)
+	for _, user := range []string{user1, user2, "user3@example.com", "user4@example.com", "user5@example.com"} {
+		users[user] = newTestAppInfos([]App{{GUID: "app-" + user}})
+	}
+	var mu sync.Mutex
+	sent := make(map[string]int)
+	forEachOwner(users, 4, func(user string, apps []appInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(apps) != 1 || apps[0].GUID != "app-"+user {
+			t.Errorf("Expected %s to be sent their own apps, found %+v", user, apps)
+		}
+		sent[user]++
+	})
+	if len(sent) != len(users) {
+		t.Errorf("Expected every owner to be sent an e-mail, found %v", sent)
+	}
+	for user, count := range sent {
+		if count != 1 {
+			t.Errorf("Expected %s to be sent one e-mail, found %d", user, count)
+		}
+	}
+}
+
+func TestThrottledMailer(t *testing.T) {
+	mockMailer := new(mocks.Mailer)
+	mockMailer.On("SendEmail", mock.Anything, "subject", mock.Anything).Return(nil)
+	mailer := newThrottledMailer(mockMailer, 2)
+	var mu sync.Mutex
+	var waited time.Duration
+	mailer.sleep = func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		waited += d
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mailer.SendEmail(user1, "subject", nil)
+		}()
+	}
+	wg.Wait()
+	mockMailer.AssertNumberOfCalls(t, "SendEmail", 3)
+	// The second e-mail waits about half a second and the third about a
+	// second, since sleeping is faked.
+	if waited < 1300*time.Millisecond || waited > 1500*time.Millisecond {
+		t.Errorf("Expected the e-mails to wait 1.5s in total, found %s", waited)
+	}
+}
@@ -0,0 +1,228 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runMetrics are the metrics of a run, published once it is over.
+type runMetrics struct {
+	RunID string
+	// Decisions counts the apps evaluated by what was decided about them.
+	Decisions map[appDecision]int
+	// OutdatedByBuildpack counts the outdated apps by the buildpack they are
+	// outdated on, including the apps held back by --limit.
+	OutdatedByBuildpack map[string]int
+	// OutdatedByOrg counts the outdated apps by org, and
+	// OutdatedByOrgBuildpack by org and buildpack, for patch compliance
+	// across the foundation.
+	OutdatedByOrg          map[string]int
+	OutdatedByOrgBuildpack map[orgBuildpack]int
+	// OwnersNotified are the distinct owners of the apps notified about.
+	OwnersNotified int
+	EmailsSent     int
+	EmailsFailed   int
+	CFAPIRequests  int
+	Phases         []phaseTiming
+	Duration       time.Duration
+	FinishedAt     time.Time
+}
+
+// orgBuildpack is a buildpack used in an org.
+type orgBuildpack struct {
+	org, buildpack string
+}
+
+// collectRunMetrics gathers the metrics of a run from its report and errors.
+func collectRunMetrics(runID string, report *runReport, errs *runErrors, cfAPIRequests int, duration time.Duration, now time.Time) runMetrics {
+	m := runMetrics{
+		RunID:                  runID,
+		Decisions:              make(map[appDecision]int),
+		OutdatedByBuildpack:    make(map[string]int),
+		OutdatedByOrg:          make(map[string]int),
+		OutdatedByOrgBuildpack: make(map[orgBuildpack]int),
+		CFAPIRequests:          cfAPIRequests,
+		Phases:                 report.phaseTimings(),
+		Duration:               duration,
+		FinishedAt:             now,
+	}
+	owners := make(map[string]bool)
+	for _, app := range report.appReports() {
+		m.Decisions[app.Decision]++
+		for _, owner := range app.Owners {
+			owners[owner] = true
+		}
+		if app.Decision != decisionOutdated && app.Decision != decisionHeldBack {
+			continue
+		}
+		m.OutdatedByOrg[app.Org]++
+		for _, buildpack := range app.Buildpacks {
+			if buildpack.Outdated {
+				m.OutdatedByBuildpack[buildpack.Name]++
+				m.OutdatedByOrgBuildpack[orgBuildpack{org: app.Org, buildpack: buildpack.Name}]++
+			}
+		}
+	}
+	m.OwnersNotified = len(owners)
+	errs.mu.Lock()
+	m.EmailsSent, m.EmailsFailed = errs.sent, errs.sendFailures
+	errs.mu.Unlock()
+	return m
+}
+
+// writeText writes the metrics in the Prometheus text exposition format.
+func (m runMetrics) writeText(w io.Writer) error {
+	var b bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP buildpack_notify_%s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE buildpack_notify_%s gauge\n", name)
+	}
+	gauge("run_info", "The ID of the last run, which its logs, e-mails and audit records carry too.")
+	fmt.Fprintf(&b, "buildpack_notify_run_info{run_id=%q} 1\n", m.RunID)
+	gauge("apps_evaluated", "Apps evaluated by the last run, by what was decided about them.")
+	for _, decision := range appDecisions {
+		if m.Decisions[decision] > 0 {
+			fmt.Fprintf(&b, "buildpack_notify_apps_evaluated{decision=%q} %d\n", decision, m.Decisions[decision])
+		}
+	}
+	gauge("outdated_apps", "Outdated apps found by the last run, by buildpack.")
+	var buildpacks []string
+	for name := range m.OutdatedByBuildpack {
+		buildpacks = append(buildpacks, name)
+	}
+	sort.Strings(buildpacks)
+	for _, name := range buildpacks {
+		fmt.Fprintf(&b, "buildpack_notify_outdated_apps{buildpack=%q} %d\n", name, m.OutdatedByBuildpack[name])
+	}
+	gauge("outdated_apps_by_org", "Outdated apps found by the last run, by org.")
+	var orgs []string
+	for org := range m.OutdatedByOrg {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+	for _, org := range orgs {
+		fmt.Fprintf(&b, "buildpack_notify_outdated_apps_by_org{org=%q} %d\n", org, m.OutdatedByOrg[org])
+	}
+	gauge("outdated_apps_by_org_buildpack", "Outdated apps found by the last run, by org and buildpack.")
+	var pairs []orgBuildpack
+	for pair := range m.OutdatedByOrgBuildpack {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].org != pairs[j].org {
+			return pairs[i].org < pairs[j].org
+		}
+		return pairs[i].buildpack < pairs[j].buildpack
+	})
+	for _, pair := range pairs {
+		fmt.Fprintf(&b, "buildpack_notify_outdated_apps_by_org_buildpack{org=%q,buildpack=%q} %d\n", pair.org, pair.buildpack, m.OutdatedByOrgBuildpack[pair])
+	}
+	gauge("owners_notified", "Owners of the apps the last run notified about.")
+	fmt.Fprintf(&b, "buildpack_notify_owners_notified %d\n", m.OwnersNotified)
+	gauge("emails_sent", "E-mails the last run sent.")
+	fmt.Fprintf(&b, "buildpack_notify_emails_sent %d\n", m.EmailsSent)
+	gauge("emails_failed", "E-mails the last run failed to send.")
+	fmt.Fprintf(&b, "buildpack_notify_emails_failed %d\n", m.EmailsFailed)
+	gauge("cf_api_requests", "Requests the last run sent to the CF API, including retries.")
+	fmt.Fprintf(&b, "buildpack_notify_cf_api_requests %d\n", m.CFAPIRequests)
+	gauge("phase_duration_seconds", "How long each phase of the last run took.")
+	for _, phase := range m.Phases {
+		fmt.Fprintf(&b, "buildpack_notify_phase_duration_seconds{phase=%q} %g\n", phase.Name, phase.Seconds)
+	}
+	gauge("phase_cf_api_requests", "Requests each phase of the last run sent to the CF API, including retries.")
+	for _, phase := range m.Phases {
+		fmt.Fprintf(&b, "buildpack_notify_phase_cf_api_requests{phase=%q} %d\n", phase.Name, phase.CFAPIRequests)
+	}
+	gauge("run_duration_seconds", "How long the last run took.")
+	fmt.Fprintf(&b, "buildpack_notify_run_duration_seconds %g\n", m.Duration.Seconds())
+	gauge("last_run_timestamp_seconds", "When the last run finished, in seconds since the epoch.")
+	fmt.Fprintf(&b, "buildpack_notify_last_run_timestamp_seconds %d\n", m.FinishedAt.Unix())
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// metricsPublisher publishes the metrics of a run once it is over. Batch runs
+// push them to a Pushgateway, while a long running process can serve the
+// latest ones on /metrics with a metricsHandler.
+type metricsPublisher interface {
+	publish(m runMetrics) error
+}
+
+// pushgateway pushes the metrics of every run to a Prometheus Pushgateway,
+// replacing those of the previous run of the job.
+type pushgateway struct {
+	url    string
+	client *http.Client
+}
+
+// newPushgateway returns the publisher pushing to the Pushgateway at address,
+// grouping the metrics under job.
+func newPushgateway(address, job string) (*pushgateway, error) {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("Invalid Pushgateway URL %q", address)
+	}
+	if job == "" {
+		return nil, errors.New("A job name is required to push metrics")
+	}
+	return &pushgateway{
+		url:    strings.TrimSuffix(address, "/") + "/metrics/job/" + url.PathEscape(job),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (p *pushgateway) publish(m runMetrics) error {
+	var body bytes.Buffer
+	if err := m.writeText(&body); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, p.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("Pushgateway responded with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// metricsHandler serves the metrics of the latest run published to it in the
+// Prometheus text format.
+type metricsHandler struct {
+	mu     sync.Mutex
+	latest *runMetrics
+}
+
+func (h *metricsHandler) publish(m runMetrics) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest = &m
+	return nil
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	latest := h.latest
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if latest != nil {
+		latest.writeText(w)
+	}
+}
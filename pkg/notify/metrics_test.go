@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushMetrics(t *testing.T) {
+	errs := &runErrors{sent: 2, sendFailures: 1}
+	metrics := collectRunMetrics("run1", newTestRunReport(), errs, 42, 90*time.Second, time.Unix(1600000000, 0))
+
+	var pushed, path, method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		pushed, path, method = string(body), r.URL.Path, r.Method
+	}))
+	defer ts.Close()
+	pushgateway, err := newPushgateway(ts.URL+"/", "buildpack_notify")
+	if err != nil {
+		t.Fatalf("Unable to create pushgateway. Error: %s", err)
+	}
+	if err := pushgateway.publish(metrics); err != nil {
+		t.Fatalf("Unable to push metrics. Error: %s", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/buildpack_notify" {
+		t.Errorf("Expected the metrics to replace those of the job, found %s %s", method, path)
+	}
+	for _, expected := range []string{
+		`buildpack_notify_run_info{run_id="run1"} 1`,
+		`buildpack_notify_apps_evaluated{decision="not_started"} 1`,
+		`buildpack_notify_apps_evaluated{decision="outdated"} 1`,
+		`buildpack_notify_outdated_apps{buildpack="python_buildpack"} 1`,
+		`buildpack_notify_outdated_apps_by_org{org="agency"} 1`,
+		`buildpack_notify_outdated_apps_by_org_buildpack{org="agency",buildpack="python_buildpack"} 1`,
+		"buildpack_notify_owners_notified 2\n",
+		"buildpack_notify_emails_sent 2\n",
+		"buildpack_notify_emails_failed 1\n",
+		"buildpack_notify_cf_api_requests 42\n",
+		`buildpack_notify_phase_duration_seconds{phase="list apps"} 1.5`,
+		`buildpack_notify_phase_cf_api_requests{phase="find outdated apps"} 2`,
+		"buildpack_notify_run_duration_seconds 90\n",
+		"buildpack_notify_last_run_timestamp_seconds 1600000000\n",
+	} {
+		if !strings.Contains(pushed, expected) {
+			t.Errorf("Expected the pushed metrics to contain %q, found:\n%s", expected, pushed)
+		}
+	}
+
+	// A long running process serves the same metrics.
+	handler := &metricsHandler{}
+	handler.publish(metrics)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if recorder.Body.String() != pushed {
+		t.Errorf("Expected /metrics to serve the pushed metrics, found:\n%s", recorder.Body.String())
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid metric", http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	pushgateway, _ = newPushgateway(failing.URL, "buildpack_notify")
+	if err := pushgateway.publish(metrics); err == nil || !strings.Contains(err.Error(), "invalid metric") {
+		t.Errorf("Expected the push to fail with the Pushgateway's message, found %v", err)
+	}
+	if _, err := newPushgateway("pushgateway:9091", "buildpack_notify"); err == nil {
+		t.Errorf("Expected a URL without a scheme to be invalid")
+	}
+}
@@ -0,0 +1,204 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// The notifications recorded in the notification audit log.
+const (
+	notificationOutdated            = "outdated_buildpack"
+	notificationEscalation          = "escalation"
+	notificationRestageWarning      = "restage_warning"
+	notificationRestageConfirmation = "restage_confirmation"
+	notificationPinnedBuildpack     = "pinned_buildpack"
+	notificationCampaign            = "campaign"
+	notificationStackEOL            = "stack_eol"
+)
+
+// The results recorded in the notification audit log.
+const (
+	notificationSent        = "sent"
+	notificationFailed      = "failed"
+	notificationDryRun      = "dry_run"
+	notificationNotRendered = "not_rendered"
+	notificationHeldBack    = "held_back"
+)
+
+// notificationAuditRecord is a line of the notification audit log, recording
+// what a user was told about an app and a buildpack, and when.
+type notificationAuditRecord struct {
+	Time             string `json:"time"`
+	RunID            string `json:"run_id"`
+	Recipient        string `json:"recipient,omitempty"`
+	Action           string `json:"action"`
+	Result           string `json:"result"`
+	AppGUID          string `json:"app_guid"`
+	AppName          string `json:"app_name"`
+	Org              string `json:"org,omitempty"`
+	Space            string `json:"space,omitempty"`
+	Buildpack        string `json:"buildpack,omitempty"`
+	BuildpackVersion string `json:"buildpack_version,omitempty"`
+	Stack            string `json:"stack,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// notificationAuditLog records every notification decided on and sent as a
+// line of JSON, apart from the logs of the run, to prove who was told what and
+// when. A nil log records nothing.
+type notificationAuditLog struct {
+	mu    sync.Mutex
+	w     io.WriteCloser
+	runID string
+	now   func() time.Time
+}
+
+// openNotificationAuditLog opens the audit log at target, a file appended to
+// across runs, or an s3://bucket/prefix URL where each run uploads an object
+// named after it once it is over.
+func openNotificationAuditLog(target, runID string) (*notificationAuditLog, error) {
+	var w io.WriteCloser
+	if strings.HasPrefix(target, "s3://") {
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(target, "s3://"), "/")
+		if bucket == "" {
+			return nil, errors.Errorf("Invalid S3 URL %q, expected s3://bucket/prefix", target)
+		}
+		config, err := loadAWSConfig()
+		if err != nil {
+			return nil, err
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		w = &s3Object{client: newS3Client(config), bucket: bucket, key: prefix + runID + ".jsonl"}
+	} else {
+		f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &notificationAuditLog{w: w, runID: runID, now: time.Now}, nil
+}
+
+// close flushes the log, uploading it when it goes to S3. Closing it again
+// does nothing.
+func (l *notificationAuditLog) close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return nil
+	}
+	err := l.w.Close()
+	l.w = nil
+	return err
+}
+
+// recordSend records the result of sending the e-mail about apps to user,
+// which failed with sendErr if it isn't nil.
+func (l *notificationAuditLog) recordSend(user string, apps []appInfo, action string, dryRun bool, sendErr error, errs *runErrors) {
+	result := notificationSent
+	switch {
+	case sendErr != nil:
+		result = notificationFailed
+	case dryRun:
+		result = notificationDryRun
+	}
+	l.record(user, apps, action, result, sendErr, errs)
+}
+
+// record records a line for each app and buildpack user was, or wasn't,
+// notified about.
+func (l *notificationAuditLog) record(user string, apps []appInfo, action, result string, cause error, errs *runErrors) {
+	if l == nil {
+		return
+	}
+	now := l.now().UTC().Format(time.RFC3339)
+	var lines bytes.Buffer
+	for _, app := range apps {
+		base := notificationAuditRecord{
+			Time:      now,
+			RunID:     l.runID,
+			Recipient: user,
+			Action:    action,
+			Result:    result,
+			AppGUID:   app.GUID,
+			AppName:   app.Name,
+			Org:       app.Org.Name,
+			Space:     app.Space.Name,
+			Stack:     app.Stack,
+		}
+		if cause != nil {
+			base.Error = cause.Error()
+		}
+		records := []notificationAuditRecord{base}
+		if len(app.Buildpacks) > 0 || len(app.GitBuildpacks) > 0 {
+			records = nil
+		}
+		for _, buildpack := range app.Buildpacks {
+			record := base
+			record.Buildpack, record.BuildpackVersion = buildpack.BuildpackName, buildpack.BuildpackVersion
+			records = append(records, record)
+		}
+		for _, buildpack := range app.GitBuildpacks {
+			record := base
+			record.Buildpack, record.BuildpackVersion = buildpack.URL, buildpack.Ref
+			records = append(records, record)
+		}
+		for _, record := range records {
+			line, err := json.Marshal(record)
+			if err != nil {
+				errs.addf("Unable to record the notification about app %s guid %s in the audit log. Error: %s", app.Name, app.GUID, err)
+				continue
+			}
+			lines.Write(append(line, '\n'))
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return
+	}
+	if _, err := l.w.Write(lines.Bytes()); err != nil {
+		errs.addf("Unable to record the notification to %s in the audit log. Error: %s", user, err)
+	}
+}
+
+// s3Object buffers what is written to it and uploads it to S3 on Close,
+// since objects can't be appended to.
+type s3Object struct {
+	client *s3.Client
+	bucket string
+	key    string
+	buf    bytes.Buffer
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+func (o *s3Object) Close() error {
+	if o.buf.Len() == 0 {
+		return nil
+	}
+	_, err := o.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(o.key),
+		Body:        bytes.NewReader(o.buf.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	return err
+}
@@ -0,0 +1,2357 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+type Config struct {
+	InState           string   `envconfig:"in_state" required:"true"`
+	OutState          string   `envconfig:"out_state"`
+	DryRun            bool     `envconfig:"dry_run"`
+	IncludeOrgs       []string `envconfig:"include_orgs"`
+	ExcludeOrgs       []string `envconfig:"exclude_orgs"`
+	IncludeSpaces     []string `envconfig:"include_spaces"`
+	ExcludeSpaces     []string `envconfig:"exclude_spaces"`
+	IncludeBuildpacks []string `envconfig:"include_buildpacks"`
+	ExcludeBuildpacks []string `envconfig:"exclude_buildpacks"`
+	// OnlyOrgs, OnlySpaces and OnlyApps come from the command line flags
+	// narrowing the run, rather than from the environment.
+	OnlyOrgs   []string `ignored:"true"`
+	OnlySpaces []string `ignored:"true"`
+	OnlyApps   []string `ignored:"true"`
+	// ReportJSON and ReportCSV are the paths the --report-json and
+	// --report-csv flags write reports of the run to.
+	ReportJSON string `ignored:"true"`
+	ReportCSV  string `ignored:"true"`
+	// DecisionTrace is the path the --decision-trace flag writes the
+	// reasoning behind the decision about every app to.
+	DecisionTrace string `ignored:"true"`
+	// CPUProfile and HeapProfile are the paths the --cpu-profile and
+	// --heap-profile flags write profiles of the run to, and PprofAddr the
+	// address --pprof-addr serves net/http/pprof on while the run lasts.
+	CPUProfile  string `ignored:"true"`
+	HeapProfile string `ignored:"true"`
+	PprofAddr   string `ignored:"true"`
+	// ReadOnly is set by the commands that never write the state, which
+	// don't need OutState.
+	ReadOnly bool `ignored:"true"`
+	// ListOutdated is where the run lists the outdated apps and their owners
+	// for people to read once it is over, as report --list does.
+	ListOutdated io.Writer `ignored:"true"`
+	// Fixtures is the file of CF API resources a simulated run reads instead
+	// of the CF API, which it then doesn't need the credentials of.
+	Fixtures string `ignored:"true"`
+	// CheckpointDir is the directory every e-mail sent is recorded in as soon
+	// as it is sent, in a file named after the run, if set. Resume is the ID
+	// of a run that died part way through, given with --resume, whose
+	// recorded e-mails aren't sent again.
+	CheckpointDir string `envconfig:"checkpoint_dir"`
+	Resume        string `ignored:"true"`
+	// RunID is the ID of the run, which ties its logs, e-mails, audit
+	// records and metrics together. Empty is a new ID, or Resume.
+	RunID string `ignored:"true"`
+	// Logger is what the run logs to. Nil logs to stderr at LogLevel in
+	// LogFormat.
+	Logger *Logger `ignored:"true"`
+	// ErrorReporter reports the failures of the run, if set.
+	ErrorReporter *ErrorReporter `ignored:"true"`
+	// Limit is the most e-mails about outdated apps a run sends, given with
+	// --limit. The notifications beyond it are held back in the state for
+	// the next run. Zero is no limit.
+	Limit int `ignored:"true"`
+	// SystemOrgs are the orgs owned by the operators, whose apps are listed
+	// in the run summary instead of their owners being notified.
+	SystemOrgs []string `envconfig:"system_orgs" default:"system"`
+	// OwnerRoles are the space and organization roles whose holders are
+	// notified about an app.
+	OwnerRoles []string `envconfig:"owner_roles" default:"space_manager,space_developer"`
+	// ResolveEmailsViaUAA looks up the e-mail address of owners whose
+	// username isn't one in UAA instead of skipping them.
+	ResolveEmailsViaUAA bool `envconfig:"resolve_emails_via_uaa"`
+	// EscalateAfter is how many notifications about an app can go without a
+	// restage before the managers in EscalationRoles are told. Zero turns
+	// escalation off.
+	EscalateAfter   int      `envconfig:"escalate_after"`
+	EscalationRoles []string `envconfig:"escalation_roles" default:"space_manager,organization_manager"`
+	// SendRestageConfirmations thanks the owners of apps that were restaged
+	// after they were notified about them.
+	SendRestageConfirmations bool `envconfig:"send_restage_confirmations"`
+	// BuildpackConfig is a JSON file with settings for each buildpack, such
+	// as its release notes page, and BuildpackReleaseURLs sets the release
+	// notes pages as name=url, overriding the file and the pages of the
+	// system buildpacks.
+	BuildpackConfig      string   `envconfig:"buildpack_config"`
+	BuildpackReleaseURLs []string `envconfig:"buildpack_release_urls"`
+	// BuildpackAliases maps old buildpack names still found in droplets to
+	// the buildpack that replaced them.
+	BuildpackAliases map[string]string `envconfig:"buildpack_aliases"`
+	// NotifyPinnedBuildpacks warns the owners of apps using custom buildpacks
+	// pinned to a git tag or commit.
+	NotifyPinnedBuildpacks bool `envconfig:"notify_pinned_buildpacks"`
+	// VerifyReleaseTags checks that GitHub has a release for the version of
+	// every outdated buildpack before linking to its release notes,
+	// IncludeReleaseNotes adds an excerpt of the notes to the e-mails, and
+	// CountReleasesBehind tells owners how many releases behind the latest
+	// version their apps are, all asking with GitHubToken if set.
+	VerifyReleaseTags   bool   `envconfig:"verify_release_tags"`
+	IncludeReleaseNotes bool   `envconfig:"include_release_notes"`
+	CountReleasesBehind bool   `envconfig:"count_releases_behind"`
+	GitHubToken         string `envconfig:"github_token"`
+	// NVDSeverities looks up the severity of the CVEs named in the release
+	// notes in the National Vulnerability Database when the notes don't
+	// give it, asking with NVDAPIKey if set.
+	NVDSeverities bool   `envconfig:"nvd_severities"`
+	NVDAPIKey     string `envconfig:"nvd_api_key"`
+	// SecurityEscalateAfter escalates apps whose restage picks up security
+	// fixes after this many notifications instead of EscalateAfter, if
+	// sooner.
+	SecurityEscalateAfter int `envconfig:"security_escalate_after"`
+	// IncludeDisabledBuildpacks also notifies about updates to buildpacks
+	// that are disabled.
+	IncludeDisabledBuildpacks bool `envconfig:"include_disabled_buildpacks"`
+	// ClockSkewTolerance is how long before a buildpack update a droplet can
+	// be created and still count as staged with the update.
+	ClockSkewTolerance time.Duration `envconfig:"clock_skew_tolerance"`
+	// LogLevel is one of debug, info, warn or error. Debug adds a line for
+	// every app checked.
+	LogLevel string `envconfig:"log_level" default:"info"`
+	// LogFormat is text, or json for a JSON object per line carrying the run
+	// ID and the app, space and buildpack the line is about as fields.
+	LogFormat string `envconfig:"log_format" default:"text"`
+	// PushgatewayURL is the Prometheus Pushgateway the metrics of every run
+	// are pushed to once it is over, grouped under PushgatewayJob, if set.
+	PushgatewayURL string `envconfig:"pushgateway_url"`
+	PushgatewayJob string `envconfig:"pushgateway_job" default:"buildpack_notify"`
+	// The OTLP settings export the spans of every run, following the
+	// OpenTelemetry conventions for the environment. OTLPTracesEndpoint
+	// defaults to the /v1/traces path of OTLPEndpoint.
+	OTLPEndpoint       string `envconfig:"otel_exporter_otlp_endpoint"`
+	OTLPTracesEndpoint string `envconfig:"otel_exporter_otlp_traces_endpoint"`
+	OTLPHeaders        string `envconfig:"otel_exporter_otlp_headers"`
+	OTELServiceName    string `envconfig:"otel_service_name" default:"buildpack-notify"`
+	// CloudWatchLogGroup is the CloudWatch Logs group every run ships its
+	// lines to, in a stream named after the run, along with its metrics in
+	// the embedded metric format under CloudWatchNamespace, if set.
+	CloudWatchLogGroup  string `envconfig:"cloudwatch_log_group"`
+	CloudWatchNamespace string `envconfig:"cloudwatch_namespace" default:"BuildpackNotify"`
+	// Campaign settings switch the run to notifying the owners of every app
+	// using a buildpack that is being retired.
+	CampaignBuildpack string `envconfig:"campaign_buildpack"`
+	CampaignStack     string `envconfig:"campaign_stack"`
+	CampaignTemplate  string `envconfig:"campaign_template"`
+	CampaignSubject   string `envconfig:"campaign_subject" default:"Action required: a buildpack used by your applications is being retired"`
+	// EOLStacks switch the run to notifying the owners of every app running
+	// on one of these stacks that it has to move to EOLStackReplacement.
+	EOLStacks           []string `envconfig:"eol_stacks"`
+	EOLStackReplacement string   `envconfig:"eol_stack_replacement"`
+	// EOLCalendar is a JSON file of the end of support dates of buildpack
+	// versions and runtimes, warned about in notifications from
+	// EOLWarningDays before the date.
+	EOLCalendar    string `envconfig:"eol_calendar"`
+	EOLWarningDays int    `envconfig:"eol_warning_days" default:"90"`
+	// Cohorts splits the owners notified by a campaign or stack end of life
+	// notification into this many cohorts, notifying one of them per run.
+	// Cohort, given with --cohort, picks the cohort instead of the day.
+	Cohorts int `envconfig:"cohorts"`
+	Cohort  int `ignored:"true"`
+	// AutoRestage restages the outdated apps in RestageOrgs and RestageSpaces,
+	// or in orgs opted in with the auto-restage annotation, instead of
+	// notifying their owners, who are only notified if the restage fails.
+	AutoRestage        bool          `envconfig:"auto_restage"`
+	RestageOrgs        []string      `envconfig:"restage_orgs"`
+	RestageSpaces      []string      `envconfig:"restage_spaces"`
+	RestageConcurrency int           `envconfig:"restage_concurrency" default:"2"`
+	RestageTimeout     time.Duration `envconfig:"restage_timeout" default:"15m"`
+	// RestageSpaceConcurrency and RestageOrgConcurrency, when set, also cap
+	// the restages running at the same time within a space or an org.
+	RestageSpaceConcurrency int `envconfig:"restage_space_concurrency"`
+	RestageOrgConcurrency   int `envconfig:"restage_org_concurrency"`
+	// RestageCanary restages one app per space first and waits up to
+	// RestageHealthTimeout for it to be healthy before restaging the rest of
+	// the space. Failed canaries are rolled back and RestageAlertEmails are
+	// alerted.
+	RestageCanary        bool          `envconfig:"restage_canary"`
+	RestageHealthTimeout time.Duration `envconfig:"restage_health_timeout" default:"5m"`
+	RestageAlertEmails   []string      `envconfig:"restage_alert_emails"`
+	// RestageApproval only restages apps once an operator approved the plan
+	// of their restages, e-mailed to RestageApprovalEmails, by passing its
+	// token as RestageApprovalToken to a following run.
+	RestageApproval       bool     `envconfig:"restage_approval"`
+	RestageApprovalToken  string   `envconfig:"restage_approval_token"`
+	RestageApprovalEmails []string `envconfig:"restage_approval_emails"`
+	// RestageAuditLog is the file every automated restage is appended to as
+	// a line of JSON, if set.
+	RestageAuditLog string `envconfig:"restage_audit_log"`
+	// NotificationAuditLog is where every notification decided on and sent
+	// is recorded as a line of JSON, if set: a file appended to, or an
+	// s3://bucket/prefix URL each run uploads an object to.
+	NotificationAuditLog string `envconfig:"notification_audit_log"`
+	// HistoryFile is the SQLite database a snapshot of every run that saves
+	// the state is recorded in, for the history command to print trends from.
+	HistoryFile string `envconfig:"history_file"`
+	// EmailConcurrency is how many e-mails to the owners of apps are sent at
+	// a time, and EmailsPerSecond the most e-mails sent per second across
+	// all of them. Zero doesn't limit the rate.
+	EmailConcurrency int     `envconfig:"email_concurrency" default:"1"`
+	EmailsPerSecond  float64 `envconfig:"emails_per_second"`
+	// OperatorSummaryEmails are sent a summary of every run once it is over.
+	OperatorSummaryEmails []string `envconfig:"operator_summary_emails"`
+	// RestageWindows are cron expressions, separated by semicolons, matching
+	// the minutes apps may be restaged in. Outside of them restages wait in
+	// the state for the next run inside one.
+	RestageWindows        string `envconfig:"restage_windows"`
+	RestageWindowTimezone string `envconfig:"restage_window_timezone" default:"UTC"`
+	// RestageAfterNotifications, when set, only restages apps whose owners
+	// were notified this many times over at least RestageAfterDays days
+	// without restaging them, after warning them RestageNotice ahead.
+	RestageAfterNotifications int           `envconfig:"restage_after_notifications"`
+	RestageAfterDays          int           `envconfig:"restage_after_days"`
+	RestageNotice             time.Duration `envconfig:"restage_notice" default:"168h"`
+}
+
+// runScope returns the scope limiting the run to the configured orgs, spaces
+// and buildpacks.
+func (c Config) runScope() (runScope, error) {
+	orgs, err := newResourceFilter(c.IncludeOrgs, c.ExcludeOrgs)
+	if err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid org filter")
+	}
+	spaces, err := newResourceFilter(c.IncludeSpaces, c.ExcludeSpaces)
+	if err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid space filter")
+	}
+	buildpacks, err := newResourceFilter(c.IncludeBuildpacks, c.ExcludeBuildpacks)
+	if err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid buildpack filter")
+	}
+	systemOrgs, err := parsePatternList(c.SystemOrgs)
+	if err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid system orgs")
+	}
+	if orgs.only, err = parsePatternList(c.OnlyOrgs); err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid --org")
+	}
+	if spaces.only, err = parsePatternList(c.OnlySpaces); err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid --space")
+	}
+	apps, err := parsePatternList(c.OnlyApps)
+	if err != nil {
+		return runScope{}, errors.Wrap(err, "Invalid --app")
+	}
+	return runScope{orgs: orgs, spaces: spaces, buildpacks: buildpacks, systemOrgs: systemOrgs, apps: apps}, nil
+}
+
+// campaign returns the configured deprecation campaign, or nil when the run
+// isn't a campaign.
+func (c Config) campaign() (*campaign, error) {
+	if c.CampaignBuildpack == "" {
+		return nil, nil
+	}
+	if c.CampaignTemplate == "" {
+		return nil, errors.New("A campaign template is required to run a campaign")
+	}
+	buildpack, err := parsePattern(c.CampaignBuildpack)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid campaign buildpack")
+	}
+	return &campaign{buildpack: buildpack, stack: c.CampaignStack, subject: c.CampaignSubject, aliases: c.BuildpackAliases}, nil
+}
+
+// stackEOL returns the configured stack end of life notification, or nil when
+// the run isn't about stacks.
+func (c Config) stackEOL() (*stackEOL, error) {
+	if len(c.EOLStacks) == 0 {
+		return nil, nil
+	}
+	if c.CampaignBuildpack != "" {
+		return nil, errors.New("A run can't be both a campaign and a stack end of life notification")
+	}
+	if c.EOLStackReplacement == "" {
+		return nil, errors.New("A replacement stack is required to notify about end of life stacks")
+	}
+	return &stackEOL{stacks: c.EOLStacks, replacement: c.EOLStackReplacement}, nil
+}
+
+// restageScope returns the orgs and spaces whose outdated apps are restaged
+// automatically, or nil when apps aren't restaged.
+func (c Config) restageScope() (*restageScope, error) {
+	if !c.AutoRestage {
+		return nil, nil
+	}
+	orgs, err := parsePatternList(c.RestageOrgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid restage orgs")
+	}
+	spaces, err := parsePatternList(c.RestageSpaces)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid restage spaces")
+	}
+	windows, err := parseRestageWindows(c.RestageWindows, c.RestageWindowTimezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid restage windows")
+	}
+	return &restageScope{
+		orgs:               orgs,
+		spaces:             spaces,
+		windows:            windows,
+		afterNotifications: c.RestageAfterNotifications,
+		afterDays:          c.RestageAfterDays,
+		notice:             c.RestageNotice,
+		canary:             c.RestageCanary,
+	}, nil
+}
+
+// needsAppsWithoutUpdates tells whether the apps need to be checked even
+// though no buildpack was updated: to carry forward the notifications held
+// back by the last run, to restage the apps queued for restaging, to warn
+// about pinned buildpacks, or to thank the owners of apps restaged since they
+// were notified. Other restages are recorded by the next run checking apps.
+func (c Config) needsAppsWithoutUpdates(state *runState, restage *restageScope) bool {
+	if len(state.HeldNotifications) > 0 || len(state.UncheckedApps) > 0 || c.NotifyPinnedBuildpacks {
+		return true
+	}
+	if restage != nil && len(state.RestageQueue) > 0 {
+		return true
+	}
+	if c.SendRestageConfirmations {
+		for _, record := range state.Apps {
+			if record.ResolvedAt == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Config) restageLimits() restageLimits {
+	return restageLimits{total: c.RestageConcurrency, perSpace: c.RestageSpaceConcurrency, perOrg: c.RestageOrgConcurrency}
+}
+
+type EmailConfig struct {
+	From     string `envconfig:"smtp_from" required:"true"`
+	Host     string `envconfig:"smtp_host" required:"true"`
+	Password string `envconfig:"smtp_password" required:"true"`
+	Port     string `envconfig:"smtp_port" required:"true"`
+	User     string `envconfig:"smtp_user" required:"true"`
+	Cert     string `envconfig:"smtp_cert"`
+	Proxy    string `envconfig:"smtp_proxy"`
+}
+
+type CFAPIConfig struct {
+	API                 string        `envconfig:"cf_api"`
+	ClientID            string        `envconfig:"client_id"`
+	ClientSecret        string        `envconfig:"client_secret"`
+	PerPage             int           `envconfig:"cf_per_page" default:"100"`
+	MaxPages            int           `envconfig:"cf_max_pages"`
+	AllowPartialResults bool          `envconfig:"cf_allow_partial_results"`
+	RateLimitMaxRetries int           `envconfig:"cf_rate_limit_max_retries" default:"5"`
+	RateLimitMaxWait    time.Duration `envconfig:"cf_rate_limit_max_wait" default:"5m"`
+	RetryMaxAttempts    int           `envconfig:"cf_retry_max_attempts" default:"3"`
+	RetryBackoff        time.Duration `envconfig:"cf_retry_backoff" default:"1s"`
+	DropletConcurrency  int           `envconfig:"cf_droplet_concurrency" default:"5"`
+	RoleConcurrency     int           `envconfig:"cf_role_concurrency" default:"5"`
+	Timeout             time.Duration `envconfig:"cf_timeout" default:"30s"`
+	MaxIdleConns        int           `envconfig:"cf_max_idle_conns" default:"10"`
+	MaxConns            int           `envconfig:"cf_max_conns"`
+	ClientCert          string        `envconfig:"cf_client_cert"`
+	ClientKey           string        `envconfig:"cf_client_key"`
+	// Record writes every CF API response to this file, sanitized, and
+	// Replay answers the CF API requests of a run from such a file instead
+	// of the CF API.
+	Record string `envconfig:"cf_api_record"`
+	Replay string `envconfig:"cf_api_replay"`
+}
+
+// transportOptions returns the connection settings of the CF API client.
+func (c CFAPIConfig) transportOptions(insecure bool) (transportOptions, error) {
+	opts := transportOptions{
+		Timeout:      c.Timeout,
+		MaxIdleConns: c.MaxIdleConns,
+		MaxConns:     c.MaxConns,
+		Insecure:     insecure,
+	}
+	if c.ClientCert == "" && c.ClientKey == "" {
+		return opts, nil
+	}
+	if c.ClientCert == "" || c.ClientKey == "" {
+		return transportOptions{}, errors.New("CF_CLIENT_CERT and CF_CLIENT_KEY must be set together")
+	}
+	cert, err := tls.X509KeyPair([]byte(c.ClientCert), []byte(c.ClientKey))
+	if err != nil {
+		return transportOptions{}, errors.Wrap(err, "Invalid CF API client certificate")
+	}
+	opts.Certificates = []tls.Certificate{cert}
+	return opts, nil
+}
+
+// listOptions returns the pagination options to use when listing apps and buildpacks.
+func (c CFAPIConfig) listOptions() ListOptions {
+	return ListOptions{
+		PerPage:             c.PerPage,
+		MaxPages:            c.MaxPages,
+		AllowPartialResults: c.AllowPartialResults,
+	}
+}
+
+type buildpackRecord struct {
+	LastUpdatedAt string
+	// Filename is the file the buildpack was uploaded as, which changes with
+	// every new version. Older states don't have it.
+	Filename string `json:",omitempty"`
+}
+
+// runState is everything remembered from one run to the next.
+type runState struct {
+	// Buildpacks maps a buildpack GUID to when it was last updated.
+	Buildpacks map[string]buildpackRecord
+	// PinnedBuildpackWarnings maps an app GUID to the pinned custom buildpacks
+	// its owners were last warned about.
+	PinnedBuildpackWarnings map[string]pinnedBuildpackRecord
+	// Apps maps an app GUID to the history of notifications about it.
+	Apps map[string]appNotificationRecord
+	// RestageQueue maps an app GUID to the restage waiting for the next
+	// restage window.
+	RestageQueue map[string]queuedRestage
+	// HeldNotifications maps an app GUID to the notification about it held
+	// back by the --limit of the last run, or that failed to reach its owners.
+	HeldNotifications map[string]heldNotification
+	// UncheckedApps maps an app GUID to the buildpacks the last run couldn't
+	// check it against.
+	UncheckedApps map[string]uncheckedApp `json:",omitempty"`
+	// RestagePlan is the restages awaiting an operator's approval, if any.
+	RestagePlan *restagePlan `json:",omitempty"`
+	// Releases caches what GitHub answered about the release of each
+	// buildpack version, by buildpack name and version.
+	Releases map[string]releaseRecord `json:",omitempty"`
+	// CVESeverities caches the severity of CVEs looked up in the NVD, by
+	// CVE.
+	CVESeverities map[string]string `json:",omitempty"`
+}
+
+// trackedApps returns the apps the state keeps track of: the apps notified
+// about, queued or planned for restaging, or whose notification was held back.
+// The rest of the apps checked can be forgotten once they are checked.
+func (s *runState) trackedApps(apps []appInfo) []appInfo {
+	var tracked []appInfo
+	for _, app := range apps {
+		_, notified := s.Apps[app.GUID]
+		_, queued := s.RestageQueue[app.GUID]
+		_, held := s.HeldNotifications[app.GUID]
+		planned := false
+		if s.RestagePlan != nil {
+			_, planned = s.RestagePlan.Restages[app.GUID]
+		}
+		if notified || queued || held || planned {
+			tracked = append(tracked, app)
+		}
+	}
+	return tracked
+}
+
+func newRunState() *runState {
+	return &runState{
+		Buildpacks:              make(map[string]buildpackRecord),
+		PinnedBuildpackWarnings: make(map[string]pinnedBuildpackRecord),
+		Apps:                    make(map[string]appNotificationRecord),
+		RestageQueue:            make(map[string]queuedRestage),
+		HeldNotifications:       make(map[string]heldNotification),
+		UncheckedApps:           make(map[string]uncheckedApp),
+		Releases:                make(map[string]releaseRecord),
+		CVESeverities:           make(map[string]string),
+	}
+}
+
+type buildpackReleaseInfo struct {
+	BuildpackName    string
+	BuildpackVersion string
+	BuildpackURL     string
+	// Docs are the documentation pages of the buildpack, if configured.
+	Docs []DocLink `json:",omitempty"`
+	// ReleaseNotes is the excerpt of the release notes, if included,
+	// SecurityFixes the CVEs they name and RemovedRuntimes the dependency
+	// lines they remove.
+	ReleaseNotes    releaseNotes     `json:",omitempty"`
+	SecurityFixes   []securityFix    `json:",omitempty"`
+	RemovedRuntimes []removedRuntime `json:",omitempty"`
+	// CurrentVersion is the version of the buildpack the app was staged
+	// with, if the droplet records it, and ReleasesBehind how many releases
+	// came out since, if counted.
+	CurrentVersion string `json:",omitempty"`
+	ReleasesBehind int    `json:",omitempty"`
+	// DetectOutput is what the buildpack reported detecting when it staged
+	// the droplet of the app, e.g. ruby 3.1.4, if the droplet records it.
+	DetectOutput string `json:",omitempty"`
+	// EOLWarnings are the end of support dates coming up for the version
+	// the app was staged with, or the runtimes of the buildpack.
+	EOLWarnings []eolWarning `json:",omitempty"`
+}
+
+// YourVersion returns the version the app was staged with, written the way
+// the latest version is, e.g. v1.7.40 rather than 1.7.40, for the e-mails.
+func (i buildpackReleaseInfo) YourVersion() string {
+	if strings.HasPrefix(i.BuildpackVersion, "v") && !strings.HasPrefix(i.CurrentVersion, "v") {
+		return "v" + i.CurrentVersion
+	}
+	return i.CurrentVersion
+}
+
+// DetectedRuntimeRemoved reports whether the latest release removes the line
+// of the runtime the app was detected with, so restaging it without changing
+// its runtime version may fail.
+func (i buildpackReleaseInfo) DetectedRuntimeRemoved() bool {
+	for _, runtime := range i.RemovedRuntimes {
+		if in, _ := detectedRuntimeIn(i.DetectOutput, runtime.Name, runtime.Version); in {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBuildpackVersion(buildpackFileName string) string {
+	// Takes a buildpack file name and parses out the version number from it.
+	// Buildpack filenames currently look like this: python_buildpack-cflinuxfs3-v1.7.43.zip
+	// "v1.7.43" is the version in this case. Buildpacks uploaded without a
+	// file, e.g. some offline or admin buildpacks, have no version.
+	if strings.TrimSpace(buildpackFileName) == "" {
+		return ""
+	}
+
+	fileNameParts := strings.Split(buildpackFileName, "-")
+	buildpackVersion := strings.ReplaceAll(fileNameParts[len(fileNameParts)-1], ".zip", "")
+	return buildpackVersion
+}
+
+func getBuildpackVersionURL(buildpackReleaseURL string, buildpackVersion string) string {
+	// Takes a buildpack version and appends it to a URL to create a specific
+	// release URL.  If the version isn't correct, fall back to the main
+	// releases URL.
+	buildpackVersionURL := buildpackReleaseURL
+	buildpackVersionPath := "/tag/"
+
+	// Check to make sure that the buildpackVersion matches the format of
+	// vX.Y[.Z], e.g.: v1.7.43 or v1.6
+	versionRe := regexp.MustCompile(`^v[0-9]+\.[0-9]+(\.[0-9]+)?$`)
+	versionMatch := versionRe.FindAllString(buildpackVersion, -1)
+
+	if versionMatch != nil && buildpackReleaseURL != "" {
+		buildpackVersionURL = buildpackReleaseURL + buildpackVersionPath + buildpackVersion
+	}
+
+	return buildpackVersionURL
+}
+
+// loadState reads the state saved by the previous run. State files written
+// before the state held anything but buildpacks are a bare map of buildpack
+// GUIDs, which are still read.
+func loadState(path string) (*runState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	state := newRunState()
+	if _, found := raw["Buildpacks"]; !found {
+		for guid, record := range raw {
+			var buildpack buildpackRecord
+			if err := json.Unmarshal(record, &buildpack); err != nil {
+				return nil, err
+			}
+			state.Buildpacks[guid] = buildpack
+		}
+		return state, nil
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	// Parts of the state added since the file was written are missing.
+	if state.PinnedBuildpackWarnings == nil {
+		state.PinnedBuildpackWarnings = make(map[string]pinnedBuildpackRecord)
+	}
+	if state.Apps == nil {
+		state.Apps = make(map[string]appNotificationRecord)
+	}
+	if state.RestageQueue == nil {
+		state.RestageQueue = make(map[string]queuedRestage)
+	}
+	if state.HeldNotifications == nil {
+		state.HeldNotifications = make(map[string]heldNotification)
+	}
+	if state.UncheckedApps == nil {
+		state.UncheckedApps = make(map[string]uncheckedApp)
+	}
+	if state.Releases == nil {
+		state.Releases = make(map[string]releaseRecord)
+	}
+	if state.CVESeverities == nil {
+		state.CVESeverities = make(map[string]string)
+	}
+	return state, nil
+}
+
+func copyState(inPath, outPath string) error {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func saveState(state *runState, path string) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	encoder := json.NewEncoder(fp)
+	return encoder.Encode(state)
+}
+
+// runSettings are the settings of a run parsed from its configuration.
+type runSettings struct {
+	scope     runScope
+	campaign  *campaign
+	eol       *stackEOL
+	calendar  *eolCalendar
+	restage   *restageScope
+	owners    ownerSettings
+	managers  ownerSettings
+	transport transportOptions
+	// links are the release notes and documentation pages of the
+	// buildpacks.
+	links     buildpackLinks
+	logLevel  logLevel
+	logFormat logFormat
+	// logger is the logger of the run, see parseSettings.
+	logger  *Logger
+	metrics []metricsPublisher
+	tracer  *tracer
+	logs    *cloudWatchLogs
+}
+
+// settings parses the settings of a run, returning every problem with the
+// configuration rather than only the first.
+func (c Config) settings(cfAPIConfig CFAPIConfig, insecure bool) (runSettings, []error) {
+	var settings runSettings
+	var problems []error
+	var err error
+	if c.OutState == "" && !c.ReadOnly {
+		problems = append(problems, errors.New("required key OUT_STATE missing value"))
+	}
+	if c.Limit < 0 {
+		problems = append(problems, errors.Errorf("Invalid limit %d, expected a positive number of e-mails", c.Limit))
+	}
+	if c.EmailConcurrency < 1 {
+		problems = append(problems, errors.Errorf("Invalid e-mail concurrency %d, expected at least 1", c.EmailConcurrency))
+	}
+	if c.EmailsPerSecond < 0 {
+		problems = append(problems, errors.Errorf("Invalid e-mail rate %g, expected a positive number of e-mails per second", c.EmailsPerSecond))
+	}
+	if c.SecurityEscalateAfter < 0 {
+		problems = append(problems, errors.Errorf("Invalid security escalation %d, expected a positive number of notifications", c.SecurityEscalateAfter))
+	}
+	if (c.NVDSeverities || c.SecurityEscalateAfter > 0) && !c.IncludeReleaseNotes {
+		problems = append(problems, errors.New("Finding the security fixes of releases needs INCLUDE_RELEASE_NOTES"))
+	}
+	if c.Resume != "" && c.CheckpointDir == "" {
+		problems = append(problems, errors.New("Resuming a run needs CHECKPOINT_DIR"))
+	}
+	if cfAPIConfig.MaxPages > 0 && !c.DryRun && !c.ReadOnly {
+		// A run cut short leaves the state alone, so real runs would
+		// notify the owners of the apps on the first pages every time.
+		problems = append(problems, errors.New("CF_MAX_PAGES is for testing and needs DRY_RUN"))
+	}
+	if cfAPIConfig.Record != "" && cfAPIConfig.Replay != "" {
+		problems = append(problems, errors.New("CF_API_RECORD and CF_API_REPLAY can't be set together"))
+	}
+	if c.Fixtures == "" && cfAPIConfig.Replay == "" {
+		for _, required := range [][2]string{{"CF_API", cfAPIConfig.API}, {"CLIENT_ID", cfAPIConfig.ClientID}, {"CLIENT_SECRET", cfAPIConfig.ClientSecret}} {
+			if required[1] == "" {
+				problems = append(problems, errors.Errorf("required key %s missing value", required[0]))
+			}
+		}
+	}
+	if settings.scope, err = c.runScope(); err != nil {
+		problems = append(problems, err)
+	}
+	if settings.campaign, err = c.campaign(); err != nil {
+		problems = append(problems, err)
+	}
+	if settings.eol, err = c.stackEOL(); err != nil {
+		problems = append(problems, err)
+	}
+	if settings.calendar, err = c.eolCalendar(); err != nil {
+		problems = append(problems, err)
+	}
+	runCohorts, err := c.cohorts(time.Now())
+	if err != nil {
+		problems = append(problems, err)
+	}
+	if settings.campaign != nil {
+		settings.campaign.cohorts = runCohorts
+	}
+	if settings.eol != nil {
+		settings.eol.cohorts = runCohorts
+	}
+	if settings.restage, err = c.restageScope(); err != nil {
+		problems = append(problems, err)
+	}
+	if settings.links, err = c.links(); err != nil {
+		problems = append(problems, err)
+	}
+	roles, err := newOwnerRoles(c.OwnerRoles)
+	if err != nil {
+		problems = append(problems, errors.Wrap(err, "Invalid owner roles"))
+	}
+	settings.owners = ownerSettings{roles: roles, resolveEmailsViaUAA: c.ResolveEmailsViaUAA, concurrency: cfAPIConfig.RoleConcurrency}
+	escalationRoles, err := newOwnerRoles(c.EscalationRoles)
+	if err != nil {
+		problems = append(problems, errors.Wrap(err, "Invalid escalation roles"))
+	}
+	settings.managers = ownerSettings{roles: escalationRoles, resolveEmailsViaUAA: c.ResolveEmailsViaUAA, concurrency: cfAPIConfig.RoleConcurrency}
+	if settings.transport, err = cfAPIConfig.transportOptions(insecure); err != nil {
+		problems = append(problems, errors.Wrap(err, "Invalid cf api config"))
+	}
+	if settings.logLevel, err = parseLogLevel(c.LogLevel); err != nil {
+		problems = append(problems, err)
+	}
+	if settings.logFormat, err = parseLogFormat(c.LogFormat); err != nil {
+		problems = append(problems, err)
+	}
+	if c.PushgatewayURL != "" {
+		pushgateway, err := newPushgateway(c.PushgatewayURL, c.PushgatewayJob)
+		if err != nil {
+			problems = append(problems, err)
+		} else {
+			settings.metrics = append(settings.metrics, pushgateway)
+		}
+	}
+	if c.CloudWatchLogGroup != "" {
+		awsConfig, err := loadAWSConfig()
+		if err != nil {
+			problems = append(problems, err)
+		} else {
+			settings.logs = newCloudWatchLogs(awsConfig, c.CloudWatchLogGroup, c.CloudWatchNamespace)
+			settings.metrics = append(settings.metrics, settings.logs)
+		}
+	}
+	if endpoint := c.otlpTracesEndpoint(); endpoint != "" {
+		if settings.tracer, err = newTracer(endpoint, c.OTLPHeaders, c.OTELServiceName); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return settings, problems
+}
+
+// otlpTracesEndpoint returns the endpoint the spans of the run are exported
+// to, or an empty string when runs aren't traced.
+func (c Config) otlpTracesEndpoint() string {
+	if c.OTLPTracesEndpoint != "" || c.OTLPEndpoint == "" {
+		return c.OTLPTracesEndpoint
+	}
+	return strings.TrimSuffix(c.OTLPEndpoint, "/") + "/v1/traces"
+}
+
+// newCFClient creates the client of the CF API, along with the transport
+// rate limiting its requests. The retries and rate limiting are logged to
+// logger.
+func newCFClient(cfAPIConfig CFAPIConfig, transport transportOptions, insecure bool, logger *Logger) (*cfclient.Client, *rateLimitTransport, error) {
+	if cfAPIConfig.Replay != "" {
+		return newReplayClient(cfAPIConfig.Replay)
+	}
+	cfTransport := newDeadlineTransport(newCFTransport(transport), transport.Timeout)
+	// Discovering the API endpoints and fetching tokens don't need a token themselves.
+	authClient := &http.Client{Transport: newRetryTransport(cfTransport, cfAPIConfig.RetryMaxAttempts, cfAPIConfig.RetryBackoff, logger)}
+	client, err := cfclient.NewClient(&cfclient.Config{
+		ApiAddress:        cfAPIConfig.API,
+		ClientID:          cfAPIConfig.ClientID,
+		ClientSecret:      cfAPIConfig.ClientSecret,
+		SkipSslValidation: insecure,
+		HttpClient:        authClient,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Replace the client's own token handling with one that also recovers
+	// from tokens rejected part way through long runs.
+	tokens := newTokenTransport(cfTransport, clientCredentialsTokens(client.Endpoint.TokenEndpoint, cfAPIConfig.ClientID, cfAPIConfig.ClientSecret, authClient), logger)
+	rateLimiter := newRateLimitTransport(tokens, cfAPIConfig.RateLimitMaxRetries, cfAPIConfig.RateLimitMaxWait, logger)
+	var clientTransport http.RoundTripper = newRetryTransport(rateLimiter, cfAPIConfig.RetryMaxAttempts, cfAPIConfig.RetryBackoff, logger)
+	if cfAPIConfig.Record != "" {
+		if clientTransport, err = newRecordTransport(clientTransport, cfAPIConfig.Record, logger); err != nil {
+			return nil, nil, err
+		}
+	}
+	client.Config.HttpClient = &http.Client{Transport: clientTransport}
+	return client, rateLimiter, nil
+}
+
+// parseSettings parses the settings of a run, logging every problem with
+// them. The settings carry the logger of the run even when there are
+// problems: config.Logger, or one logging to stderr as configured.
+func parseSettings(config Config, cfAPIConfig CFAPIConfig, insecure bool) (runSettings, error) {
+	settings, problems := config.settings(cfAPIConfig, insecure)
+	settings.logger = config.Logger
+	if settings.logger == nil {
+		settings.logger = newLogger(os.Stderr, settings.logLevel, settings.logFormat)
+	}
+	for _, problem := range problems {
+		settings.logger.errorf("Unable to parse config: %s", problem)
+	}
+	if len(problems) > 0 {
+		return settings, failf(ExitConfig, "The configuration has %d problems", len(problems))
+	}
+	return settings, nil
+}
+
+// Run runs the pipeline finding outdated apps and notifying their owners, or
+// the campaign or stack end of life notification configured instead, sending
+// the e-mails through mailer. A nil mailer sends nothing. The *Error it
+// returns tells the exit code of the run. Since the logs of the run are
+// shipped until it returns, Run logs the failure it returns itself, and
+// reports it to config.ErrorReporter.
+func Run(config Config, cfAPIConfig CFAPIConfig, mailer Mailer) (err error) {
+	started := time.Now()
+	insecure := os.Getenv("INSECURE") == "1"
+	settings, err := parseSettings(config, cfAPIConfig, insecure)
+	logger := settings.logger
+	stopShippingLogs := func() {}
+	defer func() {
+		logFailure(err, logger, config.ErrorReporter)
+		stopShippingLogs()
+	}()
+	if err != nil {
+		return err
+	}
+	logger.infof("Running %s.\n", currentBuild())
+	runID := config.RunID
+	if config.Resume != "" {
+		runID = config.Resume
+		logger.infof("Resuming run %s.\n", runID)
+	} else {
+		if runID == "" {
+			runID = newRunID()
+		}
+		logger.infof("Starting run %s.\n", runID)
+	}
+	config.ErrorReporter.SetTag("run_id", runID)
+	logger, stopShippingLogs = shipLogs(settings.logs, runID, logger.WithRunID(runID))
+	scope, campaign, eol, restage := settings.scope, settings.campaign, settings.eol, settings.restage
+	owners, managers := settings.owners, settings.managers
+	stopProfiling, err := startProfiling(config, logger)
+	if err != nil {
+		return failf(ExitConfig, "Unable to profile the run. Error: %s", err)
+	}
+	defer stopProfiling()
+
+	if config.DryRun {
+		logger.infof("Dry-Run mode activated. No modifications happening\n")
+	}
+
+	state, err := loadState(config.InState)
+	if err != nil {
+		return failf(ExitFailed, "Error reading state: %s", err)
+	}
+
+	templates, err := initTemplates()
+	if err != nil {
+		return failf(ExitConfig, "Unable to initialize templates: %s", err)
+	}
+	if campaign != nil {
+		if err := templates.addTemplate(campaignTemplate, config.CampaignTemplate); err != nil {
+			return failf(ExitConfig, "Unable to initialize campaign template: %s", err)
+		}
+	}
+	var client *cfclient.Client
+	var rateLimiter *rateLimitTransport
+	if config.Fixtures != "" {
+		client, rateLimiter, err = newFixtureClient(config.Fixtures)
+	} else {
+		client, rateLimiter, err = newCFClient(cfAPIConfig, settings.transport, insecure, logger)
+	}
+	if err != nil {
+		return failf(ExitCFAPI, "Unable to create client. Error: %s", err.Error())
+	}
+	// Stopping the run leaves the state as it was, since the apps it didn't
+	// get to would be lost otherwise. The e-mails already sent are skipped
+	// by resuming the run from its checkpoint.
+	ctx, stop := withShutdown(context.Background(), logger)
+	defer stop()
+	client.Config.HttpClient = withContext(client.Config.HttpClient, ctx)
+	runTracer = settings.tracer
+	runSpan := runTracer.startRun(logFields{"run_id", runID})
+	errs := &runErrors{logger: logger}
+	if mailer != nil {
+		mailer = withRunID(mailer, runID)
+		if config.EmailsPerSecond > 0 {
+			mailer = newThrottledMailer(mailer, config.EmailsPerSecond)
+		}
+		if runTracer != nil {
+			mailer = &tracedMailer{Mailer: mailer}
+		}
+		mailer = errs.trackSends(mailer)
+	}
+	if config.CheckpointDir != "" && mailer != nil && !config.DryRun {
+		checkpoint, err := newCheckpointMailer(mailer, config.CheckpointDir, runID, config.Resume != "", logger)
+		if err != nil {
+			return failf(ExitFailed, "Unable to open checkpoint. Error: %s", err)
+		}
+		defer checkpoint.close()
+		mailer = checkpoint
+	}
+	if mailer != nil {
+		mailer = &interruptibleMailer{Mailer: mailer, ctx: ctx}
+	}
+	var notificationAudit *notificationAuditLog
+	if config.NotificationAuditLog != "" {
+		if notificationAudit, err = openNotificationAuditLog(config.NotificationAuditLog, runID); err != nil {
+			return failf(ExitFailed, "Unable to open notification audit log. Error: %s", err)
+		}
+	}
+	interrupted := func() error {
+		if err := notificationAudit.close(); err != nil {
+			logger.errorf("Unable to close notification audit log. Error: %s", err)
+		}
+		if !config.ReadOnly {
+			if err := copyState(config.InState, config.OutState); err != nil {
+				logger.errorf("Error copying state: %s", err)
+			}
+		}
+		if config.CheckpointDir != "" && !config.DryRun {
+			return failf(ExitInterrupted, "Run %s was interrupted and left the state alone. Resume it with --resume %s to skip the e-mails it sent.", runID, runID)
+		}
+		return failf(ExitInterrupted, "Run %s was interrupted and left the state alone.", runID)
+	}
+	// fail returns the failure stopping the run, which is the run being
+	// interrupted if it was.
+	fail := func(code int, format string, args ...interface{}) error {
+		if ctx.Err() != nil {
+			logger.errorf(format, args...)
+			return interrupted()
+		}
+		return failf(code, format, args...)
+	}
+	// listOpts records whether the listings were cut short by CF_MAX_PAGES
+	// or CF_ALLOW_PARTIAL_RESULTS, in which case the apps on the pages left
+	// out weren't checked.
+	listOpts := cfAPIConfig.listOptions()
+	listOpts.Truncated = &atomic.Bool{}
+	listOpts.Logger = logger
+	report := newRunReport()
+	owners.report, managers.report = report, report
+	owners.roleCache, managers.roleCache = newRoleCache(), newRoleCache()
+	// timePhase starts the span of a phase of the run, and returns the
+	// function ending it that records its timing in the report.
+	timePhase := func(name string, fields logFields) func(error) {
+		span := startPhase(name, fields)
+		start, requests := time.Now(), rateLimiter.requestCount()
+		return func(err error) {
+			span.finish(err)
+			report.recordPhase(name, time.Since(start), rateLimiter.requestCount()-requests)
+		}
+	}
+	// triggers are the buildpacks or stacks the run notified about, for the
+	// operator summary.
+	var triggers []string
+	// snapshot sums up the compliance of the foundation for the history
+	// once the state is saved.
+	var snapshot runSnapshot
+	// nothingToDo is set when no buildpack was updated and nothing else
+	// needed the apps to be checked, so the run skipped them.
+	var nothingToDo bool
+	switch {
+	case campaign != nil:
+		logger.infof("Campaign mode activated. Notifying the owners of every app using %s.\n", config.CampaignBuildpack)
+		triggers = []string{config.CampaignBuildpack}
+		finishPhase := timePhase("campaign", logFields{"buildpack", config.CampaignBuildpack})
+		err := runCampaign(client, campaign, scope, listOpts, cfAPIConfig.DropletConcurrency, owners, templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, report, errs, logger)
+		finishPhase(err)
+		if err != nil {
+			return fail(ExitCFAPI, "Unable to run campaign. Error: %s", err)
+		}
+	case eol != nil:
+		logger.infof("Stack end of life mode activated. Notifying the owners of every app running on %s.\n", strings.Join(config.EOLStacks, ", "))
+		triggers = config.EOLStacks
+		finishPhase := timePhase("stack end of life", logFields{"stacks", config.EOLStacks})
+		err := runStackEOL(client, eol, scope, listOpts, cfAPIConfig.DropletConcurrency, owners, templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, report, errs, logger)
+		finishPhase(err)
+		if err != nil {
+			return fail(ExitCFAPI, "Unable to notify about end of life stacks. Error: %s", err)
+		}
+	default:
+		logger.infof("Calculating notifications to send for outdated buildpacks.\n")
+		finishPhase := timePhase("list buildpacks", nil)
+		// The buildpacks as of the last run tell the version of the apps
+		// staged since, when their droplets don't record it.
+		previousBuildpacks := make(map[string]buildpackRecord, len(state.Buildpacks))
+		for guid, record := range state.Buildpacks {
+			previousBuildpacks[guid] = record
+		}
+		buildpacks, disabledBuildpacks, buildpackState, err := getUpdatedBuildpacks(client, state.Buildpacks, listOpts, scope.buildpacks, config.IncludeDisabledBuildpacks, errs, logger)
+		finishPhase(err)
+		if err != nil {
+			return fail(ExitCFAPI, "Unable to check buildpacks for updates. Error: %s", err)
+		}
+		if len(buildpacks) == 0 && !config.needsAppsWithoutUpdates(state, restage) {
+			logger.infof("No buildpacks were updated since the last run, nothing to do.\n")
+			nothingToDo = true
+			break
+		}
+		state.Buildpacks = buildpackState
+		for name := range buildpacks {
+			triggers = append(triggers, name)
+		}
+		sort.Strings(triggers)
+		warnUnmappedBuildpacks(buildpacks, settings.links, logger)
+		addBuildpackAliases(buildpacks, config.BuildpackAliases)
+		// The apps are checked a page at a time as they are listed. Only
+		// the outdated apps and the apps the state keeps track of are kept
+		// past their page.
+		var outdatedApps, gitBuildpackApps, checkedApps []appInfo
+		// The apps the last run couldn't check are checked again, against
+		// the buildpacks it couldn't check them against as well.
+		uncheckedApps := state.UncheckedApps
+		state.UncheckedApps = make(map[string]uncheckedApp)
+		finishPhase = timePhase("find outdated apps", nil)
+		spaces, err := listAppsWithSpacesByPage(client, listOpts, func(apps []App, pageSpaces map[string]spaceInfo) {
+			apps, retriedApps := splitUncheckedApps(filterAppsByScope(apps, pageSpaces, scope, report, logger), uncheckedApps)
+			check := func(apps []App, buildpacks map[string]Buildpack) {
+				outdated, gitApps, checked := findOutdatedApps(client, apps, buildpacks, disabledBuildpacks, settings.links, config.ClockSkewTolerance, cfAPIConfig.DropletConcurrency, report, errs, logger)
+				outdatedApps = append(outdatedApps, outdated...)
+				gitBuildpackApps = append(gitBuildpackApps, gitApps...)
+				checkedApps = append(checkedApps, state.trackedApps(checked)...)
+			}
+			check(apps, buildpacks)
+			for _, app := range retriedApps {
+				check([]App{app}, uncheckedApps[app.GUID].buildpacksToCheck(buildpacks))
+			}
+		})
+		finishPhase(err)
+		if err != nil {
+			return fail(ExitCFAPI, "Unable to get apps. Error: %s", err)
+		}
+		recordUncheckedApps(report, buildpacks, uncheckedApps, state.UncheckedApps, time.Now(), logger)
+		resolveVersionsFromHistory(outdatedApps, buildpacks, previousBuildpacks)
+		verifier := newReleaseVerifier(config.GitHubToken, settings.links, state.Releases, releaseOptions{
+			verifyTags:   config.VerifyReleaseTags,
+			includeNotes: config.IncludeReleaseNotes,
+			countBehind:  config.CountReleasesBehind,
+		}, logger)
+		if verifier != nil && config.NVDSeverities {
+			verifier.cves = newCVEEnricher(config.NVDAPIKey, state.CVESeverities, logger)
+		}
+		verifier.verifyApps(outdatedApps, report)
+		outdatedApps = takeHeldNotifications(outdatedApps, checkedApps, state.HeldNotifications, report, logger)
+		settings.calendar.annotate(outdatedApps, time.Now())
+		restagedApps := resolveRestagedApps(checkedApps, outdatedApps, state.Apps, time.Now())
+		logger.infof("%d apps were restaged since their owners were notified.\n", len(restagedApps))
+		if config.SendRestageConfirmations {
+			restagedOwners := findOwnersOfApps(restagedApps, client, owners, errs, logger)
+			logger.infof("Will thank %d owners of restaged apps.\n", len(restagedOwners))
+			sendRestagedEmailToUsers(restagedOwners, templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, errs, logger)
+		}
+		if restage != nil {
+			var approval *restageApproval
+			if config.RestageApproval {
+				approval = &restageApproval{token: config.RestageApprovalToken, plan: state.RestagePlan, logger: logger}
+			}
+			var audit *restageAuditLog
+			if config.RestageAuditLog != "" {
+				if audit, err = openRestageAuditLog(config.RestageAuditLog, runID); err != nil {
+					return fail(ExitFailed, "Unable to open restage audit log. Error: %s", err)
+				}
+			}
+			var canaryFailures []canaryFailure
+			outdatedApps, canaryFailures = runRestages(outdatedApps, checkedApps, spaces, *restage, newRestager(client, config.RestageTimeout, config.RestageHealthTimeout, logger), config.restageLimits(), audit, state.RestageQueue, approval, time.Now(), config.DryRun, errs, logger)
+			if err := audit.close(); err != nil {
+				errs.addf("Unable to close restage audit log. Error: %s", err)
+			}
+			sendCanaryFailureEmails(canaryFailures, config.RestageAlertEmails, templates, mailer, config.DryRun, errs, logger)
+			if approval != nil {
+				state.RestagePlan = approval.plan
+				if approval.changed && approval.plan != nil {
+					sendRestagePlanEmails(approval.plan, spaces, config.RestageApprovalEmails, templates, mailer, config.DryRun, errs, logger)
+				}
+			}
+		}
+		finishPhase = timePhase("find owners", logFields{"apps", len(outdatedApps)})
+		outdatedOwners, unownedApps := findOwnersOfAppsWithFailures(outdatedApps, client, owners, errs, logger)
+		outdatedOwners = onlyRecipients(outdatedOwners)
+		finishPhase(nil)
+		if config.Limit > 0 {
+			var heldApps []appInfo
+			outdatedOwners, heldApps = limitNotifications(outdatedOwners, config.Limit)
+			if len(heldApps) > 0 {
+				logger.warnf("Reached the limit of %d e-mails. Holding back the notifications about %d apps for the next run.\n", config.Limit, len(heldApps))
+				for _, app := range heldApps {
+					logger.with(app.logFields()).infof("Held back the notification about app %s guid %s.\n", app.Name, app.GUID)
+					report.traceApp(app.App, "limit", "held back", fmt.Sprintf("the run reached the limit of %d e-mails", config.Limit))
+					report.recordApp(app.App, decisionHeldBack)
+					notificationAudit.record("", []appInfo{app}, notificationOutdated, notificationHeldBack, nil, errs)
+				}
+				outdatedApps = holdNotifications(outdatedApps, heldApps, state.HeldNotifications, time.Now())
+			}
+		}
+		report.recordOwners(outdatedOwners)
+		logger.infof("Will notify %d owners of outdated apps.\n", len(outdatedOwners))
+		finishPhase = timePhase("send e-mails", logFields{"owners", len(outdatedOwners)})
+		failedOwners := sendNotifyEmailToUsers(outdatedOwners, templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, errs, logger)
+		finishPhase(nil)
+		outdatedApps = holdFailedNotifications(outdatedApps, unownedApps, outdatedOwners, failedOwners, state.HeldNotifications, time.Now(), logger)
+		outdatedApps = recordAppNotifications(outdatedApps, state.Apps, time.Now())
+		if config.EscalateAfter > 0 || config.SecurityEscalateAfter > 0 {
+			escalatedApps := filterForAppsToEscalate(outdatedApps, config.EscalateAfter, config.SecurityEscalateAfter)
+			escalationManagers := findOwnersOfApps(escalatedApps, client, managers, errs, logger)
+			logger.infof("Will escalate %d apps to %d managers.\n", len(escalatedApps), len(escalationManagers))
+			sendEscalationEmailToUsers(escalationManagers, templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, errs, logger)
+		}
+		if restage != nil && restage.waitsForNotifications() {
+			now := time.Now()
+			scheduledApps := scheduleRestages(outdatedApps, spaces, *restage, state.Apps, state.RestageQueue, now, logger)
+			warnedOwners := findOwnersOfApps(scheduledApps, client, owners, errs, logger)
+			logger.infof("Will warn %d owners of %d apps about their upcoming restage.\n", len(warnedOwners), len(scheduledApps))
+			sendRestageWarningEmailToUsers(warnedOwners, now.Add(restage.notice), templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, errs, logger)
+		}
+		if config.NotifyPinnedBuildpacks {
+			var pinnedApps []appInfo
+			pinnedApps, state.PinnedBuildpackWarnings = filterForNewPinnedBuildpacks(gitBuildpackApps, state.PinnedBuildpackWarnings, logger)
+			pinnedOwners := findOwnersOfApps(pinnedApps, client, owners, errs, logger)
+			logger.infof("Will warn %d owners of apps using pinned custom buildpacks.\n", len(pinnedOwners))
+			sendPinnedBuildpackEmailToUsers(pinnedOwners, templates, mailer, config.EmailConcurrency, config.DryRun, notificationAudit, errs, logger)
+		}
+		snapshot = newRunSnapshot(runID, report, restagedApps, state.Apps, time.Now())
+	}
+	report.logSummary(logger)
+	if config.ListOutdated != nil {
+		if err := report.writeOutdated(config.ListOutdated); err != nil {
+			errs.addf("Unable to list outdated apps. Error: %s", err)
+		}
+	}
+	writeReports(config, report, runID, errs)
+	if err := notificationAudit.close(); err != nil {
+		errs.addf("Unable to close notification audit log. Error: %s", err)
+	}
+	if len(config.OperatorSummaryEmails) > 0 && mailer != nil && ctx.Err() == nil {
+		summary := newOperatorSummary(runID, config.DryRun, triggers, report, errs)
+		sendOperatorSummaryEmails(summary, config.OperatorSummaryEmails, templates, mailer, config.DryRun, errs, logger)
+	}
+	if waits, waited := rateLimiter.stats(); waits > 0 {
+		logger.infof("Spent %s throttled by CF API rate limiting across %d waits.\n", waited, waits)
+	}
+	if len(settings.metrics) > 0 {
+		metrics := collectRunMetrics(runID, report, errs, rateLimiter.requestCount(), time.Since(started), time.Now())
+		for _, publisher := range settings.metrics {
+			if err := publisher.publish(metrics); err != nil {
+				logger.warnf("Unable to publish the metrics of the run. Error: %s\n", err)
+			}
+		}
+	}
+	if runTracer != nil {
+		var runErr error
+		if ctx.Err() != nil {
+			runErr = errors.New("The run was interrupted")
+		} else if errs.count() > 0 {
+			runErr = errors.Errorf("The run completed with %d errors", errs.count())
+		}
+		runSpan.finish(runErr)
+		if err := runTracer.export(); err != nil {
+			logger.warnf("Unable to export the trace of the run. Error: %s\n", err)
+		}
+	}
+	if ctx.Err() != nil {
+		errs.logSummary()
+		return interrupted()
+	}
+
+	// Campaigns and stack end of life notifications don't look at buildpack
+	// updates so they leave the state alone. Runs narrowed on the command
+	// line leave it alone too, so that the rest of the foundation is still
+	// notified about the updates, and so do runs whose listings were cut
+	// short, so that the apps left out are checked by the next run. Runs
+	// that failed on some apps or owners leave it alone as well, like
+	// interrupted runs, so that the next run notifies them again. Runs that
+	// had nothing to do leave it alone too. Simulations don't write it at
+	// all.
+	truncated := listOpts.Truncated.Load()
+	if truncated && !config.ReadOnly {
+		logger.warnf("The listings of the CF API were cut short by CF_MAX_PAGES or CF_ALLOW_PARTIAL_RESULTS. Leaving the state alone so that the next run checks the apps left out.\n")
+	}
+	switch {
+	case config.ReadOnly:
+	case config.DryRun || campaign != nil || eol != nil || scope.isNarrowed() || nothingToDo || truncated:
+		if err := copyState(config.InState, config.OutState); err != nil {
+			return failf(ExitFailed, "Error copying state: %s", err)
+		}
+	default:
+		if err := saveState(state, config.OutState); err != nil {
+			return failf(ExitFailed, "Error saving state: %s", err)
+		}
+		if config.HistoryFile != "" {
+			if err := saveRunSnapshot(config.HistoryFile, snapshot); err != nil {
+				errs.addf("Unable to record the run in the history. Error: %s", err)
+			}
+		}
+	}
+
+	if errs.count() > 0 {
+		errs.logSummary()
+		config.ErrorReporter.captureErrors(errs.messages())
+	}
+	return errs.failure()
+}
+
+func filterForNewlyUpdatedBuildpacks(buildpacks []Buildpack, state map[string]buildpackRecord, filter resourceFilter, errs *runErrors, logger *Logger) ([]Buildpack, map[string]buildpackRecord) {
+	filteredBuildpacks := []Buildpack{}
+	// Go through the passed in buildpacks
+	// Check if current buildpack.guid matches a guid in storeBuildpacks
+	// 1) If so, compare the buildpack.Meta.UpdatedAt with the storeBuildpack.LastUpdatedAt
+	// 1a)   If buildpack.Meta.UpdatedAt (updated recently) > storeBuildpack.LastUpdatedAt,
+	//       then add to filteredBuildpacks and updated database, unless the
+	//       buildpack.Filename is still the storeBuildpack.Filename
+	// 1b)   Else, continue
+	// 2) If not, add to filteredBuildpacks and updated database
+	// for buildpacks return buildpack.guid in stored.
+
+	for _, buildpack := range buildpacks {
+		// Buildpacks filtered out of this run are left untouched in the state
+		// so that a later run still picks up their updates.
+		if !filter.allows(buildpack.Name, buildpack.GUID) {
+			logger.with(buildpackFields(buildpack)).infof("Buildpack %s guid %s skipped because it is filtered out\n", buildpack.Name, buildpack.GUID)
+			continue
+		}
+		storedBuildpack, found := state[buildpack.GUID]
+		if !found {
+			filteredBuildpacks = append(filteredBuildpacks, buildpack)
+			state[buildpack.GUID] = buildpackRecord{LastUpdatedAt: buildpack.UpdatedAt, Filename: buildpack.Filename}
+		} else {
+			buildpackUpdatedAt, err := time.Parse(time.RFC3339, buildpack.UpdatedAt)
+			if err != nil {
+				errs.addf("Unable to parse buildpack updatedAt time. Buildpack GUID %s Error %s",
+					buildpack.GUID, err)
+				continue
+			}
+			storedBuildpackUpdatedAt, err := time.Parse(time.RFC3339, storedBuildpack.LastUpdatedAt)
+			if err != nil {
+				errs.addf("Unable to parse stored buildpack LastUpdatedAt time. Buildpack GUID %s Error %s",
+					buildpack.GUID, err)
+				continue
+			}
+			if !buildpackUpdatedAt.After(storedBuildpackUpdatedAt) {
+				logger.with(buildpackFields(buildpack)).infof("Supported Buildpack %s has not been updated\n", buildpack.Name)
+				continue
+			}
+			state[buildpack.GUID] = buildpackRecord{LastUpdatedAt: buildpack.UpdatedAt, Filename: buildpack.Filename}
+			// Changing the position or lock of a buildpack also touches its
+			// update time, but apps only need restaging when a new file was
+			// uploaded.
+			if storedBuildpack.Filename != "" && storedBuildpack.Filename == buildpack.Filename {
+				logger.with(buildpackFields(buildpack)).infof("Supported Buildpack %s was updated without uploading a new version\n", buildpack.Name)
+				continue
+			}
+			filteredBuildpacks = append(filteredBuildpacks, buildpack)
+		}
+	}
+
+	return filteredBuildpacks, state
+}
+
+// getUpdatedBuildpacks returns the buildpacks updated since the last run,
+// keyed by name, along with the names of the buildpacks that are disabled.
+// Disabled buildpacks are left out unless includeDisabled is set, since apps
+// can't restage against them. Failing to list the buildpacks is an error for
+// the whole run.
+func getUpdatedBuildpacks(client *cfclient.Client, state map[string]buildpackRecord, listOpts ListOptions, buildpackFilter resourceFilter, includeDisabled bool, errs *runErrors, logger *Logger) (map[string]Buildpack, map[string]bool, map[string]buildpackRecord, error) {
+	// Get all the buildpacks from our CF deployment via CF_API.
+	buildpackList, err := ListBuildpacks(client, listOpts)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "Unable to get buildpacks")
+	}
+	disabled := map[string]bool{}
+	if !includeDisabled {
+		buildpackList, disabled = splitDisabledBuildpacks(buildpackList, logger)
+	}
+	filteredBuildpackList, state := filterForNewlyUpdatedBuildpacks(buildpackList, state, buildpackFilter, errs, logger)
+
+	// Create a map with the key being the buildpack name for quick comparison later on.
+	buildpacks := make(map[string]Buildpack)
+	for _, buildpack := range filteredBuildpackList {
+		buildpacks[buildpack.Name] = buildpack
+	}
+	return buildpacks, disabled, state, nil
+}
+
+// splitDisabledBuildpacks drops the disabled buildpacks from buildpacks. It
+// also returns the names no enabled buildpack goes by, so that apps staged with
+// them can be told apart from apps using buildpacks that weren't updated. A
+// name with a buildpack enabled on another stack isn't disabled.
+func splitDisabledBuildpacks(buildpacks []Buildpack, logger *Logger) ([]Buildpack, map[string]bool) {
+	var enabled []Buildpack
+	disabled := map[string]bool{}
+	for _, buildpack := range buildpacks {
+		if buildpack.Enabled {
+			enabled = append(enabled, buildpack)
+			continue
+		}
+		// Disabled buildpacks are left untouched in the state so that their
+		// updates are still picked up if they are enabled again.
+		logger.with(buildpackFields(buildpack)).infof("Buildpack %s guid %s skipped because it is disabled\n", buildpack.Name, buildpack.GUID)
+		disabled[buildpack.Name] = true
+	}
+	for _, buildpack := range enabled {
+		delete(disabled, buildpack.Name)
+	}
+	return enabled, disabled
+}
+
+func deduplicateBuildpacks(allBuildpacks []buildpackReleaseInfo) []buildpackReleaseInfo {
+	// The notes and security fixes come with the release, so the name,
+	// version and link tell releases apart.
+	type releaseKey struct{ name, version, url string }
+	keys := make(map[releaseKey]bool)
+	deduplicated := []buildpackReleaseInfo{}
+	for _, entry := range allBuildpacks {
+		key := releaseKey{entry.BuildpackName, entry.BuildpackVersion, entry.BuildpackURL}
+		if _, value := keys[key]; !value {
+			keys[key] = true
+			deduplicated = append(deduplicated, entry)
+		}
+	}
+	return deduplicated
+}
+
+// resolveBuildpackAlias follows the aliases of a renamed buildpack, which may
+// have been renamed more than once, to the name it goes by now.
+func resolveBuildpackAlias(name string, aliases map[string]string) string {
+	// Stop after as many steps as there are aliases in case they loop.
+	for i := 0; i < len(aliases); i++ {
+		next, found := aliases[name]
+		if !found {
+			break
+		}
+		name = next
+	}
+	return name
+}
+
+// addBuildpackAliases makes droplets staged with an old name of a buildpack
+// resolve to the buildpack that replaced it.
+func addBuildpackAliases(buildpacks map[string]Buildpack, aliases map[string]string) {
+	for alias := range aliases {
+		if _, found := buildpacks[alias]; found {
+			// An updated buildpack still goes by this name, leave it be.
+			continue
+		}
+		if buildpack, found := buildpacks[resolveBuildpackAlias(alias, aliases)]; found {
+			buildpacks[alias] = buildpack
+		}
+	}
+}
+
+// getSupportedBuildpacksOfDroplet checks the buildpacks the droplet is using and returns every one of them
+// that is a provided system buildpack. Apps can use more than one buildpack, e.g. nodejs and python.
+func getSupportedBuildpacksOfDroplet(droplet Droplet, buildpacks map[string]Buildpack) []Buildpack {
+	var supported []Buildpack
+	for _, dropletBuildpack := range droplet.Buildpacks {
+		if buildpack, found := buildpacks[dropletBuildpack.Name]; found && dropletBuildpack.Name != "" {
+			supported = append(supported, buildpack)
+		}
+	}
+	return supported
+}
+
+// dropletBuildpackVersion returns the version of buildpack the droplet was
+// staged with, if recorded, whether under its name or one of its aliases.
+func dropletBuildpackVersion(droplet Droplet, buildpacks map[string]Buildpack, buildpack Buildpack) string {
+	return findDropletBuildpack(droplet, buildpacks, buildpack).Version
+}
+
+// findDropletBuildpack returns what the droplet records of buildpack, or
+// nothing if it wasn't staged with it.
+func findDropletBuildpack(droplet Droplet, buildpacks map[string]Buildpack, buildpack Buildpack) DropletBuildpack {
+	for _, dropletBuildpack := range droplet.Buildpacks {
+		if found, ok := buildpacks[dropletBuildpack.Name]; ok && found.Name == buildpack.Name {
+			return dropletBuildpack
+		}
+	}
+	return DropletBuildpack{}
+}
+
+// resolveVersionsFromHistory sets the version of the outdated buildpacks apps
+// were staged with when their droplets don't record it, from the buildpacks
+// as of the last run: apps staged after the last update before this run were
+// staged with the file uploaded then, unless it was updated again since
+// unnoticed.
+func resolveVersionsFromHistory(apps []appInfo, buildpacks map[string]Buildpack, previous map[string]buildpackRecord) {
+	for _, app := range apps {
+		stagedAt, err := time.Parse(time.RFC3339, app.StagedAt)
+		if err != nil {
+			continue
+		}
+		for i, info := range app.Buildpacks {
+			if info.CurrentVersion != "" {
+				continue
+			}
+			record, found := previous[buildpacks[info.BuildpackName].GUID]
+			if !found || record.Filename == "" {
+				continue
+			}
+			updatedAt, err := time.Parse(time.RFC3339, record.LastUpdatedAt)
+			if err == nil && !stagedAt.Before(updatedAt) {
+				app.Buildpacks[i].CurrentVersion = parseBuildpackVersion(record.Filename)
+			}
+		}
+	}
+}
+
+// usesDisabledBuildpack reports whether any of the buildpacks the droplet was
+// staged with is disabled.
+func usesDisabledBuildpack(droplet Droplet, disabledBuildpacks map[string]bool) bool {
+	for _, dropletBuildpack := range droplet.Buildpacks {
+		if disabledBuildpacks[dropletBuildpack.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseInfo gets the release information of a buildpack to pass along to the user.
+func (l buildpackLinks) releaseInfo(buildpack Buildpack) buildpackReleaseInfo {
+	buildpackReleaseURL := l.releaseURL(buildpack.Name)
+	buildpackVersion := parseBuildpackVersion(buildpack.Filename)
+	buildpackVersionURL := getBuildpackVersionURL(buildpackReleaseURL, buildpackVersion)
+
+	return buildpackReleaseInfo{
+		BuildpackName:    buildpack.Name,
+		BuildpackVersion: buildpackVersion,
+		BuildpackURL:     buildpackVersionURL,
+		Docs:             l.docs[buildpack.Name],
+	}
+}
+
+// isDropletUsingOutdatedBuildpack checks if the droplet was created before the last time the buildpack was updated.
+// This comparison is the heart of checking whether the app needs an update.
+// Droplets created no more than tolerance before the update are considered
+// current, since apps restaged during a rollout may be stamped slightly earlier.
+// Format of time stamp: 2016-06-08T16:41:45Z
+func isDropletUsingOutdatedBuildpack(client *cfclient.Client, droplet Droplet, buildpack Buildpack, tolerance time.Duration) (bool, error) {
+	timeOfLastAppRestage, err := time.Parse(time.RFC3339, droplet.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("Unable to parse last restage time. Droplet GUID %s Error %s",
+			droplet.GUID, err)
+	}
+	timeOfLastBuildpackUpdate, err := time.Parse(time.RFC3339, buildpack.UpdatedAt)
+	if err != nil {
+		return false, fmt.Errorf("Unable to parse last buildpack update time. Buildpack %s Buildpack GUID %s Error %s",
+			buildpack.Name, buildpack.GUID, err)
+	}
+	return timeOfLastBuildpackUpdate.After(timeOfLastAppRestage.Add(tolerance)), nil
+}
+
+// appInfo is an app along with the space and organization it belongs to,
+// which is everything an owner needs to target and restage it, and the
+// outdated buildpacks or custom git buildpacks it uses.
+type appInfo struct {
+	App
+	Space       Space
+	Org         Organization
+	Stack       string
+	DropletGUID string
+	// StagedAt is when the current droplet was staged, for outdated apps.
+	StagedAt      string `json:",omitempty"`
+	Buildpacks    []buildpackReleaseInfo
+	GitBuildpacks []gitBuildpack
+	// Notifications counts how many times owners were told about the app
+	// being outdated without it being restaged since.
+	Notifications int
+	// recipients, when set, are the only owners left to notify about the
+	// app, for a notification carried forward after reaching the others.
+	recipients []string
+}
+
+// spaceInfo is a space along with the organization it belongs to.
+type spaceInfo struct {
+	Space Space
+	Org   Organization
+}
+
+// listAppsWithSpaces lists every app along with the space and organization
+// each of them is in, keyed by space GUID.
+func listAppsWithSpaces(client *cfclient.Client, listOpts ListOptions) ([]App, map[string]spaceInfo, error) {
+	apps := []App{}
+	spaces, err := listAppsWithSpacesByPage(client, listOpts, func(page []App, _ map[string]spaceInfo) {
+		apps = append(apps, page...)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return apps, spaces, nil
+}
+
+// listAppsWithSpacesByPage hands each page of apps to handle along with the
+// spaces of the page, so that large foundations are checked a page at a time
+// rather than holding every app at once. It returns the spaces of every page,
+// which are far fewer than the apps.
+func listAppsWithSpacesByPage(client *cfclient.Client, listOpts ListOptions, handle func(apps []App, spaces map[string]spaceInfo)) (map[string]spaceInfo, error) {
+	orgs := make(map[string]Organization)
+	spaces := make(map[string]spaceInfo)
+	err := ListAppsByPage(client, listOpts, func(page AppResponse) {
+		for _, org := range page.Included.Organizations {
+			orgs[org.GUID] = org
+		}
+		pageSpaces := make(map[string]spaceInfo)
+		for _, space := range page.Included.Spaces {
+			orgGUID := space.Relationships.Organization.Data.GUID
+			org, found := orgs[orgGUID]
+			if !found {
+				org = Organization{GUID: orgGUID}
+			}
+			pageSpaces[space.GUID] = spaceInfo{Space: space, Org: org}
+			spaces[space.GUID] = pageSpaces[space.GUID]
+		}
+		handle(page.Apps, pageSpaces)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get apps")
+	}
+	return spaces, nil
+}
+
+// spaceUser is a user along with every role they hold in a single space,
+// including the roles they hold in the organization of the space.
+type spaceUser struct {
+	User
+	SpaceRoles []string
+}
+
+// ownerSettings decide who is notified about an app and how they are reached.
+type ownerSettings struct {
+	roles               ownerRoles
+	resolveEmailsViaUAA bool
+	// report records the users dropped for not having an e-mail address,
+	// if set. Its relationships resolve the apps to their space and org, so
+	// that the spaces listed along with the apps aren't requested again.
+	report *runReport
+	// concurrency bounds the requests listing roles sent at a time.
+	concurrency int
+	// roleCache keeps the roles listed across the lookups of a run, so that
+	// the roles of a space are listed once however many lookups it is part
+	// of. Each lookup lists them anew if it isn't set.
+	roleCache *roleCache
+}
+
+// roleCache holds the holders of the owner roles in each space and
+// organization, by GUID.
+type roleCache struct {
+	spaces map[string][]spaceUser
+	orgs   map[string][]spaceUser
+}
+
+func newRoleCache() *roleCache {
+	return &roleCache{spaces: make(map[string][]spaceUser), orgs: make(map[string][]spaceUser)}
+}
+
+type cfSpaceCache struct {
+	roles      ownerRoles
+	emails     *uaaEmailCache
+	relations  *relationships
+	spaceRoles map[string][]spaceUser
+	orgRoles   map[string][]spaceUser
+	spaceUsers map[string]map[string]spaceUser
+	orgUsers   map[string]map[string]spaceUser
+	report     *runReport
+	logger     *Logger
+	// concurrency bounds the requests listing roles sent at a time.
+	concurrency int
+}
+
+func createCFSpaceCache(settings ownerSettings, client *cfclient.Client, errs *runErrors, logger *Logger) *cfSpaceCache {
+	var emails *uaaEmailCache
+	if settings.resolveEmailsViaUAA {
+		emails = newUAAEmailCache(client, errs, logger)
+	}
+	relations := newRelationships()
+	if settings.report != nil {
+		relations = settings.report.relationships
+	}
+	roles := settings.roleCache
+	if roles == nil {
+		roles = newRoleCache()
+	}
+	return &cfSpaceCache{
+		roles:       settings.roles,
+		emails:      emails,
+		relations:   relations,
+		spaceRoles:  roles.spaces,
+		orgRoles:    roles.orgs,
+		spaceUsers:  make(map[string]map[string]spaceUser),
+		orgUsers:    make(map[string]map[string]spaceUser),
+		report:      settings.report,
+		logger:      logger,
+		concurrency: settings.concurrency,
+	}
+}
+
+// filterForValidEmailUsernames drops the users whose username isn't an e-mail
+// address. When emails is set, their e-mail address is looked up in UAA first
+// and used as their username. The users dropped are recorded in report.
+func filterForValidEmailUsernames(users []spaceUser, app appInfo, emails *uaaEmailCache, report *runReport, logger *Logger) []spaceUser {
+	var filteredUsers []spaceUser
+	for _, user := range users {
+		if _, err := mail.ParseAddress(user.Username); err == nil {
+			filteredUsers = append(filteredUsers, user)
+			continue
+		}
+		if emails != nil {
+			if email, found := emails.getEmail(user.User); found {
+				user.Username = email
+				filteredUsers = append(filteredUsers, user)
+				continue
+			}
+		}
+		logger.with(app.logFields().with("user_guid", user.GUID)).debugf("Dropping notification to user %s about app %s in space %s because "+
+			"invalid e-mail address\n", user.Username, app.Name, app.Space.GUID)
+		report.recordDroppedUser(user.Username)
+	}
+	return filteredUsers
+}
+
+// groupRolesByUser collapses the individual V3 roles of a space into one entry
+// per user listing all of the roles that user holds.
+func groupRolesByUser(roles []Role, users []User) []spaceUser {
+	usersByGUID := make(map[string]User)
+	for _, user := range users {
+		usersByGUID[user.GUID] = user
+	}
+	var order []string
+	grouped := make(map[string]*spaceUser)
+	for _, role := range roles {
+		userGUID := role.Relationships.User.Data.GUID
+		entry, found := grouped[userGUID]
+		if !found {
+			user, ok := usersByGUID[userGUID]
+			if !ok {
+				user = User{GUID: userGUID}
+			}
+			entry = &spaceUser{User: user}
+			grouped[userGUID] = entry
+			order = append(order, userGUID)
+		}
+		entry.SpaceRoles = append(entry.SpaceRoles, role.Type)
+	}
+	spaceUsers := make([]spaceUser, 0, len(order))
+	for _, userGUID := range order {
+		spaceUsers = append(spaceUsers, *grouped[userGUID])
+	}
+	return spaceUsers
+}
+
+// loadRoles lists the holders of the owner roles in the spaces and
+// organizations of every app in bulk, many spaces or organizations at a time,
+// so that they don't need to be requested one at a time.
+func (c *cfSpaceCache) loadRoles(apps []appInfo, client *cfclient.Client) error {
+	var spaceGUIDs, orgGUIDs []string
+	requested := make(map[string]bool)
+	for _, app := range apps {
+		if _, cached := c.spaceRoles[app.Space.GUID]; !cached && !requested[app.Space.GUID] {
+			requested[app.Space.GUID] = true
+			spaceGUIDs = append(spaceGUIDs, app.Space.GUID)
+		}
+		if _, cached := c.orgRoles[app.Org.GUID]; !cached && !requested[app.Org.GUID] {
+			requested[app.Org.GUID] = true
+			orgGUIDs = append(orgGUIDs, app.Org.GUID)
+		}
+	}
+	var err error
+	if c.roles.includesSpaceRoles() {
+		err = c.loadRolesInBatches(spaceGUIDs, c.spaceRoles, "spaces", func(batch []string) ([]Role, []User, error) {
+			return ListSpaceRoles(client, batch, c.roles.spaceRoleTypes())
+		}, func(role Role) string { return role.Relationships.Space.Data.GUID })
+	}
+	if len(c.roles.orgRoleTypes()) > 0 {
+		orgErr := c.loadRolesInBatches(orgGUIDs, c.orgRoles, "organizations", func(batch []string) ([]Role, []User, error) {
+			return ListOrganizationRoles(client, batch, c.roles.orgRoleTypes())
+		}, func(role Role) string { return role.Relationships.Organization.Data.GUID })
+		if err == nil {
+			err = orgErr
+		}
+	}
+	return err
+}
+
+// loadRolesInBatches lists the roles in guids with list, guidsPerRequest at
+// a time, and caches them by the GUID guidOfRole returns. The batches listed
+// are kept even if others failed, so that only the spaces or organizations
+// of the failed batches are looked up one at a time.
+func (c *cfSpaceCache) loadRolesInBatches(guids []string, cache map[string][]spaceUser, kind string, list func(batch []string) ([]Role, []User, error), guidOfRole func(Role) string) error {
+	batches := guidBatches(len(guids))
+	listed := make([]map[string][]spaceUser, len(batches))
+	errs := make([]error, len(batches))
+	forEachConcurrently(len(batches), c.concurrency, func(b int) {
+		batch := guids[batches[b][0]:batches[b][1]]
+		span := startSpan("list roles", logFields{kind, len(batch)})
+		roles, users, err := list(batch)
+		span.finish(err)
+		if err != nil {
+			errs[b] = errors.Wrapf(err, "Unable to get roles for all users in %s", kind)
+			return
+		}
+		rolesByGUID := make(map[string][]Role)
+		for _, role := range roles {
+			guid := guidOfRole(role)
+			rolesByGUID[guid] = append(rolesByGUID[guid], role)
+		}
+		listed[b] = make(map[string][]spaceUser)
+		for _, guid := range batch {
+			listed[b][guid] = groupRolesByUser(rolesByGUID[guid], users)
+		}
+	})
+	var err error
+	for b := range batches {
+		if errs[b] != nil {
+			if err == nil {
+				err = errs[b]
+			}
+			continue
+		}
+		for guid, users := range listed[b] {
+			cache[guid] = users
+		}
+	}
+	return err
+}
+
+// getSpaceRoles returns every holder of an owner role in the app's space.
+func (c *cfSpaceCache) getSpaceRoles(app appInfo, client *cfclient.Client) ([]spaceUser, error) {
+	if spaceRoles, ok := c.spaceRoles[app.Space.GUID]; ok {
+		return spaceRoles, nil
+	}
+	span := startSpan("list space roles", logFields{"space_guid", app.Space.GUID})
+	roles, users, err := ListSpaceRoles(client, []string{app.Space.GUID}, c.roles.spaceRoleTypes())
+	span.finish(err)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to get roles for all users in space %s", app.Space.Name)
+	}
+	spaceRoles := groupRolesByUser(roles, users)
+	c.spaceRoles[app.Space.GUID] = spaceRoles
+	return spaceRoles, nil
+}
+
+func (c *cfSpaceCache) getOwnersInAppSpace(app appInfo, client *cfclient.Client) (map[string]spaceUser, error) {
+	if owners, ok := c.spaceUsers[app.Space.GUID]; ok {
+		return owners, nil
+	}
+	owners := make(map[string]spaceUser)
+	if c.roles.includesSpaceRoles() {
+		spaceRoles, err := c.getSpaceRoles(app, client)
+		if err != nil {
+			return nil, err
+		}
+		owners = filterForUsersWithRoles(filterForValidEmailUsernames(spaceRoles, app, c.emails, c.report, c.logger), c.roles)
+	}
+	if len(c.roles.orgRoleTypes()) > 0 {
+		orgOwners, err := c.getOwnersInAppOrg(app, client)
+		if err != nil {
+			return nil, err
+		}
+		for guid, orgOwner := range orgOwners {
+			owner, found := owners[guid]
+			if !found {
+				owner = spaceUser{User: orgOwner.User}
+			}
+			owner.SpaceRoles = append(owner.SpaceRoles, orgOwner.SpaceRoles...)
+			owners[guid] = owner
+		}
+	}
+
+	c.spaceUsers[app.Space.GUID] = owners
+
+	return owners, nil
+}
+
+func (c *cfSpaceCache) getOwnersInAppOrg(app appInfo, client *cfclient.Client) (map[string]spaceUser, error) {
+	if owners, ok := c.orgUsers[app.Org.GUID]; ok {
+		return owners, nil
+	}
+	orgRoles, ok := c.orgRoles[app.Org.GUID]
+	if !ok {
+		roles, users, err := ListOrganizationRoles(client, []string{app.Org.GUID}, c.roles.orgRoleTypes())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to get roles for all users in organization %s", app.Org.Name)
+		}
+		orgRoles = groupRolesByUser(roles, users)
+		c.orgRoles[app.Org.GUID] = orgRoles
+	}
+	owners := filterForUsersWithRoles(filterForValidEmailUsernames(orgRoles, app, c.emails, c.report, c.logger), c.roles)
+	c.orgUsers[app.Org.GUID] = owners
+	return owners, nil
+}
+
+// ownerRoles are the V3 role types whose holders are notified about an app.
+// We use a map for quick look-ups and comparisons. Holders of an organization
+// role are notified about the apps in every space of the organization.
+type ownerRoles map[string]bool
+
+// knownOwnerRoles are the role types that can be notified.
+var knownOwnerRoles = map[string]bool{
+	"space_manager":                true,
+	"space_developer":              true,
+	"space_auditor":                true,
+	"space_supporter":              true,
+	"organization_manager":         true,
+	"organization_auditor":         true,
+	"organization_billing_manager": true,
+}
+
+func newOwnerRoles(types []string) (ownerRoles, error) {
+	roles := make(ownerRoles)
+	for _, roleType := range types {
+		roleType = strings.TrimSpace(roleType)
+		if !knownOwnerRoles[roleType] {
+			return nil, fmt.Errorf("unknown role %s", roleType)
+		}
+		roles[roleType] = true
+	}
+	if len(roles) == 0 {
+		return nil, errors.New("at least one role is required")
+	}
+	return roles, nil
+}
+
+func (r ownerRoles) includesSpaceRoles() bool {
+	for roleType := range r {
+		if strings.HasPrefix(roleType, "space_") {
+			return true
+		}
+	}
+	return false
+}
+
+// spaceRoleTypes returns the space roles, sorted so that requests for them
+// are always the same.
+func (r ownerRoles) spaceRoleTypes() []string {
+	return r.typesWithPrefix("space_")
+}
+
+// orgRoleTypes returns the organization roles, sorted so that requests for
+// them are always the same.
+func (r ownerRoles) orgRoleTypes() []string {
+	return r.typesWithPrefix("organization_")
+}
+
+func (r ownerRoles) typesWithPrefix(prefix string) []string {
+	var types []string
+	for roleType := range r {
+		if strings.HasPrefix(roleType, prefix) {
+			types = append(types, roleType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func filterForUsersWithRoles(spaceUsers []spaceUser, filteredRoles map[string]bool) map[string]spaceUser {
+	filteredSpaceUsers := make(map[string]spaceUser)
+	for _, spaceUser := range spaceUsers {
+		if spaceUserHasRoles(spaceUser, filteredRoles) {
+			filteredSpaceUsers[spaceUser.GUID] = spaceUser
+		}
+	}
+	return filteredSpaceUsers
+}
+
+func findOwnersOfApps(apps []appInfo, client *cfclient.Client, settings ownerSettings, errs *runErrors, logger *Logger) map[string][]appInfo {
+	owners, _ := findOwnersOfAppsWithFailures(apps, client, settings, errs, logger)
+	return owners
+}
+
+// findOwnersOfAppsWithFailures maps the owners of apps to the apps they own,
+// like findOwnersOfApps, and returns the apps whose owners couldn't be found
+// as well.
+func findOwnersOfAppsWithFailures(apps []appInfo, client *cfclient.Client, settings ownerSettings, errs *runErrors, logger *Logger) (map[string][]appInfo, []appInfo) {
+	// Mapping of users to the apps.
+	owners := make(map[string][]appInfo)
+	var unowned []appInfo
+	spaceCache := createCFSpaceCache(settings, client, errs, logger)
+	var located []appInfo
+	for _, info := range apps {
+		app := info.App
+		// Get the space and org
+		space, err := spaceCache.relations.spaceOfApp(app, client)
+		if err != nil {
+			errs.addf("Unable to find owners of app %s guid %s. Error: %s", app.Name, app.GUID, err)
+			unowned = append(unowned, info)
+			continue
+		}
+		info.Space = space.Space
+		info.Org = space.Org
+		located = append(located, info)
+	}
+	if err := spaceCache.loadRoles(located, client); err != nil {
+		logger.warnf("Unable to list roles in bulk, looking them up one space or organization at a time. Error: %s\n", err)
+	}
+	for _, info := range located {
+		app := info.App
+		ownersWithSpaceRoles, err := spaceCache.getOwnersInAppSpace(info, client)
+		if err != nil {
+			errs.addf("Unable to find owners of app %s guid %s. Error: %s", app.Name, app.GUID, err)
+			unowned = append(unowned, info)
+			continue
+		}
+		for _, ownerWithSpaceRoles := range ownersWithSpaceRoles {
+			owners[ownerWithSpaceRoles.Username] = append(owners[ownerWithSpaceRoles.Username], info)
+		}
+	}
+	return owners, unowned
+}
+
+// getCurrentDropletForApp will try to query the current droplet.
+// A running app will have 1 droplet associated with it. Apps part way through
+// a deployment may not, in which case the droplet staged by their most recent
+// successful build is used instead.
+func getCurrentDropletForApp(app App, client *cfclient.Client) (Droplet, bool, error) {
+	droplets, err := app.GetDropletsByQuery(client, url.Values{"current": []string{"true"}})
+	if err != nil {
+		return Droplet{}, false, errors.Wrapf(err, "Unable to get droplet for app. App %s App GUID %s",
+			app.Name, app.GUID)
+	}
+	if len(droplets) == 1 {
+		return droplets[0], true, nil
+	}
+	return getLatestBuildDropletForApp(app, client)
+}
+
+// getLatestBuildDropletForApp returns the droplet staged by the most recent
+// successful build of the app, if it has one.
+func getLatestBuildDropletForApp(app App, client *cfclient.Client) (Droplet, bool, error) {
+	builds, err := ListBuilds(client, url.Values{
+		"app_guids": []string{app.GUID},
+		"states":    []string{"STAGED"},
+		"order_by":  []string{"-created_at"},
+	}, ListOptions{PerPage: 1, MaxPages: 1})
+	if err != nil {
+		return Droplet{}, false, errors.Wrapf(err, "Unable to get builds for app. App %s App GUID %s",
+			app.Name, app.GUID)
+	}
+	if len(builds) == 0 || builds[0].Droplet.GUID == "" {
+		return Droplet{}, false, nil
+	}
+	droplet, err := GetDroplet(client, builds[0].Droplet.GUID)
+	if err != nil {
+		return Droplet{}, false, errors.Wrapf(err, "Unable to get droplet of build %s for app. App %s App GUID %s",
+			builds[0].GUID, app.Name, app.GUID)
+	}
+	return droplet, true, nil
+}
+
+// snoozeLabel is the app label teams set to a date, e.g. 2024-09-01, to stop
+// notifications about the app until that date has passed.
+const snoozeLabel = "notify.cloud.gov/snooze-until"
+
+// getSnoozeOfApp returns the date the app is snoozed until, if it's snoozed at now.
+func getSnoozeOfApp(app App, now time.Time, logger *Logger) (string, bool) {
+	until, found := app.Metadata.Labels[snoozeLabel]
+	if !found {
+		return "", false
+	}
+	date, err := time.Parse("2006-01-02", until)
+	if err != nil {
+		logger.with(appFields(app)).warnf("Ignoring snooze of app %s guid %s because %s isn't a date like 2024-09-01\n", app.Name, app.GUID, until)
+		return "", false
+	}
+	// The app stays snoozed for the whole day it's snoozed until.
+	return until, now.Before(date.AddDate(0, 0, 1))
+}
+
+// isAppToCheck reports whether the app is a started buildpack app whose
+// droplet is worth looking up. Apps that aren't are recorded in the report.
+func isAppToCheck(app App, report *runReport, logger *Logger) bool {
+	report.traceApp(app, "state", strings.ToLower(app.State), "")
+	if app.State != "STARTED" {
+		logger.with(appFields(app)).debugf("App %s guid %s not in STARTED state\n", app.Name, app.GUID)
+		report.recordApp(app, decisionNotStarted)
+		return false
+	}
+	// Docker apps don't have buildpacks so there is no droplet worth looking up.
+	if app.Lifecycle.Type == "docker" {
+		logger.with(appFields(app)).debugf("App %s guid %s is a docker app\n", app.Name, app.GUID)
+		report.traceApp(app, "lifecycle", "docker", "docker apps have no buildpacks")
+		report.recordApp(app, decisionDocker)
+		return false
+	}
+	if until, snoozed := getSnoozeOfApp(app, time.Now(), logger); snoozed {
+		logger.with(appFields(app)).debugf("App %s guid %s is snoozed until %s\n", app.Name, app.GUID, until)
+		report.traceApp(app, "snooze", "snoozed", fmt.Sprintf("the app is labeled %s=%s", snoozeLabel, until))
+		report.recordApp(app, decisionSnoozed)
+		return false
+	}
+	return true
+}
+
+// getDropletToCheck looks up the current droplet of an app on its own. Apps
+// without one are recorded in the report and false is returned.
+func getDropletToCheck(app App, client *cfclient.Client, report *runReport, errs *runErrors, logger *Logger) (Droplet, bool) {
+	span := startSpan("fetch droplet", appFields(app))
+	droplet, foundDroplet, err := getCurrentDropletForApp(app, client)
+	span.finish(err)
+	if err != nil {
+		errs.addf("%s", err)
+		report.traceApp(app, "current droplet", "error", err.Error())
+		report.recordApp(app, decisionError)
+		return Droplet{}, false
+	}
+	if !foundDroplet {
+		logNoCurrentDroplet(app, report, logger)
+		return Droplet{}, false
+	}
+	return droplet, true
+}
+
+func logNoCurrentDroplet(app App, report *runReport, logger *Logger) {
+	logger.with(appFields(app)).debugf("Unable to find current droplet for app %s guid %s. Safely skipping.\n", app.Name, app.GUID)
+	report.traceApp(app, "current droplet", "not found", "")
+	report.recordApp(app, decisionNoDroplet)
+}
+
+// guidsPerRequest is how many GUIDs a single bulk request filters on, which
+// keeps the request URL reasonably short.
+const guidsPerRequest = 50
+
+// listStagedDroplets lists the staged droplets of apps in bulk, keyed by app
+// GUID, sending up to concurrency requests at a time. Every app gets an
+// entry, even if it has no staged droplets.
+func listStagedDroplets(client *cfclient.Client, apps []App, concurrency int) (map[string][]Droplet, error) {
+	staged := make(map[string][]Droplet)
+	for _, app := range apps {
+		staged[app.GUID] = nil
+	}
+	batches := guidBatches(len(apps))
+	listed := make([][]Droplet, len(batches))
+	errs := make([]error, len(batches))
+	forEachConcurrently(len(batches), concurrency, func(b int) {
+		var appGUIDs []string
+		for _, app := range apps[batches[b][0]:batches[b][1]] {
+			appGUIDs = append(appGUIDs, app.GUID)
+		}
+		span := startSpan("list droplets", logFields{"apps", len(appGUIDs)})
+		listed[b], errs[b] = ListDroplets(client, url.Values{
+			"app_guids": []string{strings.Join(appGUIDs, ",")},
+			"states":    []string{"STAGED"},
+		})
+		span.finish(errs[b])
+	})
+	// The batches are merged in order so that runs stay deterministic however
+	// the requests interleave.
+	for b, droplets := range listed {
+		if errs[b] != nil {
+			return nil, errs[b]
+		}
+		for _, droplet := range droplets {
+			appGUID := droplet.appGUID()
+			if _, requested := staged[appGUID]; requested {
+				staged[appGUID] = append(staged[appGUID], droplet)
+			}
+		}
+	}
+	return staged, nil
+}
+
+// listDeployingDroplets returns the droplets being rolled out by the active
+// deployments of apps, keyed by app GUID, sending up to concurrency requests
+// at a time.
+func listDeployingDroplets(client *cfclient.Client, apps []App, concurrency int) (map[string]string, error) {
+	batches := guidBatches(len(apps))
+	listed := make([][]Deployment, len(batches))
+	errs := make([]error, len(batches))
+	forEachConcurrently(len(batches), concurrency, func(b int) {
+		var appGUIDs []string
+		for _, app := range apps[batches[b][0]:batches[b][1]] {
+			appGUIDs = append(appGUIDs, app.GUID)
+		}
+		listed[b], errs[b] = ListDeployments(client, url.Values{
+			"app_guids":     []string{strings.Join(appGUIDs, ",")},
+			"status_values": []string{"ACTIVE"},
+		})
+	})
+	deploying := make(map[string]string)
+	for b, deployments := range listed {
+		if errs[b] != nil {
+			return nil, errs[b]
+		}
+		for _, deployment := range deployments {
+			deploying[deployment.Relationships.App.Data.GUID] = deployment.Droplet.GUID
+		}
+	}
+	return deploying, nil
+}
+
+// appDroplet is an app along with its current droplet, if it has one worth checking.
+type appDroplet struct {
+	app     App
+	droplet Droplet
+	ok      bool
+}
+
+// getDropletsToCheck returns the current droplet of every started buildpack
+// app. The droplets are listed in bulk, a page at a time. The current droplet
+// of a started app is always staged, so an app with a single staged droplet is
+// using it and an app with none has no current droplet. An app with several
+// staged droplets is usually part way through a rolling deployment, in which
+// case the droplet being deployed is the one checked. Only the remaining apps
+// are looked up on their own, up to concurrency at a time. The results are in the same order as apps so that
+// runs stay deterministic however the lookups interleave.
+func getDropletsToCheck(apps []App, client *cfclient.Client, concurrency int, report *runReport, errs *runErrors, logger *Logger) []appDroplet {
+	results := make([]appDroplet, len(apps))
+	var checked []int
+	var toCheck []App
+	for i, app := range apps {
+		results[i].app = app
+		if isAppToCheck(app, report, logger) {
+			checked = append(checked, i)
+			toCheck = append(toCheck, app)
+		}
+	}
+	var staged map[string][]Droplet
+	if len(toCheck) > 0 {
+		var err error
+		staged, err = listStagedDroplets(client, toCheck, concurrency)
+		if err != nil {
+			logger.warnf("Unable to list droplets in bulk, looking them up one app at a time. Error: %s\n", err)
+		}
+	}
+	var lookups []int
+	var ambiguous []int
+	for _, i := range checked {
+		droplets, listed := staged[apps[i].GUID]
+		switch {
+		case !listed:
+			lookups = append(lookups, i)
+		case len(droplets) > 1:
+			ambiguous = append(ambiguous, i)
+		case len(droplets) == 0:
+			logNoCurrentDroplet(apps[i], report, logger)
+		default:
+			results[i].droplet, results[i].ok = droplets[0], true
+		}
+	}
+	lookups = append(lookups, resolveDeployingDroplets(apps, ambiguous, staged, client, concurrency, results, logger)...)
+	sort.Ints(lookups)
+	if len(lookups) > 0 {
+		logger.infof("Looking up the current droplet of %d apps with several staged droplets.\n", len(lookups))
+	}
+
+	forEachConcurrently(len(lookups), concurrency, func(j int) {
+		i := lookups[j]
+		results[i].droplet, results[i].ok = getDropletToCheck(apps[i], client, report, errs, logger)
+	})
+	return results
+}
+
+// resolveDeployingDroplets picks the droplet being deployed for each of the
+// apps at indexes that has an active deployment, and returns the indexes of the
+// apps that still need looking up.
+func resolveDeployingDroplets(apps []App, indexes []int, staged map[string][]Droplet, client *cfclient.Client, concurrency int, results []appDroplet, logger *Logger) []int {
+	if len(indexes) == 0 {
+		return nil
+	}
+	var ambiguousApps []App
+	for _, i := range indexes {
+		ambiguousApps = append(ambiguousApps, apps[i])
+	}
+	deploying, err := listDeployingDroplets(client, ambiguousApps, concurrency)
+	if err != nil {
+		logger.warnf("Unable to list active deployments, looking up the current droplet instead. Error: %s\n", err)
+		return indexes
+	}
+	var lookups []int
+	for _, i := range indexes {
+		app := apps[i]
+		dropletGUID, found := deploying[app.GUID]
+		if !found {
+			lookups = append(lookups, i)
+			continue
+		}
+		for _, droplet := range staged[app.GUID] {
+			if droplet.GUID == dropletGUID {
+				logger.with(appFields(app)).debugf("App %s guid %s is being deployed, checking droplet %s\n", app.Name, app.GUID, dropletGUID)
+				results[i].droplet, results[i].ok = droplet, true
+				break
+			}
+		}
+		if !results[i].ok {
+			lookups = append(lookups, i)
+		}
+	}
+	return lookups
+}
+
+// findOutdatedApps returns every app that uses at least one buildpack updated since it was staged, along with
+// all of those buildpacks. It also returns every app staged with custom buildpacks pulled from git, which never
+// receive platform updates, and every app whose droplet was checked. Apps staged only with buildpacks that are now disabled are reported on their own.
+// The buildpacks of the outdated apps link to their pages in links.
+func findOutdatedApps(client *cfclient.Client, apps []App, buildpacks map[string]Buildpack, disabledBuildpacks map[string]bool, links buildpackLinks, tolerance time.Duration, concurrency int, report *runReport, errs *runErrors, logger *Logger) (outdatedApps []appInfo, gitBuildpackApps []appInfo, checkedApps []appInfo) {
+	for _, result := range getDropletsToCheck(apps, client, concurrency, report, errs, logger) {
+		if !result.ok {
+			continue
+		}
+		app, droplet := result.app, result.droplet
+		checkedApps = append(checkedApps, appInfo{App: app, DropletGUID: droplet.GUID})
+		report.recordDroplet(app, droplet)
+		gitBuildpacks := getGitBuildpacksOfDroplet(droplet)
+		if customBuildpacks := getCustomBuildpacksOfApp(app, droplet); len(customBuildpacks) > 0 {
+			logger.with(appFields(app)).debugf("App %s guid %s is using custom buildpacks %v\n", app.Name, app.GUID, customBuildpacks)
+			gitBuildpackApps = append(gitBuildpackApps, appInfo{App: app, GitBuildpacks: customBuildpacks})
+		}
+		supportedBuildpacks := getSupportedBuildpacksOfDroplet(droplet, buildpacks)
+		if len(supportedBuildpacks) == 0 {
+			if len(gitBuildpacks) > 0 {
+				report.traceApp(app, "supported buildpack", "git buildpack", "the app is only staged with custom buildpacks from git")
+				report.recordApp(app, decisionGitBuildpack)
+				continue
+			}
+			if usesDisabledBuildpack(droplet, disabledBuildpacks) {
+				logger.with(appFields(app)).debugf("App %s guid %s is using a disabled buildpack\n", app.Name, app.GUID)
+				report.traceApp(app, "supported buildpack", "disabled buildpack", "the app is staged with a disabled buildpack")
+				report.recordApp(app, decisionDisabledBuildpack)
+				continue
+			}
+			logger.with(appFields(app)).debugf("App %s guid %s not using supported buildpack\n", app.Name, app.GUID)
+			report.traceApp(app, "supported buildpack", "none", "none of the buildpacks of the app were updated since the last run")
+			report.recordApp(app, decisionUnsupportedBuildpack)
+			continue
+		}
+		// If the app is using supported buildpacks, check each of them for being outdated.
+		var outdatedBuildpacks []buildpackReleaseInfo
+		failed := false
+		for _, buildpack := range supportedBuildpacks {
+			buildpackIsOutdated, err := isDropletUsingOutdatedBuildpack(client, droplet, buildpack, tolerance)
+			check := "buildpack " + buildpack.Name
+			if err != nil {
+				errs.addf("Unable to check app %s guid %s. Error: %s", app.Name, app.GUID, err)
+				report.traceApp(app, check, "error", err.Error())
+				failed = true
+				continue
+			}
+			compared := fmt.Sprintf("buildpack updated at %s, droplet staged at %s, clock skew tolerance %s", buildpack.UpdatedAt, droplet.CreatedAt, tolerance)
+			if !buildpackIsOutdated {
+				report.traceApp(app, check, "not outdated", compared)
+				logger.with(appFields(app).with("buildpack", buildpack.Name)).debugf("App %s Guid %s | Buildpack %s not outdated\n", app.Name, app.GUID, buildpack.Name)
+				continue
+			}
+			logger.with(appFields(app).with("buildpack", buildpack.Name)).infof("App %s Guid %s | Buildpack %s is outdated\n", app.Name, app.GUID, buildpack.Name)
+			report.traceApp(app, check, "outdated", compared)
+			if strings.TrimSpace(buildpack.Filename) == "" {
+				report.recordBuildpackWithoutFilename(buildpack.Name)
+			}
+			info := links.releaseInfo(buildpack)
+			dropletBuildpack := findDropletBuildpack(droplet, buildpacks, buildpack)
+			info.CurrentVersion, info.DetectOutput = dropletBuildpack.Version, strings.TrimSpace(dropletBuildpack.DetectOutput)
+			outdatedBuildpacks = append(outdatedBuildpacks, info)
+		}
+		switch {
+		case len(outdatedBuildpacks) > 0:
+			report.recordOutdatedBuildpacks(app, outdatedBuildpacks)
+			report.recordApp(app, decisionOutdated)
+			outdatedApps = append(outdatedApps, appInfo{App: app, DropletGUID: droplet.GUID, StagedAt: droplet.CreatedAt, Buildpacks: outdatedBuildpacks})
+		case failed:
+			report.recordApp(app, decisionError)
+		default:
+			report.recordApp(app, decisionNotOutdated)
+		}
+	}
+	return
+}
+
+func spaceUserHasRoles(user spaceUser, roles map[string]bool) bool {
+	for _, roleOfUser := range user.SpaceRoles {
+		if found, _ := roles[roleOfUser]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// getBuildpacksOfApps returns the outdated buildpacks used by any of apps.
+func getBuildpacksOfApps(apps []appInfo) []buildpackReleaseInfo {
+	var allBuildpacks []buildpackReleaseInfo
+	for _, app := range apps {
+		allBuildpacks = append(allBuildpacks, app.Buildpacks...)
+	}
+	return deduplicateBuildpacks(allBuildpacks)
+}
+
+// sendNotifyEmailToUsers e-mails each of users about the outdated apps they
+// own, and returns the users whose e-mail couldn't be rendered or sent, along
+// with their apps.
+func sendNotifyEmailToUsers(users map[string][]appInfo, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) map[string][]appInfo {
+	var mu sync.Mutex
+	failed := make(map[string][]appInfo)
+	fail := func(user string, apps []appInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[user] = apps
+	}
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		// Create buffer
+		body := new(bytes.Buffer)
+		// Determine whether the user has one application or more than one.
+		isMultipleApp := false
+		if len(apps) > 1 {
+			isMultipleApp = true
+		}
+		// Fill buffer with completed e-mail
+		if err := templates.getNotifyEmail(body, notifyEmail{user, apps, isMultipleApp, getBuildpacksOfApps(apps)}); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationOutdated, notificationNotRendered, err, errs)
+			fail(user, apps)
+			return
+		}
+		// Send email
+		if !dryRun {
+			subj := "Action required: restage your application"
+			if isMultipleApp {
+				subj += "s"
+			}
+			if hasSecurityFixes(apps) {
+				subj += " to pick up security fixes"
+			}
+			err := mailer.SendEmail(user, fmt.Sprint(subj), body.Bytes())
+			if err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationOutdated, dryRun, err, errs)
+				fail(user, apps)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationOutdated, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent e-mail to %s\n", user)
+	})
+	return failed
+}
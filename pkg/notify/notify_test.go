@@ -0,0 +1,1353 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloud-gov/buildpack-notify/mocks"
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSpaceUserHasRoles(t *testing.T) {
+	testCases := []struct {
+		name         string
+		rolesToCheck map[string]bool
+		spaceUser    spaceUser
+		expected     bool
+	}{
+		{"role there", map[string]bool{"test": true}, spaceUser{SpaceRoles: []string{"test"}}, true},
+		{"role not there", map[string]bool{"test": true}, spaceUser{SpaceRoles: []string{""}}, false},
+		{"multiple roles not there", map[string]bool{"test1": true, "test2": true}, spaceUser{SpaceRoles: []string{"foo"}}, false},
+		{"multiple roles there", map[string]bool{"test1": true, "test2": true}, spaceUser{SpaceRoles: []string{"test2", "test"}}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if ret := spaceUserHasRoles(tc.spaceUser, tc.rolesToCheck); ret != tc.expected {
+				t.Errorf("Test %s failed. Expected %v Actual %v\n", tc.name, tc.expected, ret)
+			}
+		})
+	}
+}
+
+func TestBuildPackURLIsReturnedForSystemBuildPacks(t *testing.T) {
+	testBuildPackNames := []string{
+		"staticfile_buildpack",
+		"java_buildpack",
+		"ruby_buildpack",
+		"dotnet_core_buildpack",
+		"nodejs_buildpack",
+		"go_buildpack",
+		"python_buildpack",
+		"php_buildpack",
+		"binary_buildpack",
+		"nginx_buildpack",
+		"r_buildpack",
+	}
+
+	for _, testBuildPackName := range testBuildPackNames {
+		testBuildPackURL := defaultBuildpackLinks.releaseURL(testBuildPackName)
+
+		if testBuildPackURL == "" {
+			t.Errorf("Finding the buildpack URL failed for %s.", testBuildPackName)
+		}
+	}
+}
+
+func TestEmptyStringReturnedForUnknownBuildpack(t *testing.T) {
+	testBuildpackName := "my_fake_buildpack"
+
+	testBuildpackURL := defaultBuildpackLinks.releaseURL(testBuildpackName)
+
+	if testBuildpackURL != "" {
+		t.Errorf("The buildpack %s should not have mapped to a URL.", testBuildpackName)
+	}
+}
+
+func TestParseBuildpackVersion(t *testing.T) {
+	testBuildpackFileName := "python_buildpack-cflinuxfs3-v1.7.43.zip"
+	expectedBuildpackVersion := "v1.7.43"
+
+	buildpackVersion := parseBuildpackVersion(testBuildpackFileName)
+
+	if buildpackVersion != expectedBuildpackVersion {
+		t.Errorf("The buildpack version for %s was not parsed correctly; expected %s", testBuildpackFileName, expectedBuildpackVersion)
+	}
+}
+
+func TestParseBuildpackVersionMoreDashes(t *testing.T) {
+	testBuildpackFileName := "php-buildpack-cflinuxfs3-v4.4.49.zip"
+	expectedBuildpackVersion := "v4.4.49"
+
+	buildpackVersion := parseBuildpackVersion(testBuildpackFileName)
+
+	if buildpackVersion != expectedBuildpackVersion {
+		t.Errorf("The buildpack version for %s was not parsed correctly; expected %s", testBuildpackFileName, expectedBuildpackVersion)
+	}
+}
+
+func TestGetBuildpackReleaseInfoWithoutFilename(t *testing.T) {
+	for _, filename := range []string{"", "  "} {
+		info := defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack", Filename: filename})
+		if info.BuildpackVersion != "" || info.BuildpackURL != "https://github.com/cloudfoundry/python-buildpack/releases" {
+			t.Errorf("Expected buildpack with filename %q to link to its releases page, found %+v", filename, info)
+		}
+	}
+}
+
+func TestBuildpackVersionURL(t *testing.T) {
+	testBuildpackReleaseURL := "https://github.com/cloudfoundry/python-buildpack/releases"
+	testBuildpackVersion := "v1.7.43"
+	expectedVersionURL := "https://github.com/cloudfoundry/python-buildpack/releases/tag/v1.7.43"
+
+	buildpackVersionURL := getBuildpackVersionURL(testBuildpackReleaseURL, testBuildpackVersion)
+
+	if buildpackVersionURL != expectedVersionURL {
+		t.Errorf("The buildpack version URL for %s (%s) was not built correctly; expected %s", testBuildpackReleaseURL, testBuildpackVersion, expectedVersionURL)
+	}
+}
+
+func TestBuildpackVersionURLWithBadVersion(t *testing.T) {
+	testBuildpackReleaseURL := "https://github.com/cloudfoundry/python-buildpack/releases"
+	testBuildpackVersionMissingV := "7.5"
+	testBuildpackVersionDifferentFormat := "x.321.y.323"
+	expectedVersionURL := "https://github.com/cloudfoundry/python-buildpack/releases"
+
+	buildpackVersionURL1 := getBuildpackVersionURL(testBuildpackReleaseURL, testBuildpackVersionMissingV)
+	buildpackVersionURL2 := getBuildpackVersionURL(testBuildpackReleaseURL, testBuildpackVersionDifferentFormat)
+
+	if buildpackVersionURL1 != expectedVersionURL {
+		t.Errorf("The buildpack version URL for %s (%s) was not built correctly; expected %s", testBuildpackReleaseURL, testBuildpackVersionMissingV, expectedVersionURL)
+	}
+
+	if buildpackVersionURL2 != expectedVersionURL {
+		t.Errorf("The buildpack version URL for %s (%s) was not built correctly; expected %s", testBuildpackReleaseURL, testBuildpackVersionDifferentFormat, expectedVersionURL)
+	}
+}
+
+type spaceSpec struct {
+	space      SpaceResponse
+	spaceRoles RoleResponse
+}
+
+const (
+	user1     = "user1@example.com"
+	user1GUID = "user1-guid"
+	user2     = "user2@example.com"
+	user2GUID = "user2-guid"
+)
+
+func newTestApp(guid, spaceGUID string) App {
+	app := App{GUID: guid}
+	app.Relationships.Space.Data.GUID = spaceGUID
+	return app
+}
+
+func newTestAppInfos(apps []App) []appInfo {
+	infos := make([]appInfo, 0, len(apps))
+	for _, app := range apps {
+		infos = append(infos, appInfo{App: app})
+	}
+	return infos
+}
+
+func newTestSpace(guid string) SpaceResponse {
+	space := SpaceResponse{Space: Space{GUID: guid, Name: guid}}
+	space.Relationships.Organization.Data.GUID = "org1"
+	space.Included.Organizations = []Organization{{GUID: "org1", Name: "org1"}}
+	return space
+}
+
+func newTestSpaceRoles(users ...spaceUser) RoleResponse {
+	var resp RoleResponse
+	for _, user := range users {
+		for _, roleType := range user.SpaceRoles {
+			role := Role{GUID: user.GUID + "-" + roleType, Type: roleType}
+			role.Relationships.User.Data.GUID = user.GUID
+			resp.Roles = append(resp.Roles, role)
+		}
+		resp.Included.Users = append(resp.Included.Users, user.User)
+	}
+	return resp
+}
+
+// rolesInSpaces merges the roles of every space requested with space_guids
+// into a single response, like the V3 API does.
+func rolesInSpaces(r *http.Request, rolesOf func(spaceGUID string) RoleResponse) RoleResponse {
+	var resp RoleResponse
+	for _, spaceGUID := range strings.Split(r.URL.Query().Get("space_guids"), ",") {
+		spaceResp := rolesOf(spaceGUID)
+		for _, role := range spaceResp.Roles {
+			role.Relationships.Space.Data.GUID = spaceGUID
+			resp.Roles = append(resp.Roles, role)
+		}
+		resp.Included.Users = append(resp.Included.Users, spaceResp.Included.Users...)
+	}
+	return resp
+}
+
+func mustOwnerRoles(t *testing.T, types ...string) ownerRoles {
+	roles, err := newOwnerRoles(types)
+	if err != nil {
+		t.Fatalf("Unable to create owner roles. Error %s", err)
+	}
+	return roles
+}
+
+func TestFindOwnersOfApps(t *testing.T) {
+	testCases := []struct {
+		name     string
+		apps     []App
+		spaces   map[string]spaceSpec
+		expected map[string][]App
+	}{
+		{
+			"single app, single user",
+			[]App{newTestApp("app1", "space1")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}}),
+				},
+			},
+			map[string][]App{user1: {newTestApp("app1", "space1")}},
+		},
+		{
+			"single app, single user multiple valid roles",
+			[]App{newTestApp("app1", "space1")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager", "space_developer"}}),
+				},
+			},
+			map[string][]App{user1: {newTestApp("app1", "space1")}},
+		},
+		{
+			"single app, single user one valid role, one invalid role",
+			[]App{newTestApp("app1", "space1")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager", "space_auditor"}}),
+				},
+			},
+			map[string][]App{user1: {newTestApp("app1", "space1")}},
+		},
+		{
+			"single app, single user no valid role",
+			[]App{newTestApp("app1", "space1")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_auditor"}}),
+				},
+			},
+			map[string][]App{},
+		},
+		{
+			"same single app, multiple users",
+			[]App{newTestApp("app1", "space1")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(
+						spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}},
+						spaceUser{User{GUID: user2GUID, Username: user2}, []string{"space_manager"}},
+					),
+				},
+			},
+			map[string][]App{
+				user1: {newTestApp("app1", "space1")},
+				user2: {newTestApp("app1", "space1")},
+			},
+		},
+		{
+			"same single app, multiple users, one without valid role",
+			[]App{newTestApp("app1", "space1")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(
+						spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_auditor"}},
+						spaceUser{User{GUID: user2GUID, Username: user2}, []string{"space_manager"}},
+					),
+				},
+			},
+			map[string][]App{
+				user2: {newTestApp("app1", "space1")},
+			},
+		},
+		{
+			"two apps in different spaces, two users, mutually exclusive app ownership",
+			[]App{newTestApp("app1", "space1"), newTestApp("app2", "space2")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}}),
+				},
+				"space2": {
+					newTestSpace("space2"),
+					newTestSpaceRoles(spaceUser{User{GUID: user2GUID, Username: user2}, []string{"space_manager"}}),
+				},
+			},
+			map[string][]App{
+				user1: {newTestApp("app1", "space1")},
+				user2: {newTestApp("app2", "space2")},
+			},
+		},
+		{
+			"two apps in different spaces, two users with ownership in both spaces",
+			[]App{newTestApp("app1", "space1"), newTestApp("app2", "space2")},
+			map[string]spaceSpec{
+				"space1": {
+					newTestSpace("space1"),
+					newTestSpaceRoles(
+						spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}},
+						spaceUser{User{GUID: user2GUID, Username: user2}, []string{"space_manager"}},
+					),
+				},
+				"space2": {
+					newTestSpace("space2"),
+					newTestSpaceRoles(
+						spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}},
+						spaceUser{User{GUID: user2GUID, Username: user2}, []string{"space_manager"}},
+					),
+				},
+			},
+			map[string][]App{
+				user1: {newTestApp("app1", "space1"), newTestApp("app2", "space2")},
+				user2: {newTestApp("app1", "space1"), newTestApp("app2", "space2")},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			roleRequests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoder := json.NewEncoder(w)
+				parts := strings.Split(r.URL.Path, "/")
+				if r.URL.Path == "/v3/roles" {
+					roleRequests++
+					if types := r.URL.Query().Get("types"); types != "space_developer,space_manager" {
+						t.Errorf("Test %s failed. Expected role types space_developer,space_manager, found %s", tc.name, types)
+					}
+					encoder.Encode(rolesInSpaces(r, func(spaceGUID string) RoleResponse { return tc.spaces[spaceGUID].spaceRoles }))
+				} else if strings.HasPrefix(r.URL.Path, "/v3/spaces/") && len(parts) == 4 {
+					encoder.Encode(tc.spaces[parts[3]].space)
+				} else {
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			actual := findOwnersOfApps(newTestAppInfos(tc.apps), &c, ownerSettings{roles: mustOwnerRoles(t, "space_manager", "space_developer")}, &runErrors{}, nil)
+			if len(actual) != len(tc.expected) {
+				t.Errorf("Test %s failed. Expected %d user entries, only found %d\n", tc.name, len(tc.expected), len(actual))
+			}
+			if roleRequests != 1 {
+				t.Errorf("Test %s failed. Expected the roles of every space to be requested at once, found %d requests", tc.name, roleRequests)
+			}
+			for actualUsername, actualOutdatedApps := range actual {
+				expectedOutdatedApps, found := tc.expected[actualUsername]
+				if !found {
+					t.Errorf("Test %s failed. Couldn't find user %s in expected map\n", tc.name, actualUsername)
+					continue
+				}
+				if len(expectedOutdatedApps) != len(actualOutdatedApps) {
+					t.Errorf("Test %s failed. Expected %d outdated apps, only found %d\n", tc.name, len(expectedOutdatedApps), len(actualOutdatedApps))
+					t.Errorf("Expected %+v\nActual %+v\n", expectedOutdatedApps, actualOutdatedApps)
+					continue
+				}
+				for _, actualOutdatedApp := range actualOutdatedApps {
+					found := false
+					for _, expectedOutdatedApp := range expectedOutdatedApps {
+						if expectedOutdatedApp.GUID == actualOutdatedApp.GUID {
+							found = true
+						}
+					}
+					if !found {
+						t.Errorf("Test %s failed. Looked for app with guid %s, Could not find it", tc.name, actualOutdatedApp.GUID)
+					}
+					if actualOutdatedApp.Space.GUID != actualOutdatedApp.Relationships.Space.Data.GUID || actualOutdatedApp.Org.Name != "org1" {
+						t.Errorf("Test %s failed. App with guid %s was not resolved to its space and org", tc.name, actualOutdatedApp.GUID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFindOwnersOfAppsContinuesPastSpaceErrors(t *testing.T) {
+	space := newTestSpace("space1")
+	roles := newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		switch r.URL.Path {
+		case "/v3/spaces/space1":
+			encoder.Encode(space)
+		case "/v3/roles":
+			encoder.Encode(rolesInSpaces(r, func(string) RoleResponse { return roles }))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	errs := &runErrors{}
+	owners := findOwnersOfApps(newTestAppInfos([]App{newTestApp("app1", "broken-space"), newTestApp("app2", "space1")}), &c, ownerSettings{roles: mustOwnerRoles(t, "space_manager")}, errs, nil)
+	if errs.count() != 1 {
+		t.Errorf("Expected 1 error to be collected, found %d", errs.count())
+	}
+	if len(owners[user1]) != 1 || owners[user1][0].GUID != "app2" {
+		t.Errorf("Expected app2 to still be attributed to %s, found %+v", user1, owners)
+	}
+}
+
+func TestFindOwnersOfAppsSharesRoleCache(t *testing.T) {
+	roles := newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}})
+	roleRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		switch r.URL.Path {
+		case "/v3/spaces/space1":
+			encoder.Encode(newTestSpace("space1"))
+		case "/v3/roles":
+			roleRequests++
+			encoder.Encode(rolesInSpaces(r, func(string) RoleResponse { return roles }))
+		default:
+			t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	settings := ownerSettings{roles: mustOwnerRoles(t, "space_manager"), roleCache: newRoleCache()}
+	for _, guid := range []string{"app1", "app2"} {
+		owners := findOwnersOfApps(newTestAppInfos([]App{newTestApp(guid, "space1")}), &c, settings, &runErrors{}, nil)
+		if len(owners[user1]) != 1 {
+			t.Errorf("Expected %s to own %s, found %+v", user1, guid, owners)
+		}
+	}
+	if roleRequests != 1 {
+		t.Errorf("Expected the roles of space1 to be requested once, found %d", roleRequests)
+	}
+}
+
+func TestNewOwnerRoles(t *testing.T) {
+	roles := mustOwnerRoles(t, "space_developer", "organization_manager")
+	if !roles.includesSpaceRoles() || len(roles.orgRoleTypes()) != 1 || roles.orgRoleTypes()[0] != "organization_manager" {
+		t.Errorf("Expected space_developer and organization_manager, found %+v", roles)
+	}
+	if mustOwnerRoles(t, "organization_manager").includesSpaceRoles() {
+		t.Errorf("Expected only organization roles")
+	}
+	for _, invalid := range [][]string{nil, {"space_admin"}} {
+		if _, err := newOwnerRoles(invalid); err == nil {
+			t.Errorf("Expected roles %v to be invalid", invalid)
+		}
+	}
+}
+
+func TestFindOwnersOfAppsWithOrgManagers(t *testing.T) {
+	spaceRoles := newTestSpaceRoles(
+		spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_developer"}},
+		spaceUser{User{GUID: user2GUID, Username: user2}, []string{"space_developer"}},
+	)
+	orgRoles := newTestSpaceRoles(spaceUser{User{GUID: user2GUID, Username: user2}, []string{"organization_manager"}})
+	for i := range orgRoles.Roles {
+		orgRoles.Roles[i].Relationships.Organization.Data.GUID = "org1"
+	}
+	apps := newTestAppInfos([]App{newTestApp("app1", "space1"), newTestApp("app2", "space2")})
+	testCases := []struct {
+		name          string
+		roles         ownerRoles
+		expected      map[string]int
+		expectedTypes string
+	}{
+		{"space roles only", mustOwnerRoles(t, "space_developer"), map[string]int{user1: 2, user2: 2}, ""},
+		{"space and org roles", mustOwnerRoles(t, "space_developer", "organization_manager"), map[string]int{user1: 2, user2: 2}, "organization_manager"},
+		{"org roles only", mustOwnerRoles(t, "organization_manager"), map[string]int{user2: 2}, "organization_manager"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			spaceRequests, orgRequests := 0, 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoder := json.NewEncoder(w)
+				parts := strings.Split(r.URL.Path, "/")
+				switch {
+				case r.URL.Path == "/v3/roles" && r.URL.Query().Get("organization_guids") == "org1":
+					orgRequests++
+					if types := r.URL.Query().Get("types"); types != tc.expectedTypes {
+						t.Errorf("Test %s failed. Expected role types %s, found %s", tc.name, tc.expectedTypes, types)
+					}
+					encoder.Encode(orgRoles)
+				case r.URL.Path == "/v3/roles":
+					spaceRequests++
+					encoder.Encode(rolesInSpaces(r, func(string) RoleResponse { return spaceRoles }))
+				case strings.HasPrefix(r.URL.Path, "/v3/spaces/") && len(parts) == 4:
+					encoder.Encode(newTestSpace(parts[3]))
+				default:
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			owners := findOwnersOfApps(apps, &c, ownerSettings{roles: tc.roles}, &runErrors{}, nil)
+			if len(owners) != len(tc.expected) {
+				t.Errorf("Test %s failed. Expected owners %v, found %+v", tc.name, tc.expected, owners)
+			}
+			for user, count := range tc.expected {
+				if len(owners[user]) != count {
+					t.Errorf("Test %s failed. Expected %s to own %d apps, found %+v", tc.name, user, count, owners[user])
+				}
+			}
+			if tc.expectedTypes != "" && orgRequests != 1 {
+				t.Errorf("Test %s failed. Expected the org roles to be requested once, found %d", tc.name, orgRequests)
+			}
+			if !tc.roles.includesSpaceRoles() && spaceRequests != 0 {
+				t.Errorf("Test %s failed. Expected no space role requests, found %d", tc.name, spaceRequests)
+			}
+		})
+	}
+}
+
+func TestFilterForNewlyUpdatedBuildpacksSkipsBadTimestamps(t *testing.T) {
+	state := map[string]buildpackRecord{
+		"bp1": {LastUpdatedAt: "2020-01-01T00:00:00Z"},
+		"bp2": {LastUpdatedAt: "not a time"},
+	}
+	buildpacks := []Buildpack{
+		{GUID: "bp1", Name: "python_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"},
+		{GUID: "bp2", Name: "ruby_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"},
+	}
+	errs := &runErrors{}
+	filtered, state := filterForNewlyUpdatedBuildpacks(buildpacks, state, resourceFilter{}, errs, nil)
+	if len(filtered) != 1 || filtered[0].GUID != "bp1" {
+		t.Errorf("Expected only bp1 to be considered updated, found %+v", filtered)
+	}
+	if errs.count() != 1 {
+		t.Errorf("Expected 1 error to be collected, found %d", errs.count())
+	}
+	if state["bp2"].LastUpdatedAt != "not a time" {
+		t.Errorf("Expected the state of bp2 to be left alone, found %+v", state["bp2"])
+	}
+}
+
+func TestFilterForNewlyUpdatedBuildpacksWithFilter(t *testing.T) {
+	buildpacks := []Buildpack{
+		{GUID: "bp1", Name: "java_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"},
+		{GUID: "bp2", Name: "python_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"},
+		{GUID: "bp3", Name: "ruby_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"},
+	}
+	filter, err := newResourceFilter([]string{"java_buildpack"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, state := filterForNewlyUpdatedBuildpacks(buildpacks, map[string]buildpackRecord{}, filter, &runErrors{}, nil)
+	if len(filtered) != 1 || filtered[0].GUID != "bp1" {
+		t.Errorf("Expected only bp1 to be considered updated, found %+v", filtered)
+	}
+	if _, found := state["bp2"]; found {
+		t.Errorf("Expected filtered out buildpacks to be left out of the state, found %+v", state)
+	}
+	if _, found := state["bp1"]; !found {
+		t.Errorf("Expected bp1 to be recorded in the state, found %+v", state)
+	}
+}
+
+func TestFilterForNewlyUpdatedBuildpacksSkipsMetadataUpdates(t *testing.T) {
+	state := map[string]buildpackRecord{
+		"bp1": {LastUpdatedAt: "2020-01-01T00:00:00Z", Filename: "python_buildpack-cflinuxfs4-v1.8.0.zip"},
+		"bp2": {LastUpdatedAt: "2020-01-01T00:00:00Z", Filename: "ruby_buildpack-cflinuxfs4-v1.9.0.zip"},
+		"bp3": {LastUpdatedAt: "2020-01-01T00:00:00Z"},
+	}
+	buildpacks := []Buildpack{
+		{GUID: "bp1", Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs4-v1.8.0.zip", UpdatedAt: "2020-02-01T00:00:00Z"},
+		{GUID: "bp2", Name: "ruby_buildpack", Filename: "ruby_buildpack-cflinuxfs4-v1.9.1.zip", UpdatedAt: "2020-02-01T00:00:00Z"},
+		{GUID: "bp3", Name: "go_buildpack", Filename: "go_buildpack-cflinuxfs4-v1.10.0.zip", UpdatedAt: "2020-02-01T00:00:00Z"},
+	}
+	filtered, state := filterForNewlyUpdatedBuildpacks(buildpacks, state, resourceFilter{}, &runErrors{}, nil)
+	if len(filtered) != 2 || filtered[0].GUID != "bp2" || filtered[1].GUID != "bp3" {
+		t.Errorf("Expected bp2 and bp3 to be considered updated, found %+v", filtered)
+	}
+	expected := buildpackRecord{LastUpdatedAt: "2020-02-01T00:00:00Z", Filename: "python_buildpack-cflinuxfs4-v1.8.0.zip"}
+	if state["bp1"] != expected {
+		t.Errorf("Expected the update time of bp1 to be recorded, found %+v", state["bp1"])
+	}
+	if state["bp3"].Filename != "go_buildpack-cflinuxfs4-v1.10.0.zip" {
+		t.Errorf("Expected the filename of bp3 to be recorded, found %+v", state["bp3"])
+	}
+}
+
+func TestSplitDisabledBuildpacks(t *testing.T) {
+	buildpacks := []Buildpack{
+		{GUID: "bp1", Name: "python_buildpack", Stack: "cflinuxfs4", Enabled: true},
+		{GUID: "bp2", Name: "python_buildpack", Stack: "cflinuxfs3"},
+		{GUID: "bp3", Name: "ruby_buildpack", Stack: "cflinuxfs4"},
+	}
+	enabled, disabled := splitDisabledBuildpacks(buildpacks, nil)
+	if len(enabled) != 1 || enabled[0].GUID != "bp1" {
+		t.Errorf("Expected only bp1 to be enabled, found %+v", enabled)
+	}
+	if !reflect.DeepEqual(disabled, map[string]bool{"ruby_buildpack": true}) {
+		t.Errorf("Expected only ruby_buildpack to be disabled, found %+v", disabled)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents string
+		expected *runState
+	}{
+		{
+			"legacy buildpack map",
+			`{"bp1":{"LastUpdatedAt":"2020-01-01T00:00:00Z"}}`,
+			&runState{
+				Buildpacks:              map[string]buildpackRecord{"bp1": {LastUpdatedAt: "2020-01-01T00:00:00Z"}},
+				PinnedBuildpackWarnings: map[string]pinnedBuildpackRecord{},
+				Apps:                    map[string]appNotificationRecord{},
+				RestageQueue:            map[string]queuedRestage{},
+				HeldNotifications:       map[string]heldNotification{},
+				UncheckedApps:           map[string]uncheckedApp{},
+				Releases:                map[string]releaseRecord{},
+				CVESeverities:           map[string]string{},
+			},
+		},
+		{
+			"run state",
+			`{"Buildpacks":{"bp1":{"LastUpdatedAt":"2020-01-01T00:00:00Z"}},"PinnedBuildpackWarnings":{"app1":{"Buildpacks":["https://github.com/example/buildpack#v1.0.0"]}}}`,
+			&runState{
+				Buildpacks:              map[string]buildpackRecord{"bp1": {LastUpdatedAt: "2020-01-01T00:00:00Z"}},
+				PinnedBuildpackWarnings: map[string]pinnedBuildpackRecord{"app1": {Buildpacks: []string{"https://github.com/example/buildpack#v1.0.0"}}},
+				Apps:                    map[string]appNotificationRecord{},
+				RestageQueue:            map[string]queuedRestage{},
+				HeldNotifications:       map[string]heldNotification{},
+				UncheckedApps:           map[string]uncheckedApp{},
+				Releases:                map[string]releaseRecord{},
+				CVESeverities:           map[string]string{},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := ioutil.WriteFile(path, []byte(tc.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			state, err := loadState(path)
+			if err != nil {
+				t.Fatalf("Test %s failed. Unexpected error %s", tc.name, err)
+			}
+			if !reflect.DeepEqual(state, tc.expected) {
+				t.Errorf("Test %s failed. Expected %+v, found %+v", tc.name, tc.expected, state)
+			}
+			if err := saveState(state, path); err != nil {
+				t.Fatal(err)
+			}
+			saved, err := loadState(path)
+			if err != nil || !reflect.DeepEqual(saved, tc.expected) {
+				t.Errorf("Test %s failed. Expected saved state %+v, found %+v", tc.name, tc.expected, saved)
+			}
+		})
+	}
+}
+
+type testNotifyEmail struct {
+	notifyEmail
+	subject string
+}
+
+func TestSendNotifyEmailToUsers(t *testing.T) {
+	updatedBuildpacks := []buildpackReleaseInfo{
+		{
+			BuildpackName:    "java_buildpack",
+			BuildpackVersion: "v4.41",
+			BuildpackURL:     "https://github.com/cloudfoundry/java-buildpack/releases/tags/v4.41",
+		},
+		{
+			BuildpackName:    "python_buildpack",
+			BuildpackVersion: "v1.7.43",
+			BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.7.43",
+		},
+		{
+			BuildpackName:    "ruby_buildpack",
+			BuildpackVersion: "v1.8.43",
+			BuildpackURL:     "https://github.com/cloudfoundry/ruby-buildpack/releases/tags/v1.8.43",
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		usersAndApps  map[string][]appInfo
+		expectedCalls []testNotifyEmail
+	}{
+		{
+			"single user, single app",
+			map[string][]appInfo{
+				"james@example.com": []appInfo{
+					{App: App{Name: "testapp"}},
+				},
+			},
+			[]testNotifyEmail{
+				{
+					notifyEmail{
+						"james@example.com",
+						[]appInfo{
+							{App: App{Name: "testapp"}},
+						},
+						false,
+						updatedBuildpacks,
+					},
+					"Action required: restage your application",
+				},
+			},
+		},
+		{
+			"single user, multiple apps",
+			map[string][]appInfo{
+				"james@example.com": []appInfo{
+					{App: App{Name: "testapp1"}},
+					{App: App{Name: "testapp2"}},
+				},
+			},
+			[]testNotifyEmail{
+				{
+					notifyEmail{
+						"james@example.com",
+						[]appInfo{
+							{App: App{Name: "testapp1"}},
+							{App: App{Name: "testapp2"}},
+						},
+						true,
+						updatedBuildpacks,
+					},
+					"Action required: restage your applications",
+				},
+			},
+		},
+		{
+			"multiple users, each with a single app",
+			map[string][]appInfo{
+				"james@example.com": []appInfo{
+					{App: App{Name: "testapp1"}},
+				},
+				"bob@example.com": []appInfo{
+					{App: App{Name: "testapp2"}},
+				},
+			},
+			[]testNotifyEmail{
+				{
+					notifyEmail{
+						"james@example.com",
+						[]appInfo{
+							{App: App{Name: "testapp1"}},
+						},
+						false,
+						updatedBuildpacks,
+					},
+					"Action required: restage your application",
+				},
+				{
+					notifyEmail{
+						"bob@example.com",
+						[]appInfo{
+							{App: App{Name: "testapp2"}},
+						},
+						false,
+						updatedBuildpacks,
+					},
+					"Action required: restage your application",
+				},
+			},
+		},
+		{
+			"multiple users, each with multiple apps",
+			map[string][]appInfo{
+				"james@example.com": []appInfo{
+					{App: App{Name: "testapp1"}},
+					{App: App{Name: "testapp2"}},
+				},
+				"bob@example.com": []appInfo{
+					{App: App{Name: "testapp3"}},
+					{App: App{Name: "testapp4"}},
+				},
+			},
+			[]testNotifyEmail{
+				{
+					notifyEmail{
+						"james@example.com",
+						[]appInfo{
+							{App: App{Name: "testapp1"}},
+							{App: App{Name: "testapp2"}},
+						},
+						true,
+						updatedBuildpacks,
+					},
+					"Action required: restage your applications",
+				},
+				{
+					notifyEmail{
+						"bob@example.com",
+						[]appInfo{
+							{App: App{Name: "testapp3"}},
+							{App: App{Name: "testapp4"}},
+						},
+						true,
+						updatedBuildpacks,
+					},
+					"Action required: restage your applications",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		templates, _ := initTemplates()
+		t.Run(tc.name, func(t *testing.T) {
+			mockMailer := new(mocks.Mailer)
+			mockMailer.On("SendEmail", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+			sendNotifyEmailToUsers(tc.usersAndApps, templates, mockMailer, 1, false, nil, &runErrors{}, nil)
+			if !mockMailer.AssertNumberOfCalls(t, "SendEmail", len(tc.expectedCalls)) {
+				t.Errorf("Did not call send e-mail the number of expected times")
+				t.Log(len(mockMailer.Calls))
+			}
+			count := 0
+			for _, expectedCall := range tc.expectedCalls {
+				for _, call := range mockMailer.Calls {
+					if call.Method == "SendEmail" && call.Arguments.String(0) == expectedCall.Username {
+						if call.Arguments.String(1) != expectedCall.subject {
+							t.Errorf("Failed to match subject line. Found %s, Expected %s", call.Arguments.String(1), expectedCall.subject)
+							continue
+						}
+						raw := call.Arguments.Get(2).([]byte)
+						rawString := string(raw)
+						foundApps := true
+						for _, app := range expectedCall.Apps {
+							if !strings.Contains(rawString, app.Name) {
+								t.Errorf("Unable to find app name in e-mail %s", app.Name)
+								foundApps = false
+							}
+						}
+						if foundApps {
+							count++
+						}
+					}
+				}
+			}
+			// Sanity check.
+			if count != len(tc.expectedCalls) {
+				t.Error("Something unexpected happened which caused a mismatch number of calls")
+			}
+		})
+	}
+}
+
+func TestSendNotifyEmailToUsersListsBuildpacksOfTheirApps(t *testing.T) {
+	java := buildpackReleaseInfo{BuildpackName: "java_buildpack", BuildpackVersion: "v4.41", BuildpackURL: "https://github.com/cloudfoundry/java-buildpack/releases/tags/v4.41"}
+	nodejs := buildpackReleaseInfo{BuildpackName: "nodejs_buildpack", BuildpackVersion: "v1.7.60", BuildpackURL: "https://github.com/cloudfoundry/nodejs-buildpack/releases/tags/v1.7.60"}
+	python := buildpackReleaseInfo{BuildpackName: "python_buildpack", BuildpackVersion: "v1.7.43", BuildpackURL: "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.7.43"}
+	users := map[string][]appInfo{
+		"james@example.com": {
+			{App: App{Name: "testapp1"}, Buildpacks: []buildpackReleaseInfo{nodejs, python}},
+			{App: App{Name: "testapp2"}, Buildpacks: []buildpackReleaseInfo{python}},
+		},
+		"bob@example.com": {
+			{App: App{Name: "testapp3"}, Buildpacks: []buildpackReleaseInfo{java}},
+		},
+	}
+	expected := map[string][]buildpackReleaseInfo{
+		"james@example.com": {nodejs, python},
+		"bob@example.com":   {java},
+	}
+	templates, _ := initTemplates()
+	mockMailer := new(mocks.Mailer)
+	mockMailer.On("SendEmail", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	sendNotifyEmailToUsers(users, templates, mockMailer, 1, false, nil, &runErrors{}, nil)
+	for _, call := range mockMailer.Calls {
+		user := call.Arguments.String(0)
+		body := string(call.Arguments.Get(2).([]byte))
+		for _, buildpack := range []buildpackReleaseInfo{java, nodejs, python} {
+			shouldList := false
+			for _, expectedBuildpack := range expected[user] {
+				if reflect.DeepEqual(expectedBuildpack, buildpack) {
+					shouldList = true
+				}
+			}
+			if strings.Contains(body, buildpack.BuildpackURL) != shouldList {
+				t.Errorf("E-mail to %s listing %s: expected %v", user, buildpack.BuildpackName, shouldList)
+			}
+			if shouldList && strings.Count(body, buildpack.BuildpackURL) != 1 {
+				t.Errorf("E-mail to %s should list %s exactly once", user, buildpack.BuildpackName)
+			}
+		}
+	}
+}
+
+func TestGetSupportedBuildpacksOfDroplet(t *testing.T) {
+	buildpacks := map[string]Buildpack{
+		"nodejs_buildpack": {GUID: "bp1", Name: "nodejs_buildpack"},
+		"python_buildpack": {GUID: "bp2", Name: "python_buildpack"},
+	}
+	var droplet Droplet
+	for _, name := range []string{"nodejs_buildpack", "custom_buildpack", "python_buildpack"} {
+		droplet.Buildpacks = append(droplet.Buildpacks, DropletBuildpack{Name: name})
+	}
+	supported := getSupportedBuildpacksOfDroplet(droplet, buildpacks)
+	if len(supported) != 2 || supported[0].Name != "nodejs_buildpack" || supported[1].Name != "python_buildpack" {
+		t.Errorf("Expected both nodejs and python buildpacks to be supported, found %+v", supported)
+	}
+}
+
+func TestDropletBuildpackVersion(t *testing.T) {
+	python := Buildpack{GUID: "bp2", Name: "python_buildpack"}
+	buildpacks := map[string]Buildpack{
+		"nginx_buildpack":      {GUID: "bp1", Name: "nginx_buildpack"},
+		"python_buildpack":     python,
+		"python_buildpack_old": python,
+	}
+	droplet := Droplet{Buildpacks: []DropletBuildpack{
+		{Name: "nginx_buildpack", Version: "1.2.3"},
+		{Name: "python_buildpack_old", Version: "1.7.40"},
+	}}
+	if version := dropletBuildpackVersion(droplet, buildpacks, python); version != "1.7.40" {
+		t.Errorf("Expected the version staged under the alias, found %q", version)
+	}
+	if version := dropletBuildpackVersion(Droplet{Buildpacks: []DropletBuildpack{{Name: "python_buildpack"}}}, buildpacks, python); version != "" {
+		t.Errorf("Expected no version when the droplet doesn't record it, found %q", version)
+	}
+	droplet.Buildpacks[1].DetectOutput = "python 3.8.18"
+	if found := findDropletBuildpack(droplet, buildpacks, python); found != droplet.Buildpacks[1] {
+		t.Errorf("Expected the buildpack staged under the alias, found %+v", found)
+	}
+	if found := findDropletBuildpack(droplet, buildpacks, Buildpack{Name: "ruby_buildpack"}); found != (DropletBuildpack{}) {
+		t.Errorf("Expected nothing of a buildpack the droplet wasn't staged with, found %+v", found)
+	}
+}
+
+func TestResolveVersionsFromHistory(t *testing.T) {
+	buildpacks := map[string]Buildpack{"python_buildpack": {GUID: "bp2", Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs4-v1.7.45.zip"}}
+	previous := map[string]buildpackRecord{"bp2": {LastUpdatedAt: "2020-01-01T00:00:00Z", Filename: "python_buildpack-cflinuxfs4-v1.7.43.zip"}}
+	newApp := func(stagedAt, currentVersion string) appInfo {
+		return appInfo{StagedAt: stagedAt, Buildpacks: []buildpackReleaseInfo{{BuildpackName: "python_buildpack", BuildpackVersion: "v1.7.45", CurrentVersion: currentVersion}}}
+	}
+	apps := []appInfo{
+		newApp("2020-01-02T00:00:00Z", ""),
+		newApp("2019-12-31T00:00:00Z", ""),
+		newApp("2020-01-02T00:00:00Z", "1.7.44"),
+	}
+	resolveVersionsFromHistory(apps, buildpacks, previous)
+	for i, expected := range []string{"v1.7.43", "", "1.7.44"} {
+		if version := apps[i].Buildpacks[0].CurrentVersion; version != expected {
+			t.Errorf("Expected app %d to be staged with %q, found %q", i, expected, version)
+		}
+	}
+	if version := apps[2].Buildpacks[0].YourVersion(); version != "v1.7.44" {
+		t.Errorf("Expected the version the app was staged with to be written like the latest, found %s", version)
+	}
+}
+
+func TestResolveBuildpackAlias(t *testing.T) {
+	aliases := map[string]string{
+		"staticfile_buildpack_old": "staticfile_buildpack_v2",
+		"staticfile_buildpack_v2":  "nginx_buildpack",
+		"loop_a":                   "loop_b",
+		"loop_b":                   "loop_a",
+	}
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"python_buildpack", "python_buildpack"},
+		{"staticfile_buildpack_v2", "nginx_buildpack"},
+		{"staticfile_buildpack_old", "nginx_buildpack"},
+		{"loop_a", "loop_a"},
+	}
+	for _, tc := range testCases {
+		if actual := resolveBuildpackAlias(tc.name, aliases); actual != tc.expected {
+			t.Errorf("Test %s failed. Expected %s, found %s", tc.name, tc.expected, actual)
+		}
+	}
+}
+
+func TestAddBuildpackAliases(t *testing.T) {
+	buildpacks := map[string]Buildpack{
+		"nginx_buildpack":  {GUID: "bp1", Name: "nginx_buildpack"},
+		"python_buildpack": {GUID: "bp2", Name: "python_buildpack"},
+	}
+	addBuildpackAliases(buildpacks, map[string]string{
+		"staticfile_buildpack": "nginx_buildpack",
+		"python_buildpack":     "nginx_buildpack",
+		"ruby_buildpack_old":   "ruby_buildpack",
+	})
+	droplet := newTestDroplet("2020-01-01T00:00:00Z", "staticfile_buildpack", "python_buildpack", "ruby_buildpack_old")
+	supported := getSupportedBuildpacksOfDroplet(droplet, buildpacks)
+	if len(supported) != 2 || supported[0].GUID != "bp1" || supported[1].GUID != "bp2" {
+		t.Errorf("Expected staticfile_buildpack to resolve to nginx_buildpack, found %+v", supported)
+	}
+}
+
+func newTestDroplet(createdAt string, buildpacks ...string) Droplet {
+	droplet := Droplet{GUID: "droplet-guid", CreatedAt: createdAt}
+	droplet.Links.App.Href = "https://api.example.com/v3/apps/app1"
+	for _, name := range buildpacks {
+		droplet.Buildpacks = append(droplet.Buildpacks, DropletBuildpack{Name: name})
+	}
+	return droplet
+}
+
+func newTestStartedApp(lifecycle string) App {
+	app := App{GUID: "app1", State: "STARTED"}
+	app.Lifecycle.Type = lifecycle
+	return app
+}
+
+func newTestBuild(guid, dropletGUID string) Build {
+	build := Build{GUID: guid, State: "STAGED"}
+	build.Droplet.GUID = dropletGUID
+	return build
+}
+
+func TestGetCurrentDropletForApp(t *testing.T) {
+	testCases := []struct {
+		name            string
+		current         []Droplet
+		builds          []Build
+		expectedDroplet string
+	}{
+		{"current droplet", []Droplet{{GUID: "current"}}, nil, "current"},
+		{"latest build", nil, []Build{newTestBuild("build1", "built")}, "built"},
+		{"no build", nil, nil, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoder := json.NewEncoder(w)
+				switch r.URL.Path {
+				case "/v3/apps/app1/droplets":
+					encoder.Encode(DropletResponse{Droplets: tc.current})
+				case "/v3/builds":
+					if r.URL.Query().Get("order_by") != "-created_at" || r.URL.Query().Get("states") != "STAGED" {
+						t.Errorf("Test %s failed. Expected the latest staged build, found %s", tc.name, r.URL.RawQuery)
+					}
+					encoder.Encode(BuildResponse{Builds: tc.builds})
+				case "/v3/droplets/built":
+					encoder.Encode(Droplet{GUID: "built"})
+				default:
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			droplet, found, err := getCurrentDropletForApp(newTestStartedApp("buildpack"), &c)
+			if err != nil || found != (tc.expectedDroplet != "") || droplet.GUID != tc.expectedDroplet {
+				t.Errorf("Test %s failed. Expected droplet %q, found %+v/%t/%v", tc.name, tc.expectedDroplet, droplet, found, err)
+			}
+		})
+	}
+}
+
+func TestGetDropletsToCheck(t *testing.T) {
+	testCases := []struct {
+		name            string
+		failBulk        bool
+		expectedLookups int
+	}{
+		// Every third app kept several staged droplets. Those that aren't
+		// being deployed are the only ones looked up on their own.
+		{"bulk", false, 3},
+		{"bulk fails", true, 19},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			lookups, inFlight, maxInFlight := 0, 0, 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				encoder := json.NewEncoder(w)
+				if r.URL.Path == "/v3/droplets" {
+					if tc.failBulk {
+						w.WriteHeader(http.StatusInternalServerError)
+						return
+					}
+					if states := r.URL.Query().Get("states"); states != "STAGED" {
+						t.Errorf("Test %s failed. Expected only staged droplets, found %s", tc.name, states)
+					}
+					var resp DropletResponse
+					for i, appGUID := range strings.Split(r.URL.Query().Get("app_guids"), ",") {
+						droplet := Droplet{GUID: appGUID + "-droplet"}
+						droplet.Links.App.Href = "https://api.example.com/v3/apps/" + appGUID
+						resp.Droplets = append(resp.Droplets, droplet)
+						if i%3 == 0 {
+							droplet.GUID = appGUID + "-old-droplet"
+							resp.Droplets = append(resp.Droplets, droplet)
+						}
+					}
+					encoder.Encode(resp)
+					return
+				}
+				if r.URL.Path == "/v3/deployments" {
+					if status := r.URL.Query().Get("status_values"); status != "ACTIVE" {
+						t.Errorf("Test %s failed. Expected only active deployments, found %s", tc.name, status)
+					}
+					// Apps with an even number are being deployed.
+					var resp DeploymentResponse
+					for _, appGUID := range strings.Split(r.URL.Query().Get("app_guids"), ",") {
+						var n int
+						fmt.Sscanf(appGUID, "app%d", &n)
+						if n%2 == 0 {
+							deployment := Deployment{GUID: appGUID + "-deployment"}
+							deployment.Relationships.App.Data.GUID = appGUID
+							deployment.Droplet.GUID = appGUID + "-droplet"
+							resp.Deployments = append(resp.Deployments, deployment)
+						}
+					}
+					encoder.Encode(resp)
+					return
+				}
+				mu.Lock()
+				lookups++
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				appGUID := strings.Split(r.URL.Path, "/")[3]
+				encoder.Encode(DropletResponse{Droplets: []Droplet{{GUID: appGUID + "-droplet"}}})
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			var apps []App
+			for i := 0; i < 20; i++ {
+				app := newTestStartedApp("buildpack")
+				app.GUID = fmt.Sprintf("app%d", i)
+				apps = append(apps, app)
+			}
+			apps[1].State = "STOPPED"
+			results := getDropletsToCheck(apps, &c, 4, newRunReport(), &runErrors{}, nil)
+			if len(results) != len(apps) {
+				t.Fatalf("Test %s failed. Expected %d results, found %d", tc.name, len(apps), len(results))
+			}
+			for i, result := range results {
+				if result.app.GUID != apps[i].GUID {
+					t.Errorf("Test %s failed. Expected result %d to be about %s, found %s", tc.name, i, apps[i].GUID, result.app.GUID)
+				}
+				if result.ok != (i != 1) || (result.ok && result.droplet.GUID != apps[i].GUID+"-droplet") {
+					t.Errorf("Test %s failed. Unexpected result %d %+v", tc.name, i, result)
+				}
+			}
+			if lookups != tc.expectedLookups {
+				t.Errorf("Test %s failed. Expected %d droplet lookups, found %d", tc.name, tc.expectedLookups, lookups)
+			}
+			if maxInFlight < 2 || maxInFlight > 4 {
+				t.Errorf("Test %s failed. Expected between 2 and 4 droplet lookups at a time, found %d", tc.name, maxInFlight)
+			}
+		})
+	}
+}
+
+func newTestSnoozedApp(until string) App {
+	app := newTestStartedApp("buildpack")
+	app.Metadata.Labels = map[string]string{snoozeLabel: until}
+	return app
+}
+
+func TestGetSnoozeOfApp(t *testing.T) {
+	now := time.Date(2024, 9, 1, 15, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		app      App
+		expected bool
+	}{
+		{"no label", newTestStartedApp("buildpack"), false},
+		{"snoozed until later", newTestSnoozedApp("2024-09-02"), true},
+		{"snoozed until today", newTestSnoozedApp("2024-09-01"), true},
+		{"snooze passed", newTestSnoozedApp("2024-08-31"), false},
+		{"not a date", newTestSnoozedApp("next-week"), false},
+	}
+	for _, tc := range testCases {
+		if _, snoozed := getSnoozeOfApp(tc.app, now, nil); snoozed != tc.expected {
+			t.Errorf("Test %s failed. Expected snoozed %v, found %v", tc.name, tc.expected, snoozed)
+		}
+	}
+}
+
+func TestIsDropletUsingOutdatedBuildpack(t *testing.T) {
+	buildpack := Buildpack{GUID: "bp1", Name: "python_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"}
+	testCases := []struct {
+		name      string
+		createdAt string
+		tolerance time.Duration
+		expected  bool
+	}{
+		{"staged before update", "2020-01-31T23:55:00Z", 0, true},
+		{"staged after update", "2020-02-01T00:05:00Z", 0, false},
+		{"staged within tolerance", "2020-01-31T23:55:00Z", 10 * time.Minute, false},
+		{"staged before tolerance", "2020-01-31T23:45:00Z", 10 * time.Minute, true},
+	}
+	for _, tc := range testCases {
+		droplet := newTestDroplet(tc.createdAt, "python_buildpack")
+		outdated, err := isDropletUsingOutdatedBuildpack(nil, droplet, buildpack, tc.tolerance)
+		if err != nil || outdated != tc.expected {
+			t.Errorf("Test %s failed. Expected outdated to be %t, found %t/%v", tc.name, tc.expected, outdated, err)
+		}
+	}
+}
+
+func TestFindOutdatedApps(t *testing.T) {
+	buildpacks := map[string]Buildpack{
+		"python_buildpack": {GUID: "bp1", Name: "python_buildpack", Filename: "python_buildpack-cflinuxfs3-v1.7.43.zip", UpdatedAt: "2020-02-01T00:00:00Z"},
+	}
+	testCases := []struct {
+		name             string
+		app              App
+		droplets         []Droplet
+		expectedDecision appDecision
+		expectedGitApps  int
+	}{
+		{"not started", App{GUID: "app1", State: "STOPPED"}, nil, decisionNotStarted, 0},
+		{"docker", newTestStartedApp("docker"), nil, decisionDocker, 0},
+		{"snoozed", newTestSnoozedApp("2999-01-01"), nil, decisionSnoozed, 0},
+		{"snooze passed", newTestSnoozedApp("2000-01-01"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")}, decisionOutdated, 0},
+		{"no current droplet", newTestStartedApp("buildpack"), nil, decisionNoDroplet, 0},
+		{"unsupported buildpack", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "custom_buildpack")}, decisionUnsupportedBuildpack, 0},
+		{"disabled buildpack", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "ruby_buildpack")}, decisionDisabledBuildpack, 0},
+		{"disabled and outdated buildpack", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "ruby_buildpack", "python_buildpack")}, decisionOutdated, 0},
+		{"not outdated", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-03-01T00:00:00Z", "python_buildpack")}, decisionNotOutdated, 0},
+		{"outdated", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")}, decisionOutdated, 0},
+		{"bad droplet timestamp", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("yesterday", "python_buildpack")}, decisionError, 0},
+		{"git buildpack", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "https://github.com/cloudfoundry/python-buildpack#v1.7.43")}, decisionGitBuildpack, 1},
+		{"git and outdated system buildpack", newTestStartedApp("buildpack"), []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "https://github.com/example/apt-buildpack#v0.2.1", "python_buildpack")}, decisionOutdated, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dropletRequests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v3/droplets" {
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+				dropletRequests++
+				json.NewEncoder(w).Encode(DropletResponse{Droplets: tc.droplets})
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			report := newRunReport()
+			outdated, gitApps, _ := findOutdatedApps(&c, []App{tc.app}, buildpacks, map[string]bool{"ruby_buildpack": true}, defaultBuildpackLinks, 0, 1, report, &runErrors{}, nil)
+			if report.count(tc.expectedDecision) != 1 {
+				t.Errorf("Test %s failed. Expected decision %s, found %+v", tc.name, tc.expectedDecision, report.decisions)
+			}
+			if (len(outdated) == 1) != (tc.expectedDecision == decisionOutdated) {
+				t.Errorf("Test %s failed. Unexpected outdated apps %+v", tc.name, outdated)
+			}
+			if len(gitApps) != tc.expectedGitApps {
+				t.Errorf("Test %s failed. Expected %d apps using git buildpacks, found %+v", tc.name, tc.expectedGitApps, gitApps)
+			}
+			if (tc.expectedDecision == decisionDocker || tc.expectedDecision == decisionNotStarted || tc.expectedDecision == decisionSnoozed) && dropletRequests != 0 {
+				t.Errorf("Test %s failed. Expected no droplet requests, found %d", tc.name, dropletRequests)
+			}
+		})
+	}
+}
+
+func TestDecisionTrace(t *testing.T) {
+	buildpacks := map[string]Buildpack{
+		"python_buildpack": {GUID: "bp1", Name: "python_buildpack", UpdatedAt: "2020-02-01T00:00:00Z"},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(DropletResponse{Droplets: []Droplet{newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")}})
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	report := newRunReport()
+	app := newTestStartedApp("buildpack")
+	findOutdatedApps(&c, []App{app}, buildpacks, nil, defaultBuildpackLinks, time.Minute, 1, report, &runErrors{}, nil)
+
+	var buf bytes.Buffer
+	if err := report.writeDecisionTrace(&buf, "run1"); err != nil {
+		t.Fatalf("Unable to write decision trace. Error: %s", err)
+	}
+	var trace decisionTrace
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("Expected a single JSON line, found %q. Error: %s", buf.String(), err)
+	}
+	if trace.RunID != "run1" || trace.AppGUID != app.GUID || trace.Decision != decisionOutdated {
+		t.Errorf("Expected the outdated app of run run1, found %+v", trace)
+	}
+	var outcomes []string
+	for _, step := range trace.Steps {
+		outcomes = append(outcomes, step.Check+": "+step.Outcome)
+	}
+	expected := []string{"state: started", "current droplet: found", "buildpack python_buildpack: outdated"}
+	if strings.Join(outcomes, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("Expected steps %v, found %v", expected, outcomes)
+	}
+	compared := trace.Steps[len(trace.Steps)-1].Detail
+	if !strings.Contains(compared, "2020-02-01T00:00:00Z") || !strings.Contains(compared, "2020-01-01T00:00:00Z") || !strings.Contains(compared, "1m0s") {
+		t.Errorf("Expected the timestamps compared, found %q", compared)
+	}
+}
+
+func TestNeedsAppsWithoutUpdates(t *testing.T) {
+	if (Config{}).needsAppsWithoutUpdates(newRunState(), nil) {
+		t.Errorf("Expected a run without updates to have nothing to do")
+	}
+	held := newRunState()
+	held.HeldNotifications["app1"] = heldNotification{Name: "app1"}
+	queued := newRunState()
+	queued.RestageQueue["app1"] = queuedRestage{Name: "app1"}
+	notified := newRunState()
+	notified.Apps["app1"] = appNotificationRecord{FirstNotifiedAt: "2020-01-01T00:00:00Z"}
+	resolved := newRunState()
+	resolved.Apps["app1"] = appNotificationRecord{FirstNotifiedAt: "2020-01-01T00:00:00Z", ResolvedAt: "2020-01-02T00:00:00Z"}
+	testCases := []struct {
+		name     string
+		config   Config
+		state    *runState
+		restage  *restageScope
+		expected bool
+	}{
+		{"held notifications", Config{}, held, nil, true},
+		{"queued restages", Config{}, queued, &restageScope{}, true},
+		{"queued restages without restaging", Config{}, queued, nil, false},
+		{"pinned buildpack warnings", Config{NotifyPinnedBuildpacks: true}, newRunState(), nil, true},
+		{"restage confirmations", Config{SendRestageConfirmations: true}, notified, nil, true},
+		{"restage confirmations all sent", Config{SendRestageConfirmations: true}, resolved, nil, false},
+		{"notified apps without confirmations", Config{}, notified, nil, false},
+	}
+	for _, tc := range testCases {
+		if needed := tc.config.needsAppsWithoutUpdates(tc.state, tc.restage); needed != tc.expected {
+			t.Errorf("Test %s failed. Expected %t, found %t", tc.name, tc.expected, needed)
+		}
+	}
+}
+
+func TestRunStateTrackedApps(t *testing.T) {
+	state := newRunState()
+	state.Apps["notified"] = appNotificationRecord{}
+	state.RestageQueue["queued"] = queuedRestage{}
+	state.HeldNotifications["held"] = heldNotification{}
+	state.RestagePlan = &restagePlan{Restages: map[string]queuedRestage{"planned": {}}}
+	apps := newTestAppInfos([]App{{GUID: "notified"}, {GUID: "queued"}, {GUID: "held"}, {GUID: "planned"}, {GUID: "untracked"}})
+	tracked := state.trackedApps(apps)
+	if len(tracked) != 4 {
+		t.Fatalf("Expected 4 tracked apps, found %+v", tracked)
+	}
+	for _, app := range tracked {
+		if app.GUID == "untracked" {
+			t.Errorf("Expected untracked apps to be dropped, found %+v", tracked)
+		}
+	}
+}
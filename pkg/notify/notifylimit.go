@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"sort"
+	"time"
+)
+
+// heldNotification is an outdated app whose owners weren't notified because
+// the run reached its limit of e-mails, or because finding its owners or
+// e-mailing them failed, carried forward in the state to the next run.
+type heldNotification struct {
+	Name        string
+	DropletGUID string
+	Buildpacks  []buildpackReleaseInfo
+	HeldAt      string
+	// Recipients are the owners left to notify, when the notification reached
+	// the others already. Empty is every owner.
+	Recipients []string `json:",omitempty"`
+}
+
+// takeHeldNotifications takes the notifications held back by the last run
+// out of held and adds their apps to outdated, as long as they still run the
+// droplet they were held with. Apps restaged or stopped in the meantime, or
+// not checked this run, are dropped, as are apps outdated again anyway.
+func takeHeldNotifications(outdated []appInfo, checked []appInfo, held map[string]heldNotification, report *runReport, logger *Logger) []appInfo {
+	if len(held) == 0 {
+		return outdated
+	}
+	alreadyOutdated := make(map[string]bool)
+	for _, app := range outdated {
+		alreadyOutdated[app.GUID] = true
+	}
+	taken := 0
+	for _, app := range checked {
+		notification, found := held[app.GUID]
+		if !found || notification.DropletGUID != app.DropletGUID || alreadyOutdated[app.GUID] {
+			continue
+		}
+		app.Buildpacks = notification.Buildpacks
+		app.recipients = notification.Recipients
+		report.traceApp(app.App, "held notification", "carried forward", "the notification was held back by the last run")
+		report.recordApp(app.App, decisionOutdated)
+		report.recordOutdatedBuildpacks(app.App, app.Buildpacks)
+		outdated = append(outdated, app)
+		taken++
+	}
+	logger.infof("Carrying forward %d of %d notifications held back by the last run.\n", taken, len(held))
+	for guid := range held {
+		delete(held, guid)
+	}
+	return outdated
+}
+
+// limitNotifications picks the apps whose owners can be notified without
+// sending more than limit e-mails, an e-mail per owner, going through the apps
+// in order of the severity of the security fixes their restage picks up, then
+// of GUID, and skipping those with too many owners not yet picked. It
+// returns the owners to notify about the picked apps and the apps held back.
+func limitNotifications(owners map[string][]appInfo, limit int) (map[string][]appInfo, []appInfo) {
+	ownersOfApp := make(map[string][]string)
+	apps := make(map[string]appInfo)
+	for owner, ownedApps := range owners {
+		for _, app := range ownedApps {
+			ownersOfApp[app.GUID] = append(ownersOfApp[app.GUID], owner)
+			apps[app.GUID] = app
+		}
+	}
+	guids := make([]string, 0, len(apps))
+	for guid := range apps {
+		guids = append(guids, guid)
+	}
+	// The apps whose restage picks up the most severe security fixes are
+	// notified about first.
+	sort.Slice(guids, func(i, j int) bool {
+		if ri, rj := securityRank(apps[guids[i]]), securityRank(apps[guids[j]]); ri != rj {
+			return ri > rj
+		}
+		return guids[i] < guids[j]
+	})
+	picked := make(map[string]bool)
+	var held []appInfo
+	for _, guid := range guids {
+		newOwners := 0
+		for _, owner := range ownersOfApp[guid] {
+			if !picked[owner] {
+				newOwners++
+			}
+		}
+		if len(picked)+newOwners > limit {
+			held = append(held, apps[guid])
+			continue
+		}
+		for _, owner := range ownersOfApp[guid] {
+			picked[owner] = true
+		}
+	}
+	if len(held) == 0 {
+		return owners, nil
+	}
+	heldGUIDs := make(map[string]bool)
+	for _, app := range held {
+		heldGUIDs[app.GUID] = true
+	}
+	limited := make(map[string][]appInfo)
+	for owner := range picked {
+		for _, app := range owners[owner] {
+			if !heldGUIDs[app.GUID] {
+				limited[owner] = append(limited[owner], app)
+			}
+		}
+	}
+	return limited, held
+}
+
+// holdNotifications records the apps held back in held, to notify their owners
+// in the next run, and returns outdated without them.
+func holdNotifications(outdated []appInfo, heldApps []appInfo, held map[string]heldNotification, now time.Time) []appInfo {
+	heldAt := now.UTC().Format(time.RFC3339)
+	heldGUIDs := make(map[string]bool)
+	for _, app := range heldApps {
+		heldGUIDs[app.GUID] = true
+		held[app.GUID] = heldNotification{Name: app.Name, DropletGUID: app.DropletGUID, Buildpacks: app.Buildpacks, HeldAt: heldAt, Recipients: app.recipients}
+	}
+	var notified []appInfo
+	for _, app := range outdated {
+		if !heldGUIDs[app.GUID] {
+			notified = append(notified, app)
+		}
+	}
+	return notified
+}
@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimitNotifications(t *testing.T) {
+	app1 := appInfo{App: App{GUID: "app1", Name: "app1"}, DropletGUID: "droplet1"}
+	app2 := appInfo{App: App{GUID: "app2", Name: "app2"}, DropletGUID: "droplet2"}
+	app3 := appInfo{App: App{GUID: "app3", Name: "app3"}, DropletGUID: "droplet3", Buildpacks: []buildpackReleaseInfo{{BuildpackName: "python_buildpack"}}}
+	owners := map[string][]appInfo{
+		user1:                 {app1, app2},
+		user2:                 {app2},
+		"user3@example.com":   {app3},
+		"manager@example.com": {app3},
+	}
+	testCases := []struct {
+		name           string
+		limit          int
+		expectedOwners map[string][]appInfo
+		expectedHeld   []appInfo
+	}{
+		{"under the limit", 4, owners, nil},
+		{
+			// app3 has two owners not picked yet, which don't fit.
+			"over the limit",
+			3,
+			map[string][]appInfo{user1: {app1, app2}, user2: {app2}},
+			[]appInfo{app3},
+		},
+		{"first app only", 1, map[string][]appInfo{user1: {app1}}, []appInfo{app2, app3}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limited, held := limitNotifications(owners, tc.limit)
+			if !reflect.DeepEqual(limited, tc.expectedOwners) {
+				t.Errorf("Test %s failed. Expected owners %+v, found %+v", tc.name, tc.expectedOwners, limited)
+			}
+			if !reflect.DeepEqual(held, tc.expectedHeld) {
+				t.Errorf("Test %s failed. Expected held apps %+v, found %+v", tc.name, tc.expectedHeld, held)
+			}
+		})
+	}
+}
+
+func TestLimitNotificationsSecurityFixesFirst(t *testing.T) {
+	app1 := appInfo{App: App{GUID: "app1"}}
+	app2 := appInfo{App: App{GUID: "app2"}, Buildpacks: []buildpackReleaseInfo{{SecurityFixes: []securityFix{{CVE: "CVE-2023-1"}}}}}
+	app3 := appInfo{App: App{GUID: "app3"}, Buildpacks: []buildpackReleaseInfo{{SecurityFixes: []securityFix{{CVE: "CVE-2023-2", Severity: "High"}}}}}
+	owners := map[string][]appInfo{user1: {app1}, user2: {app2}, "user3@example.com": {app3}}
+	limited, held := limitNotifications(owners, 2)
+	expected := map[string][]appInfo{user2: {app2}, "user3@example.com": {app3}}
+	if !reflect.DeepEqual(limited, expected) || !reflect.DeepEqual(held, []appInfo{app1}) {
+		t.Errorf("Expected the apps with security fixes to be notified about first, found %+v holding back %+v", limited, held)
+	}
+}
+
+func TestHeldNotificationsCarryForward(t *testing.T) {
+	app1 := appInfo{App: App{GUID: "app1", Name: "app1"}, DropletGUID: "droplet1"}
+	app2 := appInfo{App: App{GUID: "app2", Name: "app2"}, DropletGUID: "droplet2", Buildpacks: []buildpackReleaseInfo{{BuildpackName: "python_buildpack"}}}
+	app3 := appInfo{App: App{GUID: "app3", Name: "app3"}, DropletGUID: "droplet3", Buildpacks: []buildpackReleaseInfo{{BuildpackName: "ruby_buildpack"}}}
+	held := make(map[string]heldNotification)
+	notified := holdNotifications([]appInfo{app1, app2, app3}, []appInfo{app2, app3}, held, time.Now())
+	if len(notified) != 1 || notified[0].GUID != "app1" || len(held) != 2 {
+		t.Fatalf("Expected app2 and app3 to be held back, found %+v notified and %+v held", notified, held)
+	}
+
+	// The next run finds neither outdated, since the buildpack updates were
+	// recorded, and app3 was restaged in the meantime.
+	checked := []appInfo{app1, {App: app2.App, DropletGUID: "droplet2"}, {App: app3.App, DropletGUID: "droplet4"}}
+	outdated := takeHeldNotifications(nil, checked, held, newRunReport(), nil)
+	if len(outdated) != 1 || outdated[0].GUID != "app2" || !reflect.DeepEqual(outdated[0].Buildpacks, app2.Buildpacks) {
+		t.Errorf("Expected app2 to be carried forward with its buildpacks, found %+v", outdated)
+	}
+	if len(held) != 0 {
+		t.Errorf("Expected the held notifications to be taken, found %+v", held)
+	}
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// orgCount is how many apps of an org something applies to.
+type orgCount struct {
+	Org  string
+	Apps int
+}
+
+// newOperatorSummary summarizes the run for the operators: what triggered
+// it, the outdated apps by org, the e-mails sent, the users who couldn't be
+// notified and what needs their attention.
+func newOperatorSummary(runID string, dryRun bool, triggers []string, report *runReport, errs *runErrors) operatorSummaryEmail {
+	summary := operatorSummaryEmail{RunID: runID, DryRun: dryRun, Triggers: triggers}
+	outdated := make(map[string]int)
+	heldBack := 0
+	for _, app := range report.appReports() {
+		switch app.Decision {
+		case decisionOutdated, decisionCampaignTarget, decisionEOLStack:
+			outdated[app.Org]++
+		case decisionHeldBack:
+			outdated[app.Org]++
+			heldBack++
+		}
+	}
+	for org, apps := range outdated {
+		summary.OutdatedByOrg = append(summary.OutdatedByOrg, orgCount{Org: org, Apps: apps})
+	}
+	sort.Slice(summary.OutdatedByOrg, func(i, j int) bool {
+		return summary.OutdatedByOrg[i].Org < summary.OutdatedByOrg[j].Org
+	})
+
+	report.mu.Lock()
+	for username := range report.droppedUsers {
+		summary.DroppedUsers = append(summary.DroppedUsers, username)
+	}
+	systemApps := append([]string(nil), report.systemApps...)
+	var withoutFilename []string
+	for name := range report.buildpacksWithoutFilename {
+		withoutFilename = append(withoutFilename, name)
+	}
+	report.mu.Unlock()
+	sort.Strings(summary.DroppedUsers)
+	sort.Strings(systemApps)
+	sort.Strings(withoutFilename)
+
+	for _, app := range systemApps {
+		summary.FollowUps = append(summary.FollowUps, fmt.Sprintf("App %s is in a system org, left to the operators", app))
+	}
+	for _, name := range withoutFilename {
+		summary.FollowUps = append(summary.FollowUps, fmt.Sprintf("Buildpack %s has no filename, so its owners were linked to its releases page instead of a version", name))
+	}
+	if heldBack > 0 {
+		summary.FollowUps = append(summary.FollowUps, fmt.Sprintf("%d apps were held back by --limit until the next run", heldBack))
+	}
+	for _, message := range errs.messages() {
+		summary.FollowUps = append(summary.FollowUps, "Error: "+message)
+	}
+
+	errs.mu.Lock()
+	summary.EmailsSent, summary.EmailsFailed = errs.sent, errs.sendFailures
+	errs.mu.Unlock()
+	return summary
+}
+
+// sendOperatorSummaryEmails sends the summary of the run to the operators at
+// recipients.
+func sendOperatorSummaryEmails(summary operatorSummaryEmail, recipients []string, templates *Templates, mailer Mailer, dryRun bool, errs *runErrors, logger *Logger) {
+	body := new(bytes.Buffer)
+	if err := templates.getOperatorSummaryEmail(body, summary); err != nil {
+		errs.addf("Unable to render operator summary e-mail. Error: %s", err)
+		return
+	}
+	outdated := 0
+	for _, org := range summary.OutdatedByOrg {
+		outdated += org.Apps
+	}
+	subj := fmt.Sprintf("buildpack-notify run %s: %d outdated apps, %d e-mails sent, %d failed", summary.RunID, outdated, summary.EmailsSent, summary.EmailsFailed)
+	for _, recipient := range recipients {
+		if !dryRun {
+			if err := mailer.SendEmail(recipient, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", recipient, err)
+				continue
+			}
+		}
+		logger.with(logFields{"recipient", recipient}).infof("Sent operator summary e-mail to %s\n", recipient)
+	}
+}
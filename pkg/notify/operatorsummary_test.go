@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloud-gov/buildpack-notify/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOperatorSummary(t *testing.T) {
+	report := newTestRunReport()
+	heldApp := newTestApp("app4", "space1")
+	report.recordApp(heldApp, decisionHeldBack)
+	report.recordSystemApp(newTestApp("app5", "space2"), spaceInfo{Space: Space{Name: "tools"}, Org: Organization{Name: "system"}})
+	report.recordDroppedUser("admin")
+	report.recordDroppedUser("admin")
+	errs := &runErrors{sent: 2}
+	errs.addf("Unable to send e-mail to %s. Error: %s", user2, "mailbox full")
+
+	summary := newOperatorSummary("run1", false, []string{"python_buildpack"}, report, errs)
+	if len(summary.OutdatedByOrg) != 1 || summary.OutdatedByOrg[0] != (orgCount{"agency", 2}) {
+		t.Errorf("Expected the outdated and held back apps of agency, found %+v", summary.OutdatedByOrg)
+	}
+	if len(summary.DroppedUsers) != 1 || summary.DroppedUsers[0] != "admin" {
+		t.Errorf("Expected admin to be dropped once, found %v", summary.DroppedUsers)
+	}
+	if len(summary.FollowUps) != 3 || !strings.Contains(summary.FollowUps[0], "system") || !strings.Contains(summary.FollowUps[1], "held back") || !strings.Contains(summary.FollowUps[2], "mailbox full") {
+		t.Errorf("Expected the system app, the held back app and the error to be followed up, found %v", summary.FollowUps)
+	}
+	if summary.EmailsSent != 2 {
+		t.Errorf("Expected 2 e-mails sent, found %d", summary.EmailsSent)
+	}
+
+	templates, _ := initTemplates()
+	mockMailer := new(mocks.Mailer)
+	mockMailer.On("SendEmail", "ops@example.gov", "buildpack-notify run run1: 2 outdated apps, 2 e-mails sent, 0 failed", mock.Anything).Return(nil)
+	sendOperatorSummaryEmails(summary, []string{"ops@example.gov"}, templates, mockMailer, false, errs, nil)
+	mockMailer.AssertExpectations(t)
+	sendOperatorSummaryEmails(summary, []string{"ops@example.gov"}, templates, mockMailer, true, errs, nil)
+	mockMailer.AssertNumberOfCalls(t, "SendEmail", 1)
+}
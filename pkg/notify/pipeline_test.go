@@ -0,0 +1,240 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runAgainstFakeCFAPI runs the pipeline configured by the environment against
+// api from the state inState, returning the exit code of the run, the e-mails
+// it sent and the state it saved.
+func runAgainstFakeCFAPI(t *testing.T, api *fakeCFAPI, inState string) (int, *memoryMailer, *runState) {
+	mailer := newMemoryMailer()
+	code, state := runAgainstFakeCFAPIWith(t, api, inState, mailer)
+	return code, mailer, state
+}
+
+// runAgainstFakeCFAPIWith is runAgainstFakeCFAPI sending the e-mails through
+// mailer.
+func runAgainstFakeCFAPIWith(t *testing.T, api *fakeCFAPI, inState string, mailer *memoryMailer) (int, *runState) {
+	dir := t.TempDir()
+	inPath, outPath := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+	if err := ioutil.WriteFile(inPath, []byte(inState), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	t.Setenv("IN_STATE", inPath)
+	t.Setenv("OUT_STATE", outPath)
+	api.setEnv(t)
+	config, cfAPIConfig := loadTestConfig(t)
+	code := ExitCode(Run(config, cfAPIConfig, mailer))
+	state, err := loadState(outPath)
+	if err != nil {
+		t.Fatalf("Unable to read the state saved by the run. Error: %s", err)
+	}
+	return code, state
+}
+
+const fakeCFAPIState = `{"Buildpacks": {"bp1": {"LastUpdatedAt": "2020-01-15T00:00:00Z"}}}`
+
+func TestPipelineNotifiesOwnersOfOutdatedApps(t *testing.T) {
+	setTestConfigEnv(t)
+	api := newFakeCFAPI(t, newTestFixtures())
+	code, mailer, state := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code != ExitOK {
+		t.Fatalf("Expected the run to succeed, found exit code %d", code)
+	}
+	sent := mailer.emails()
+	if len(sent) != 1 || sent[0].To != user1 {
+		t.Fatalf("Expected a single e-mail to the space developer %s, found %+v", user1, sent)
+	}
+	if sent[0].Subject != "Action required: restage your application" || sent[0].RunID == "" {
+		t.Errorf("Expected the notification of the run, found %+v", sent[0])
+	}
+	if body := sent[0].Body; !strings.Contains(body, "cf restage --strategy rolling app1") || !strings.Contains(body, "python_buildpack yours: v1.7.40 → latest: v1.8.0") {
+		t.Errorf("Expected the e-mail to tell about app1 on python_buildpack 1.7.40, found\n%s", body)
+	}
+	if record := state.Buildpacks["bp1"]; record.LastUpdatedAt != "2020-02-01T00:00:00Z" {
+		t.Errorf("Expected the update of python_buildpack to be recorded, found %+v", record)
+	}
+	if _, notified := state.Apps["app1"]; !notified {
+		t.Errorf("Expected the notification about app1 to be recorded, found %+v", state.Apps)
+	}
+	if api.tokens == 0 || api.requestCount("GET /v2/info") != 1 {
+		t.Errorf("Expected the client to discover the API and fetch a token, found %d tokens and requests %v", api.tokens, api.requests)
+	}
+
+	// The next run finds nothing new to notify about.
+	code, mailer, _ = runAgainstFakeCFAPI(t, api, `{"Buildpacks": {"bp1": {"LastUpdatedAt": "2020-02-01T00:00:00Z"}}}`)
+	if sent := mailer.emails(); code != ExitOK || len(sent) != 0 {
+		t.Errorf("Expected a run without buildpack updates to send nothing, found exit code %d and e-mails %+v", code, sent)
+	}
+}
+
+func TestPipelineListsOutdatedApps(t *testing.T) {
+	setTestConfigEnv(t)
+	api := newFakeCFAPI(t, newTestFixtures())
+	api.setEnv(t)
+	dir := t.TempDir()
+	inPath, outPath := filepath.Join(dir, "in.json"), filepath.Join(dir, "out.json")
+	if err := ioutil.WriteFile(inPath, []byte(fakeCFAPIState), 0644); err != nil {
+		t.Fatalf("Unable to write state. Error: %s", err)
+	}
+	t.Setenv("IN_STATE", inPath)
+	t.Setenv("OUT_STATE", outPath)
+	config, cfAPIConfig := loadTestConfig(t)
+	// What report --list sets.
+	var out bytes.Buffer
+	config.DryRun, config.ReadOnly, config.ListOutdated = true, true, &out
+	if code := ExitCode(Run(config, cfAPIConfig, nil)); code != ExitOK {
+		t.Fatalf("Expected the run to succeed, found exit code %d", code)
+	}
+	expected := "agency/dev/app1 guid app1: python_buildpack 1.7.40 -> v1.8.0. Owners: " + user1 + "\n1 outdated apps.\n"
+	if out.String() != expected {
+		t.Errorf("Expected\n%s\nfound\n%s", expected, out.String())
+	}
+	if _, err := os.Stat(outPath); !os.IsNotExist(err) {
+		t.Errorf("Expected listing the outdated apps to leave OUT_STATE alone, found %v", err)
+	}
+}
+
+func TestPipelineLeavesStateAloneWhenListingsAreCutShort(t *testing.T) {
+	setTestConfigEnv(t)
+	t.Setenv("CF_PER_PAGE", "1")
+	t.Setenv("CF_MAX_PAGES", "1")
+	f := newTestFixtures()
+	app := newTestApp("app2", "space1")
+	app.Name, app.State = "app2", "STARTED"
+	app.Lifecycle.Type = "buildpack"
+	droplet := newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")
+	droplet.GUID = "droplet2-guid"
+	droplet.Links.App.Href = "https://api.example.com/v3/apps/app2"
+	f.Apps, f.Droplets = append(f.Apps, app), append(f.Droplets, droplet)
+	api := newFakeCFAPI(t, f)
+	t.Setenv("DRY_RUN", "true")
+	code, mailer, state := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code != ExitOK {
+		t.Fatalf("Expected the run to succeed, found exit code %d", code)
+	}
+	if sent := mailer.emails(); len(sent) != 0 {
+		t.Errorf("Expected a dry run to send nothing, found %+v", sent)
+	}
+	if record := state.Buildpacks["bp1"]; record.LastUpdatedAt != "2020-01-15T00:00:00Z" {
+		t.Errorf("Expected the state to be left alone, found %+v", record)
+	}
+}
+
+func TestPipelineRetriesFailedNotifications(t *testing.T) {
+	setTestConfigEnv(t)
+	t.Setenv("OWNER_ROLES", "space_developer,organization_manager")
+	api := newFakeCFAPI(t, newTestFixtures())
+	mailer := newMemoryMailer()
+	mailer.failing = map[string]bool{user1: true}
+	code, state := runAgainstFakeCFAPIWith(t, api, fakeCFAPIState, mailer)
+	if code == ExitOK {
+		t.Fatalf("Expected the run to fail, found exit code %d", code)
+	}
+	// The update is recorded as handled, and the notification carried
+	// forward for the space developer it didn't reach.
+	if record := state.Buildpacks["bp1"]; record.LastUpdatedAt != "2020-02-01T00:00:00Z" {
+		t.Errorf("Expected the update of python_buildpack to be recorded, found %+v", record)
+	}
+	if held, found := state.HeldNotifications["app1"]; !found || !reflect.DeepEqual(held.Recipients, []string{user1}) {
+		t.Errorf("Expected the notification about app1 to be held for %s, found %+v", user1, state.HeldNotifications)
+	}
+	mailer = newMemoryMailer()
+	saved, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Unable to encode state. Error: %s", err)
+	}
+	code, state = runAgainstFakeCFAPIWith(t, api, string(saved), mailer)
+	if sent := mailer.emails(); code != ExitOK || len(sent) != 1 || sent[0].To != user1 {
+		t.Errorf("Expected the next run to notify %s only, found exit code %d and e-mails %+v", user1, code, sent)
+	}
+	if len(state.HeldNotifications) != 0 {
+		t.Errorf("Expected no notifications left to retry, found %+v", state.HeldNotifications)
+	}
+}
+
+func TestPipelineRetriesUncheckedApps(t *testing.T) {
+	setTestConfigEnv(t)
+	api := newFakeCFAPI(t, newTestFixtures())
+	api.failing["GET /v3/droplets"] = true
+	api.failing["GET /v3/apps/app1/droplets"] = true
+	code, mailer, state := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code == ExitOK || len(mailer.emails()) != 0 {
+		t.Fatalf("Expected the run to fail without e-mails, found exit code %d and e-mails %+v", code, mailer.emails())
+	}
+	if record := state.Buildpacks["bp1"]; record.LastUpdatedAt != "2020-02-01T00:00:00Z" {
+		t.Errorf("Expected the update of python_buildpack to be recorded, found %+v", record)
+	}
+	unchecked, found := state.UncheckedApps["app1"]
+	if !found || unchecked.Buildpacks["python_buildpack"].GUID != "bp1" {
+		t.Fatalf("Expected app1 to be carried forward with python_buildpack, found %+v", state.UncheckedApps)
+	}
+	// The next run finds no buildpack updated, and checks app1 against the
+	// update it couldn't check it against.
+	api.failing = map[string]bool{}
+	saved, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Unable to encode state. Error: %s", err)
+	}
+	code, mailer, state = runAgainstFakeCFAPI(t, api, string(saved))
+	if sent := mailer.emails(); code != ExitOK || len(sent) != 1 || sent[0].To != user1 {
+		t.Errorf("Expected the next run to notify %s, found exit code %d and e-mails %+v", user1, code, sent)
+	}
+	if len(state.UncheckedApps) != 0 {
+		t.Errorf("Expected no apps left to check, found %+v", state.UncheckedApps)
+	}
+}
+
+func TestPipelineResolvesEmailsViaUAA(t *testing.T) {
+	setTestConfigEnv(t)
+	t.Setenv("RESOLVE_EMAILS_VIA_UAA", "true")
+	f := newTestFixtures()
+	// Users whose username isn't an e-mail address are looked up in UAA.
+	f.Users[0].Username = "developer"
+	api := newFakeCFAPI(t, f)
+	api.uaaEmails[user1GUID] = user1
+	code, mailer, _ := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code != ExitOK {
+		t.Fatalf("Expected the run to succeed, found exit code %d", code)
+	}
+	if sent := mailer.emails(); len(sent) != 1 || sent[0].To != user1 {
+		t.Errorf("Expected a single e-mail to the address of the space developer in UAA, found %+v", sent)
+	}
+	if api.requestCount("GET /Users/"+user1GUID) != 1 {
+		t.Errorf("Expected the space developer to be looked up in UAA once, found requests %v", api.requests)
+	}
+}
+
+func TestPipelineRestagesOutdatedApps(t *testing.T) {
+	setTestConfigEnv(t)
+	t.Setenv("AUTO_RESTAGE", "true")
+	t.Setenv("RESTAGE_ORGS", "agency")
+	f := newTestFixtures()
+	f.Droplets[0].GUID = "droplet1"
+	f.Droplets[0].Links.Package.Href = "https://api.example.com/v3/packages/package1"
+	api := newFakeCFAPI(t, f)
+	code, mailer, _ := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code != ExitOK {
+		t.Fatalf("Expected the run to succeed, found exit code %d", code)
+	}
+	if api.requestCount("POST /v3/builds") != 1 || api.requestCount("POST /v3/deployments") != 1 {
+		t.Errorf("Expected app1 to be staged and rolled out, found requests %v", api.requests)
+	}
+	droplet, ok := api.currentDroplet("app1")
+	if !ok || droplet.GUID == "droplet1" {
+		t.Errorf("Expected app1 to run a new droplet, found %+v", droplet)
+	}
+	for _, email := range mailer.emails() {
+		if strings.Contains(email.Body, "cf restage --strategy rolling app1") {
+			t.Errorf("Expected the owners of the restaged app1 not to be asked to restage it, found e-mail to %s\n%s", email.To, email.Body)
+		}
+	}
+}
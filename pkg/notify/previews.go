@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// templatePreview is a variant of a template rendered from fixed data, for
+// reviewers to see what a change to the template looks like in an e-mail.
+type templatePreview struct {
+	// Template is the name of the template file, e.g. notify, and Variant
+	// names the data it is rendered with, e.g. multiple_apps.
+	Template string
+	Variant  string
+	render   func(t *Templates, w io.Writer) error
+}
+
+// previewApp is an app of the previews.
+func previewApp(name, space, org string) appInfo {
+	return appInfo{App: App{GUID: name + "-guid", Name: name}, Space: Space{Name: space}, Org: Organization{Name: org}}
+}
+
+// templatePreviews returns a variant of every template for each case its
+// e-mails read differently in, e.g. with a single app or with several.
+func templatePreviews() []templatePreview {
+	drupal, wordpress := previewApp("my-drupal-app", "dev", "sandbox"), previewApp("my-wordpress-app", "staging", "paid-org")
+	python := buildpackReleaseInfo{
+		BuildpackName:    "python_buildpack",
+		BuildpackVersion: "v1.8.15",
+		BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tag/v1.8.15",
+	}
+	php := buildpackReleaseInfo{
+		BuildpackName:    "php_buildpack",
+		BuildpackVersion: "v4.6.12",
+		BuildpackURL:     "https://github.com/cloudfoundry/php-buildpack/releases/tag/v4.6.12",
+	}
+	// The detailed variant has every optional part of a notification.
+	detailed := python
+	detailed.ReleaseNotes = "Add python 3.12.1\nRemove python 3.8.18\nIncluding security fixes for: CVE-2023-40217"
+	detailed.SecurityFixes = []securityFix{{CVE: "CVE-2023-40217", Severity: "Medium"}}
+	detailed.RemovedRuntimes = []removedRuntime{{Name: "python", Version: "3.8"}}
+	detailed.Docs = []DocLink{{Title: "Restaging Python apps on cloud.gov", URL: "https://cloud.gov/docs/python/"}}
+	detailedApp := drupal
+	detailedApp.Buildpacks = []buildpackReleaseInfo{detailed}
+	detailedApp.Buildpacks[0].CurrentVersion, detailedApp.Buildpacks[0].ReleasesBehind = "1.8.9", 6
+	detailedApp.Buildpacks[0].DetectOutput = "python 3.8.18"
+	detailedApp.Buildpacks[0].EOLWarnings = []eolWarning{{Subject: "python 3.8", Date: "2024-10-07", DaysLeft: 30, Runtime: true, Detected: true}}
+	pinned := drupal
+	pinned.GitBuildpacks = []gitBuildpack{{URL: "https://github.com/cloudfoundry/python-buildpack", Ref: "v1.7.43"}}
+	pinnedArchive := wordpress
+	pinnedArchive.GitBuildpacks = []gitBuildpack{{URL: "https://github.com/cloudfoundry/php-buildpack/releases/download/v4.6.1/php-buildpack-cflinuxfs4-v4.6.1.zip", Ref: "v4.6.1", Archive: true}}
+	escalated, escalatedMore := drupal, wordpress
+	escalated.Notifications, escalatedMore.Notifications = 3, 5
+	onStack, onStackMore := drupal, wordpress
+	onStack.Stack, onStackMore.Stack = "cflinuxfs3", "cflinuxfs3"
+	planned, plannedMore := drupal, wordpress
+	planned.DropletGUID, plannedMore.DropletGUID = "droplet-guid-1", "droplet-guid-2"
+
+	previews := []templatePreview{
+		{"notify", "single_app", func(t *Templates, w io.Writer) error {
+			return t.getNotifyEmail(w, notifyEmail{"user@example.com", []appInfo{drupal}, false, []buildpackReleaseInfo{python}})
+		}},
+		{"notify", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getNotifyEmail(w, notifyEmail{"user@example.com", []appInfo{drupal, wordpress}, true, []buildpackReleaseInfo{python, php}})
+		}},
+		{"notify", "detailed", func(t *Templates, w io.Writer) error {
+			return t.getNotifyEmail(w, notifyEmail{"user@example.com", []appInfo{detailedApp}, false, []buildpackReleaseInfo{detailed}})
+		}},
+		{"pinned_buildpack", "single_app", func(t *Templates, w io.Writer) error {
+			return t.getPinnedBuildpackEmail(w, pinnedBuildpackEmail{"user@example.com", []appInfo{pinned}, false})
+		}},
+		{"pinned_buildpack", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getPinnedBuildpackEmail(w, pinnedBuildpackEmail{"user@example.com", []appInfo{pinned, pinnedArchive}, true})
+		}},
+		{"campaign", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getCampaignEmail(w, campaignEmail{"user@example.com", []appInfo{drupal, wordpress}, true, "php_buildpack", "cflinuxfs3"})
+		}},
+		{"stack_eol", "single_app", func(t *Templates, w io.Writer) error {
+			return t.getStackEOLEmail(w, stackEOLEmail{"user@example.com", []appInfo{onStack}, false, "cflinuxfs4"})
+		}},
+		{"stack_eol", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getStackEOLEmail(w, stackEOLEmail{"user@example.com", []appInfo{onStack, onStackMore}, true, "cflinuxfs4"})
+		}},
+		{"escalation", "single_app", func(t *Templates, w io.Writer) error {
+			return t.getEscalationEmail(w, escalationEmail{"manager@example.com", []appInfo{escalated}, false})
+		}},
+		{"escalation", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getEscalationEmail(w, escalationEmail{"manager@example.com", []appInfo{escalated, escalatedMore}, true})
+		}},
+		{"restaged", "single_app", func(t *Templates, w io.Writer) error {
+			return t.getRestagedEmail(w, restagedEmail{"user@example.com", []appInfo{drupal}, false})
+		}},
+		{"restaged", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getRestagedEmail(w, restagedEmail{"user@example.com", []appInfo{drupal, wordpress}, true})
+		}},
+		{"restage_warning", "single_app", func(t *Templates, w io.Writer) error {
+			return t.getRestageWarningEmail(w, restageWarningEmail{"user@example.com", []appInfo{drupal}, false, "February 8, 2020"})
+		}},
+		{"restage_warning", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getRestageWarningEmail(w, restageWarningEmail{"user@example.com", []appInfo{drupal, wordpress}, true, "February 8, 2020"})
+		}},
+		{"canary_failed", "no_skipped_apps", func(t *Templates, w io.Writer) error {
+			return t.getCanaryFailedEmail(w, canaryFailedEmail{drupal, "Instance 1 crashed, rolled back to droplet droplet-guid-1", nil})
+		}},
+		{"canary_failed", "skipped_apps", func(t *Templates, w io.Writer) error {
+			return t.getCanaryFailedEmail(w, canaryFailedEmail{drupal, "Staging failed: BuildpackCompileFailed", []appInfo{wordpress}})
+		}},
+		{"restage_plan", "multiple_apps", func(t *Templates, w io.Writer) error {
+			return t.getRestagePlanEmail(w, restagePlanEmail{"0123456789abcdef", []appInfo{planned, plannedMore}})
+		}},
+		{"operator_summary", "follow_ups", func(t *Templates, w io.Writer) error {
+			return t.getOperatorSummaryEmail(w, operatorSummaryEmail{
+				RunID:         "0123456789abcdef",
+				Triggers:      []string{"php_buildpack", "python_buildpack"},
+				OutdatedByOrg: []orgCount{{"paid-org", 3}, {"sandbox", 1}},
+				EmailsSent:    3,
+				EmailsFailed:  1,
+				DroppedUsers:  []string{"admin"},
+				FollowUps:     []string{"Error: Unable to send e-mail to bob@example.com. Error: mailbox full"},
+			})
+		}},
+		{"operator_summary", "quiet_dry_run", func(t *Templates, w io.Writer) error {
+			return t.getOperatorSummaryEmail(w, operatorSummaryEmail{RunID: "0123456789abcdef", DryRun: true})
+		}},
+	}
+	return previews
+}
+
+// previewCampaignTemplate is the shipped campaign template previewed when
+// none is configured, since campaigns bring their own.
+var previewCampaignTemplate = path.Join("templates", "mail", "campaign_example.txt")
+
+// initPreviewTemplates parses the shipped templates along with the campaign
+// template at campaignPath, or the example one without it.
+func initPreviewTemplates(campaignPath string) (*Templates, error) {
+	templates, err := initTemplates()
+	if err != nil {
+		return nil, err
+	}
+	if campaignPath == "" {
+		tpl, err := template.ParseFS(mailTemplates, previewCampaignTemplate)
+		if err != nil {
+			return nil, err
+		}
+		templates.templates[campaignTemplate] = tpl
+		return templates, nil
+	}
+	if err := templates.addTemplate(campaignTemplate, campaignPath); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// previewPage is the HTML page of a preview, showing the text e-mail the way
+// mail clients do.
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{ .Template }}: {{ .Variant }}</title></head>
+<body>
+<p><a href="../index.html">All previews</a></p>
+<h1>{{ .Template }}: {{ .Variant }}</h1>
+<pre style="white-space: pre-wrap; font-family: monospace; max-width: 80ch;">{{ .Body }}</pre>
+</body>
+</html>
+`))
+
+// previewIndex is the HTML page linking to every preview.
+var previewIndex = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>E-mail previews</title></head>
+<body>
+<h1>E-mail previews</h1>
+<ul>
+{{- range . }}
+<li><a href="{{ .Template }}/{{ .Variant }}.html">{{ .Template }}: {{ .Variant }}</a> (<a href="{{ .Template }}/{{ .Variant }}.txt">text</a>)</li>
+{{- end }}
+</ul>
+</body>
+</html>
+`))
+
+// renderPreviews writes every preview to dir as <template>/<variant>.txt,
+// the e-mail as sent, and <template>/<variant>.html, along with an
+// index.html linking to all of them.
+func renderPreviews(templates *Templates, previews []templatePreview, dir string) error {
+	for _, preview := range previews {
+		body := new(bytes.Buffer)
+		if err := preview.render(templates, body); err != nil {
+			return fmt.Errorf("Unable to render %s %s. Error: %s", preview.Template, preview.Variant, err)
+		}
+		templateDir := filepath.Join(dir, preview.Template)
+		if err := os.MkdirAll(templateDir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(templateDir, preview.Variant+".txt"), body.Bytes(), 0644); err != nil {
+			return err
+		}
+		page := new(bytes.Buffer)
+		err := previewPage.Execute(page, struct{ Template, Variant, Body string }{preview.Template, preview.Variant, body.String()})
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(templateDir, preview.Variant+".html"), page.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	index := new(bytes.Buffer)
+	if err := previewIndex.Execute(index, previews); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "index.html"), index.Bytes(), 0644)
+}
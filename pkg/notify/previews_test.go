@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplatePreviews(t *testing.T) {
+	templates, err := initPreviewTemplates("")
+	if err != nil {
+		t.Fatalf("Unable to init templates. Error %s", err.Error())
+	}
+	rendered := make(map[string]bool)
+	for _, preview := range templatePreviews() {
+		name := preview.Template + "/" + preview.Variant
+		t.Run(name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			if err := preview.render(templates, body); err != nil {
+				t.Fatalf("Unable to render %s. Error %s", name, err.Error())
+			}
+			compareWithExpectedEmail(t, name, body, filepath.Join("testdata", "previews", preview.Template, preview.Variant+".txt"))
+		})
+		rendered[preview.Template] = true
+	}
+	// Every template shipped has previews.
+	files, err := filepath.Glob(filepath.Join("templates", "mail", "*.txt"))
+	if err != nil {
+		t.Fatalf("Unable to list templates. Error %s", err.Error())
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		if name == "campaign_example" {
+			name = "campaign"
+		}
+		if !rendered[name] {
+			t.Errorf("Expected previews of the %s template, found none", name)
+		}
+	}
+}
+
+func TestRenderPreviewsCommand(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := RenderPreviews("", dir); err != nil {
+		t.Fatalf("Expected the previews to be rendered, found %s", err)
+	}
+	text, err := ioutil.ReadFile(filepath.Join(dir, "notify", "multiple_apps.txt"))
+	if err != nil {
+		t.Fatalf("Unable to read the text preview. Error %s", err.Error())
+	}
+	expected, _ := ioutil.ReadFile(filepath.Join("testdata", "previews", "notify", "multiple_apps.txt"))
+	if string(text) != string(expected) {
+		t.Errorf("Expected the text preview to be the e-mail as sent, found\n%s", text)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(dir, "notify", "multiple_apps.html"))
+	if err != nil {
+		t.Fatalf("Unable to read the HTML preview. Error %s", err.Error())
+	}
+	if !strings.Contains(string(page), "<pre") || !strings.Contains(string(page), "my-wordpress-app") {
+		t.Errorf("Expected the HTML preview to show the e-mail, found\n%s", page)
+	}
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		t.Fatalf("Unable to read the index. Error %s", err.Error())
+	}
+	if !strings.Contains(string(index), `href="operator_summary/quiet_dry_run.html"`) {
+		t.Errorf("Expected the index to link to every preview, found\n%s", index)
+	}
+
+	// A campaign template that doesn't parse is a configuration error.
+	broken := filepath.Join(dir, "broken.txt")
+	if err := ioutil.WriteFile(broken, []byte("{{ .Missing"), 0644); err != nil {
+		t.Fatalf("Unable to write template. Error %s", err.Error())
+	}
+	if _, err := RenderPreviews(broken, dir); ExitCode(err) != ExitConfig {
+		t.Errorf("Expected a broken campaign template to be a configuration error, found %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pprofHandler serves the profiles of net/http/pprof, on a mux of its own so
+// that they are only served on --pprof-addr.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startProfiling starts profiling the run as the --cpu-profile, --heap-profile
+// and --pprof-addr flags of config ask, and returns the function that stops
+// it, writing the profiles.
+func startProfiling(config Config, logger *Logger) (func(), error) {
+	var server *http.Server
+	if config.PprofAddr != "" {
+		listener, err := net.Listen("tcp", config.PprofAddr)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to serve pprof")
+		}
+		server = &http.Server{Handler: pprofHandler()}
+		go server.Serve(listener)
+		logger.infof("Serving pprof on http://%s/debug/pprof/.\n", listener.Addr())
+	}
+	var cpuProfile *os.File
+	if config.CPUProfile != "" {
+		f, err := os.Create(config.CPUProfile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create CPU profile")
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "Unable to start CPU profile")
+		}
+		cpuProfile = f
+	}
+	return func() {
+		if cpuProfile != nil {
+			rpprof.StopCPUProfile()
+			if err := cpuProfile.Close(); err != nil {
+				logger.warnf("Unable to write CPU profile. Error: %s\n", err)
+			}
+		}
+		if config.HeapProfile != "" {
+			if err := writeHeapProfile(config.HeapProfile); err != nil {
+				logger.warnf("Unable to write heap profile. Error: %s\n", err)
+			}
+		}
+		if server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}
+	}, nil
+}
+
+// writeHeapProfile writes the allocations still live at the end of the run to
+// path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := rpprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStartProfiling(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{CPUProfile: filepath.Join(dir, "cpu.pprof"), HeapProfile: filepath.Join(dir, "heap.pprof"), PprofAddr: "127.0.0.1:0"}
+	stop, err := startProfiling(config, nil)
+	if err != nil {
+		t.Fatalf("Unable to start profiling. Error: %s", err)
+	}
+	stop()
+	for _, path := range []string{config.CPUProfile, config.HeapProfile} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("Expected a profile at %s, found %v", path, err)
+		}
+	}
+	if _, err := startProfiling(Config{CPUProfile: filepath.Join(dir, "missing", "cpu.pprof")}, nil); err == nil {
+		t.Errorf("Expected a CPU profile in a missing directory to fail")
+	}
+}
+
+func TestPprofHandler(t *testing.T) {
+	ts := httptest.NewServer(pprofHandler())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/debug/pprof/heap?debug=1")
+	if err != nil {
+		t.Fatalf("Unable to get heap profile. Error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the heap profile to be served, found %s", resp.Status)
+	}
+}
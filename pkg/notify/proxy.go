@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// newProxyDialer returns a dialer that connects through the proxy at rawURL,
+// e.g. socks5://proxy:1080 or http://proxy:3128. Without a URL it falls back
+// to the ALL_PROXY environment variable, and returns nil when that's unset
+// too. HTTP and HTTPS requests don't need it since http.Transport already
+// honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+func newProxyDialer(rawURL string) (proxy.Dialer, error) {
+	if rawURL == "" {
+		rawURL = os.Getenv("ALL_PROXY")
+	}
+	if rawURL == "" {
+		rawURL = os.Getenv("all_proxy")
+	}
+	if rawURL == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid proxy URL")
+	}
+	if proxyURL.Scheme == "http" {
+		return &httpConnectDialer{proxyURL: proxyURL, forward: proxy.Direct}, nil
+	}
+	dialer, err := proxy.FromURL(proxyURL, proxy.Direct)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unsupported proxy %s", proxyURL.Redacted())
+	}
+	return dialer, nil
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with the
+// CONNECT method, which most HTTP proxies allow for any port.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  proxy.ContextDialer
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext tunnels a connection to addr, giving up on the proxy when ctx
+// is done.
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.proxyURL.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to connect to proxy %s", d.proxyURL.Host)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Unable to request tunnel from proxy %s", d.proxyURL.Host)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Unable to read tunnel response from proxy %s", d.proxyURL.Host)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s refused tunnel to %s: %s", d.proxyURL.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	// The server may have spoken first (SMTP servers greet right away), so
+	// keep reading through whatever was already buffered.
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a bufio.Reader that may
+// already hold data read from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// dialSMTP connects to the SMTP server at addr, through dialer if there is
+// one, and bounds the whole conversation with the server to timeout so that
+// neither a stalled proxy nor a stalled server hangs the run.
+func dialSMTP(dialer proxy.Dialer, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	switch d := dialer.(type) {
+	case nil:
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	case proxy.ContextDialer:
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	default:
+		conn, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,196 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// recordedInteraction is a CF API request and its response, a line of JSON
+// in a recording.
+type recordedInteraction struct {
+	Method string `json:"method"`
+	// URL is the path and query of the request, since the host the
+	// responses are replayed from is another one.
+	URL         string `json:"url"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+// replayAPIAddress is the API address the responses of a recording are
+// replayed from, which is never dialed. Links to the recorded foundation in
+// the responses point at it instead.
+const replayAPIAddress = "http://replay.invalid"
+
+// recordTransport is a http.RoundTripper that writes every CF API response
+// to a recording, sanitized, for replayTransport to answer the same requests
+// with later.
+type recordTransport struct {
+	base   http.RoundTripper
+	logger *Logger
+	mu     sync.Mutex
+	file   *os.File
+}
+
+// newRecordTransport records the responses base gets to the file at path,
+// replacing it.
+func newRecordTransport(base http.RoundTripper, path string, logger *Logger) (*recordTransport, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create recording")
+	}
+	return &recordTransport{base: base, logger: logger, file: file}, nil
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	interaction := recordedInteraction{
+		Method:      req.Method,
+		URL:         sanitizeRecorded(req.URL.RequestURI(), origin),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        sanitizeRecorded(string(body), origin),
+	}
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.file.Write(append(line, '\n')); err != nil {
+		t.logger.warnf("Unable to record %s %s. Error: %s", req.Method, req.URL.Path, err)
+	}
+	return resp, nil
+}
+
+var (
+	// recordedSecretRe matches the tokens UAA hands out.
+	recordedSecretRe = regexp.MustCompile(`"(access_token|refresh_token|id_token)"(\s*):(\s*)"[^"]*"`)
+	// recordedAddressRe matches the e-mail addresses of users, in the fields
+	// of users, roles and UAA users holding them.
+	recordedAddressRe = regexp.MustCompile(`"(username|userName|email|value)"(\s*):(\s*)"([^"@]+@[^"]+)"`)
+)
+
+// sanitizeRecorded takes the secrets and the personal information out of
+// recorded text: tokens are redacted, e-mail addresses are replaced with
+// made up ones, the same for the same address so that a user is still
+// recognized across responses, and links to the foundation at origin point
+// at replayAPIAddress instead.
+func sanitizeRecorded(text, origin string) string {
+	text = strings.Replace(text, origin, replayAPIAddress, -1)
+	text = recordedSecretRe.ReplaceAllString(text, `"$1"$2:$3"REDACTED"`)
+	return recordedAddressRe.ReplaceAllStringFunc(text, func(field string) string {
+		match := recordedAddressRe.FindStringSubmatch(field)
+		return fmt.Sprintf(`"%s"%s:%s"%s"`, match[1], match[2], match[3], recordedAddress(match[4]))
+	})
+}
+
+// recordedAddress is the made up e-mail address replacing address in
+// recordings.
+func recordedAddress(address string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(address)))
+	return "user-" + hex.EncodeToString(sum[:4]) + "@example.com"
+}
+
+// replayTransport is a http.RoundTripper answering CF API requests from a
+// recording. Requests made more than once are answered in the order they
+// were recorded, with the last response repeated once they run out, and
+// requests that weren't recorded are not found.
+type replayTransport struct {
+	mu        sync.Mutex
+	responses map[string][]recordedInteraction
+}
+
+// loadRecording reads the recording at path, written by recordTransport.
+func loadRecording(path string) (*replayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	t := &replayTransport{responses: make(map[string][]recordedInteraction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var interaction recordedInteraction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, errors.Wrapf(err, "Unable to parse line %d of recording", line)
+		}
+		key := interaction.Method + " " + interaction.URL
+		t.responses[key] = append(t.responses[key], interaction)
+	}
+	return t, scanner.Err()
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	t.mu.Lock()
+	key := req.Method + " " + req.URL.RequestURI()
+	recorded := t.responses[key]
+	if len(recorded) > 1 {
+		t.responses[key] = recorded[1:]
+	}
+	t.mu.Unlock()
+	if len(recorded) == 0 {
+		return fixtureResponse(req, http.StatusNotFound, map[string]interface{}{
+			"errors": []map[string]interface{}{{"code": 10010, "title": "CF-ResourceNotFound", "detail": fmt.Sprintf("%s %s was not recorded", req.Method, req.URL.RequestURI())}},
+		})
+	}
+	interaction := recorded[0]
+	header := make(http.Header)
+	if interaction.ContentType != "" {
+		header.Set("Content-Type", interaction.ContentType)
+	}
+	return &http.Response{
+		StatusCode: interaction.Status,
+		Status:     fmt.Sprintf("%d %s", interaction.Status, http.StatusText(interaction.Status)),
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(interaction.Body)),
+		Request:    req,
+	}, nil
+}
+
+// newReplayClient creates a client answering the CF API requests of a run
+// from the recording at path, along with the rate limiting transport runs
+// report on. UAA is replayed from the recording too.
+func newReplayClient(path string) (*cfclient.Client, *rateLimitTransport, error) {
+	replay, err := loadRecording(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rateLimiter := newRateLimitTransport(replay, 0, 0, nil)
+	client := &cfclient.Client{Config: cfclient.Config{
+		ApiAddress: replayAPIAddress,
+		HttpClient: &http.Client{Transport: rateLimiter},
+	}}
+	client.Endpoint.TokenEndpoint = replayAPIAddress
+	return client, rateLimiter, nil
+}
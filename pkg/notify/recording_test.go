@@ -0,0 +1,150 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeRecorded(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{
+			"links to the foundation",
+			`{"links": {"next": {"href": "https://api.fr.cloud.gov/v3/apps?page=2"}}}`,
+			`{"links": {"next": {"href": "http://replay.invalid/v3/apps?page=2"}}}`,
+		},
+		{
+			"tokens",
+			`{"access_token": "eyJhbGciOi", "refresh_token":"abc", "token_type": "bearer"}`,
+			`{"access_token": "REDACTED", "refresh_token":"REDACTED", "token_type": "bearer"}`,
+		},
+		{
+			"e-mail addresses",
+			`{"username": "Jane@Agency.gov", "emails": [{"value": "jane@agency.gov"}], "origin": "uaa"}`,
+			`{"username": "` + recordedAddress("jane@agency.gov") + `", "emails": [{"value": "` + recordedAddress("jane@agency.gov") + `"}], "origin": "uaa"}`,
+		},
+		{
+			"usernames that aren't e-mail addresses",
+			`{"username": "admin"}`,
+			`{"username": "admin"}`,
+		},
+		{
+			"git buildpacks",
+			`{"buildpacks": [{"name": "git@github.com:agency/buildpack.git"}]}`,
+			`{"buildpacks": [{"name": "git@github.com:agency/buildpack.git"}]}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if sanitized := sanitizeRecorded(tc.text, "https://api.fr.cloud.gov"); sanitized != tc.expected {
+				t.Errorf("Test %s failed. Expected %s, found %s", tc.name, tc.expected, sanitized)
+			}
+		})
+	}
+}
+
+func TestReplayTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	recording := `{"method": "GET", "url": "/v3/apps/app1", "status": 200, "body": "{\"name\": \"first\"}"}
+{"method": "GET", "url": "/v3/apps/app1", "status": 200, "body": "{\"name\": \"second\"}"}
+{"method": "POST", "url": "/v3/builds", "status": 422, "body": "{}"}
+`
+	if err := ioutil.WriteFile(path, []byte(recording), 0644); err != nil {
+		t.Fatalf("Unable to write recording. Error: %s", err)
+	}
+	replay, err := loadRecording(path)
+	if err != nil {
+		t.Fatalf("Unable to load recording. Error: %s", err)
+	}
+	client := &http.Client{Transport: replay}
+	// Requests made again are answered in order, then with the last
+	// response.
+	for _, expected := range []string{"first", "second", "second"} {
+		resp, err := client.Get(replayAPIAddress + "/v3/apps/app1")
+		if err != nil {
+			t.Fatalf("Unable to replay request. Error: %s", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), expected) {
+			t.Errorf("Expected the %s response, found %d %s", expected, resp.StatusCode, body)
+		}
+	}
+	resp, err := client.Post(replayAPIAddress+"/v3/builds", "application/json", strings.NewReader("{}"))
+	if err != nil || resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected the recorded status of the build, found %v %v", resp, err)
+	}
+	resp, err = client.Get(replayAPIAddress + "/v3/apps/app2")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected requests that weren't recorded not to be found, found %v %v", resp, err)
+	}
+}
+
+func TestRecordAndReplayPipeline(t *testing.T) {
+	setTestConfigEnv(t)
+	recording := filepath.Join(t.TempDir(), "recording.jsonl")
+	api := newFakeCFAPI(t, newTestFixtures())
+	t.Setenv("CF_API_RECORD", recording)
+	code, recorded, _ := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code != ExitOK || len(recorded.emails()) != 1 {
+		t.Fatalf("Expected the recorded run to notify a single owner, found exit code %d and e-mails %+v", code, recorded.emails())
+	}
+	data, err := ioutil.ReadFile(recording)
+	if err != nil {
+		t.Fatalf("Unable to read recording. Error: %s", err)
+	}
+	for _, secret := range []string{fakeCFAPIToken, api.URL, user1} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("Expected the recording to be sanitized, found %s in\n%s", secret, data)
+		}
+	}
+
+	// The run is replayed without the CF API.
+	api.Close()
+	t.Setenv("CF_API_RECORD", "")
+	t.Setenv("CF_API_REPLAY", recording)
+	code, replayed, _ := runAgainstFakeCFAPI(t, api, fakeCFAPIState)
+	if code != ExitOK {
+		t.Fatalf("Expected the replayed run to succeed, found exit code %d", code)
+	}
+	sent := replayed.emails()
+	if len(sent) != 1 || sent[0].To != recordedAddress(user1) || sent[0].Body != recorded.emails()[0].Body {
+		t.Errorf("Expected the replayed run to send the recorded e-mail to the made up address of %s, found %+v", user1, sent)
+	}
+
+	// A run can't replay its own recording.
+	t.Setenv("CF_API_RECORD", recording)
+	if problems := ValidateConfig(loadTestConfig(t)); len(problems) != 1 || !strings.Contains(problems[0].Error(), "CF_API_REPLAY") {
+		t.Errorf("Expected recording and replaying together to be a problem, found %v", problems)
+	}
+}
+
+// TestCheckAppFromRecording reproduces why an app was flagged from the CF API
+// responses recorded by check-app with CF_API_RECORD, the way bugs only seen
+// on a foundation are turned into tests.
+func TestCheckAppFromRecording(t *testing.T) {
+	client, _, err := newReplayClient(filepath.Join("testdata", "recordings", "check_app_outdated.jsonl"))
+	if err != nil {
+		t.Fatalf("Unable to load recording. Error: %s", err)
+	}
+	settings := runSettings{owners: ownerSettings{roles: mustOwnerRoles(t, "space_developer", "space_manager")}}
+	var out bytes.Buffer
+	decision, err := checkApp(client, "app1", Config{}, ListOptions{PerPage: 100}, settings, newRunState(), &out)
+	if err != nil {
+		t.Fatalf("Unable to check app. Error: %s", err)
+	}
+	if decision != decisionOutdated {
+		t.Errorf("Expected app1 to be outdated, found %s\n%s", decision, out.String())
+	}
+	for _, expected := range []string{"python_buildpack 1.7.40: outdated", "Owners notified: " + recordedAddress(user1)} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q, found\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"sync"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// relationships resolves apps to their space and org. It is built from the
+// spaces and orgs listed along with the apps, and shared by the report, owner
+// resolution and the grouping of apps into e-mails, so that a space is only
+// requested on its own when no listing included it, and only once.
+type relationships struct {
+	mu     sync.Mutex
+	spaces map[string]spaceInfo
+}
+
+func newRelationships() *relationships {
+	return &relationships{spaces: make(map[string]spaceInfo)}
+}
+
+// addSpaces adds spaces, by GUID.
+func (r *relationships) addSpaces(spaces map[string]spaceInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for guid, space := range spaces {
+		r.spaces[guid] = space
+	}
+}
+
+// space returns the space with guid and its org, if they are known.
+func (r *relationships) space(guid string) (spaceInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	space, found := r.spaces[guid]
+	return space, found
+}
+
+// spaceOfApp returns the space of app and its org, requesting them if no
+// listing included them.
+func (r *relationships) spaceOfApp(app App, client *cfclient.Client) (spaceInfo, error) {
+	spaceGUID := app.Relationships.Space.Data.GUID
+	if info, found := r.space(spaceGUID); found {
+		return info, nil
+	}
+	space, org, err := GetSpaceWithOrganization(client, spaceGUID)
+	if err != nil {
+		return spaceInfo{}, errors.Wrapf(err, "Unable to get space of app %s", app.Name)
+	}
+	info := spaceInfo{Space: space, Org: org}
+	r.addSpaces(map[string]spaceInfo{spaceGUID: info})
+	return info, nil
+}
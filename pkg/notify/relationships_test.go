@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestRelationshipsSharedWithReport(t *testing.T) {
+	spaceRequests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		switch r.URL.Path {
+		case "/v3/spaces/space2":
+			spaceRequests++
+			encoder.Encode(newTestSpace("space2"))
+		case "/v3/roles":
+			encoder.Encode(rolesInSpaces(r, func(string) RoleResponse {
+				return newTestSpaceRoles(spaceUser{User{GUID: user1GUID, Username: user1}, []string{"space_manager"}})
+			}))
+		default:
+			t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+	report := newRunReport()
+	report.recordSpaces(map[string]spaceInfo{"space1": {Space: Space{GUID: "space1", Name: "space1"}, Org: Organization{GUID: "org1", Name: "org1"}}})
+	apps := []App{newTestApp("app1", "space1"), newTestApp("app2", "space2"), newTestApp("app3", "space2")}
+	for _, app := range apps {
+		report.recordApp(app, decisionOutdated)
+	}
+	settings := ownerSettings{roles: mustOwnerRoles(t, "space_manager"), report: report}
+	for i := 0; i < 2; i++ {
+		if owners := findOwnersOfApps(newTestAppInfos(apps), &c, settings, &runErrors{}, nil); len(owners[user1]) != 3 {
+			t.Errorf("Expected %s to own every app, found %+v", user1, owners)
+		}
+	}
+	if spaceRequests != 1 {
+		t.Errorf("Expected the space missing from the listing to be requested once, found %d", spaceRequests)
+	}
+	for _, app := range report.appReports() {
+		if app.Space == "" || app.Org != "org1" {
+			t.Errorf("Expected the report to name the space and org of every app, found %+v", app)
+		}
+	}
+}
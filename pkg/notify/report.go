@@ -0,0 +1,507 @@
+package notify
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// appDecision is the outcome of checking a single app during a run.
+type appDecision string
+
+const (
+	decisionFilteredOut          appDecision = "filtered_out"
+	decisionOptedOut             appDecision = "opted_out"
+	decisionSystemOrg            appDecision = "system_org"
+	decisionSuspendedOrg         appDecision = "suspended_org"
+	decisionNotStarted           appDecision = "not_started"
+	decisionDocker               appDecision = "docker"
+	decisionSnoozed              appDecision = "snoozed"
+	decisionNoDroplet            appDecision = "no_current_droplet"
+	decisionUnsupportedBuildpack appDecision = "unsupported_buildpack"
+	decisionDisabledBuildpack    appDecision = "disabled_buildpack"
+	decisionGitBuildpack         appDecision = "git_buildpack"
+	decisionNotOutdated          appDecision = "not_outdated"
+	decisionOutdated             appDecision = "outdated"
+	decisionHeldBack             appDecision = "held_back"
+	decisionNotCampaignTarget    appDecision = "not_campaign_target"
+	decisionCampaignTarget       appDecision = "campaign_target"
+	decisionSupportedStack       appDecision = "supported_stack"
+	decisionEOLStack             appDecision = "eol_stack"
+	decisionError                appDecision = "error"
+)
+
+// appDecisions lists every decision in the order they are reported.
+var appDecisions = []appDecision{
+	decisionFilteredOut,
+	decisionOptedOut,
+	decisionSystemOrg,
+	decisionSuspendedOrg,
+	decisionNotStarted,
+	decisionDocker,
+	decisionSnoozed,
+	decisionNoDroplet,
+	decisionUnsupportedBuildpack,
+	decisionDisabledBuildpack,
+	decisionGitBuildpack,
+	decisionNotOutdated,
+	decisionOutdated,
+	decisionHeldBack,
+	decisionNotCampaignTarget,
+	decisionCampaignTarget,
+	decisionSupportedStack,
+	decisionEOLStack,
+	decisionError,
+}
+
+// runReport tallies what happened to the apps checked during a run so that
+// it can be summarized once the run is over.
+type runReport struct {
+	mu        sync.Mutex
+	decisions map[appDecision]int
+	// buildpacksWithoutFilename were linked to their releases page rather
+	// than the release of their version, which they don't tell.
+	buildpacksWithoutFilename map[string]bool
+	// systemApps are the apps in system orgs, as org/space/app, which are left
+	// to the operators.
+	systemApps []string
+	// apps are the details of every app checked, keyed by GUID, and
+	// relationships resolve them to their space and org. The lookups of the
+	// owners of apps share them.
+	apps          map[string]*appReport
+	relationships *relationships
+	// droppedUsers are the users who weren't notified because their
+	// username isn't an e-mail address.
+	droppedUsers map[string]bool
+	// phases are the timings of the phases of the run, in the order they
+	// ran.
+	phases []phaseTiming
+}
+
+// phaseTiming is how long a phase of the run took and how many requests it
+// sent to the CF API, to compare the performance of runs across releases.
+type phaseTiming struct {
+	Name          string  `json:"name"`
+	Seconds       float64 `json:"seconds"`
+	CFAPIRequests int     `json:"cf_api_requests"`
+}
+
+// appReport is what happened to a single app during a run, as written to the
+// detailed report.
+type appReport struct {
+	GUID       string            `json:"guid"`
+	Name       string            `json:"name"`
+	Org        string            `json:"org"`
+	Space      string            `json:"space"`
+	Decision   appDecision       `json:"decision"`
+	Droplet    string            `json:"droplet_guid,omitempty"`
+	StagedAt   string            `json:"staged_at,omitempty"`
+	Buildpacks []buildpackReport `json:"buildpacks,omitempty"`
+	Owners     []string          `json:"owners,omitempty"`
+
+	spaceGUID string
+	// steps are the reasoning behind the decision, in the order it was
+	// made, for the decision trace.
+	steps []decisionStep
+}
+
+// decisionStep is a check made about an app on the way to deciding what to
+// do about it, e.g. the state it is in or whether a buildpack was updated
+// since it was staged, along with what the check found.
+type decisionStep struct {
+	Check   string `json:"check"`
+	Outcome string `json:"outcome"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// buildpackReport is a buildpack an app was staged with. LatestVersion,
+// SecurityFixes, the CVEs fixed since, and ReleasesBehind, the releases since,
+// are only known for the buildpacks the app is outdated on.
+type buildpackReport struct {
+	Name           string        `json:"name"`
+	Version        string        `json:"version,omitempty"`
+	LatestVersion  string        `json:"latest_version,omitempty"`
+	Outdated       bool          `json:"outdated"`
+	SecurityFixes  []securityFix `json:"security_fixes,omitempty"`
+	ReleasesBehind int           `json:"releases_behind,omitempty"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		decisions:                 make(map[appDecision]int),
+		buildpacksWithoutFilename: make(map[string]bool),
+		apps:                      make(map[string]*appReport),
+		relationships:             newRelationships(),
+		droppedUsers:              make(map[string]bool),
+	}
+}
+
+// appLocked returns the details of app, adding them if it wasn't recorded yet.
+// r.mu must be held.
+func (r *runReport) appLocked(app App) *appReport {
+	details, found := r.apps[app.GUID]
+	if !found {
+		details = &appReport{GUID: app.GUID, Name: app.Name, spaceGUID: app.Relationships.Space.Data.GUID}
+		r.apps[app.GUID] = details
+	}
+	return details
+}
+
+// recordApp records the decision made about an app, replacing any decision
+// recorded about it earlier in the run.
+func (r *runReport) recordApp(app App, decision appDecision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	details := r.appLocked(app)
+	if details.Decision != "" {
+		r.decisions[details.Decision]--
+	}
+	r.decisions[decision]++
+	details.Decision = decision
+}
+
+// traceApp records a step of the reasoning behind the decision about app.
+func (r *runReport) traceApp(app App, check, outcome, detail string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	details := r.appLocked(app)
+	details.steps = append(details.steps, decisionStep{Check: check, Outcome: outcome, Detail: detail})
+}
+
+// recordSpaces records the spaces the apps of the run are in, which name the
+// org and space of each app in the detailed report.
+func (r *runReport) recordSpaces(spaces map[string]spaceInfo) {
+	r.relationships.addSpaces(spaces)
+}
+
+// recordDroplet records the droplet app was checked on and the buildpacks it
+// was staged with.
+func (r *runReport) recordDroplet(app App, droplet Droplet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	details := r.appLocked(app)
+	details.Droplet, details.StagedAt = droplet.GUID, droplet.CreatedAt
+	details.Buildpacks = nil
+	var buildpacks []string
+	for _, buildpack := range droplet.Buildpacks {
+		details.Buildpacks = append(details.Buildpacks, buildpackReport{Name: buildpack.Name, Version: buildpack.Version})
+		buildpacks = append(buildpacks, strings.TrimSpace(buildpack.Name+" "+buildpack.Version))
+	}
+	detail := fmt.Sprintf("droplet %s staged at %s with buildpacks %s", droplet.GUID, droplet.CreatedAt, strings.Join(buildpacks, ", "))
+	details.steps = append(details.steps, decisionStep{Check: "current droplet", Outcome: "found", Detail: detail})
+}
+
+// recordOutdatedBuildpacks records the buildpacks app is outdated on, along
+// with their latest versions and the security fixes in them.
+func (r *runReport) recordOutdatedBuildpacks(app App, outdated []buildpackReleaseInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	details := r.appLocked(app)
+	for _, release := range outdated {
+		for i := range details.Buildpacks {
+			if details.Buildpacks[i].Name == release.BuildpackName {
+				details.Buildpacks[i].LatestVersion = release.BuildpackVersion
+				details.Buildpacks[i].Outdated = true
+				details.Buildpacks[i].SecurityFixes = release.SecurityFixes
+				details.Buildpacks[i].ReleasesBehind = release.ReleasesBehind
+			}
+		}
+	}
+}
+
+// recordOwners records the owners found for each app, keyed by owner as
+// returned by findOwnersOfApps.
+func (r *runReport) recordOwners(owners map[string][]appInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for owner, apps := range owners {
+		for _, app := range apps {
+			details := r.appLocked(app.App)
+			details.Owners = append(details.Owners, owner)
+		}
+	}
+}
+
+// recordSystemApp records an app in a system org, which is listed for the
+// operators in the summary instead of its owners being notified.
+func (r *runReport) recordSystemApp(app App, space spaceInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decisions[decisionSystemOrg]++
+	details := r.appLocked(app)
+	details.Decision = decisionSystemOrg
+	details.steps = append(details.steps, decisionStep{Check: "system org", Outcome: "system org", Detail: fmt.Sprintf("org %s is a system org, left to the operators", space.Org.Name)})
+	r.systemApps = append(r.systemApps, fmt.Sprintf("%s/%s/%s", space.Org.Name, space.Space.Name, app.Name))
+}
+
+// recordBuildpackWithoutFilename records that owners were notified about a
+// buildpack that has no filename to tell its version from.
+func (r *runReport) recordBuildpackWithoutFilename(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buildpacksWithoutFilename[name] = true
+}
+
+// recordDroppedUser records a user who wasn't notified because their
+// username isn't an e-mail address.
+func (r *runReport) recordDroppedUser(username string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.droppedUsers[username] = true
+}
+
+// recordPhase records that the phase name took duration and sent requests
+// to the CF API.
+func (r *runReport) recordPhase(name string, duration time.Duration, requests int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phases = append(r.phases, phaseTiming{Name: name, Seconds: duration.Seconds(), CFAPIRequests: requests})
+}
+
+// phaseTimings returns the timings of the phases of the run.
+func (r *runReport) phaseTimings() []phaseTiming {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]phaseTiming(nil), r.phases...)
+}
+
+// count returns how many apps ended up with decision.
+func (r *runReport) count(decision appDecision) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.decisions[decision]
+}
+
+// logSummary logs how many apps ended up with each decision, leaving out the
+// decisions no app ended up with.
+func (r *runReport) logSummary(logger *Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	total := 0
+	for _, decision := range appDecisions {
+		total += r.decisions[decision]
+	}
+	logger.infof("Checked %d apps:\n", total)
+	for _, decision := range appDecisions {
+		if r.decisions[decision] > 0 {
+			logger.infof("  %s: %d\n", decision, r.decisions[decision])
+		}
+	}
+	if len(r.systemApps) > 0 {
+		sort.Strings(r.systemApps)
+		logger.infof("Apps in system orgs, left to the operators:\n")
+		for _, app := range r.systemApps {
+			logger.infof("  %s\n", app)
+		}
+	}
+	if len(r.buildpacksWithoutFilename) > 0 {
+		var names []string
+		for name := range r.buildpacksWithoutFilename {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		logger.infof("Buildpacks without a filename, linked to their releases page instead of a version: %s\n", strings.Join(names, ", "))
+	}
+	if len(r.phases) > 0 {
+		logger.infof("Phases:\n")
+		for _, phase := range r.phases {
+			logger.infof("  %s: %s, %d CF API requests\n", phase.Name, time.Duration(phase.Seconds*float64(time.Second)).Round(time.Millisecond), phase.CFAPIRequests)
+		}
+	}
+}
+
+// detailedReport is the detailed report of a run, listing every app checked.
+type detailedReport struct {
+	RunID  string        `json:"run_id"`
+	Phases []phaseTiming `json:"phases,omitempty"`
+	Apps   []appReport   `json:"apps"`
+}
+
+// appReports returns the details of every app checked, sorted by org, space
+// and name.
+func (r *runReport) appReports() []appReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	apps := make([]appReport, 0, len(r.apps))
+	for _, details := range r.apps {
+		app := *details
+		if space, found := r.relationships.space(app.spaceGUID); found {
+			app.Org, app.Space = space.Org.Name, space.Space.Name
+		}
+		app.Owners = append([]string(nil), app.Owners...)
+		app.steps = append([]decisionStep(nil), app.steps...)
+		sort.Strings(app.Owners)
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if apps[i].Org != apps[j].Org {
+			return apps[i].Org < apps[j].Org
+		}
+		if apps[i].Space != apps[j].Space {
+			return apps[i].Space < apps[j].Space
+		}
+		if apps[i].Name != apps[j].Name {
+			return apps[i].Name < apps[j].Name
+		}
+		return apps[i].GUID < apps[j].GUID
+	})
+	return apps
+}
+
+// appsDecided returns the apps the run made decision about, in GUID order.
+func (r *runReport) appsDecided(decision appDecision) []appReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var apps []appReport
+	for _, details := range r.apps {
+		if details.Decision == decision {
+			apps = append(apps, appReport{GUID: details.GUID, Name: details.Name, Decision: decision})
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].GUID < apps[j].GUID })
+	return apps
+}
+
+// writeJSON writes the detailed report of the run to w.
+func (r *runReport) writeJSON(w io.Writer, runID string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(detailedReport{RunID: runID, Phases: r.phaseTimings(), Apps: r.appReports()})
+}
+
+// decisionTrace is a line of the decision trace, the reasoning behind the
+// decision about a single app, for support to answer why an app was or
+// wasn't flagged.
+type decisionTrace struct {
+	RunID    string         `json:"run_id"`
+	AppGUID  string         `json:"app_guid"`
+	AppName  string         `json:"app_name"`
+	Org      string         `json:"org"`
+	Space    string         `json:"space"`
+	Decision appDecision    `json:"decision"`
+	Steps    []decisionStep `json:"steps"`
+}
+
+// writeDecisionTrace writes the reasoning behind the decision about every app
+// checked to w, a line of JSON per app.
+func (r *runReport) writeDecisionTrace(w io.Writer, runID string) error {
+	encoder := json.NewEncoder(w)
+	for _, app := range r.appReports() {
+		trace := decisionTrace{RunID: runID, AppGUID: app.GUID, AppName: app.Name, Org: app.Org, Space: app.Space, Decision: app.Decision, Steps: app.steps}
+		if err := encoder.Encode(trace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvReportHeader names the columns of the CSV report.
+var csvReportHeader = []string{"org", "space", "app", "app_guid", "buildpack", "current_version", "latest_version", "owners", "security_fixes", "releases_behind"}
+
+// writeCSV writes the outdated apps of the run to w, a row for each buildpack
+// an app is outdated on.
+func (r *runReport) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvReportHeader); err != nil {
+		return err
+	}
+	for _, app := range r.appReports() {
+		if app.Decision != decisionOutdated {
+			continue
+		}
+		for _, buildpack := range app.Buildpacks {
+			if !buildpack.Outdated {
+				continue
+			}
+			behind := ""
+			if buildpack.ReleasesBehind > 0 {
+				behind = strconv.Itoa(buildpack.ReleasesBehind)
+			}
+			row := []string{app.Org, app.Space, app.Name, app.GUID, buildpack.Name, buildpack.Version, buildpack.LatestVersion, strings.Join(app.Owners, "; "), joinSecurityFixes(buildpack.SecurityFixes, "; "), behind}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeOutdated lists the outdated apps of the run to w for people to read.
+func (r *runReport) writeOutdated(w io.Writer) error {
+	outdated := 0
+	for _, app := range r.appReports() {
+		if app.Decision != decisionOutdated {
+			continue
+		}
+		outdated++
+		var buildpacks []string
+		for _, buildpack := range app.Buildpacks {
+			if !buildpack.Outdated {
+				continue
+			}
+			line := fmt.Sprintf("%s %s -> %s", buildpack.Name, versionOrUnknown(buildpack.Version), versionOrUnknown(buildpack.LatestVersion))
+			if buildpack.ReleasesBehind > 0 {
+				line += fmt.Sprintf(" (%d releases behind)", buildpack.ReleasesBehind)
+			}
+			if len(buildpack.SecurityFixes) > 0 {
+				line += " fixes " + joinSecurityFixes(buildpack.SecurityFixes, ", ")
+			}
+			buildpacks = append(buildpacks, line)
+		}
+		owners := "none found"
+		if len(app.Owners) > 0 {
+			owners = strings.Join(app.Owners, ", ")
+		}
+		if _, err := fmt.Fprintf(w, "%s/%s/%s guid %s: %s. Owners: %s\n", app.Org, app.Space, app.Name, app.GUID, strings.Join(buildpacks, ", "), owners); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d outdated apps.\n", outdated)
+	return err
+}
+
+// writeReports writes the reports of the run asked for by config, recording
+// the problems writing them in errs.
+func writeReports(config Config, report *runReport, runID string, errs *runErrors) {
+	if config.ReportJSON != "" {
+		writeJSON := func(w io.Writer) error { return report.writeJSON(w, runID) }
+		if err := writeReportFile(config.ReportJSON, writeJSON); err != nil {
+			errs.addf("Unable to write JSON report. Error: %s", err)
+		}
+	}
+	if config.DecisionTrace != "" {
+		writeTrace := func(w io.Writer) error { return report.writeDecisionTrace(w, runID) }
+		if err := writeReportFile(config.DecisionTrace, writeTrace); err != nil {
+			errs.addf("Unable to write decision trace. Error: %s", err)
+		}
+	}
+	if config.ReportCSV != "" {
+		if err := writeReportFile(config.ReportCSV, report.writeCSV); err != nil {
+			errs.addf("Unable to write CSV report. Error: %s", err)
+		}
+	}
+}
+
+// writeReportFile writes a report of the run to the file at path with write.
+func writeReportFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
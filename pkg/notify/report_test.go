@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRunReport() *runReport {
+	spaces := map[string]spaceInfo{
+		"space1": {Space: Space{GUID: "space1", Name: "dev"}, Org: Organization{GUID: "org1", Name: "agency"}},
+	}
+	outdatedApp := newTestApp("app1", "space1")
+	currentApp := newTestApp("app2", "space1")
+	stoppedApp := newTestApp("app3", "space1")
+	droplet := Droplet{GUID: "droplet1", Buildpacks: []DropletBuildpack{
+		{Name: "python_buildpack", Version: "1.7.40"},
+		{Name: "binary_buildpack", Version: "1.1.0"},
+	}}
+	report := newRunReport()
+	report.recordSpaces(spaces)
+	report.recordApp(stoppedApp, decisionNotStarted)
+	report.recordDroplet(currentApp, droplet)
+	report.recordApp(currentApp, decisionNotOutdated)
+	report.recordDroplet(outdatedApp, droplet)
+	report.recordOutdatedBuildpacks(outdatedApp, []buildpackReleaseInfo{{BuildpackName: "python_buildpack", BuildpackVersion: "1.7.43"}})
+	report.recordApp(outdatedApp, decisionOutdated)
+	report.recordOwners(map[string][]appInfo{
+		"dev@example.gov":     {{App: outdatedApp}},
+		"manager@example.gov": {{App: outdatedApp}},
+	})
+	report.recordPhase("list apps", 1500*time.Millisecond, 3)
+	report.recordPhase("find outdated apps", 4*time.Second, 2)
+	return report
+}
+
+func TestRunReportWriteJSON(t *testing.T) {
+	report := newTestRunReport()
+	var buf bytes.Buffer
+	if err := report.writeJSON(&buf, "run1"); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	var written detailedReport
+	if err := json.Unmarshal(buf.Bytes(), &written); err != nil {
+		t.Fatalf("Unable to read report. Error: %s", err)
+	}
+	if written.RunID != "run1" || len(written.Apps) != 3 {
+		t.Fatalf("Expected 3 apps of run run1, found %+v", written)
+	}
+	expectedPhases := []phaseTiming{{Name: "list apps", Seconds: 1.5, CFAPIRequests: 3}, {Name: "find outdated apps", Seconds: 4, CFAPIRequests: 2}}
+	if len(written.Phases) != 2 || written.Phases[0] != expectedPhases[0] || written.Phases[1] != expectedPhases[1] {
+		t.Errorf("Expected phases %+v, found %+v", expectedPhases, written.Phases)
+	}
+	app := written.Apps[0]
+	if app.GUID != "app1" || app.Org != "agency" || app.Space != "dev" || app.Decision != decisionOutdated {
+		t.Errorf("Expected app1 in agency/dev to be outdated, found %+v", app)
+	}
+	expectedBuildpacks := []buildpackReport{
+		{Name: "python_buildpack", Version: "1.7.40", LatestVersion: "1.7.43", Outdated: true},
+		{Name: "binary_buildpack", Version: "1.1.0"},
+	}
+	if !reflect.DeepEqual(app.Buildpacks, expectedBuildpacks) {
+		t.Errorf("Expected buildpacks %+v, found %+v", expectedBuildpacks, app.Buildpacks)
+	}
+	if len(app.Owners) != 2 || app.Owners[0] != "dev@example.gov" || app.Owners[1] != "manager@example.gov" {
+		t.Errorf("Expected both owners of app1, found %v", app.Owners)
+	}
+	if written.Apps[1].Decision != decisionNotOutdated || len(written.Apps[1].Buildpacks) != 2 || len(written.Apps[1].Owners) != 0 {
+		t.Errorf("Expected app2 to be current without owners, found %+v", written.Apps[1])
+	}
+	if written.Apps[2].Decision != decisionNotStarted || len(written.Apps[2].Buildpacks) != 0 {
+		t.Errorf("Expected app3 not to be started, found %+v", written.Apps[2])
+	}
+}
+
+func TestRunReportWriteCSV(t *testing.T) {
+	report := newTestRunReport()
+	var buf bytes.Buffer
+	if err := report.writeCSV(&buf); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	expected := "org,space,app,app_guid,buildpack,current_version,latest_version,owners,security_fixes,releases_behind\n" +
+		"agency,dev,,app1,python_buildpack,1.7.40,1.7.43,dev@example.gov; manager@example.gov,,\n"
+	if buf.String() != expected {
+		t.Errorf("Expected CSV report\n%s\nfound\n%s", expected, buf.String())
+	}
+}
+
+func TestRunReportSecurityFixes(t *testing.T) {
+	report := newTestRunReport()
+	// The security fixes are found once the releases are looked up, after
+	// the app was found outdated.
+	report.recordOutdatedBuildpacks(newTestApp("app1", "space1"), []buildpackReleaseInfo{{
+		BuildpackName:    "python_buildpack",
+		BuildpackVersion: "1.7.43",
+		SecurityFixes:    []securityFix{{CVE: "CVE-2023-40217", Severity: "Medium"}, {CVE: "CVE-2023-41105"}},
+	}})
+	var csvReport, outdated bytes.Buffer
+	if err := report.writeCSV(&csvReport); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	if !strings.HasSuffix(csvReport.String(), ",CVE-2023-40217 (Medium); CVE-2023-41105,\n") {
+		t.Errorf("Expected the security fixes in the CSV report, found\n%s", csvReport.String())
+	}
+	if err := report.writeOutdated(&outdated); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	if !strings.Contains(outdated.String(), "python_buildpack 1.7.40 -> 1.7.43 fixes CVE-2023-40217 (Medium), CVE-2023-41105.") {
+		t.Errorf("Expected the security fixes in the list of outdated apps, found\n%s", outdated.String())
+	}
+}
+
+func TestRunReportReleasesBehind(t *testing.T) {
+	report := newTestRunReport()
+	report.recordOutdatedBuildpacks(newTestApp("app1", "space1"), []buildpackReleaseInfo{{
+		BuildpackName:    "python_buildpack",
+		BuildpackVersion: "1.7.43",
+		CurrentVersion:   "1.7.40",
+		ReleasesBehind:   3,
+	}})
+	var csvReport, outdated bytes.Buffer
+	if err := report.writeCSV(&csvReport); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	if !strings.HasSuffix(csvReport.String(), ",,3\n") {
+		t.Errorf("Expected the releases behind in the CSV report, found\n%s", csvReport.String())
+	}
+	if err := report.writeOutdated(&outdated); err != nil {
+		t.Fatalf("Unable to write report. Error: %s", err)
+	}
+	if !strings.Contains(outdated.String(), "python_buildpack 1.7.40 -> 1.7.43 (3 releases behind).") {
+		t.Errorf("Expected the releases behind in the list of outdated apps, found\n%s", outdated.String())
+	}
+}
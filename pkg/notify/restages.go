@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"bytes"
+	"time"
+)
+
+// resolveRestagedApps returns the checked apps whose owners were notified
+// about them being outdated and that were restaged since, and records in
+// history when they were. Apps that are still outdated aren't resolved, even
+// with a new droplet, since the notification about them starts over instead.
+func resolveRestagedApps(checked []appInfo, outdated []appInfo, history map[string]appNotificationRecord, now time.Time) []appInfo {
+	stillOutdated := make(map[string]bool)
+	for _, app := range outdated {
+		stillOutdated[app.GUID] = true
+	}
+	resolvedAt := now.UTC().Format(time.RFC3339)
+	var restaged []appInfo
+	for _, app := range checked {
+		record, found := history[app.GUID]
+		if !found || record.ResolvedAt != "" || record.DropletGUID == app.DropletGUID || stillOutdated[app.GUID] {
+			continue
+		}
+		record.ResolvedAt = resolvedAt
+		record.ResolvedDropletGUID = app.DropletGUID
+		history[app.GUID] = record
+		app.Notifications = record.Notifications
+		restaged = append(restaged, app)
+	}
+	return restaged
+}
+
+func sendRestagedEmailToUsers(users map[string][]appInfo, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) {
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		body := new(bytes.Buffer)
+		isMultipleApp := len(apps) > 1
+		if err := templates.getRestagedEmail(body, restagedEmail{user, apps, isMultipleApp}); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationRestageConfirmation, notificationNotRendered, err, errs)
+			return
+		}
+		if !dryRun {
+			subj := "Thank you for restaging your application"
+			if isMultipleApp {
+				subj += "s"
+			}
+			if err := mailer.SendEmail(user, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationRestageConfirmation, dryRun, err, errs)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationRestageConfirmation, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent restage confirmation e-mail to %s\n", user)
+	})
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveRestagedApps(t *testing.T) {
+	now := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	history := map[string]appNotificationRecord{
+		"app1": {DropletGUID: "droplet1", Notifications: 2},
+		"app2": {DropletGUID: "old-droplet", Notifications: 1},
+		"app3": {DropletGUID: "old-droplet", Notifications: 1},
+		"app4": {DropletGUID: "old-droplet", Notifications: 1, ResolvedAt: "2020-01-15T00:00:00Z", ResolvedDropletGUID: "droplet4"},
+	}
+	checked := []appInfo{
+		{App: newTestApp("app1", "space1"), DropletGUID: "droplet1"},
+		{App: newTestApp("app2", "space1"), DropletGUID: "droplet2"},
+		{App: newTestApp("app3", "space1"), DropletGUID: "droplet3"},
+		{App: newTestApp("app4", "space1"), DropletGUID: "droplet4"},
+		{App: newTestApp("app5", "space1"), DropletGUID: "droplet5"},
+	}
+	// app3 was restaged but another buildpack update makes it outdated again.
+	outdated := []appInfo{{App: newTestApp("app3", "space1"), DropletGUID: "droplet3"}}
+	restaged := resolveRestagedApps(checked, outdated, history, now)
+	if len(restaged) != 1 || restaged[0].GUID != "app2" || restaged[0].Notifications != 1 {
+		t.Errorf("Expected only app2 to be restaged, found %+v", restaged)
+	}
+	if history["app2"].ResolvedAt != "2020-02-01T00:00:00Z" || history["app2"].ResolvedDropletGUID != "droplet2" {
+		t.Errorf("Expected the restage of app2 to be recorded, found %+v", history["app2"])
+	}
+	if history["app4"].ResolvedAt != "2020-01-15T00:00:00Z" {
+		t.Errorf("Expected app4 to keep when it was first resolved, found %+v", history["app4"])
+	}
+	for _, guid := range []string{"app1", "app3"} {
+		if history[guid].ResolvedAt != "" {
+			t.Errorf("Expected %s to be left unresolved, found %+v", guid, history[guid])
+		}
+	}
+	if _, found := history["app5"]; found {
+		t.Errorf("Expected apps never notified about to be left out of the history, found %+v", history["app5"])
+	}
+}
@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"sort"
+	"time"
+)
+
+// uncheckedApp is an app a run couldn't check against the buildpacks it found
+// updated, e.g. because looking up its droplet failed, carried forward in the
+// state so that the next run checks it against them again rather than the
+// update going by without its owners being told.
+type uncheckedApp struct {
+	Name string
+	// Buildpacks are the buildpacks the app was to be checked against, by
+	// name, as they were when the run failed to check it.
+	Buildpacks map[string]Buildpack
+	FailedAt   string
+}
+
+// buildpacksToCheck returns the buildpacks to check the app against: the
+// buildpacks updated since, along with the ones it couldn't be checked
+// against that weren't updated again.
+func (u uncheckedApp) buildpacksToCheck(updated map[string]Buildpack) map[string]Buildpack {
+	buildpacks := make(map[string]Buildpack, len(updated)+len(u.Buildpacks))
+	for name, buildpack := range u.Buildpacks {
+		buildpacks[name] = buildpack
+	}
+	for name, buildpack := range updated {
+		buildpacks[name] = buildpack
+	}
+	return buildpacks
+}
+
+// splitUncheckedApps splits apps into the apps to check against the
+// buildpacks updated since the last run and the apps the last run couldn't
+// check, which are checked against the buildpacks it couldn't check them
+// against as well.
+func splitUncheckedApps(apps []App, unchecked map[string]uncheckedApp) ([]App, []App) {
+	if len(unchecked) == 0 {
+		return apps, nil
+	}
+	var rest, retried []App
+	for _, app := range apps {
+		if _, found := unchecked[app.GUID]; found {
+			retried = append(retried, app)
+		} else {
+			rest = append(rest, app)
+		}
+	}
+	return rest, retried
+}
+
+// recordUncheckedApps records in unchecked the apps report has as failed to
+// check, along with the buildpacks they were checked against: updated, or
+// those of retried for the apps the last run couldn't check either.
+func recordUncheckedApps(report *runReport, updated map[string]Buildpack, retried, unchecked map[string]uncheckedApp, now time.Time, logger *Logger) {
+	failedAt := now.UTC().Format(time.RFC3339)
+	failed := report.appsDecided(decisionError)
+	for _, app := range failed {
+		buildpacks := updated
+		if previous, found := retried[app.GUID]; found {
+			buildpacks = previous.buildpacksToCheck(updated)
+		}
+		unchecked[app.GUID] = uncheckedApp{Name: app.Name, Buildpacks: buildpacks, FailedAt: failedAt}
+	}
+	if len(failed) > 0 {
+		logger.warnf("Carrying forward %d apps that couldn't be checked, to check them again in the next run.\n", len(failed))
+	}
+}
+
+// onlyRecipients drops from owners the apps carried forward for other owners
+// only, so that the owners the notification reached already aren't told
+// again.
+func onlyRecipients(owners map[string][]appInfo) map[string][]appInfo {
+	kept := make(map[string][]appInfo, len(owners))
+	for owner, apps := range owners {
+		for _, app := range apps {
+			if len(app.recipients) == 0 || containsString(app.recipients, owner) {
+				kept[owner] = append(kept[owner], app)
+			}
+		}
+	}
+	return kept
+}
+
+// holdFailedNotifications carries forward in held the notifications about
+// outdated apps that didn't reach every owner, to retry them in the next run:
+// the apps whose owners couldn't be found, for every owner, and the apps in
+// the e-mails that failed to send, for the owners they failed to. It returns
+// outdated without the apps no owner was told about.
+func holdFailedNotifications(outdated []appInfo, unowned []appInfo, owners map[string][]appInfo, failed map[string][]appInfo, held map[string]heldNotification, now time.Time, logger *Logger) []appInfo {
+	heldAt := now.UTC().Format(time.RFC3339)
+	notHeld := make(map[string]bool)
+	for _, app := range unowned {
+		held[app.GUID] = heldNotification{Name: app.Name, DropletGUID: app.DropletGUID, Buildpacks: app.Buildpacks, HeldAt: heldAt}
+		notHeld[app.GUID] = true
+	}
+	failedTo := make(map[string][]string)
+	failedApps := make(map[string]appInfo)
+	for owner, apps := range failed {
+		for _, app := range apps {
+			failedTo[app.GUID] = append(failedTo[app.GUID], owner)
+			failedApps[app.GUID] = app
+		}
+	}
+	reached := make(map[string]bool)
+	for owner, apps := range owners {
+		if _, sendFailed := failed[owner]; sendFailed {
+			continue
+		}
+		for _, app := range apps {
+			reached[app.GUID] = true
+		}
+	}
+	for guid, recipients := range failedTo {
+		app := failedApps[guid]
+		sort.Strings(recipients)
+		held[guid] = heldNotification{Name: app.Name, DropletGUID: app.DropletGUID, Buildpacks: app.Buildpacks, HeldAt: heldAt, Recipients: recipients}
+		if !reached[guid] {
+			notHeld[guid] = true
+		}
+	}
+	if count := len(unowned) + len(failedTo); count > 0 {
+		logger.warnf("Carrying forward %d notifications that didn't reach every owner, to retry them in the next run.\n", count)
+	}
+	var notified []appInfo
+	for _, app := range outdated {
+		if !notHeld[app.GUID] {
+			notified = append(notified, app)
+		}
+	}
+	return notified
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHoldFailedNotifications(t *testing.T) {
+	app1 := appInfo{App: App{GUID: "app1", Name: "app1"}, DropletGUID: "droplet1"}
+	app2 := appInfo{App: App{GUID: "app2", Name: "app2"}, DropletGUID: "droplet2"}
+	app3 := appInfo{App: App{GUID: "app3", Name: "app3"}, DropletGUID: "droplet3"}
+	owners := map[string][]appInfo{user1: {app1, app2}, user2: {app2}}
+	failed := map[string][]appInfo{user1: {app1, app2}}
+	held := make(map[string]heldNotification)
+	notified := holdFailedNotifications([]appInfo{app1, app2, app3}, []appInfo{app3}, owners, failed, held, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), nil)
+	// app2 reached user2, so only app1, which reached no one, and app3,
+	// whose owners couldn't be found, aren't recorded as notified.
+	if !reflect.DeepEqual(notified, []appInfo{app2}) {
+		t.Errorf("Expected only app2 to be notified, found %+v", notified)
+	}
+	expected := map[string]heldNotification{
+		"app1": {Name: "app1", DropletGUID: "droplet1", HeldAt: "2020-02-01T00:00:00Z", Recipients: []string{user1}},
+		"app2": {Name: "app2", DropletGUID: "droplet2", HeldAt: "2020-02-01T00:00:00Z", Recipients: []string{user1}},
+		"app3": {Name: "app3", DropletGUID: "droplet3", HeldAt: "2020-02-01T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(held, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, held)
+	}
+}
+
+func TestOnlyRecipients(t *testing.T) {
+	app1 := appInfo{App: App{GUID: "app1"}}
+	app2 := appInfo{App: App{GUID: "app2"}, recipients: []string{user1}}
+	kept := onlyRecipients(map[string][]appInfo{user1: {app1, app2}, user2: {app1, app2}})
+	expected := map[string][]appInfo{user1: {app1, app2}, user2: {app1}}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, kept)
+	}
+}
+
+func TestRecordUncheckedApps(t *testing.T) {
+	report := newRunReport()
+	report.recordApp(App{GUID: "app1", Name: "app1"}, decisionError)
+	report.recordApp(App{GUID: "app2", Name: "app2"}, decisionError)
+	report.recordApp(App{GUID: "app3", Name: "app3"}, decisionNotOutdated)
+	updated := map[string]Buildpack{"python_buildpack": {GUID: "bp1", UpdatedAt: "2020-02-01T00:00:00Z"}}
+	retried := map[string]uncheckedApp{
+		"app2": {Name: "app2", Buildpacks: map[string]Buildpack{"ruby_buildpack": {GUID: "bp2", UpdatedAt: "2020-01-01T00:00:00Z"}}},
+	}
+	unchecked := make(map[string]uncheckedApp)
+	recordUncheckedApps(report, updated, retried, unchecked, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), nil)
+	// app2 still has to be checked against the update the last run
+	// couldn't check it against.
+	expected := map[string]uncheckedApp{
+		"app1": {Name: "app1", Buildpacks: updated, FailedAt: "2020-02-01T00:00:00Z"},
+		"app2": {Name: "app2", Buildpacks: map[string]Buildpack{
+			"python_buildpack": {GUID: "bp1", UpdatedAt: "2020-02-01T00:00:00Z"},
+			"ruby_buildpack":   {GUID: "bp2", UpdatedAt: "2020-01-01T00:00:00Z"},
+		}, FailedAt: "2020-02-01T00:00:00Z"},
+	}
+	if !reflect.DeepEqual(unchecked, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, unchecked)
+	}
+}
@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// runErrors collects the errors hit while processing individual buildpacks,
+// apps, spaces and users. A failure for one of them is recorded and skipped
+// so that everybody else is still notified, and the collected errors decide
+// the exit code once the run is over.
+type runErrors struct {
+	mu     sync.Mutex
+	errs   []error
+	logger *Logger
+	// sent and sendFailures count the e-mails sent through the Mailer
+	// returned by trackSends.
+	sent, sendFailures int
+}
+
+// addf records a new error and logs it right away so it shows up next to the
+// rest of the output for the item that failed.
+func (r *runErrors) addf(format string, args ...interface{}) {
+	err := fmt.Errorf(format, args...)
+	r.logger.errorf("Error: %s\n", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errs = append(r.errs, err)
+}
+
+// count returns the number of errors recorded so far.
+func (r *runErrors) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.errs)
+}
+
+// messages returns the errors recorded so far.
+func (r *runErrors) messages() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var messages []string
+	for _, err := range r.errs {
+		messages = append(messages, err.Error())
+	}
+	return messages
+}
+
+// err returns the errors recorded so far as a single error, or nil if there
+// weren't any, for the callers of the package API.
+func (r *runErrors) err() error {
+	messages := r.messages()
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d errors: %s", len(messages), strings.Join(messages, "; "))
+}
+
+// failure returns the failure of a run that completed with the errors
+// recorded, whose summary is logged by logSummary, or nil if there weren't
+// any.
+func (r *runErrors) failure() error {
+	err := r.err()
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: r.exitCode(), Err: err, logged: true}
+}
+
+// trackSends returns a Mailer counting the e-mails mailer sends and fails to
+// send, which tells an SMTP server that is down apart from failures for a few
+// recipients.
+func (r *runErrors) trackSends(mailer Mailer) Mailer {
+	return &trackedMailer{mailer: mailer, errs: r}
+}
+
+type trackedMailer struct {
+	mailer Mailer
+	errs   *runErrors
+}
+
+func (m *trackedMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	err := m.mailer.SendEmail(emailAddress, subject, body)
+	m.errs.mu.Lock()
+	defer m.errs.mu.Unlock()
+	if err != nil {
+		m.errs.sendFailures++
+	} else {
+		m.errs.sent++
+	}
+	return err
+}
+
+// exitCode returns the exit code of a run that completed with the errors
+// recorded: ExitSMTP if it tried to send e-mails and none could be sent,
+// ExitPartial for any other errors.
+func (r *runErrors) exitCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case len(r.errs) == 0:
+		return ExitOK
+	case r.sendFailures > 0 && r.sent == 0:
+		return ExitSMTP
+	default:
+		return ExitPartial
+	}
+}
+
+// logSummary logs every error recorded during the run.
+func (r *runErrors) logSummary() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errs) == 0 {
+		return
+	}
+	r.logger.errorf("Run completed with %d errors:\n", len(r.errs))
+	for _, err := range r.errs {
+		r.logger.errorf("  - %s\n", err)
+	}
+}
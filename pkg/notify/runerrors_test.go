@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloud-gov/buildpack-notify/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRunErrorsExitCode(t *testing.T) {
+	testCases := []struct {
+		name         string
+		sendErrs     []error
+		otherErrors  int
+		expectedCode int
+	}{
+		{"clean run", []error{nil, nil}, 0, ExitOK},
+		{"failed lookups", []error{nil}, 1, ExitPartial},
+		{"some sends failed", []error{nil, errors.New("mailbox full")}, 0, ExitPartial},
+		{"every send failed", []error{errors.New("connection refused"), errors.New("connection refused")}, 0, ExitSMTP},
+		{"nothing sent", nil, 1, ExitPartial},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := &runErrors{}
+			mockMailer := new(mocks.Mailer)
+			mailer := errs.trackSends(mockMailer)
+			for _, sendErr := range tc.sendErrs {
+				mockMailer.On("SendEmail", user1, mock.Anything, mock.Anything).Return(sendErr).Once()
+				if err := mailer.SendEmail(user1, "subject", nil); err != nil {
+					errs.addf("Unable to send e-mail to %s. Error: %s", user1, err)
+				}
+			}
+			for i := 0; i < tc.otherErrors; i++ {
+				errs.addf("Unable to get roles of space %d", i)
+			}
+			if code := errs.exitCode(); code != tc.expectedCode {
+				t.Errorf("Test %s failed. Expected exit code %d, found %d", tc.name, tc.expectedCode, code)
+			}
+		})
+	}
+}
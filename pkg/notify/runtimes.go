@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"regexp"
+	"strings"
+)
+
+// removedRuntime is a version line of a dependency, e.g. node 14 or python
+// 3.7, that a buildpack release removed without adding another version of
+// the same line, so apps still asking for it may fail to restage.
+type removedRuntime struct {
+	Name    string
+	Version string
+}
+
+func (r removedRuntime) String() string {
+	return r.Name + " " + r.Version
+}
+
+// majorLineRuntimes are the dependencies whose versions are supported by
+// major version, rather than by major and minor version.
+var majorLineRuntimes = map[string]bool{"node": true, "nodejs": true}
+
+var (
+	// runtimeChangeRe matches changes like "Add node 18.17.1", "remove
+	// python 3.7.x" and "Removed support for node 14".
+	runtimeChangeRe = regexp.MustCompile(`(?i)\b(add(?:s|ed)?|remov(?:e|es|ed)|drop(?:s|ped)?)\s+(?:support\s+for\s+)?([a-z][a-z0-9_\-]*)\s+v?([0-9]+(?:\.(?:[0-9]+|x|\*))*)`)
+	// runtimeChangedRe matches changes like "Node 14 removed" and "python
+	// 3.7 has been dropped".
+	runtimeChangedRe = regexp.MustCompile(`(?i)\b([a-z][a-z0-9_\-]*)\s+v?([0-9]+(?:\.(?:[0-9]+|x|\*))*)\s+(?:is\s+|was\s+|has\s+been\s+)?(added|removed|dropped)\b`)
+)
+
+// detectOutputRe matches the runtime a buildpack reports detecting when it
+// stages a droplet, e.g. "ruby 3.1.4", "python-3.8.x" or "Node.js 18".
+var detectOutputRe = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9_.\-]*?)[\s\-_]+v?([0-9]+(?:\.(?:[0-9]+|x|\*))*)\s*$`)
+
+// runtimeAliases are the other names release notes, calendars and buildpacks
+// give runtimes.
+var runtimeAliases = map[string]string{"nodejs": "node", "node.js": "node"}
+
+// notRuntimes are words matched where a dependency is expected that don't
+// name one, e.g. "remove version 1.2".
+var notRuntimes = map[string]bool{"support": true, "version": true, "versions": true, "for": true, "the": true, "stack": true, "stacks": true}
+
+// extractRemovedRuntimes returns the version lines of dependencies the notes
+// of a release remove, in the order they are first named, leaving out those
+// replaced by another version of the same line, e.g. "Add node 18.17.1,
+// remove node 18.17.0".
+func extractRemovedRuntimes(body string) []removedRuntime {
+	var removed []removedRuntime
+	added := make(map[removedRuntime]bool)
+	record := func(verb, name, version string) {
+		name = strings.ToLower(name)
+		if notRuntimes[name] {
+			return
+		}
+		runtime := removedRuntime{Name: name, Version: versionLine(name, version)}
+		if strings.HasPrefix(strings.ToLower(verb), "add") {
+			added[runtime] = true
+			return
+		}
+		for _, known := range removed {
+			if known == runtime {
+				return
+			}
+		}
+		removed = append(removed, runtime)
+	}
+	for _, match := range runtimeChangeRe.FindAllStringSubmatch(body, -1) {
+		record(match[1], match[2], match[3])
+	}
+	for _, match := range runtimeChangedRe.FindAllStringSubmatch(body, -1) {
+		record(match[3], match[1], match[2])
+	}
+	var lines []removedRuntime
+	for _, runtime := range removed {
+		if !added[runtime] {
+			lines = append(lines, runtime)
+		}
+	}
+	return lines
+}
+
+// versionLine returns the line version belongs to, e.g. 3.7 for python
+// 3.7.17 and 14 for node 14.21.3.
+func versionLine(name, version string) string {
+	parts := strings.Split(trimWildcards(version), ".")
+	switch {
+	case majorLineRuntimes[name]:
+		parts = parts[:1]
+	case len(parts) > 2:
+		parts = parts[:2]
+	}
+	return strings.Join(parts, ".")
+}
+
+// trimWildcards removes the wildcards ending version, e.g. 3.7 out of 3.7.x.
+func trimWildcards(version string) string {
+	parts := strings.Split(version, ".")
+	for len(parts) > 1 && (parts[len(parts)-1] == "x" || parts[len(parts)-1] == "*") {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// canonicalRuntime returns the name runtime is known by whatever name it is
+// given, e.g. node for nodejs.
+func canonicalRuntime(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := runtimeAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// parseDetectOutput returns the runtime and its version out of the detect
+// output of a buildpack. Buildpacks only naming the runtime, e.g. "python",
+// say nothing of its version.
+func parseDetectOutput(output string) (name, version string, ok bool) {
+	match := detectOutputRe.FindStringSubmatch(output)
+	if match == nil {
+		return "", "", false
+	}
+	return canonicalRuntime(match[1]), trimWildcards(match[2]), true
+}
+
+// detectedRuntimeIn reports whether the runtime detected by output is in the
+// line of name and version, e.g. ruby 3.1.4 in ruby 3.1, and whether that is
+// known at all: outputs without a version, of another runtime or less precise
+// than the line, e.g. python 3.x against python 3.8, say nothing.
+func detectedRuntimeIn(output, name, version string) (in, known bool) {
+	detectedName, detectedVersion, ok := parseDetectOutput(output)
+	if !ok || detectedName != canonicalRuntime(name) {
+		return false, false
+	}
+	detected, line := parseVersion(detectedVersion), parseVersion(trimWildcards(version))
+	if detected == nil || line == nil || len(detected) < len(line) {
+		return false, false
+	}
+	return hasVersionPrefix(detected, line), true
+}
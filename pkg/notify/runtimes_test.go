@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractRemovedRuntimes(t *testing.T) {
+	body := `* Add node 18.17.1, remove node 18.17.0
+* Add node 20.5.1, remove node 20.4.0
+* Node 14 removed
+* Remove python 3.7.x
+* Removed support for ruby 2.7.8
+* Bump yarn to 1.22.19
+* Remove version 2 of the manifest
+* python 3.7 has been dropped`
+	expected := []removedRuntime{
+		{Name: "python", Version: "3.7"},
+		{Name: "ruby", Version: "2.7"},
+		{Name: "node", Version: "14"},
+	}
+	if removed := extractRemovedRuntimes(body); !reflect.DeepEqual(removed, expected) {
+		t.Errorf("Expected %+v, found %+v", expected, removed)
+	}
+	if removed := extractRemovedRuntimes("* Add python 3.11.5, remove python 3.11.4"); removed != nil {
+		t.Errorf("Expected replaced versions not to be removed, found %+v", removed)
+	}
+}
+
+func TestVersionLine(t *testing.T) {
+	for _, c := range []struct{ name, version, expected string }{
+		{"python", "3.7.17", "3.7"},
+		{"python", "3.7.x", "3.7"},
+		{"node", "14.21.3", "14"},
+		{"node", "14", "14"},
+		{"php", "8.1", "8.1"},
+	} {
+		if line := versionLine(c.name, c.version); line != c.expected {
+			t.Errorf("Expected %s %s to be on line %s, found %s", c.name, c.version, c.expected, line)
+		}
+	}
+}
+
+func TestDetectedRuntimeIn(t *testing.T) {
+	for _, c := range []struct {
+		output, name, version string
+		in, known             bool
+	}{
+		{"ruby 3.1.4", "ruby", "3.1", true, true},
+		{"python-3.8.x", "python", "3.8", true, true},
+		{"Node.js 14.21.3", "node", "14", true, true},
+		{"nodejs 18", "node", "14", false, true},
+		{"python 3.9.18", "python", "3.8", false, true},
+		{"python 3.x", "python", "3.8", false, false},
+		{"python", "python", "3.8", false, false},
+		{"ruby 3.1.4", "python", "3.8", false, false},
+		{"", "python", "3.8", false, false},
+	} {
+		if in, known := detectedRuntimeIn(c.output, c.name, c.version); in != c.in || known != c.known {
+			t.Errorf("Expected %q in %s %s to be %v/%v, found %v/%v", c.output, c.name, c.version, c.in, c.known, in, known)
+		}
+	}
+}
+
+func TestDetectedRuntimeRemoved(t *testing.T) {
+	info := buildpackReleaseInfo{
+		BuildpackName:   "nodejs_buildpack",
+		DetectOutput:    "nodejs 14.21.3",
+		RemovedRuntimes: []removedRuntime{{Name: "python", Version: "3.7"}, {Name: "node", Version: "14"}},
+	}
+	if !info.DetectedRuntimeRemoved() {
+		t.Errorf("Expected node 14 to be removed")
+	}
+	info.DetectOutput = "nodejs 18.17.1"
+	if info.DetectedRuntimeRemoved() {
+		t.Errorf("Expected node 18 not to be removed")
+	}
+}
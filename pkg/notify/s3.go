@@ -0,0 +1,27 @@
+package notify
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newS3Client returns the S3 client of config, addressing buckets in the path
+// of the URLs of S3Endpoint when it is set.
+func newS3Client(config AWSConfig) *s3.Client {
+	options := s3.Options{
+		Region:      config.Region,
+		Credentials: config.credentials(),
+		HTTPClient:  &http.Client{Timeout: time.Minute},
+		// S3 compatible APIs don't all support the checksums AWS adds by
+		// default.
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+	}
+	if config.S3Endpoint != "" {
+		options.BaseEndpoint = aws.String(config.S3Endpoint)
+		options.UsePathStyle = true
+	}
+	return s3.New(options)
+}
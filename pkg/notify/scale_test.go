@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// The budget of a run over scaleApps apps. The API calls are what the CF API
+// rate limit is spent on, so a change that sends a request per app instead of
+// per page or batch blows through it long before the wall time does.
+const (
+	scaleApps           = 10000
+	scaleAPICallBudget  = 400
+	scaleWallTimeBudget = 10 * time.Second
+)
+
+// newScaleFixtures returns fixtures with apps started buildpack apps, ten to
+// a space and twenty spaces to an organization, every space with a developer
+// and every organization with a manager. Half of the apps were staged before
+// their buildpack was updated.
+func newScaleFixtures(apps int) *fixtures {
+	f := &fixtures{}
+	buildpacks := []string{"python_buildpack", "nodejs_buildpack", "go_buildpack"}
+	for i, name := range buildpacks {
+		f.Buildpacks = append(f.Buildpacks, Buildpack{
+			GUID:      fmt.Sprintf("bp%d", i),
+			Name:      name,
+			Enabled:   true,
+			Filename:  name + "-cflinuxfs4-v1.8.0.zip",
+			UpdatedAt: "2020-02-01T00:00:00Z",
+		})
+	}
+	users := 0
+	addRole := func(roleType, spaceGUID, orgGUID string) {
+		users++
+		user := User{GUID: fmt.Sprintf("user%d-guid", users), Username: fmt.Sprintf("user%d@example.com", users)}
+		role := Role{GUID: fmt.Sprintf("role%d", users), Type: roleType}
+		role.Relationships.User.Data.GUID = user.GUID
+		role.Relationships.Space.Data.GUID = spaceGUID
+		role.Relationships.Organization.Data.GUID = orgGUID
+		f.Users = append(f.Users, user)
+		f.Roles = append(f.Roles, role)
+	}
+	for i := 0; i < apps; i++ {
+		spaceGUID, orgGUID := fmt.Sprintf("space%d", i/10), fmt.Sprintf("org%d", i/200)
+		if i%200 == 0 {
+			f.Organizations = append(f.Organizations, Organization{GUID: orgGUID, Name: orgGUID})
+			addRole("organization_manager", "", orgGUID)
+		}
+		if i%10 == 0 {
+			space := Space{GUID: spaceGUID, Name: spaceGUID}
+			space.Relationships.Organization.Data.GUID = orgGUID
+			f.Spaces = append(f.Spaces, space)
+			addRole("space_developer", spaceGUID, "")
+		}
+		app := newTestApp(fmt.Sprintf("app%d", i), spaceGUID)
+		app.Name, app.State = app.GUID, "STARTED"
+		app.Lifecycle.Type = "buildpack"
+		f.Apps = append(f.Apps, app)
+		stagedAt := "2020-03-01T00:00:00Z"
+		if i%2 == 0 {
+			stagedAt = "2020-01-01T00:00:00Z"
+		}
+		droplet := newTestDroplet(stagedAt, buildpacks[i%len(buildpacks)])
+		droplet.GUID = "droplet-" + app.GUID
+		droplet.Links.App.Href = fixturesAPIAddress + "/v3/apps/" + app.GUID
+		f.Droplets = append(f.Droplets, droplet)
+	}
+	return f
+}
+
+// runScalePipeline finds the outdated apps of f and their owners the way a
+// notify run does, returning how many owners were found and how many
+// requests were sent to the API.
+func runScalePipeline(tb testing.TB, f *fixtures) (int, int) {
+	rateLimiter := newRateLimitTransport(f, 0, 0, nil)
+	client := &cfclient.Client{Config: cfclient.Config{
+		ApiAddress: fixturesAPIAddress,
+		HttpClient: &http.Client{Transport: rateLimiter},
+	}}
+	roles, err := newOwnerRoles([]string{"space_developer", "organization_manager"})
+	if err != nil {
+		tb.Fatalf("Unable to create owner roles. Error %s", err)
+	}
+	listOpts := ListOptions{PerPage: 100}
+	errs := &runErrors{}
+	report := newRunReport()
+	state := map[string]buildpackRecord{}
+	for _, buildpack := range f.Buildpacks {
+		state[buildpack.Name] = buildpackRecord{LastUpdatedAt: "2020-01-15T00:00:00Z"}
+	}
+	buildpacks, disabledBuildpacks, _, err := getUpdatedBuildpacks(client, state, listOpts, resourceFilter{}, false, errs, nil)
+	if err != nil {
+		tb.Fatalf("Unable to get buildpacks. Error: %s", err)
+	}
+	var outdatedApps []appInfo
+	_, err = listAppsWithSpacesByPage(client, listOpts, func(apps []App, spaces map[string]spaceInfo) {
+		apps = filterAppsByScope(apps, spaces, runScope{}, report, nil)
+		outdated, _, _ := findOutdatedApps(client, apps, buildpacks, disabledBuildpacks, defaultBuildpackLinks, 0, 4, report, errs, nil)
+		outdatedApps = append(outdatedApps, outdated...)
+	})
+	if err != nil {
+		tb.Fatalf("Unable to get apps. Error: %s", err)
+	}
+	owners := findOwnersOfApps(outdatedApps, client, ownerSettings{roles: roles, report: report, concurrency: 4, roleCache: newRoleCache()}, errs, nil)
+	if errs.count() != 0 {
+		tb.Fatalf("Expected no errors, found %d", errs.count())
+	}
+	if len(outdatedApps) != len(f.Apps)/2 {
+		tb.Fatalf("Expected half of the %d apps to be outdated, found %d", len(f.Apps), len(outdatedApps))
+	}
+	return len(owners), rateLimiter.requestCount()
+}
+
+// TestScaleBudget fails when a run over a large foundation sends more
+// requests or takes longer than it used to. It is skipped with -short.
+func TestScaleBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping the scale test in short mode")
+	}
+	f := newScaleFixtures(scaleApps)
+	start := time.Now()
+	owners, requests := runScalePipeline(t, f)
+	elapsed := time.Since(start)
+	t.Logf("Found %d owners of %d apps with %d requests in %s", owners, scaleApps, requests, elapsed)
+	// Every space developer and every org manager owns an outdated app.
+	if expected := len(f.Users); owners != expected {
+		t.Errorf("Expected %d owners, found %d", expected, owners)
+	}
+	if requests > scaleAPICallBudget {
+		t.Errorf("Expected at most %d API calls for %d apps, found %d", scaleAPICallBudget, scaleApps, requests)
+	}
+	if elapsed > scaleWallTimeBudget {
+		t.Errorf("Expected %d apps to be checked within %s, took %s", scaleApps, scaleWallTimeBudget, elapsed)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	f := newScaleFixtures(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runScalePipeline(b, f)
+	}
+}
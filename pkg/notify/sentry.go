@@ -0,0 +1,243 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sentryMaxEvents caps the errors a run reports, so that a foundation wide
+// failure doesn't flood the project.
+const sentryMaxEvents = 100
+
+// ErrorReporter reports panics, fatal failures and the errors collected by a
+// run to a Sentry compatible endpoint, tagged with the context of the run. A
+// nil ErrorReporter reports nothing.
+type ErrorReporter struct {
+	dsn         string
+	endpoint    string
+	auth        string
+	environment string
+	client      *http.Client
+	logger      *Logger
+
+	mu   sync.Mutex
+	tags map[string]string
+	sent int
+}
+
+// NewErrorReporter returns the reporter sending to the project of dsn, e.g.
+// https://public-key@sentry.example.com/42, logging the failures to report to
+// logger.
+func NewErrorReporter(dsn, environment string, logger *Logger) (*ErrorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return nil, errors.Errorf("Invalid Sentry DSN %q, expected https://key@host/project", dsn)
+	}
+	path := strings.Trim(u.Path, "/")
+	project := path[strings.LastIndex(path, "/")+1:]
+	if project == "" {
+		return nil, errors.Errorf("Invalid Sentry DSN %q, expected https://key@host/project", dsn)
+	}
+	prefix := strings.TrimSuffix(strings.TrimSuffix(path, project), "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	return &ErrorReporter{
+		dsn:         dsn,
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_key=%s, sentry_client=buildpack-notify/%s", u.User.Username(), version),
+		environment: environment,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      logger,
+		tags:        make(map[string]string),
+	}, nil
+}
+
+// SetTag adds key to every event reported from now on.
+func (r *ErrorReporter) SetTag(key, value string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags[key] = value
+}
+
+// sentryEvent is an event of the Sentry protocol, see
+// https://develop.sentry.dev/sdk/data-model/event-payloads/.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Release     string            `json:"release"`
+	Environment string            `json:"environment,omitempty"`
+	Message     *sentryMessage    `json:"message,omitempty"`
+	Exception   *sentryExceptions `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type sentryMessage struct {
+	Formatted string `json:"formatted"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// captureMessage reports message at level, e.g. error or fatal.
+func (r *ErrorReporter) captureMessage(level, message string) {
+	if r == nil {
+		return
+	}
+	event := r.newEvent(level)
+	event.Message = &sentryMessage{Formatted: strings.TrimSpace(message)}
+	r.send(event)
+}
+
+// CaptureError reports err as the failure that stopped the command.
+func (r *ErrorReporter) CaptureError(err error) {
+	r.captureMessage("fatal", err.Error())
+}
+
+// captureErrors reports the errors collected by a run, up to
+// sentryMaxEvents.
+func (r *ErrorReporter) captureErrors(messages []string) {
+	for _, message := range messages {
+		r.captureMessage("error", message)
+	}
+}
+
+// CapturePanic reports the panic with value, along with the stack of the
+// goroutine that panicked. It must be called from the deferred function
+// recovering it.
+func (r *ErrorReporter) CapturePanic(value interface{}) {
+	if r == nil {
+		return
+	}
+	pcs := make([]uintptr, 64)
+	// Skip runtime.Callers, CapturePanic, the deferred function and the
+	// runtime's panic handling.
+	n := runtime.Callers(4, pcs)
+	var frames []sentryFrame
+	callers := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := callers.Next()
+		frames = append(frames, sentryFrame{
+			Function: frame.Function,
+			Filename: frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, "main.") || strings.HasPrefix(frame.Function, "github.com/cloud-gov/buildpack-notify"),
+		})
+		if !more {
+			break
+		}
+	}
+	// Sentry lists the frames from the oldest call to the newest.
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	event := r.newEvent("fatal")
+	event.Exception = &sentryExceptions{Values: []sentryException{{
+		Type:       "panic",
+		Value:      fmt.Sprint(value),
+		Stacktrace: &sentryStacktrace{Frames: frames},
+	}}}
+	r.send(event)
+}
+
+func (r *ErrorReporter) newEvent(level string) sentryEvent {
+	build := currentBuild()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := make(map[string]string, len(r.tags))
+	for key, value := range r.tags {
+		tags[key] = value
+	}
+	return sentryEvent{
+		EventID:     randomHex(16),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "buildpack-notify",
+		Release:     build.Version,
+		Environment: r.environment,
+		Tags:        tags,
+		Extra:       map[string]string{"git_sha": build.GitSHA},
+	}
+}
+
+// send posts event in an envelope, logging the failures since there is
+// nowhere else to report them.
+func (r *ErrorReporter) send(event sentryEvent) {
+	r.mu.Lock()
+	if r.sent >= sentryMaxEvents {
+		r.mu.Unlock()
+		return
+	}
+	r.sent++
+	r.mu.Unlock()
+	if err := r.post(event); err != nil {
+		r.logger.warnf("Unable to report to Sentry. Error: %s\n", err)
+	}
+}
+
+func (r *ErrorReporter) post(event sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(map[string]string{"event_id": event.EventID, "dsn": r.dsn, "sent_at": event.Timestamp})
+	item, _ := json.Marshal(map[string]interface{}{"type": "event", "length": len(payload)})
+	var body bytes.Buffer
+	for _, line := range [][]byte{header, item, payload} {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("Sentry responded with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
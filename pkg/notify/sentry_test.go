@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSentryReporter(t *testing.T) {
+	var mu sync.Mutex
+	var events []sentryEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sentry/api/42/envelope/" {
+			t.Errorf("Expected the envelope endpoint of the project, found %s", r.URL.Path)
+		}
+		if auth := r.Header.Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=public") {
+			t.Errorf("Expected the key of the DSN, found %q", auth)
+		}
+		scanner := bufio.NewScanner(r.Body)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if len(lines) != 3 {
+			t.Fatalf("Expected a header, an item and an event, found %q", lines)
+		}
+		var item struct {
+			Type   string `json:"type"`
+			Length int    `json:"length"`
+		}
+		json.Unmarshal([]byte(lines[1]), &item)
+		if item.Type != "event" || item.Length != len(lines[2]) {
+			t.Errorf("Expected the item to describe the event, found %s", lines[1])
+		}
+		var event sentryEvent
+		if err := json.Unmarshal([]byte(lines[2]), &event); err != nil {
+			t.Fatalf("Unable to read event. Error: %s", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	dsn := strings.Replace(ts.URL, "://", "://public@", 1) + "/sentry/42"
+	reporter, err := NewErrorReporter(dsn, "production", nil)
+	if err != nil {
+		t.Fatalf("Unable to create reporter. Error: %s", err)
+	}
+	reporter.SetTag("run_id", "run1")
+	reporter.captureMessage("fatal", "Unable to get apps. Error: timeout\n")
+	func() {
+		defer func() {
+			if value := recover(); value != nil {
+				reporter.CapturePanic(value)
+			}
+		}()
+		panic("boom")
+	}()
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, found %+v", events)
+	}
+	if events[0].Level != "fatal" || events[0].Message.Formatted != "Unable to get apps. Error: timeout" || events[0].Tags["run_id"] != "run1" || events[0].Environment != "production" {
+		t.Errorf("Expected the fatal failure with the context of the run, found %+v", events[0])
+	}
+	panicked := events[1].Exception.Values[0]
+	frames := panicked.Stacktrace.Frames
+	if panicked.Value != "boom" || len(frames) == 0 || !strings.Contains(frames[len(frames)-1].Function, ".TestSentryReporter") || !frames[len(frames)-1].InApp {
+		t.Errorf("Expected the panic with the stack of the function that panicked, found %+v", panicked)
+	}
+
+	// A run reports at most sentryMaxEvents errors.
+	var messages []string
+	for i := 0; i < sentryMaxEvents; i++ {
+		messages = append(messages, "Unable to send e-mail to user"+strconv.Itoa(i))
+	}
+	reporter.captureErrors(messages)
+	if len(events) != sentryMaxEvents {
+		t.Errorf("Expected %d events, found %d", sentryMaxEvents, len(events))
+	}
+}
+
+func TestNewSentryReporterInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"sentry.example.com/42", "https://sentry.example.com/42", "https://key@sentry.example.com/"} {
+		if _, err := NewErrorReporter(dsn, "", nil); err == nil {
+			t.Errorf("Expected %q to be rejected", dsn)
+		}
+	}
+}
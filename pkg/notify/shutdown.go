@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// withShutdown returns a context cancelled when the process is asked to stop
+// with SIGINT or SIGTERM, as CF tasks and Concourse do, and a function
+// releasing it. A second signal stops the process right away.
+func withShutdown(parent context.Context, logger *Logger) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.warnf("Received %s, stopping the run after the e-mail being sent. Send it again to stop right away.\n", sig)
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// contextTransport sends every request with the context of the run, so that
+// stopping the run cancels the requests in flight and fails the rest.
+type contextTransport struct {
+	base http.RoundTripper
+	ctx  context.Context
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "The run was interrupted")
+	}
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// withContext makes the requests of client use ctx.
+func withContext(client *http.Client, ctx context.Context) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	withCtx := *client
+	withCtx.Transport = &contextTransport{base: base, ctx: ctx}
+	return &withCtx
+}
+
+// interruptibleMailer stops sending e-mails once the run is interrupted. The
+// e-mail being sent when it is still goes out, so that it is recorded.
+type interruptibleMailer struct {
+	Mailer
+	ctx context.Context
+}
+
+func (m *interruptibleMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	if err := m.ctx.Err(); err != nil {
+		return errors.New("Not sent, the run was interrupted")
+	}
+	return m.Mailer.SendEmail(emailAddress, subject, body)
+}
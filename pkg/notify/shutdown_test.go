@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloud-gov/buildpack-notify/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx, stop := withShutdown(context.Background(), nil)
+	defer stop()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	client := withContext(http.DefaultClient, ctx)
+	mockMailer := new(mocks.Mailer)
+	mockMailer.On("SendEmail", user1, "Action required", mock.Anything).Return(nil)
+	mailer := &interruptibleMailer{Mailer: mockMailer, ctx: ctx}
+
+	if resp, err := client.Get(ts.URL); err != nil {
+		t.Fatalf("Expected the request to succeed before the signal, found %s", err)
+	} else {
+		resp.Body.Close()
+	}
+	if err := mailer.SendEmail(user1, "Action required", []byte("body")); err != nil {
+		t.Errorf("Expected the e-mail to be sent before the signal, found %s", err)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected SIGTERM to stop the run")
+	}
+	if _, err := client.Get(ts.URL); err == nil {
+		t.Errorf("Expected requests to fail after the signal")
+	}
+	if err := mailer.SendEmail(user1, "Action required", []byte("body")); err == nil {
+		t.Errorf("Expected e-mails not to be sent after the signal")
+	}
+	mockMailer.AssertNumberOfCalls(t, "SendEmail", 1)
+}
@@ -0,0 +1,269 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// SmokeTestConfig designates the app and the mailbox the smoke test runs
+// against, and how it reads the mailbox to check the e-mail was delivered.
+type SmokeTestConfig struct {
+	Org     string `envconfig:"smoke_test_org" required:"true"`
+	Space   string `envconfig:"smoke_test_space" required:"true"`
+	App     string `envconfig:"smoke_test_app" required:"true"`
+	Mailbox string `envconfig:"smoke_test_mailbox" required:"true"`
+	// POP3Address is the host:port of the POP3 server of the mailbox, over
+	// TLS, and MailHogAPI the address of a MailHog API standing in for it.
+	POP3Address  string        `envconfig:"smoke_test_pop3_address"`
+	POP3User     string        `envconfig:"smoke_test_pop3_user"`
+	POP3Password string        `envconfig:"smoke_test_pop3_password"`
+	MailHogAPI   string        `envconfig:"smoke_test_mailhog_api"`
+	Timeout      time.Duration `envconfig:"smoke_test_timeout" default:"5m"`
+}
+
+// deliveryChecker tells whether the e-mail sent with a token in its subject
+// reached the mailbox.
+type deliveryChecker interface {
+	delivered(token string) (bool, error)
+}
+
+// deliveryChecker returns the checker of the mailbox configured.
+func (c SmokeTestConfig) deliveryChecker(logger *Logger) (deliveryChecker, error) {
+	switch {
+	case c.POP3Address != "" && c.MailHogAPI != "":
+		return nil, errors.New("SMOKE_TEST_POP3_ADDRESS and SMOKE_TEST_MAILHOG_API can't be set together")
+	case c.POP3Address != "":
+		return &pop3Checker{address: c.POP3Address, user: c.POP3User, password: c.POP3Password, dial: dialPOP3TLS, logger: logger}, nil
+	case c.MailHogAPI != "":
+		return &mailHogChecker{api: strings.TrimRight(c.MailHogAPI, "/"), client: &http.Client{Timeout: 30 * time.Second}}, nil
+	default:
+		return nil, errors.New("SMOKE_TEST_POP3_ADDRESS or SMOKE_TEST_MAILHOG_API is required to check the e-mail was delivered")
+	}
+}
+
+// smokeTest is a smoke test of the notifier against a live foundation.
+type smokeTest struct {
+	config    SmokeTestConfig
+	client    *cfclient.Client
+	owners    ownerSettings
+	links     buildpackLinks
+	templates *Templates
+	mailer    Mailer
+	checker   deliveryChecker
+	logger    *Logger
+	// interval is how long to wait between two looks at the mailbox.
+	interval time.Duration
+}
+
+// smokeTestFailure is a failed step of a smoke test, with the exit code it
+// ends the command with.
+func smokeTestFailure(code int, err error) error {
+	return &Error{Code: code, Err: err}
+}
+
+// run takes the designated app through the whole pipeline, printing each step
+// to w: it finds the app and checks its droplet against the buildpacks, finds
+// its owners, renders the e-mail telling them to restage it and sends it to
+// the designated mailbox rather than to them, then waits for the e-mail to
+// show up in the mailbox. The app is notified about whether it is outdated
+// or not, so that the e-mail is always sent.
+func (s *smokeTest) run(runID string, w io.Writer) error {
+	app, space, org, found, err := FindApp(s.client, s.config.Org, s.config.Space, s.config.App)
+	if err != nil {
+		return smokeTestFailure(ExitCFAPI, errors.Wrap(err, "Unable to find the app"))
+	}
+	if !found {
+		return smokeTestFailure(ExitCFAPI, errors.Errorf("No app %s in org %s space %s", s.config.App, s.config.Org, s.config.Space))
+	}
+	fmt.Fprintf(w, "Found app %s guid %s in org %s space %s\n", app.Name, app.GUID, org.Name, space.Name)
+
+	droplet, ok, err := getCurrentDropletForApp(app, s.client)
+	if err != nil {
+		return smokeTestFailure(ExitCFAPI, errors.Wrap(err, "Unable to get the droplet of the app"))
+	}
+	if !ok {
+		return smokeTestFailure(ExitCFAPI, errors.New("The app has no current droplet"))
+	}
+	buildpackList, err := ListBuildpacks(s.client, ListOptions{})
+	if err != nil {
+		return smokeTestFailure(ExitCFAPI, errors.Wrap(err, "Unable to get buildpacks"))
+	}
+	buildpacks := make(map[string]Buildpack)
+	for _, buildpack := range buildpackList {
+		buildpacks[buildpack.Name] = buildpack
+	}
+	supported := getSupportedBuildpacksOfDroplet(droplet, buildpacks)
+	if len(supported) == 0 {
+		return smokeTestFailure(ExitFailed, errors.Errorf("Droplet %s isn't staged with any of the buildpacks of the foundation", droplet.GUID))
+	}
+	info := appInfo{App: app, Space: space, Org: org, DropletGUID: droplet.GUID, StagedAt: droplet.CreatedAt}
+	for _, buildpack := range supported {
+		outdated, err := isDropletUsingOutdatedBuildpack(s.client, droplet, buildpack, 0)
+		if err != nil {
+			return smokeTestFailure(ExitCFAPI, errors.Wrapf(err, "Unable to check buildpack %s", buildpack.Name))
+		}
+		release := s.links.releaseInfo(buildpack)
+		dropletBuildpack := findDropletBuildpack(droplet, buildpacks, buildpack)
+		release.CurrentVersion, release.DetectOutput = dropletBuildpack.Version, strings.TrimSpace(dropletBuildpack.DetectOutput)
+		info.Buildpacks = append(info.Buildpacks, release)
+		status := "current"
+		if outdated {
+			status = "outdated"
+		}
+		fmt.Fprintf(w, "Droplet %s staged at %s with %s %s: %s\n", droplet.GUID, droplet.CreatedAt, buildpack.Name, versionOrUnknown(release.CurrentVersion), status)
+	}
+
+	errs := &runErrors{logger: s.logger}
+	owners := findOwnersOfApps([]appInfo{info}, s.client, s.owners, errs, s.logger)
+	if errs.count() > 0 {
+		return smokeTestFailure(ExitCFAPI, errors.Wrap(errs.err(), "Unable to find the owners of the app"))
+	}
+	fmt.Fprintf(w, "Owners found, not e-mailed: %s\n", strings.Join(sortedOwners(owners), ", "))
+
+	apps := []appInfo{info}
+	body := new(bytes.Buffer)
+	if err := s.templates.getNotifyEmail(body, notifyEmail{s.config.Mailbox, apps, false, getBuildpacksOfApps(apps)}); err != nil {
+		return smokeTestFailure(ExitFailed, errors.Wrap(err, "Unable to render the e-mail"))
+	}
+	subject := fmt.Sprintf("[smoke test %s] Action required: restage your application", runID)
+	if err := s.mailer.SendEmail(s.config.Mailbox, subject, body.Bytes()); err != nil {
+		return smokeTestFailure(ExitSMTP, errors.Wrapf(err, "Unable to send the e-mail to %s", s.config.Mailbox))
+	}
+	fmt.Fprintf(w, "Sent e-mail %q to %s\n", subject, s.config.Mailbox)
+
+	sent := time.Now()
+	for {
+		delivered, err := s.checker.delivered(runID)
+		if err != nil {
+			s.logger.warnf("Unable to check the mailbox. Error: %s\n", err)
+		}
+		if delivered {
+			fmt.Fprintf(w, "Delivered to %s after %s\n", s.config.Mailbox, time.Since(sent).Round(time.Second))
+			return nil
+		}
+		if time.Since(sent)+s.interval > s.config.Timeout {
+			return smokeTestFailure(ExitFailed, errors.Errorf("The e-mail didn't reach %s within %s", s.config.Mailbox, s.config.Timeout))
+		}
+		time.Sleep(s.interval)
+	}
+}
+
+// mailHogChecker looks for e-mails through the API of MailHog, for trying the
+// smoke test locally.
+type mailHogChecker struct {
+	api    string
+	client *http.Client
+}
+
+func (c *mailHogChecker) delivered(token string) (bool, error) {
+	resp, err := c.client.Get(c.api + "/api/v2/search?kind=containing&query=" + url.QueryEscape(token))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.Errorf("MailHog answered %s", resp.Status)
+	}
+	var result struct {
+		Total int `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Total > 0, nil
+}
+
+// pop3Checker looks for e-mails in a mailbox over POP3, reading only their
+// headers. The e-mail found is deleted so that the mailbox doesn't fill up
+// with a message per deploy.
+type pop3Checker struct {
+	address, user, password string
+	dial                    func(address string) (net.Conn, error)
+	logger                  *Logger
+}
+
+func dialPOP3TLS(address string) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", address, &tls.Config{})
+}
+
+func (c *pop3Checker) delivered(token string) (bool, error) {
+	conn, err := c.dial(c.address)
+	if err != nil {
+		return false, err
+	}
+	text := textproto.NewConn(conn)
+	defer text.Close()
+	if _, err := pop3Response(text); err != nil {
+		return false, err
+	}
+	if _, err := pop3Command(text, "USER %s", c.user); err != nil {
+		return false, err
+	}
+	if _, err := pop3Command(text, "PASS %s", c.password); err != nil {
+		return false, err
+	}
+	stat, err := pop3Command(text, "STAT")
+	if err != nil {
+		return false, err
+	}
+	var count, size int
+	if _, err := fmt.Sscanf(stat, "%d %d", &count, &size); err != nil {
+		return false, errors.Wrapf(err, "Unable to parse STAT response %q", stat)
+	}
+	found := false
+	// The newest messages are the last ones.
+	for i := count; i >= 1 && !found; i-- {
+		if _, err := pop3Command(text, "TOP %d 0", i); err != nil {
+			return false, err
+		}
+		headers, err := text.ReadDotLines()
+		if err != nil {
+			return false, err
+		}
+		for _, header := range headers {
+			if strings.Contains(header, token) {
+				found = true
+				break
+			}
+		}
+		if found {
+			if _, err := pop3Command(text, "DELE %d", i); err != nil {
+				c.logger.warnf("Unable to delete the smoke test e-mail from the mailbox. Error: %s\n", err)
+			}
+		}
+	}
+	_, err = pop3Command(text, "QUIT")
+	return found, err
+}
+
+// pop3Command sends a POP3 command and returns the rest of its +OK response
+// line.
+func pop3Command(text *textproto.Conn, format string, args ...interface{}) (string, error) {
+	if err := text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return pop3Response(text)
+}
+
+func pop3Response(text *textproto.Conn) (string, error) {
+	line, err := text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return "", errors.Errorf("POP3 server answered %q", line)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "+OK")), nil
+}
@@ -0,0 +1,254 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentChecker finds the e-mails sent through a memoryMailer, as if each of
+// them was delivered right away.
+type sentChecker struct {
+	mailer *memoryMailer
+}
+
+func (c *sentChecker) delivered(token string) (bool, error) {
+	for _, email := range c.mailer.emails() {
+		if strings.Contains(email.Subject, token) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// neverDelivered is a mailbox the e-mails never reach.
+type neverDelivered struct{}
+
+func (neverDelivered) delivered(token string) (bool, error) {
+	return false, nil
+}
+
+func newTestSmokeTest(t *testing.T, config SmokeTestConfig) (*smokeTest, *memoryMailer) {
+	api := newFakeCFAPI(t, newTestFixtures())
+	client, err := NewClient(CFAPIConfig{API: api.URL, ClientID: api.clientID, ClientSecret: api.clientSecret})
+	if err != nil {
+		t.Fatalf("Unable to create client. Error: %s", err)
+	}
+	templates, err := initTemplates()
+	if err != nil {
+		t.Fatalf("Unable to initialize templates. Error: %s", err)
+	}
+	mailer := newMemoryMailer()
+	test := &smokeTest{
+		config:    config,
+		client:    client,
+		owners:    ownerSettings{roles: mustOwnerRoles(t, "space_developer")},
+		templates: templates,
+		mailer:    mailer,
+		checker:   &sentChecker{mailer: mailer},
+		interval:  time.Millisecond,
+	}
+	return test, mailer
+}
+
+func TestSmokeTest(t *testing.T) {
+	config := SmokeTestConfig{Org: "agency", Space: "dev", App: "app1", Mailbox: "smoke@example.com", Timeout: time.Second}
+	test, mailer := newTestSmokeTest(t, config)
+	var out bytes.Buffer
+	if err := test.run("0123456789abcdef", &out); err != nil {
+		t.Fatalf("Expected the smoke test to pass, found %s\n%s", err, out.String())
+	}
+	sent := mailer.emails()
+	if len(sent) != 1 || sent[0].To != "smoke@example.com" || !strings.Contains(sent[0].Subject, "0123456789abcdef") || !strings.Contains(sent[0].Body, "cf restage --strategy rolling app1") {
+		t.Errorf("Expected a single e-mail about app1 to the mailbox only, found %+v", sent)
+	}
+	for _, expected := range []string{
+		"Found app app1 guid app1 in org agency space dev",
+		"with python_buildpack 1.7.40: outdated",
+		"Owners found, not e-mailed: " + user1,
+		"Delivered to smoke@example.com",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain %q, found\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestSmokeTestFailures(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   SmokeTestConfig
+		checker  deliveryChecker
+		code     int
+		expected string
+	}{
+		{
+			"app not found",
+			SmokeTestConfig{Org: "agency", Space: "prod", App: "app1", Mailbox: "smoke@example.com", Timeout: time.Second},
+			nil,
+			ExitCFAPI,
+			"No app app1 in org agency space prod",
+		},
+		{
+			"not delivered",
+			SmokeTestConfig{Org: "agency", Space: "dev", App: "app1", Mailbox: "smoke@example.com", Timeout: 20 * time.Millisecond},
+			neverDelivered{},
+			ExitFailed,
+			"didn't reach smoke@example.com",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test, _ := newTestSmokeTest(t, tc.config)
+			if tc.checker != nil {
+				test.checker = tc.checker
+			}
+			err := test.run("0123456789abcdef", &bytes.Buffer{})
+			if code := ExitCode(err); code != tc.code || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected exit code %d and %q, found %v", tc.code, tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestSmokeTestDeliveryChecker(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   SmokeTestConfig
+		expected string
+	}{
+		{"none", SmokeTestConfig{}, "is required"},
+		{"both", SmokeTestConfig{POP3Address: "pop.example.com:995", MailHogAPI: "http://localhost:8025"}, "can't be set together"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.config.deliveryChecker(nil); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected %q, found %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestSmokeTestCommandConfig(t *testing.T) {
+	setTestConfigEnv(t)
+	config, cfAPIConfig := loadTestConfig(t)
+	smokeConfig := SmokeTestConfig{Org: "agency", Space: "dev", App: "app1", Mailbox: "smoke@example.com", Timeout: time.Second}
+	if err := SmokeTest(config, cfAPIConfig, smokeConfig, newMemoryMailer(), &bytes.Buffer{}); ExitCode(err) != ExitConfig {
+		t.Errorf("Expected a smoke test without a way to read the mailbox to be a config error, found %v", err)
+	}
+}
+
+func TestMailHogChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		total := 0
+		if req.URL.Path == "/api/v2/search" && req.URL.Query().Get("query") == "0123456789abcdef" {
+			total = 1
+		}
+		fmt.Fprintf(w, `{"total": %d, "count": %d, "start": 0, "items": []}`, total, total)
+	}))
+	defer server.Close()
+	checker := &mailHogChecker{api: server.URL, client: server.Client()}
+	if delivered, err := checker.delivered("0123456789abcdef"); err != nil || !delivered {
+		t.Errorf("Expected the e-mail to be found, found %v %v", delivered, err)
+	}
+	if delivered, err := checker.delivered("fedcba9876543210"); err != nil || delivered {
+		t.Errorf("Expected another e-mail not to be found, found %v %v", delivered, err)
+	}
+}
+
+// fakePOP3 is a POP3 server holding messages of headers only.
+type fakePOP3 struct {
+	net.Listener
+	mu       sync.Mutex
+	messages []string
+	deleted  []int
+}
+
+func newFakePOP3(t *testing.T, messages ...string) *fakePOP3 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen. Error: %s", err)
+	}
+	server := &fakePOP3{Listener: listener, messages: messages}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(textproto.NewConn(conn))
+		}
+	}()
+	return server
+}
+
+func (s *fakePOP3) serve(text *textproto.Conn) {
+	defer text.Close()
+	text.PrintfLine("+OK ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		var n int
+		switch {
+		case strings.HasPrefix(line, "USER ") || line == "PASS secret":
+			text.PrintfLine("+OK")
+		case strings.HasPrefix(line, "PASS "):
+			text.PrintfLine("-ERR invalid password")
+		case line == "STAT":
+			text.PrintfLine("+OK %d 100", len(s.messages))
+		case strings.HasPrefix(line, "TOP "):
+			fmt.Sscanf(line, "TOP %d", &n)
+			text.PrintfLine("+OK")
+			w := text.DotWriter()
+			fmt.Fprint(w, s.messages[n-1])
+			w.Close()
+		case strings.HasPrefix(line, "DELE "):
+			fmt.Sscanf(line, "DELE %d", &n)
+			s.mu.Lock()
+			s.deleted = append(s.deleted, n)
+			s.mu.Unlock()
+			text.PrintfLine("+OK")
+		case line == "QUIT":
+			text.PrintfLine("+OK bye")
+			return
+		}
+	}
+}
+
+func (s *fakePOP3) deletedMessages() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.deleted...)
+}
+
+func TestPOP3Checker(t *testing.T) {
+	server := newFakePOP3(t,
+		"Subject: [smoke test 1111111111111111] Action required: restage your application\r\n",
+		"Subject: [smoke test 0123456789abcdef] Action required: restage your application\r\nX-Notify-Run-Id: 0123456789abcdef\r\n",
+	)
+	dial := func(address string) (net.Conn, error) { return net.Dial("tcp", address) }
+	checker := &pop3Checker{address: server.Addr().String(), user: "smoke@example.com", password: "secret", dial: dial}
+	if delivered, err := checker.delivered("0123456789abcdef"); err != nil || !delivered {
+		t.Errorf("Expected the e-mail to be found, found %v %v", delivered, err)
+	}
+	if deleted := server.deletedMessages(); len(deleted) != 1 || deleted[0] != 2 {
+		t.Errorf("Expected the e-mail found to be deleted, found %v", deleted)
+	}
+	if delivered, err := checker.delivered("fedcba9876543210"); err != nil || delivered {
+		t.Errorf("Expected another e-mail not to be found, found %v %v", delivered, err)
+	}
+	checker.password = "wrong"
+	if _, err := checker.delivered("0123456789abcdef"); err == nil || !strings.Contains(err.Error(), "invalid password") {
+		t.Errorf("Expected a rejected password to be an error, found %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"bytes"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+// stackEOL notifies the owners of every app still running on a stack that is
+// reaching its end of life, telling them how to move to its replacement.
+type stackEOL struct {
+	stacks      []string
+	replacement string
+	cohorts     *cohorts
+}
+
+func (s *stackEOL) isEOL(stack string) bool {
+	for _, eolStack := range s.stacks {
+		if eolStack == stack {
+			return true
+		}
+	}
+	return false
+}
+
+// getStackOfApp returns the stack the app's current droplet was staged on,
+// falling back to the stack the app is set to stage on next.
+func getStackOfApp(app App, droplet Droplet) string {
+	if droplet.Stack != "" {
+		return droplet.Stack
+	}
+	return app.Lifecycle.Data.Stack
+}
+
+// findAppsOnEOLStacks returns every app running on one of the end of life stacks.
+func findAppsOnEOLStacks(client *cfclient.Client, apps []App, eol *stackEOL, concurrency int, report *runReport, errs *runErrors, logger *Logger) []appInfo {
+	var eolApps []appInfo
+	for _, result := range getDropletsToCheck(apps, client, concurrency, report, errs, logger) {
+		if !result.ok {
+			continue
+		}
+		app, droplet := result.app, result.droplet
+		stack := getStackOfApp(app, droplet)
+		if !eol.isEOL(stack) {
+			report.traceApp(app, "stack", "supported", stack)
+			report.recordApp(app, decisionSupportedStack)
+			continue
+		}
+		logger.with(appFields(app).with("stack", stack)).infof("App %s guid %s is running on end of life stack %s\n", app.Name, app.GUID, stack)
+		report.traceApp(app, "stack", "end of life", stack)
+		report.recordApp(app, decisionEOLStack)
+		eolApps = append(eolApps, appInfo{App: app, Stack: stack})
+	}
+	return eolApps
+}
+
+// runStackEOL notifies the owners of every app in scope running on an end of life stack.
+func runStackEOL(client *cfclient.Client, eol *stackEOL, scope runScope, listOpts ListOptions, concurrency int, settings ownerSettings, templates *Templates, mailer Mailer, emailConcurrency int, dryRun bool, audit *notificationAuditLog, report *runReport, errs *runErrors, logger *Logger) error {
+	apps, spaces, err := listAppsWithSpaces(client, listOpts)
+	if err != nil {
+		return err
+	}
+	apps = filterAppsByScope(apps, spaces, scope, report, logger)
+	eolApps := findAppsOnEOLStacks(client, apps, eol, concurrency, report, errs, logger)
+	owners := eol.cohorts.filter(findOwnersOfApps(eolApps, client, settings, errs, logger), logger)
+	report.recordOwners(owners)
+	logger.infof("Will notify %d owners of apps on end of life stacks.\n", len(owners))
+	sendStackEOLEmailToUsers(owners, eol, templates, mailer, emailConcurrency, dryRun, audit, errs, logger)
+	return nil
+}
+
+func sendStackEOLEmailToUsers(users map[string][]appInfo, eol *stackEOL, templates *Templates, mailer Mailer, concurrency int, dryRun bool, audit *notificationAuditLog, errs *runErrors, logger *Logger) {
+	forEachOwner(users, concurrency, func(user string, apps []appInfo) {
+		body := new(bytes.Buffer)
+		isMultipleApp := len(apps) > 1
+		if err := templates.getStackEOLEmail(body, stackEOLEmail{user, apps, isMultipleApp, eol.replacement}); err != nil {
+			errs.addf("Unable to render e-mail to %s. Error: %s", user, err)
+			audit.record(user, apps, notificationStackEOL, notificationNotRendered, err, errs)
+			return
+		}
+		if !dryRun {
+			subj := "Action required: move your application"
+			if isMultipleApp {
+				subj += "s"
+			}
+			subj += " to a supported stack"
+			if err := mailer.SendEmail(user, subj, body.Bytes()); err != nil {
+				errs.addf("Unable to send e-mail to %s. Error: %s", user, err)
+				audit.recordSend(user, apps, notificationStackEOL, dryRun, err, errs)
+				return
+			}
+		}
+		audit.recordSend(user, apps, notificationStackEOL, dryRun, nil, errs)
+		logger.with(logFields{"recipient", user}).infof("Sent stack end of life e-mail to %s\n", user)
+	})
+}
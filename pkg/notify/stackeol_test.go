@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func TestConfigStackEOL(t *testing.T) {
+	if eol, err := (Config{}).stackEOL(); eol != nil || err != nil {
+		t.Errorf("Expected no stack end of life notification without stacks, found %+v/%v", eol, err)
+	}
+	if _, err := (Config{EOLStacks: []string{"cflinuxfs3"}}).stackEOL(); err == nil {
+		t.Errorf("Expected a stack end of life notification without a replacement to be invalid")
+	}
+	if _, err := (Config{EOLStacks: []string{"cflinuxfs3"}, EOLStackReplacement: "cflinuxfs4", CampaignBuildpack: "php_buildpack"}).stackEOL(); err == nil {
+		t.Errorf("Expected a stack end of life notification during a campaign to be invalid")
+	}
+}
+
+func TestFindAppsOnEOLStacks(t *testing.T) {
+	eol := &stackEOL{stacks: []string{"cflinuxfs2", "cflinuxfs3"}, replacement: "cflinuxfs4"}
+	onOldStack := newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")
+	onOldStack.Stack = "cflinuxfs3"
+	onNewStack := newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack")
+	onNewStack.Stack = "cflinuxfs4"
+	lifecycleOnOldStack := newTestStartedApp("buildpack")
+	lifecycleOnOldStack.Lifecycle.Data.Stack = "cflinuxfs2"
+	testCases := []struct {
+		name             string
+		app              App
+		droplet          Droplet
+		expectedDecision appDecision
+	}{
+		{"droplet on end of life stack", newTestStartedApp("buildpack"), onOldStack, decisionEOLStack},
+		{"droplet on supported stack", newTestStartedApp("buildpack"), onNewStack, decisionSupportedStack},
+		{"droplet on supported stack wins over lifecycle", lifecycleOnOldStack, onNewStack, decisionSupportedStack},
+		{"falls back to lifecycle stack", lifecycleOnOldStack, newTestDroplet("2020-01-01T00:00:00Z", "python_buildpack"), decisionEOLStack},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v3/droplets" {
+					t.Fatalf("Unable to find handler for path %s", r.URL.Path)
+				}
+				json.NewEncoder(w).Encode(DropletResponse{Droplets: []Droplet{tc.droplet}})
+			}))
+			defer ts.Close()
+			c := cfclient.Client{Config: cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL}}
+			report := newRunReport()
+			apps := findAppsOnEOLStacks(&c, []App{tc.app}, eol, 1, report, &runErrors{}, nil)
+			if report.count(tc.expectedDecision) != 1 {
+				t.Errorf("Test %s failed. Expected decision %s, found %+v", tc.name, tc.expectedDecision, report.decisions)
+			}
+			if (len(apps) == 1) != (tc.expectedDecision == decisionEOLStack) {
+				t.Errorf("Test %s failed. Unexpected apps %+v", tc.name, apps)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// mapDiff is what changed between two versions of a map in the state, as the
+// sorted keys added, changed and removed.
+type mapDiff struct {
+	added, changed, removed []string
+}
+
+func (d mapDiff) isEmpty() bool {
+	return len(d.added) == 0 && len(d.changed) == 0 && len(d.removed) == 0
+}
+
+// diffMaps compares the entries of two versions of a map in the state.
+func diffMaps[T any](before, after map[string]T) mapDiff {
+	var diff mapDiff
+	for key, afterValue := range after {
+		beforeValue, found := before[key]
+		switch {
+		case !found:
+			diff.added = append(diff.added, key)
+		case !reflect.DeepEqual(beforeValue, afterValue):
+			diff.changed = append(diff.changed, key)
+		}
+	}
+	for key := range before {
+		if _, found := after[key]; !found {
+			diff.removed = append(diff.removed, key)
+		}
+	}
+	sort.Strings(diff.added)
+	sort.Strings(diff.changed)
+	sort.Strings(diff.removed)
+	return diff
+}
+
+// writeMapDiff writes a section of the diff of two states to w, describing
+// added and removed entries with describe and changed ones with change.
+func writeMapDiff[T any](w io.Writer, title string, before, after map[string]T, describe func(T) string, change func(before, after T) string) bool {
+	diff := diffMaps(before, after)
+	if diff.isEmpty() {
+		return false
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, key := range diff.added {
+		fmt.Fprintf(w, "  + %s: %s\n", key, describe(after[key]))
+	}
+	for _, key := range diff.changed {
+		fmt.Fprintf(w, "  ~ %s: %s\n", key, change(before[key], after[key]))
+	}
+	for _, key := range diff.removed {
+		fmt.Fprintf(w, "  - %s: %s\n", key, describe(before[key]))
+	}
+	return true
+}
+
+// changes describes the fields that changed between two versions of an
+// entry, given as name, value before and value after.
+func changes(fields ...[3]string) string {
+	var changed []string
+	for _, field := range fields {
+		if field[1] != field[2] {
+			changed = append(changed, fmt.Sprintf("%s %s -> %s", field[0], orNone(field[1]), orNone(field[2])))
+		}
+	}
+	if len(changed) == 0 {
+		return "changed"
+	}
+	return strings.Join(changed, ", ")
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// writeStateDiff writes what changed between the before and after states to
+// w, so operators can audit what a run changed.
+func writeStateDiff(w io.Writer, before, after *runState) {
+	changed := writeMapDiff(w, "Buildpacks", before.Buildpacks, after.Buildpacks,
+		func(r buildpackRecord) string {
+			return fmt.Sprintf("%s updated at %s", orNone(r.Filename), r.LastUpdatedAt)
+		},
+		func(b, a buildpackRecord) string {
+			return changes([3]string{"updated at", b.LastUpdatedAt, a.LastUpdatedAt}, [3]string{"file", b.Filename, a.Filename})
+		})
+	changed = writeMapDiff(w, "Notified apps", before.Apps, after.Apps,
+		func(r appNotificationRecord) string {
+			return fmt.Sprintf("notified %d times about droplet %s, last at %s", r.Notifications, r.DropletGUID, r.LastNotifiedAt)
+		},
+		func(b, a appNotificationRecord) string {
+			return changes(
+				[3]string{"droplet", b.DropletGUID, a.DropletGUID},
+				[3]string{"notifications", fmt.Sprint(b.Notifications), fmt.Sprint(a.Notifications)},
+				[3]string{"last notified at", b.LastNotifiedAt, a.LastNotifiedAt},
+				[3]string{"resolved at", b.ResolvedAt, a.ResolvedAt},
+			)
+		}) || changed
+	changed = writeMapDiff(w, "Pinned buildpack warnings", before.PinnedBuildpackWarnings, after.PinnedBuildpackWarnings,
+		func(r pinnedBuildpackRecord) string {
+			return strings.Join(r.Buildpacks, ", ")
+		},
+		func(b, a pinnedBuildpackRecord) string {
+			return changes([3]string{"buildpacks", strings.Join(b.Buildpacks, ", "), strings.Join(a.Buildpacks, ", ")})
+		}) || changed
+	changed = writeMapDiff(w, "Restage queue", before.RestageQueue, after.RestageQueue,
+		func(r queuedRestage) string {
+			return fmt.Sprintf("%s on droplet %s, queued at %s", r.Name, r.DropletGUID, r.QueuedAt)
+		},
+		func(b, a queuedRestage) string {
+			return changes([3]string{"droplet", b.DropletGUID, a.DropletGUID}, [3]string{"not before", b.NotBefore, a.NotBefore})
+		}) || changed
+	changed = writeMapDiff(w, "Held notifications", before.HeldNotifications, after.HeldNotifications,
+		func(r heldNotification) string {
+			return fmt.Sprintf("%s on droplet %s, held at %s", r.Name, r.DropletGUID, r.HeldAt)
+		},
+		func(b, a heldNotification) string {
+			return changes([3]string{"droplet", b.DropletGUID, a.DropletGUID}, [3]string{"held at", b.HeldAt, a.HeldAt},
+				[3]string{"recipients", strings.Join(b.Recipients, ", "), strings.Join(a.Recipients, ", ")})
+		}) || changed
+	changed = writeMapDiff(w, "Unchecked apps", before.UncheckedApps, after.UncheckedApps,
+		func(r uncheckedApp) string {
+			return fmt.Sprintf("%s, failed at %s", r.Name, r.FailedAt)
+		},
+		func(b, a uncheckedApp) string {
+			return changes([3]string{"failed at", b.FailedAt, a.FailedAt})
+		}) || changed
+	beforeToken, afterToken := "", ""
+	if before.RestagePlan != nil {
+		beforeToken = before.RestagePlan.Token
+	}
+	if after.RestagePlan != nil {
+		afterToken = after.RestagePlan.Token
+	}
+	if beforeToken != afterToken {
+		fmt.Fprintf(w, "Restage plan: %s\n", changes([3]string{"token", beforeToken, afterToken}))
+		changed = true
+	}
+	if !changed {
+		fmt.Fprintln(w, "The states are the same.")
+	}
+}
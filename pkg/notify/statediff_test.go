@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteStateDiff(t *testing.T) {
+	before := newRunState()
+	before.Buildpacks["bp1"] = buildpackRecord{LastUpdatedAt: "2020-01-01T00:00:00Z", Filename: "python_buildpack-v1.7.40.zip"}
+	before.Buildpacks["bp2"] = buildpackRecord{LastUpdatedAt: "2020-01-01T00:00:00Z", Filename: "ruby_buildpack-v1.8.0.zip"}
+	before.Buildpacks["bp3"] = buildpackRecord{LastUpdatedAt: "2020-01-01T00:00:00Z", Filename: "go_buildpack-v1.9.0.zip"}
+	before.Apps["app1"] = appNotificationRecord{DropletGUID: "droplet1", Notifications: 1, LastNotifiedAt: "2020-01-02T00:00:00Z"}
+
+	after := newRunState()
+	after.Buildpacks["bp1"] = buildpackRecord{LastUpdatedAt: "2020-02-01T00:00:00Z", Filename: "python_buildpack-v1.7.43.zip"}
+	after.Buildpacks["bp2"] = before.Buildpacks["bp2"]
+	after.Buildpacks["bp4"] = buildpackRecord{LastUpdatedAt: "2020-02-01T00:00:00Z"}
+	after.Apps["app1"] = appNotificationRecord{DropletGUID: "droplet1", Notifications: 2, LastNotifiedAt: "2020-02-02T00:00:00Z"}
+
+	var buf bytes.Buffer
+	writeStateDiff(&buf, before, after)
+	expected := `Buildpacks:
+  + bp4: (none) updated at 2020-02-01T00:00:00Z
+  ~ bp1: updated at 2020-01-01T00:00:00Z -> 2020-02-01T00:00:00Z, file python_buildpack-v1.7.40.zip -> python_buildpack-v1.7.43.zip
+  - bp3: go_buildpack-v1.9.0.zip updated at 2020-01-01T00:00:00Z
+Notified apps:
+  ~ app1: notifications 1 -> 2, last notified at 2020-01-02T00:00:00Z -> 2020-02-02T00:00:00Z
+`
+	if buf.String() != expected {
+		t.Errorf("Expected diff\n%s\nfound\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	writeStateDiff(&buf, after, after)
+	if buf.String() != "The states are the same.\n" {
+		t.Errorf("Expected identical states to be the same, found\n%s", buf.String())
+	}
+}
@@ -0,0 +1,254 @@
+package notify
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+)
+
+// mailTemplates are the e-mail templates shipped with buildpack-notify,
+// built into the binary so that the templates are found whatever directory
+// it runs in, or the program importing the package runs in.
+//
+//go:embed templates/mail/*.txt
+var mailTemplates embed.FS
+
+const (
+	notifyTemplate          = "NOTIFY_TEMPLATE"
+	pinnedBuildpackTemplate = "PINNED_BUILDPACK_TEMPLATE"
+	campaignTemplate        = "CAMPAIGN_TEMPLATE"
+	stackEOLTemplate        = "STACK_EOL_TEMPLATE"
+	escalationTemplate      = "ESCALATION_TEMPLATE"
+	restagedTemplate        = "RESTAGED_TEMPLATE"
+	restageWarningTemplate  = "RESTAGE_WARNING_TEMPLATE"
+	canaryFailedTemplate    = "CANARY_FAILED_TEMPLATE"
+	restagePlanTemplate     = "RESTAGE_PLAN_TEMPLATE"
+	operatorSummaryTemplate = "OPERATOR_SUMMARY_TEMPLATE"
+)
+
+// Templates serve as a mapping to various templates.
+// Each entry can be a compliation of multiple files mapped to a string entry.
+// This works if we ever want to use the .define blocks which are good for
+// creating a main template with swappable content.
+// Similar to https://hackernoon.com/golang-template-2-template-composition-and-how-to-organize-template-files-4cb40bcdf8f6
+type Templates struct {
+	templates map[string]*template.Template
+}
+
+// initTemplates will try to parse the templates.
+func initTemplates() (*Templates, error) {
+	templates := make(map[string]*template.Template)
+	for templateName, templatePath := range findTemplates() {
+		tpl, err := template.ParseFS(mailTemplates, templatePath...)
+		if err != nil {
+			return nil, err
+		}
+		templates[templateName] = tpl
+	}
+	return &Templates{templates}, nil
+}
+
+// findTemplates will try to construct to final path of where to find templates
+// in mailTemplates.
+func findTemplates() map[string][]string {
+	return map[string][]string{
+		notifyTemplate:          []string{path.Join("templates", "mail", "notify.txt")},
+		pinnedBuildpackTemplate: []string{path.Join("templates", "mail", "pinned_buildpack.txt")},
+		stackEOLTemplate:        []string{path.Join("templates", "mail", "stack_eol.txt")},
+		escalationTemplate:      []string{path.Join("templates", "mail", "escalation.txt")},
+		restagedTemplate:        []string{path.Join("templates", "mail", "restaged.txt")},
+		restageWarningTemplate:  []string{path.Join("templates", "mail", "restage_warning.txt")},
+		canaryFailedTemplate:    []string{path.Join("templates", "mail", "canary_failed.txt")},
+		restagePlanTemplate:     []string{path.Join("templates", "mail", "restage_plan.txt")},
+		operatorSummaryTemplate: []string{path.Join("templates", "mail", "operator_summary.txt")},
+	}
+}
+
+// addTemplate parses a template that isn't shipped with the app, e.g. one
+// given in the config, from the file system and maps it to templateKey.
+func (t *Templates) addTemplate(templateKey string, templatePath ...string) error {
+	tpl, err := template.ParseFiles(templatePath...)
+	if err != nil {
+		return err
+	}
+	t.templates[templateKey] = tpl
+	return nil
+}
+
+func (t *Templates) getTemplate(templateKey string) (*template.Template, error) {
+	if template, ok := t.templates[templateKey]; ok {
+		return template, nil
+	}
+	return nil, fmt.Errorf("unable to find template with key %s", templateKey)
+}
+
+// notifyEmail provides struct for the templates/mail/notify.tmpl
+type notifyEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+	Buildpacks    []buildpackReleaseInfo
+}
+
+// getNotifyEmail gets the filled in notify email template.
+func (t *Templates) getNotifyEmail(rw io.Writer, email notifyEmail) error {
+	tpl, err := t.getTemplate(notifyTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// pinnedBuildpackEmail provides struct for the templates/mail/pinned_buildpack.txt
+type pinnedBuildpackEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+}
+
+// getPinnedBuildpackEmail gets the filled in pinned buildpack email template.
+func (t *Templates) getPinnedBuildpackEmail(rw io.Writer, email pinnedBuildpackEmail) error {
+	tpl, err := t.getTemplate(pinnedBuildpackTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// campaignEmail provides struct for the template of a deprecation campaign.
+type campaignEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+	Buildpack     string
+	Stack         string
+}
+
+// getCampaignEmail gets the filled in campaign email template.
+func (t *Templates) getCampaignEmail(rw io.Writer, email campaignEmail) error {
+	tpl, err := t.getTemplate(campaignTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// stackEOLEmail provides struct for the templates/mail/stack_eol.txt
+type stackEOLEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+	Replacement   string
+}
+
+// getStackEOLEmail gets the filled in stack end of life email template.
+func (t *Templates) getStackEOLEmail(rw io.Writer, email stackEOLEmail) error {
+	tpl, err := t.getTemplate(stackEOLTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// escalationEmail provides struct for the templates/mail/escalation.txt
+type escalationEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+}
+
+// getEscalationEmail gets the filled in escalation email template.
+func (t *Templates) getEscalationEmail(rw io.Writer, email escalationEmail) error {
+	tpl, err := t.getTemplate(escalationTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// restagedEmail provides struct for the templates/mail/restaged.txt
+type restagedEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+}
+
+// getRestagedEmail gets the filled in restage confirmation email template.
+func (t *Templates) getRestagedEmail(rw io.Writer, email restagedEmail) error {
+	tpl, err := t.getTemplate(restagedTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// restageWarningEmail provides struct for the templates/mail/restage_warning.txt
+type restageWarningEmail struct {
+	Username      string
+	Apps          []appInfo
+	IsMultipleApp bool
+	RestageOn     string
+}
+
+// getRestageWarningEmail gets the filled in restage warning email template.
+func (t *Templates) getRestageWarningEmail(rw io.Writer, email restageWarningEmail) error {
+	tpl, err := t.getTemplate(restageWarningTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// canaryFailedEmail provides struct for the templates/mail/canary_failed.txt
+type canaryFailedEmail struct {
+	App         appInfo
+	Error       string
+	SkippedApps []appInfo
+}
+
+// getCanaryFailedEmail gets the filled in canary failure email template.
+func (t *Templates) getCanaryFailedEmail(rw io.Writer, email canaryFailedEmail) error {
+	tpl, err := t.getTemplate(canaryFailedTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// restagePlanEmail provides struct for the templates/mail/restage_plan.txt
+type restagePlanEmail struct {
+	Token string
+	Apps  []appInfo
+}
+
+// getRestagePlanEmail gets the filled in restage plan email template.
+func (t *Templates) getRestagePlanEmail(rw io.Writer, email restagePlanEmail) error {
+	tpl, err := t.getTemplate(restagePlanTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
+
+// operatorSummaryEmail provides struct for the templates/mail/operator_summary.txt
+type operatorSummaryEmail struct {
+	RunID         string
+	DryRun        bool
+	Triggers      []string
+	OutdatedByOrg []orgCount
+	EmailsSent    int
+	EmailsFailed  int
+	DroppedUsers  []string
+	FollowUps     []string
+}
+
+// getOperatorSummaryEmail gets the filled in operator summary email template.
+func (t *Templates) getOperatorSummaryEmail(rw io.Writer, email operatorSummaryEmail) error {
+	tpl, err := t.getTemplate(operatorSummaryTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(rw, email)
+}
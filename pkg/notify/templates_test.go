@@ -0,0 +1,598 @@
+package notify
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetNotifyEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "notify")
+	updatedBuildpacksSingleApp := []buildpackReleaseInfo{
+		{
+			BuildpackName:    "python_buildpack",
+			BuildpackVersion: "v1.7.43",
+			BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.7.43",
+		},
+	}
+	updatedBuildpacksMultipleApps := []buildpackReleaseInfo{
+		{
+			BuildpackName:    "python_buildpack",
+			BuildpackVersion: "v1.7.43",
+			BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.7.43",
+		},
+		{
+			BuildpackName:    "ruby_buildpack",
+			BuildpackVersion: "v1.8.43",
+			BuildpackURL:     "https://github.com/cloudfoundry/ruby-buildpack/releases/tags/v1.8.43",
+		},
+	}
+	testCases := []struct {
+		name          string
+		email         notifyEmail
+		expectedEmail string
+	}{
+		{
+			"single app",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false, updatedBuildpacksSingleApp},
+			filepath.Join(rootDataPath, "single_app.txt"),
+		},
+		{
+			"multiple apps",
+			notifyEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space: Space{Name: "dev"},
+					Org:   Organization{Name: "sandbox"},
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space: Space{Name: "staging"},
+					Org:   Organization{Name: "paid-org"},
+				},
+			}, true, updatedBuildpacksMultipleApps},
+			filepath.Join(rootDataPath, "multiple_apps.txt"),
+		},
+		{
+			"buildpack without filename",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false, []buildpackReleaseInfo{
+				defaultBuildpackLinks.releaseInfo(Buildpack{Name: "python_buildpack"}),
+				defaultBuildpackLinks.releaseInfo(Buildpack{Name: "custom_offline_buildpack"}),
+			}},
+			filepath.Join(rootDataPath, "without_filename.txt"),
+		},
+		{
+			"release notes",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false, []buildpackReleaseInfo{
+				{
+					BuildpackName:    "python_buildpack",
+					BuildpackVersion: "v1.7.43",
+					BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.7.43",
+					ReleaseNotes:     "Add python 3.11.5, remove python 3.11.4\nIncluding security fixes for: CVE-2023-40217",
+					SecurityFixes:    []securityFix{{CVE: "CVE-2023-40217", Severity: "Medium"}, {CVE: "CVE-2023-41105"}},
+				},
+			}},
+			filepath.Join(rootDataPath, "release_notes.txt"),
+		},
+		{
+			"removed runtimes",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-node-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false, []buildpackReleaseInfo{
+				{
+					BuildpackName:    "nodejs_buildpack",
+					BuildpackVersion: "v1.8.14",
+					BuildpackURL:     "https://github.com/cloudfoundry/nodejs-buildpack/releases/tags/v1.8.14",
+					ReleaseNotes:     "Add node 20.5.1\nNode 14 removed",
+					RemovedRuntimes:  []removedRuntime{{Name: "node", Version: "14"}},
+				},
+				{
+					BuildpackName:    "python_buildpack",
+					BuildpackVersion: "v1.8.15",
+					BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.8.15",
+					RemovedRuntimes:  []removedRuntime{{Name: "python", Version: "3.7"}, {Name: "python", Version: "3.8"}},
+				},
+			}},
+			filepath.Join(rootDataPath, "removed_runtimes.txt"),
+		},
+		{
+			"docs",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false, []buildpackReleaseInfo{
+				{
+					BuildpackName:    "python_buildpack",
+					BuildpackVersion: "v1.8.15",
+					BuildpackURL:     "https://github.com/cloudfoundry/python-buildpack/releases/tags/v1.8.15",
+					Docs: []DocLink{
+						{Title: "Restaging Python apps on cloud.gov", URL: "https://cloud.gov/docs/python/"},
+						{Title: "Migrating to Python 3.11", URL: "https://cloud.gov/docs/python/3.11/"},
+					},
+				},
+			}},
+			filepath.Join(rootDataPath, "docs.txt"),
+		},
+		{
+			"releases behind",
+			notifyEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space: Space{Name: "dev"},
+					Org:   Organization{Name: "sandbox"},
+					Buildpacks: []buildpackReleaseInfo{
+						{BuildpackName: "php_buildpack", BuildpackVersion: "v4.3.70", CurrentVersion: "4.3.64", ReleasesBehind: 6},
+					},
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space: Space{Name: "staging"},
+					Org:   Organization{Name: "paid-org"},
+					Buildpacks: []buildpackReleaseInfo{
+						{BuildpackName: "php_buildpack", BuildpackVersion: "v4.3.70", CurrentVersion: "4.3.69", ReleasesBehind: 1},
+						{BuildpackName: "python_buildpack", BuildpackVersion: "v1.7.43"},
+					},
+				},
+			}, true, updatedBuildpacksMultipleApps},
+			filepath.Join(rootDataPath, "releases_behind.txt"),
+		},
+		{
+			"eol warnings",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+				Buildpacks: []buildpackReleaseInfo{{
+					BuildpackName:    "python_buildpack",
+					BuildpackVersion: "v1.8.15",
+					CurrentVersion:   "1.7.40",
+					EOLWarnings: []eolWarning{
+						{Subject: "python_buildpack 1.7", Date: "2024-03-31", DaysLeft: 30},
+						{Subject: "python 3.8", Date: "2023-10-07", DaysLeft: -146, Runtime: true},
+					},
+				}},
+			}}, false, updatedBuildpacksSingleApp},
+			filepath.Join(rootDataPath, "eol_warnings.txt"),
+		},
+		{
+			"detected runtime",
+			notifyEmail{"test@example.com", []appInfo{{App: App{Name: "my-node-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+				Buildpacks: []buildpackReleaseInfo{{
+					BuildpackName:    "nodejs_buildpack",
+					BuildpackVersion: "v1.8.14",
+					CurrentVersion:   "1.8.10",
+					DetectOutput:     "nodejs 14.21.3",
+					RemovedRuntimes:  []removedRuntime{{Name: "node", Version: "14"}},
+					EOLWarnings: []eolWarning{
+						{Subject: "node 14", Date: "2023-04-30", DaysLeft: -154, Runtime: true, Detected: true},
+					},
+				}},
+			}}, false, []buildpackReleaseInfo{{
+				BuildpackName:    "nodejs_buildpack",
+				BuildpackVersion: "v1.8.14",
+				BuildpackURL:     "https://github.com/cloudfoundry/nodejs-buildpack/releases/tags/v1.8.14",
+				RemovedRuntimes:  []removedRuntime{{Name: "node", Version: "14"}},
+			}}},
+			filepath.Join(rootDataPath, "detected_runtime.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getNotifyEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+func TestGetPinnedBuildpackEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "pinned_buildpack")
+	pinned := gitBuildpack{URL: "https://github.com/cloudfoundry/python-buildpack", Ref: "v1.7.43"}
+	testCases := []struct {
+		name          string
+		email         pinnedBuildpackEmail
+		expectedEmail string
+	}{
+		{
+			"single app",
+			pinnedBuildpackEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space:         Space{Name: "dev"},
+				Org:           Organization{Name: "sandbox"},
+				GitBuildpacks: []gitBuildpack{pinned},
+			}}, false},
+			filepath.Join(rootDataPath, "single_app.txt"),
+		},
+		{
+			"multiple apps",
+			pinnedBuildpackEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space:         Space{Name: "dev"},
+					Org:           Organization{Name: "sandbox"},
+					GitBuildpacks: []gitBuildpack{pinned},
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space: Space{Name: "staging"},
+					Org:   Organization{Name: "paid-org"},
+					GitBuildpacks: []gitBuildpack{
+						{URL: "https://github.com/example/apt-buildpack", Ref: "v0.2.1"},
+						{URL: "https://github.com/cloudfoundry/php-buildpack/releases/download/v4.6.1/php-buildpack-cflinuxfs4-v4.6.1.zip", Ref: "v4.6.1", Archive: true},
+					},
+				},
+			}, true},
+			filepath.Join(rootDataPath, "multiple_apps.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getPinnedBuildpackEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+// compareWithExpectedEmail checks body against the e-mail stored at
+// expectedEmail. Set OVERRIDE_TEMPLATES=1 to store body there instead.
+func compareWithExpectedEmail(t *testing.T, name string, body *bytes.Buffer, expectedEmail string) {
+	if os.Getenv("OVERRIDE_TEMPLATES") == "1" {
+		err := ioutil.WriteFile(expectedEmail, body.Bytes(), 0644)
+		if err != nil {
+			t.Errorf("Can't save expected email. Error %s", err.Error())
+		}
+	}
+	expectedBody, err := ioutil.ReadFile(expectedEmail)
+	if err != nil {
+		t.Fatalf("Unable to read expected file. %s", err.Error())
+	}
+	if string(expectedBody) != string(body.Bytes()) {
+		t.Logf("\n===========Expected %s e-mail case BEGIN===========\n%s\n===========Expected %s e-mail case END===========\n", name, string(expectedBody), name)
+		t.Logf("\n===========Actual %s e-mail case BEGIN===========\n%s\n===========Actual %s e-mail case END===========\n", name, string(body.Bytes()), name)
+		t.Errorf("Test %s failed. For the actual output, inspect %s.returned.", name, filepath.Base(expectedEmail))
+		ioutil.WriteFile(filepath.Join(filepath.Dir(expectedEmail), filepath.Base(expectedEmail)+".returned"), body.Bytes(), 0644)
+	}
+}
+
+func TestGetCampaignEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "campaign")
+	templates, err := initTemplates()
+	if err != nil {
+		t.Fatalf("Unable to init templates. Error %s", err.Error())
+	}
+	if err := templates.addTemplate(campaignTemplate, filepath.Join("templates", "mail", "campaign_example.txt")); err != nil {
+		t.Fatalf("Unable to add campaign template. Error %s", err.Error())
+	}
+	body := new(bytes.Buffer)
+	err = templates.getCampaignEmail(body, campaignEmail{"test@example.com", []appInfo{
+		{App: App{Name: "my-drupal-app"},
+			Space: Space{Name: "dev"},
+			Org:   Organization{Name: "sandbox"},
+		},
+		{App: App{Name: "my-wordpress-app"},
+			Space: Space{Name: "staging"},
+			Org:   Organization{Name: "paid-org"},
+		},
+	}, true, "php_buildpack", "cflinuxfs3"})
+	if err != nil {
+		t.Errorf("Can't construct final email. Error %s", err.Error())
+	}
+	compareWithExpectedEmail(t, "campaign", body, filepath.Join(rootDataPath, "example.txt"))
+}
+
+func TestGetStackEOLEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "stack_eol")
+	testCases := []struct {
+		name          string
+		email         stackEOLEmail
+		expectedEmail string
+	}{
+		{
+			"single app",
+			stackEOLEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+				Stack: "cflinuxfs3",
+			}}, false, "cflinuxfs4"},
+			filepath.Join(rootDataPath, "single_app.txt"),
+		},
+		{
+			"multiple apps",
+			stackEOLEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space: Space{Name: "dev"},
+					Org:   Organization{Name: "sandbox"},
+					Stack: "cflinuxfs3",
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space: Space{Name: "staging"},
+					Org:   Organization{Name: "paid-org"},
+					Stack: "cflinuxfs3",
+				},
+			}, true, "cflinuxfs4"},
+			filepath.Join(rootDataPath, "multiple_apps.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getStackEOLEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+func TestGetEscalationEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "escalation")
+	testCases := []struct {
+		name          string
+		email         escalationEmail
+		expectedEmail string
+	}{
+		{
+			"single app",
+			escalationEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space:         Space{Name: "dev"},
+				Org:           Organization{Name: "sandbox"},
+				Notifications: 3,
+			}}, false},
+			filepath.Join(rootDataPath, "single_app.txt"),
+		},
+		{
+			"multiple apps",
+			escalationEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space:         Space{Name: "dev"},
+					Org:           Organization{Name: "sandbox"},
+					Notifications: 3,
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space:         Space{Name: "staging"},
+					Org:           Organization{Name: "paid-org"},
+					Notifications: 5,
+				},
+			}, true},
+			filepath.Join(rootDataPath, "multiple_apps.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getEscalationEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+func TestGetRestagedEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "restaged")
+	testCases := []struct {
+		name          string
+		email         restagedEmail
+		expectedEmail string
+	}{
+		{
+			"single app",
+			restagedEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false},
+			filepath.Join(rootDataPath, "single_app.txt"),
+		},
+		{
+			"multiple apps",
+			restagedEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space: Space{Name: "dev"},
+					Org:   Organization{Name: "sandbox"},
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space: Space{Name: "staging"},
+					Org:   Organization{Name: "paid-org"},
+				},
+			}, true},
+			filepath.Join(rootDataPath, "multiple_apps.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getRestagedEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+func TestGetRestageWarningEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "restage_warning")
+	testCases := []struct {
+		name          string
+		email         restageWarningEmail
+		expectedEmail string
+	}{
+		{
+			"single app",
+			restageWarningEmail{"test@example.com", []appInfo{{App: App{Name: "my-drupal-app"},
+				Space: Space{Name: "dev"},
+				Org:   Organization{Name: "sandbox"},
+			}}, false, "February 8, 2020"},
+			filepath.Join(rootDataPath, "single_app.txt"),
+		},
+		{
+			"multiple apps",
+			restageWarningEmail{"test@example.com", []appInfo{
+				{App: App{Name: "my-drupal-app"},
+					Space: Space{Name: "dev"},
+					Org:   Organization{Name: "sandbox"},
+				},
+				{App: App{Name: "my-wordpress-app"},
+					Space: Space{Name: "staging"},
+					Org:   Organization{Name: "paid-org"},
+				},
+			}, true, "February 8, 2020"},
+			filepath.Join(rootDataPath, "multiple_apps.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getRestageWarningEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+func TestGetCanaryFailedEmail(t *testing.T) {
+	rootDataPath := filepath.Join("testdata", "mail", "canary_failed")
+	canary := appInfo{App: App{GUID: "app-guid-1", Name: "my-drupal-app"},
+		Space: Space{Name: "dev"},
+		Org:   Organization{Name: "sandbox"},
+	}
+	testCases := []struct {
+		name          string
+		email         canaryFailedEmail
+		expectedEmail string
+	}{
+		{
+			"no skipped apps",
+			canaryFailedEmail{canary, "Instance 1 crashed, rolled back to droplet droplet-guid-1", nil},
+			filepath.Join(rootDataPath, "no_skipped_apps.txt"),
+		},
+		{
+			"skipped apps",
+			canaryFailedEmail{canary, "Staging failed: BuildpackCompileFailed", []appInfo{
+				{App: App{GUID: "app-guid-2", Name: "my-wordpress-app"}},
+				{App: App{GUID: "app-guid-3", Name: "my-worker-app"}},
+			}},
+			filepath.Join(rootDataPath, "skipped_apps.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		templates, err := initTemplates()
+		if err != nil {
+			t.Fatalf("Unable to init templates. Error %s", err.Error())
+		}
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			err := templates.getCanaryFailedEmail(body, tc.email)
+			if err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
+
+func TestGetRestagePlanEmail(t *testing.T) {
+	templates, err := initTemplates()
+	if err != nil {
+		t.Fatalf("Unable to init templates. Error %s", err.Error())
+	}
+	email := restagePlanEmail{"0123456789abcdef", []appInfo{
+		{App: App{GUID: "app-guid-1", Name: "my-drupal-app"},
+			DropletGUID: "droplet-guid-1",
+			Space:       Space{Name: "dev"},
+			Org:         Organization{Name: "sandbox"},
+		},
+		{App: App{GUID: "app-guid-2", Name: "my-wordpress-app"},
+			DropletGUID: "droplet-guid-2",
+			Space:       Space{Name: "staging"},
+			Org:         Organization{Name: "paid-org"},
+		},
+	}}
+	body := new(bytes.Buffer)
+	if err := templates.getRestagePlanEmail(body, email); err != nil {
+		t.Errorf("Can't construct final email. Error %s", err.Error())
+	}
+	compareWithExpectedEmail(t, "restage plan", body, filepath.Join("testdata", "mail", "restage_plan", "multiple_apps.txt"))
+}
+
+func TestGetOperatorSummaryEmail(t *testing.T) {
+	templates, err := initTemplates()
+	if err != nil {
+		t.Fatalf("Unable to init templates. Error %s", err.Error())
+	}
+	rootDataPath := filepath.Join("testdata", "mail", "operator_summary")
+	testCases := []struct {
+		name          string
+		email         operatorSummaryEmail
+		expectedEmail string
+	}{
+		{
+			"follow ups",
+			operatorSummaryEmail{
+				RunID:         "0123456789abcdef",
+				Triggers:      []string{"nodejs_buildpack", "python_buildpack"},
+				OutdatedByOrg: []orgCount{{"paid-org", 3}, {"sandbox", 1}},
+				EmailsSent:    3,
+				EmailsFailed:  1,
+				DroppedUsers:  []string{"admin"},
+				FollowUps:     []string{"App system/tools/logs is in a system org, left to the operators", "Error: Unable to send e-mail to bob@example.com. Error: mailbox full"},
+			},
+			filepath.Join(rootDataPath, "follow_ups.txt"),
+		},
+		{
+			"quiet dry run",
+			operatorSummaryEmail{RunID: "0123456789abcdef", DryRun: true},
+			filepath.Join(rootDataPath, "quiet_dry_run.txt"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			if err := templates.getOperatorSummaryEmail(body, tc.email); err != nil {
+				t.Errorf("Can't construct final email. Error %s", err.Error())
+			}
+			compareWithExpectedEmail(t, tc.name, body, tc.expectedEmail)
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// otlpBatchSize is the most spans exported in a single request.
+const otlpBatchSize = 1000
+
+// tracer records the spans of a run: a span for the run, a span for each of
+// its phases, and spans for the work done on individual apps, spaces and
+// e-mails within the phase running at the time. The spans are exported over
+// OTLP once the run is over.
+type tracer struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	mu      sync.Mutex
+	traceID string
+	root    *span
+	phase   *span
+	ended   []*span
+}
+
+// runTracer traces the current run. It is nil when tracing is off, which
+// makes starting and ending spans do nothing.
+var runTracer *tracer
+
+// span is a unit of work of the run.
+type span struct {
+	tracer   *tracer
+	spanID   string
+	parentID string
+	name     string
+	fields   logFields
+	start    time.Time
+	end      time.Time
+	err      error
+}
+
+// newTracer returns the tracer exporting to the OTLP/HTTP traces endpoint,
+// sending headers, given as comma separated key=value pairs, with every
+// request.
+func newTracer(endpoint, headers, service string) (*tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.Errorf("Invalid OTLP endpoint %q", endpoint)
+	}
+	parsedHeaders := make(map[string]string)
+	for _, header := range strings.Split(headers, ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		parts := strings.SplitN(header, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errors.Errorf("Invalid OTLP header %q, expected key=value", header)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, errors.Errorf("Invalid OTLP header %q, expected key=value", header)
+		}
+		parsedHeaders[strings.TrimSpace(parts[0])] = value
+	}
+	return &tracer{
+		endpoint: endpoint,
+		headers:  parsedHeaders,
+		service:  service,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startRun starts the span of the whole run, which every other span is part
+// of.
+func (t *tracer) startRun(fields logFields) *span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.traceID = randomHex(16)
+	t.root = &span{tracer: t, spanID: randomHex(8), name: "run", fields: fields, start: time.Now()}
+	return t.root
+}
+
+// startPhase starts the span of a phase of the run. The spans started until
+// it ends are part of it.
+func startPhase(name string, fields logFields) *span {
+	t := runTracer
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phase = t.newSpanLocked(name, fields)
+	return t.phase
+}
+
+// startSpan starts the span of work on a single app, space or e-mail within
+// the phase running.
+func startSpan(name string, fields logFields) *span {
+	t := runTracer
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.newSpanLocked(name, fields)
+	if t.phase != nil {
+		s.parentID = t.phase.spanID
+	}
+	return s
+}
+
+// newSpanLocked starts a span in the run. t.mu must be held.
+func (t *tracer) newSpanLocked(name string, fields logFields) *span {
+	s := &span{tracer: t, spanID: randomHex(8), name: name, fields: fields, start: time.Now()}
+	if t.root != nil {
+		s.parentID = t.root.spanID
+	}
+	return s
+}
+
+// finish ends the span, failed with err if it isn't nil.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s.end, s.err = time.Now(), err
+	t.ended = append(t.ended, s)
+	if t.phase == s {
+		t.phase = nil
+	}
+}
+
+// The OTLP/HTTP JSON encoding of the spans, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpValue `json:"values"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+)
+
+func newOTLPValue(value interface{}) otlpValue {
+	switch v := value.(type) {
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case []string:
+		array := &otlpArrayValue{}
+		for _, item := range v {
+			array.Values = append(array.Values, newOTLPValue(item))
+		}
+		return otlpValue{ArrayValue: array}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func newOTLPAttributes(fields logFields) []otlpKeyValue {
+	var attributes []otlpKeyValue
+	for i := 0; i+1 < len(fields); i += 2 {
+		attributes = append(attributes, otlpKeyValue{Key: fmt.Sprint(fields[i]), Value: newOTLPValue(fields[i+1])})
+	}
+	return attributes
+}
+
+func (t *tracer) otlpSpan(s *span) otlpSpan {
+	encoded := otlpSpan{
+		TraceID:           t.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        newOTLPAttributes(s.fields),
+	}
+	if s.err != nil {
+		encoded.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+	}
+	return encoded
+}
+
+// export sends the spans ended so far to the OTLP endpoint, in batches of
+// otlpBatchSize.
+func (t *tracer) export() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := make([]otlpSpan, 0, len(t.ended))
+	for _, s := range t.ended {
+		spans = append(spans, t.otlpSpan(s))
+	}
+	t.ended = nil
+	t.mu.Unlock()
+	resource := otlpResource{Attributes: newOTLPAttributes(logFields{"service.name", t.service, "service.version", version})}
+	scope := otlpScope{Name: "buildpack-notify", Version: version}
+	for start := 0; start < len(spans); start += otlpBatchSize {
+		end := start + otlpBatchSize
+		if end > len(spans) {
+			end = len(spans)
+		}
+		traces := otlpTraces{ResourceSpans: []otlpResourceSpans{{
+			Resource:   resource,
+			ScopeSpans: []otlpScopeSpans{{Scope: scope, Spans: spans[start:end]}},
+		}}}
+		if err := t.post(traces); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tracer) post(traces otlpTraces) error {
+	body, err := json.Marshal(traces)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("OTLP endpoint responded with %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// tracedMailer records a span for every e-mail sent.
+type tracedMailer struct {
+	Mailer
+}
+
+func (m *tracedMailer) SendEmail(emailAddress, subject string, body []byte) error {
+	span := startSpan("send e-mail", logFields{"subject", subject})
+	err := m.Mailer.SendEmail(emailAddress, subject, body)
+	span.finish(err)
+	return err
+}
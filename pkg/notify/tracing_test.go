@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	var exported otlpTraces
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&exported)
+	}))
+	defer ts.Close()
+	endpoint := Config{OTLPEndpoint: ts.URL + "/"}.otlpTracesEndpoint()
+	tracer, err := newTracer(endpoint, "Authorization=Bearer%20token", "buildpack-notify")
+	if err != nil {
+		t.Fatalf("Unable to create tracer. Error: %s", err)
+	}
+	runTracer = tracer
+	defer func() { runTracer = nil }()
+
+	run := tracer.startRun(logFields{"run_id", "run1"})
+	phase := startPhase("find outdated apps", nil)
+	startSpan("fetch droplet", appFields(newTestApp("app1", "space1"))).finish(errors.New("droplet not found"))
+	phase.finish(nil)
+	startSpan("send e-mail", nil).finish(nil)
+	run.finish(nil)
+	if err := tracer.export(); err != nil {
+		t.Fatalf("Unable to export spans. Error: %s", err)
+	}
+
+	if authorization != "Bearer token" {
+		t.Errorf("Expected the configured headers to be sent, found %q", authorization)
+	}
+	if len(exported.ResourceSpans) != 1 || len(exported.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected a single batch of spans, found %+v", exported)
+	}
+	spans := make(map[string]otlpSpan)
+	for _, span := range exported.ResourceSpans[0].ScopeSpans[0].Spans {
+		if span.TraceID != tracer.traceID || len(span.TraceID) != 32 {
+			t.Errorf("Expected span %s to be part of trace %s, found %s", span.Name, tracer.traceID, span.TraceID)
+		}
+		spans[span.Name] = span
+	}
+	if len(spans) != 4 {
+		t.Fatalf("Expected 4 spans, found %+v", spans)
+	}
+	root, phaseSpan, droplet, email := spans["run"], spans["find outdated apps"], spans["fetch droplet"], spans["send e-mail"]
+	if root.ParentSpanID != "" || phaseSpan.ParentSpanID != root.SpanID || droplet.ParentSpanID != phaseSpan.SpanID || email.ParentSpanID != root.SpanID {
+		t.Errorf("Expected the droplet to be part of the phase and the rest part of the run, found %+v", spans)
+	}
+	if droplet.Status.Code != otlpStatusError || droplet.Status.Message != "droplet not found" {
+		t.Errorf("Expected the droplet span to have failed, found %+v", droplet.Status)
+	}
+	if len(droplet.Attributes) == 0 || droplet.Attributes[0].Key != "app_guid" || *droplet.Attributes[0].Value.StringValue != "app1" {
+		t.Errorf("Expected the droplet span to carry the app GUID, found %+v", droplet.Attributes)
+	}
+
+	// Spans do nothing when tracing is off.
+	runTracer = nil
+	startPhase("list apps", nil).finish(nil)
+	if _, err := newTracer("collector:4318", "", "buildpack-notify"); err == nil {
+		t.Errorf("Expected an endpoint without a scheme to be invalid")
+	}
+}
@@ -0,0 +1,456 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// transportOptions controls how the CF API client connects. The zero value
+// has no timeouts and no connection limits.
+type transportOptions struct {
+	// Timeout limits each of connecting, the TLS handshake and waiting for
+	// the response headers of a single attempt, and newDeadlineTransport
+	// limits the whole attempt, reading the response included, to it.
+	Timeout time.Duration
+	// MaxIdleConns is how many connections are kept open between requests.
+	MaxIdleConns int
+	// MaxConns limits the connections open at once. Zero means no limit.
+	MaxConns int
+	// Insecure skips verifying the TLS certificate of the API.
+	Insecure bool
+	// Certificates are presented to the API when it asks for a client
+	// certificate.
+	Certificates []tls.Certificate
+}
+
+// newCFTransport creates the base transport used for every CF API request.
+// Timeouts are applied per attempt here rather than on the http.Client so
+// that time spent waiting out a rate limit doesn't count against them.
+func newCFTransport(opts transportOptions) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   opts.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: opts.Insecure, Certificates: opts.Certificates},
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		// Every request goes to the same API, so idle connections are
+		// limited per host rather than in total.
+		MaxIdleConns:        opts.MaxIdleConns,
+		MaxIdleConnsPerHost: opts.MaxIdleConns,
+		MaxConnsPerHost:     opts.MaxConns,
+	}
+}
+
+// rateLimitTransport is a http.RoundTripper that honors the CF API rate
+// limiting. When a request is rejected with 429 it waits as long as the
+// response asks (via Retry-After or X-RateLimit-Reset) and retries. When a
+// response reports no remaining requests, the next request waits for the
+// limit to reset before going out. A wait longer than maxWait fails the
+// request instead, so that a bogus header can't hold up a run indefinitely.
+type rateLimitTransport struct {
+	base        http.RoundTripper
+	maxRetries  int
+	maxWait     time.Duration
+	defaultWait time.Duration
+	sleep       func(ctx context.Context, d time.Duration) error
+	now         func() time.Time
+	logger      *Logger
+
+	mu          sync.Mutex
+	resetAt     time.Time
+	waits       int
+	waitedTotal time.Duration
+	// requests counts the requests sent to the API, including retries.
+	requests int
+}
+
+// newRateLimitTransport returns a rateLimitTransport retrying rate limited
+// requests up to maxRetries times. A zero maxWait doesn't limit the waits.
+func newRateLimitTransport(base http.RoundTripper, maxRetries int, maxWait time.Duration, logger *Logger) *rateLimitTransport {
+	return &rateLimitTransport{
+		base:        base,
+		maxRetries:  maxRetries,
+		maxWait:     maxWait,
+		defaultWait: 10 * time.Second,
+		sleep:       sleepContext,
+		now:         time.Now,
+		logger:      logger,
+	}
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever is first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.waitForReset(req.Context()); err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.requests++
+		t.mu.Unlock()
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		t.recordRemaining(resp)
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= t.maxRetries {
+			return resp, nil
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// We can't replay the body so hand the 429 back to the caller.
+			return resp, nil
+		}
+		wait := t.waitFor(resp)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if t.maxWait > 0 && wait > t.maxWait {
+			return nil, &rateLimitWaitError{method: req.Method, path: req.URL.Path, wait: wait, maxWait: t.maxWait}
+		}
+		t.logger.warnf("Rate limited by the CF API on %s %s, retrying in %s\n", req.Method, req.URL.Path, wait)
+		if err := t.throttle(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// waitForReset blocks until the rate limit resets if a previous response
+// said we had no requests remaining.
+func (t *rateLimitTransport) waitForReset(ctx context.Context) error {
+	t.mu.Lock()
+	wait := t.resetAt.Sub(t.now())
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if t.maxWait > 0 && wait > t.maxWait {
+		return &rateLimitWaitError{wait: wait, maxWait: t.maxWait}
+	}
+	t.logger.warnf("CF API rate limit exhausted, waiting %s for it to reset\n", wait)
+	return t.throttle(ctx, wait)
+}
+
+// recordRemaining remembers when the rate limit resets if resp reports that
+// there are no requests remaining.
+func (t *rateLimitTransport) recordRemaining(resp *http.Response) {
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	reset, ok := parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset"))
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.resetAt = reset
+	t.mu.Unlock()
+}
+
+// waitFor works out how long to wait before retrying a rate limited request.
+func (t *rateLimitTransport) waitFor(resp *http.Response) time.Duration {
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return clampWait(date.Sub(t.now()))
+		}
+	}
+	if reset, ok := parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset")); ok {
+		return clampWait(reset.Sub(t.now()))
+	}
+	return t.defaultWait
+}
+
+// rateLimitWaitError is a request failed because the CF API asked to wait
+// longer than the rate limiting allows.
+type rateLimitWaitError struct {
+	method, path  string
+	wait, maxWait time.Duration
+}
+
+func (e *rateLimitWaitError) Error() string {
+	if e.path == "" {
+		return fmt.Sprintf("CF API rate limit resets in %s, longer than the %s allowed", e.wait, e.maxWait)
+	}
+	return fmt.Sprintf("Rate limited by the CF API on %s %s for %s, longer than the %s allowed", e.method, e.path, e.wait, e.maxWait)
+}
+
+func (t *rateLimitTransport) throttle(ctx context.Context, wait time.Duration) error {
+	t.mu.Lock()
+	t.waits++
+	t.waitedTotal += wait
+	t.mu.Unlock()
+	return t.sleep(ctx, wait)
+}
+
+// stats returns how many times and for how long in total requests were held
+// back because of rate limiting.
+func (t *rateLimitTransport) stats() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.waits, t.waitedTotal
+}
+
+// requestCount returns how many requests were sent to the API.
+func (t *rateLimitTransport) requestCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests
+}
+
+// parseRateLimitReset parses the X-RateLimit-Reset header which holds the
+// time the limit resets as seconds since the epoch.
+func parseRateLimitReset(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+func clampWait(wait time.Duration) time.Duration {
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// retryTransport is a http.RoundTripper that retries idempotent requests
+// which fail with a network error or a server error (5xx), backing off
+// exponentially between attempts. Anything else is handed back as is.
+type retryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	backoff     time.Duration
+	sleep       func(ctx context.Context, d time.Duration) error
+	logger      *Logger
+}
+
+func newRetryTransport(base http.RoundTripper, maxAttempts int, backoff time.Duration, logger *Logger) *retryTransport {
+	return &retryTransport{
+		base:        base,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		sleep:       sleepContext,
+		logger:      logger,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+	wait := t.backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.maxAttempts || !isTransient(req.Context(), resp, err) {
+			return resp, err
+		}
+		if err != nil {
+			t.logger.warnf("Attempt %d of %d for %s %s failed, retrying in %s. Error: %s\n",
+				attempt, t.maxAttempts, req.Method, req.URL.Path, wait, err)
+		} else {
+			t.logger.warnf("Attempt %d of %d for %s %s returned %s, retrying in %s\n",
+				attempt, t.maxAttempts, req.Method, req.URL.Path, resp.Status, wait)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+		wait *= 2
+	}
+}
+
+// isTransient reports whether a failed attempt is worth retrying: either the
+// request never got a response, or the server answered with a 5xx.
+func isTransient(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		// The caller gave up, retrying won't help.
+		return false
+	}
+	if _, ok := err.(*rateLimitWaitError); ok {
+		// Retrying would only be rate limited again.
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// deadlineTransport is a http.RoundTripper that gives every attempt at a
+// request timeout to complete, reading the response body included, so that
+// an API that stalls part way through a response fails the attempt rather
+// than hanging the run. It goes below the rate limiting and retries, so that
+// neither waiting out a rate limit nor backing off counts against it, and an
+// attempt that runs out of time is retried like any other failed attempt.
+type deadlineTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+// newDeadlineTransport returns a deadlineTransport. A zero timeout doesn't
+// limit the attempts.
+func newDeadlineTransport(base http.RoundTripper, timeout time.Duration) http.RoundTripper {
+	if timeout <= 0 {
+		return base
+	}
+	return &deadlineTransport{base: base, timeout: timeout}
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose is a response body that releases the deadline of its attempt
+// once it is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// tokenExpiryMargin is how long before it expires a token is replaced, which
+// leaves time for the request carrying it to reach the API.
+const tokenExpiryMargin = time.Minute
+
+// tokenTransport is a http.RoundTripper that authenticates CF API requests
+// with a bearer token. Tokens are replaced shortly before they expire, and a
+// request rejected with 401 is retried once with a new token, so that runs
+// outlasting the token lifetime don't fail part way through. It goes below
+// the rate limiting and retries so that every attempt, however long after the
+// first one, carries a current token.
+type tokenTransport struct {
+	base   http.RoundTripper
+	fetch  func(ctx context.Context) (*oauth2.Token, error)
+	now    func() time.Time
+	logger *Logger
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+func newTokenTransport(base http.RoundTripper, fetch func(ctx context.Context) (*oauth2.Token, error), logger *Logger) *tokenTransport {
+	return &tokenTransport{
+		base:   base,
+		fetch:  fetch,
+		now:    time.Now,
+		logger: logger,
+	}
+}
+
+// clientCredentialsTokens fetches a new client credentials token from the
+// UAA token endpoint every time it's called.
+func clientCredentialsTokens(tokenEndpoint, clientID, clientSecret string, httpClient *http.Client) func(ctx context.Context) (*oauth2.Token, error) {
+	credentials := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     tokenEndpoint + "/oauth/token",
+	}
+	return func(ctx context.Context) (*oauth2.Token, error) {
+		return credentials.Token(context.WithValue(ctx, oauth2.HTTPClient, httpClient))
+	}
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.getToken(req.Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// We can't replay the body so hand the 401 back to the caller.
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	t.logger.warnf("CF API rejected the token on %s %s, retrying with a new token\n", req.Method, req.URL.Path)
+	token, err = t.getToken(req.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	retry := withToken(req, token)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// getToken returns a token that isn't about to expire. A rejected token is
+// replaced even if it hasn't expired, unless another request already did so.
+func (t *tokenTransport) getToken(ctx context.Context, rejected *oauth2.Token) (*oauth2.Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != nil && t.token != rejected && (t.token.Expiry.IsZero() || t.now().Add(tokenExpiryMargin).Before(t.token.Expiry)) {
+		return t.token, nil
+	}
+	token, err := t.fetch(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get a CF API token")
+	}
+	t.token = token
+	return token, nil
+}
+
+// withToken returns a copy of req authenticated with token, since a
+// http.RoundTripper must not modify the request it's given.
+func withToken(req *http.Request, token *oauth2.Token) *http.Request {
+	authenticated := req.Clone(req.Context())
+	token.SetAuthHeader(authenticated)
+	return authenticated
+}
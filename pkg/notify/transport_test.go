@@ -0,0 +1,464 @@
+package notify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	now := time.Unix(1000, 0)
+	testCases := []struct {
+		name             string
+		maxRetries       int
+		responses        []func(w http.ResponseWriter)
+		expectedStatus   int
+		expectedRequests int
+		expectedWaits    []time.Duration
+	}{
+		{
+			"not rate limited",
+			5,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			http.StatusOK, 1, nil,
+		},
+		{
+			"retry after seconds",
+			5,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("Retry-After", "3")
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			http.StatusOK, 2, []time.Duration{3 * time.Second},
+		},
+		{
+			"rate limit reset header",
+			5,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+					w.WriteHeader(http.StatusTooManyRequests)
+				},
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			http.StatusOK, 2, []time.Duration{time.Minute},
+		},
+		{
+			"no hints falls back to default wait",
+			5,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			http.StatusOK, 2, []time.Duration{10 * time.Second},
+		},
+		{
+			"gives up after max retries",
+			1,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+			},
+			http.StatusTooManyRequests, 2, []time.Duration{10 * time.Second},
+		},
+		{
+			"no remaining requests waits for reset before next request",
+			5,
+			[]func(w http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+					w.WriteHeader(http.StatusOK)
+				},
+				func(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) },
+			},
+			http.StatusOK, 2, []time.Duration{30 * time.Second},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.responses[requests](w)
+				requests++
+			}))
+			defer ts.Close()
+			var waits []time.Duration
+			transport := newRateLimitTransport(http.DefaultTransport, tc.maxRetries, 0, nil)
+			transport.now = func() time.Time { return now }
+			transport.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			client := &http.Client{Transport: transport}
+			var resp *http.Response
+			var err error
+			for requests < len(tc.responses) {
+				resp, err = client.Get(ts.URL)
+				if err != nil {
+					t.Fatalf("Test %s failed. Unexpected error %s", tc.name, err)
+				}
+				resp.Body.Close()
+			}
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("Test %s failed. Expected status %d, found %d", tc.name, tc.expectedStatus, resp.StatusCode)
+			}
+			if requests != tc.expectedRequests {
+				t.Errorf("Test %s failed. Expected %d requests, found %d", tc.name, tc.expectedRequests, requests)
+			}
+			if len(waits) != len(tc.expectedWaits) {
+				t.Fatalf("Test %s failed. Expected waits %v, found %v", tc.name, tc.expectedWaits, waits)
+			}
+			var expectedTotal time.Duration
+			for i := range waits {
+				if waits[i] != tc.expectedWaits[i] {
+					t.Errorf("Test %s failed. Expected waits %v, found %v", tc.name, tc.expectedWaits, waits)
+				}
+				expectedTotal += tc.expectedWaits[i]
+			}
+			if count, total := transport.stats(); count != len(tc.expectedWaits) || total != expectedTotal {
+				t.Errorf("Test %s failed. Expected stats %d/%s, found %d/%s", tc.name, len(tc.expectedWaits), expectedTotal, count, total)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransportMaxWait(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	testCases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"retry after too long", map[string]string{"Retry-After": "3600"}, http.StatusTooManyRequests},
+		{"reset too far", map[string]string{"X-RateLimit-Reset": strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}, http.StatusTooManyRequests},
+		{"exhausted until too far", map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tc.headers {
+					w.Header().Set(key, value)
+				}
+				w.WriteHeader(tc.status)
+				requests++
+			}))
+			defer ts.Close()
+			var waits []time.Duration
+			transport := newRateLimitTransport(http.DefaultTransport, 5, 5*time.Minute, nil)
+			transport.now = func() time.Time { return now }
+			transport.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			// The retries don't try again a request failed by the wait.
+			client := &http.Client{Transport: newRetryTransport(transport, 3, time.Second, nil)}
+			var err error
+			for attempt := 0; attempt < 2 && err == nil; attempt++ {
+				var resp *http.Response
+				if resp, err = client.Get(ts.URL); err == nil {
+					resp.Body.Close()
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), "longer than the 5m0s allowed") {
+				t.Errorf("Test %s failed. Expected the wait to fail the request, found %v", tc.name, err)
+			}
+			if len(waits) != 0 || requests != 1 {
+				t.Errorf("Test %s failed. Expected a single request and no waits, found %d requests and waits %v", tc.name, requests, waits)
+			}
+		})
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	testCases := []struct {
+		name             string
+		method           string
+		maxAttempts      int
+		statuses         []int
+		expectedStatus   int
+		expectedRequests int
+		expectedWaits    []time.Duration
+	}{
+		{"success", http.MethodGet, 3, []int{200}, 200, 1, nil},
+		{"client error not retried", http.MethodGet, 3, []int{404}, 404, 1, nil},
+		{"server error then success", http.MethodGet, 3, []int{503, 502, 200}, 200, 3, []time.Duration{time.Second, 2 * time.Second}},
+		{"gives up after max attempts", http.MethodGet, 2, []int{500, 500}, 500, 2, []time.Duration{time.Second}},
+		{"non idempotent not retried", http.MethodPost, 3, []int{503}, 503, 1, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.statuses[requests])
+				requests++
+			}))
+			defer ts.Close()
+			var waits []time.Duration
+			transport := newRetryTransport(http.DefaultTransport, tc.maxAttempts, time.Second, nil)
+			transport.sleep = func(ctx context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+			req, _ := http.NewRequest(tc.method, ts.URL, nil)
+			resp, err := (&http.Client{Transport: transport}).Do(req)
+			if err != nil {
+				t.Fatalf("Test %s failed. Unexpected error %s", tc.name, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("Test %s failed. Expected status %d, found %d", tc.name, tc.expectedStatus, resp.StatusCode)
+			}
+			if requests != tc.expectedRequests {
+				t.Errorf("Test %s failed. Expected %d requests, found %d", tc.name, tc.expectedRequests, requests)
+			}
+			if len(waits) != len(tc.expectedWaits) {
+				t.Fatalf("Test %s failed. Expected waits %v, found %v", tc.name, tc.expectedWaits, waits)
+			}
+			for i := range waits {
+				if waits[i] != tc.expectedWaits[i] {
+					t.Errorf("Test %s failed. Expected waits %v, found %v", tc.name, tc.expectedWaits, waits)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportRetriesNetworkErrors(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Drop the connection without responding.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	transport := newRetryTransport(&http.Transport{DisableKeepAlives: true}, 3, time.Second, nil)
+	transport.sleep = func(ctx context.Context, d time.Duration) error { return nil }
+	resp, err := (&http.Client{Transport: transport}).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests != 2 {
+		t.Errorf("Expected a successful second attempt, found status %d after %d requests", resp.StatusCode, requests)
+	}
+}
+
+func TestTokenTransport(t *testing.T) {
+	now := time.Unix(1000, 0)
+	testCases := []struct {
+		name             string
+		tokens           []*oauth2.Token
+		validTokens      map[string]bool
+		requests         int
+		expectedStatus   int
+		expectedFetches  int
+		expectedRequests int
+	}{
+		{
+			"token reused",
+			[]*oauth2.Token{{AccessToken: "token1", Expiry: now.Add(time.Hour)}},
+			map[string]bool{"token1": true}, 2, http.StatusOK, 1, 2,
+		},
+		{
+			"token about to expire is replaced",
+			[]*oauth2.Token{{AccessToken: "token1", Expiry: now.Add(90 * time.Second)}, {AccessToken: "token2", Expiry: now.Add(time.Hour)}},
+			map[string]bool{"token1": true, "token2": true}, 2, http.StatusOK, 2, 2,
+		},
+		{
+			"rejected token is replaced and the request retried",
+			[]*oauth2.Token{{AccessToken: "token1", Expiry: now.Add(time.Hour)}, {AccessToken: "token2", Expiry: now.Add(time.Hour)}},
+			map[string]bool{"token2": true}, 1, http.StatusOK, 2, 2,
+		},
+		{
+			"retried once",
+			[]*oauth2.Token{{AccessToken: "token1", Expiry: now.Add(time.Hour)}, {AccessToken: "token2", Expiry: now.Add(time.Hour)}},
+			map[string]bool{}, 1, http.StatusUnauthorized, 2, 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests, fetches := 0, 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if !tc.validTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+			transport := newTokenTransport(http.DefaultTransport, func(ctx context.Context) (*oauth2.Token, error) {
+				token := tc.tokens[fetches]
+				fetches++
+				return token, nil
+			}, nil)
+			clock := now
+			transport.now = func() time.Time { return clock }
+			var resp *http.Response
+			for i := 0; i < tc.requests; i++ {
+				var err error
+				resp, err = (&http.Client{Transport: transport}).Get(ts.URL)
+				if err != nil {
+					t.Fatalf("Test %s failed. Unexpected error %s", tc.name, err)
+				}
+				resp.Body.Close()
+				clock = clock.Add(time.Minute)
+			}
+			if resp.StatusCode != tc.expectedStatus {
+				t.Errorf("Test %s failed. Expected status %d, found %d", tc.name, tc.expectedStatus, resp.StatusCode)
+			}
+			if fetches != tc.expectedFetches || requests != tc.expectedRequests {
+				t.Errorf("Test %s failed. Expected %d token fetches and %d requests, found %d and %d",
+					tc.name, tc.expectedFetches, tc.expectedRequests, fetches, requests)
+			}
+		})
+	}
+}
+
+func TestCFTransportTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	client := &http.Client{Transport: newCFTransport(transportOptions{Timeout: 50 * time.Millisecond})}
+	if resp, err := client.Get(ts.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected a slow response to time out")
+	}
+	client = &http.Client{Transport: newCFTransport(transportOptions{Timeout: time.Second})}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	resp.Body.Close()
+}
+
+func TestDeadlineTransportCoversBody(t *testing.T) {
+	stalled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The headers and part of the body go out, then the API stalls.
+		w.Write([]byte(`{"resources": [`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stalled:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(stalled)
+	client := &http.Client{Transport: newDeadlineTransport(newCFTransport(transportOptions{Timeout: 50 * time.Millisecond}), 50*time.Millisecond)}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Expected the headers to arrive in time, found %s", err)
+	}
+	defer resp.Body.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected reading a stalled body to time out")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected reading a stalled body to time out, found it still reading")
+	}
+}
+
+// newTestClientCertificate generates a self-signed client certificate and
+// key, PEM encoded.
+func newTestClientCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key. Error %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "buildpack-notify"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate. Error %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal key. Error %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestCFTransportClientCertificate(t *testing.T) {
+	cert, key := newTestClientCertificate(t)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) != 1 || r.TLS.PeerCertificates[0].Subject.CommonName != "buildpack-notify" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	testCases := []struct {
+		name          string
+		config        CFAPIConfig
+		expectedError bool
+		expectedOK    bool
+	}{
+		{"no client certificate", CFAPIConfig{}, false, false},
+		{"client certificate", CFAPIConfig{ClientCert: cert, ClientKey: key}, false, true},
+		{"certificate without key", CFAPIConfig{ClientCert: cert}, true, false},
+		{"invalid certificate", CFAPIConfig{ClientCert: "not a certificate", ClientKey: key}, true, false},
+	}
+	for _, tc := range testCases {
+		opts, err := tc.config.transportOptions(true)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test %s failed. Unexpected error %v", tc.name, err)
+		}
+		if err != nil {
+			continue
+		}
+		resp, err := (&http.Client{Transport: newCFTransport(opts)}).Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if ok := err == nil && resp.StatusCode == http.StatusOK; ok != tc.expectedOK {
+			t.Errorf("Test %s failed. Expected success %v, found %v", tc.name, tc.expectedOK, err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+	"github.com/pkg/errors"
+)
+
+// UAAUser represents the parts of a UAA SCIM user we need. The ID of a UAA
+// user is the GUID of the matching CF user.
+// https://docs.cloudfoundry.org/api/uaa/version/74.4.0/index.html#get-3
+type UAAUser struct {
+	ID       string `json:"id"`
+	UserName string `json:"userName"`
+	Verified bool   `json:"verified"`
+	Emails   []struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+}
+
+// verifiedEmail returns the primary e-mail address of the user, or their
+// first one if none is marked primary, as long as the user has verified it.
+func (u UAAUser) verifiedEmail() (string, bool) {
+	if !u.Verified || len(u.Emails) == 0 {
+		return "", false
+	}
+	email := u.Emails[0].Value
+	for _, candidate := range u.Emails {
+		if candidate.Primary {
+			email = candidate.Value
+			break
+		}
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		return "", false
+	}
+	return email, true
+}
+
+// GetUAAUser fetches a user from the UAA the CF API authenticates against.
+// The client needs the scim.read authority.
+func GetUAAUser(c *cfclient.Client, userGUID string) (UAAUser, error) {
+	requestURL := c.Endpoint.TokenEndpoint + "/Users/" + url.PathEscape(userGUID)
+	resp, err := c.Config.HttpClient.Get(requestURL)
+	if err != nil {
+		return UAAUser{}, errors.Wrapf(err, "Error requesting UAA user %s", userGUID)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return UAAUser{}, fmt.Errorf("Error requesting UAA user %s: %s", userGUID, resp.Status)
+	}
+	var user UAAUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return UAAUser{}, errors.Wrapf(err, "Error unmarshalling UAA user %s", userGUID)
+	}
+	return user, nil
+}
+
+// uaaEmailCache looks up the e-mail address of users in UAA, remembering
+// every answer so that users with roles in many spaces are only looked up
+// once.
+type uaaEmailCache struct {
+	client *cfclient.Client
+	errs   *runErrors
+	logger *Logger
+	emails map[string]string
+}
+
+func newUAAEmailCache(client *cfclient.Client, errs *runErrors, logger *Logger) *uaaEmailCache {
+	return &uaaEmailCache{client: client, errs: errs, logger: logger, emails: make(map[string]string)}
+}
+
+// getEmail returns the verified e-mail address of the user, if they have one.
+func (c *uaaEmailCache) getEmail(user User) (string, bool) {
+	if email, found := c.emails[user.GUID]; found {
+		return email, email != ""
+	}
+	// Users that can't be looked up are remembered as having no address so
+	// that the error is only reported once.
+	c.emails[user.GUID] = ""
+	uaaUser, err := GetUAAUser(c.client, user.GUID)
+	if err != nil {
+		c.errs.addf("Unable to look up the e-mail address of user %s. Error: %s", user.Username, err)
+		return "", false
+	}
+	email, found := uaaUser.verifiedEmail()
+	if !found {
+		c.logger.with(logFields{"user_guid", user.GUID}).warnf("User %s has no verified e-mail address in UAA\n", user.Username)
+		return "", false
+	}
+	c.logger.with(logFields{"user_guid", user.GUID}).debugf("Resolved user %s to e-mail address %s via UAA\n", user.Username, email)
+	c.emails[user.GUID] = email
+	return email, true
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient"
+)
+
+func newTestUAAUser(guid, email string, verified bool) UAAUser {
+	user := UAAUser{ID: guid, UserName: guid, Verified: verified}
+	user.Emails = append(user.Emails, struct {
+		Value   string `json:"value"`
+		Primary bool   `json:"primary"`
+	}{email, true})
+	return user
+}
+
+func TestFilterForValidEmailUsernamesViaUAA(t *testing.T) {
+	uaaUsers := map[string]UAAUser{
+		"sso-guid":        newTestUAAUser("sso-guid", "sso@example.gov", true),
+		"unverified-guid": newTestUAAUser("unverified-guid", "unverified@example.gov", false),
+	}
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		user, found := uaaUsers[strings.TrimPrefix(r.URL.Path, "/Users/")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(user)
+	}))
+	defer ts.Close()
+	c := cfclient.Client{
+		Config:   cfclient.Config{HttpClient: http.DefaultClient, ApiAddress: ts.URL},
+		Endpoint: cfclient.Endpoint{TokenEndpoint: ts.URL},
+	}
+	users := []spaceUser{
+		{User{GUID: user1GUID, Username: user1}, []string{"space_developer"}},
+		{User{GUID: "sso-guid", Username: "jdoe"}, []string{"space_developer"}},
+		{User{GUID: "unverified-guid", Username: "jsmith"}, []string{"space_developer"}},
+		{User{GUID: "missing-guid", Username: "ghost"}, []string{"space_developer"}},
+	}
+	app := appInfo{App: App{Name: "app1"}}
+
+	if filtered := filterForValidEmailUsernames(users, app, nil, nil, nil); len(filtered) != 1 {
+		t.Errorf("Expected only %s without UAA lookups, found %+v", user1, filtered)
+	}
+
+	errs := &runErrors{}
+	emails := newUAAEmailCache(&c, errs, nil)
+	for i := 0; i < 2; i++ {
+		filtered := filterForValidEmailUsernames(users, app, emails, nil, nil)
+		if len(filtered) != 2 || filtered[0].Username != user1 || filtered[1].Username != "sso@example.gov" {
+			t.Errorf("Expected %s and the UAA address of jdoe, found %+v", user1, filtered)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("Expected each user to be looked up once, found %d requests", requests)
+	}
+	if errs.count() != 1 {
+		t.Errorf("Expected the missing user to be reported once, found %d errors", errs.count())
+	}
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateConfig returns every problem with config and cfAPIConfig, parsed
+// from the environment, without connecting to anything.
+func ValidateConfig(config Config, cfAPIConfig CFAPIConfig) []error {
+	_, problems := config.settings(cfAPIConfig, os.Getenv("INSECURE") == "1")
+	templates, err := initTemplates()
+	if err != nil {
+		return append(problems, errors.Wrap(err, "Unable to initialize templates"))
+	}
+	if config.CampaignTemplate != "" {
+		if err := templates.addTemplate(campaignTemplate, config.CampaignTemplate); err != nil {
+			problems = append(problems, errors.Wrap(err, "Unable to initialize campaign template"))
+		}
+	}
+	return problems
+}
+
+// CheckConnections returns every problem reaching the CF API, the SMTP server
+// and the state files with a valid configuration.
+func CheckConnections(config Config, emailConfig EmailConfig, cfAPIConfig CFAPIConfig) []error {
+	var problems []error
+	insecure := os.Getenv("INSECURE") == "1"
+	settings, _ := config.settings(cfAPIConfig, insecure)
+	client, _, err := newCFClient(cfAPIConfig, settings.transport, insecure, config.Logger)
+	if err == nil {
+		// Listing anything needs a token, so this checks the credentials too.
+		_, err = ListBuildpacks(client, ListOptions{PerPage: 1, MaxPages: 1})
+	}
+	if err != nil {
+		problems = append(problems, errors.Wrap(err, "Unable to reach the CF API"))
+	}
+	mailer, err := InitSMTPMailer(emailConfig)
+	if err == nil {
+		if checker, ok := mailer.(connectionChecker); ok {
+			err = checker.checkConnection()
+		}
+	}
+	if err != nil {
+		problems = append(problems, errors.Wrap(err, "Unable to reach the SMTP server"))
+	}
+	return append(problems, checkStateFiles(config.InState, config.OutState)...)
+}
+
+// checkStateFiles returns the problems reading the state at inPath and
+// writing it next to outPath.
+func checkStateFiles(inPath, outPath string) []error {
+	var problems []error
+	if _, err := loadState(inPath); err != nil {
+		problems = append(problems, errors.Wrap(err, "Unable to read IN_STATE"))
+	}
+	f, err := ioutil.TempFile(filepath.Dir(outPath), ".buildpack-notify-check-")
+	if err != nil {
+		return append(problems, errors.Wrap(err, "Unable to write OUT_STATE"))
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return problems
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// The build metadata, injected at build time with e.g.
+// -ldflags "-X github.com/cloud-gov/buildpack-notify/pkg/notify.version=v1.2.0 -X github.com/cloud-gov/buildpack-notify/pkg/notify.gitSHA=$(git rev-parse HEAD)".
+var (
+	version   = "dev"
+	gitSHA    = ""
+	buildDate = ""
+	// testedCAPIVersions are the CF API versions the build was tested
+	// against, separated by commas.
+	testedCAPIVersions = "3.76.0,3.102.0,3.166.0"
+)
+
+// buildInfo is the build metadata of the running binary.
+type buildInfo struct {
+	Version            string `json:"version"`
+	GitSHA             string `json:"git_sha"`
+	BuildDate          string `json:"build_date"`
+	TestedCAPIVersions string `json:"tested_capi_versions"`
+}
+
+// currentBuild returns the build metadata, falling back to the commit and
+// time recorded by go build when they weren't injected.
+func currentBuild() buildInfo {
+	info := buildInfo{Version: version, GitSHA: gitSHA, BuildDate: buildDate, TestedCAPIVersions: testedCAPIVersions}
+	if goBuild, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range goBuild.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.GitSHA == "":
+				info.GitSHA = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// String describes the build on a single line, for the run logs.
+func (b buildInfo) String() string {
+	return fmt.Sprintf("buildpack-notify %s (commit %s, built %s, tested against CAPI %s)", b.Version, orUnknown(b.GitSHA), orUnknown(b.BuildDate), orUnknown(b.TestedCAPIVersions))
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func writeVersion(w io.Writer, b buildInfo) {
+	fmt.Fprintf(w, "Version: %s\n", b.Version)
+	fmt.Fprintf(w, "Git SHA: %s\n", orUnknown(b.GitSHA))
+	fmt.Fprintf(w, "Build date: %s\n", orUnknown(b.BuildDate))
+	fmt.Fprintf(w, "Tested CAPI versions: %s\n", orUnknown(b.TestedCAPIVersions))
+}
@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronField is the set of values a field of a cron expression matches, as a
+// bit per value.
+type cronField uint64
+
+func (f cronField) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+// cronSchedule is a cron expression of five fields: minute, hour, day of
+// month, month and day of week. It matches every minute its fields do. As
+// with cron, when both the day of month and the day of week are restricted a
+// day matches if either of them does.
+type cronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	anyDayOfMonth, anyDayOfWeek                bool
+}
+
+// parseCronSchedule parses a cron expression such as "* 2-4 * * 6", which
+// matches every minute from 2:00 to 4:59 on Saturdays. Fields can be *, a
+// number, a range, a list, and any of those followed by a /step.
+func parseCronSchedule(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSchedule{}, fmt.Errorf("cron expression %q has %d fields instead of 5", expr, len(fields))
+	}
+	var schedule cronSchedule
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid minutes in %q: %s", expr, err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid hours in %q: %s", expr, err)
+	}
+	if schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid days of month in %q: %s", expr, err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid months in %q: %s", expr, err)
+	}
+	// Sunday is both 0 and 7.
+	if schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronSchedule{}, fmt.Errorf("invalid days of week in %q: %s", expr, err)
+	}
+	if schedule.dayOfWeek.has(7) {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.anyDayOfMonth = fields[2] == "*"
+	schedule.anyDayOfWeek = fields[4] == "*"
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int) (cronField, error) {
+	var values cronField
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				// A single value with a step, e.g. 5/15, runs to the end.
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of the range %d-%d", rangePart, min, max)
+		}
+		for value := low; value <= high; value += step {
+			values |= 1 << uint(value)
+		}
+	}
+	return values, nil
+}
+
+// matches reports whether the schedule matches the minute t is in.
+func (s cronSchedule) matches(t time.Time) bool {
+	if !s.minute.has(t.Minute()) || !s.hour.has(t.Hour()) || !s.month.has(int(t.Month())) {
+		return false
+	}
+	dayOfMonth, dayOfWeek := s.dayOfMonth.has(t.Day()), s.dayOfWeek.has(int(t.Weekday()))
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return dayOfWeek
+	case s.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// restageWindows are the maintenance windows apps may be restaged in, as
+// cron expressions matching every minute of the windows in location.
+type restageWindows struct {
+	schedules []cronSchedule
+	location  *time.Location
+}
+
+// parseRestageWindows parses cron expressions separated by semicolons, since
+// the expressions themselves can contain commas.
+func parseRestageWindows(exprs string, timezone string) (*restageWindows, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	windows := &restageWindows{location: location}
+	for _, expr := range strings.Split(exprs, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		schedule, err := parseCronSchedule(expr)
+		if err != nil {
+			return nil, err
+		}
+		windows.schedules = append(windows.schedules, schedule)
+	}
+	return windows, nil
+}
+
+// isOpen reports whether now is in one of the windows. Without any windows
+// apps can be restaged at any time.
+func (w *restageWindows) isOpen(now time.Time) bool {
+	if w == nil || len(w.schedules) == 0 {
+		return true
+	}
+	now = now.In(w.location)
+	for _, schedule := range w.schedules {
+		if schedule.matches(now) {
+			return true
+		}
+	}
+	return false
+}
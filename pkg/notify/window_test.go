@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleMatches(t *testing.T) {
+	// 2020-02-01 is a Saturday.
+	saturday := time.Date(2020, 2, 1, 3, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		expr     string
+		at       time.Time
+		expected bool
+	}{
+		{"* * * * *", saturday, true},
+		{"* 2-4 * * 6", saturday, true},
+		{"* 2-4 * * 6", saturday.Add(2 * time.Hour), false},
+		{"* 2-4 * * 1-5", saturday, false},
+		{"*/15 * * * *", saturday, true},
+		{"*/15 * * * *", saturday.Add(time.Minute), false},
+		{"0,30 3 * * *", saturday, true},
+		{"* * 1 * 0", saturday, true},
+		{"* * 2 * 0", saturday, false},
+		{"* * 2 * 7", saturday.AddDate(0, 0, 1), true},
+		{"* * * 3 *", saturday, false},
+	}
+	for _, tc := range testCases {
+		schedule, err := parseCronSchedule(tc.expr)
+		if err != nil {
+			t.Fatalf("Test %s failed. Unexpected error %s", tc.expr, err)
+		}
+		if schedule.matches(tc.at) != tc.expected {
+			t.Errorf("Test %s failed. Expected %s to match: %t", tc.expr, tc.at, tc.expected)
+		}
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCronSchedule(expr); err == nil {
+			t.Errorf("Expected %q to be invalid", expr)
+		}
+	}
+}
+
+func TestRestageWindowsIsOpen(t *testing.T) {
+	windows, err := parseRestageWindows("* 22-23 * * 1-5; * * * * 0,6", "America/New_York")
+	if err != nil {
+		t.Fatalf("Unable to parse restage windows. Error %s", err)
+	}
+	testCases := []struct {
+		name     string
+		at       time.Time
+		expected bool
+	}{
+		// 03:30 UTC on Tuesday is 22:30 on Monday in New York.
+		{"weeknight", time.Date(2020, 2, 4, 3, 30, 0, 0, time.UTC), true},
+		{"weekday", time.Date(2020, 2, 4, 15, 0, 0, 0, time.UTC), false},
+		{"weekend", time.Date(2020, 2, 1, 15, 0, 0, 0, time.UTC), true},
+	}
+	for _, tc := range testCases {
+		if windows.isOpen(tc.at) != tc.expected {
+			t.Errorf("Test %s failed. Expected the window to be open: %t", tc.name, tc.expected)
+		}
+	}
+	var none *restageWindows
+	if !none.isOpen(time.Now()) {
+		t.Errorf("Expected apps to be restaged at any time without windows")
+	}
+}